@@ -0,0 +1,71 @@
+package gopdq
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// BatchResult is the outcome of hashing a single file in a batch
+type BatchResult struct {
+	Path   string
+	Result *HashResult
+	Err    error
+}
+
+// HashFiles hashes every file in paths concurrently while honoring limits.
+// If limits.MaxInFlight is zero, runtime.NumCPU() is used. Results are
+// returned in the same order as paths. Progress is reported to the hasher's
+// WithProgress callback.
+func (h *PdqHasher) HashFiles(ctx context.Context, paths []string, limits Limits) []BatchResult {
+	if limits.MaxInFlight <= 0 {
+		limits.MaxInFlight = runtime.NumCPU()
+	}
+	lim := NewLimiter(limits)
+
+	results := make([]BatchResult, len(paths))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	var progressLk sync.Mutex
+	done := 0
+	for i := 0; i < limits.MaxInFlight; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ix := range work {
+				res := &results[ix]
+				res.Result, res.Err = h.hashFileLimited(ctx, res.Path, lim)
+				if h.progress != nil {
+					progressLk.Lock()
+					done++
+					h.progress(done, len(paths), res.Path)
+					progressLk.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i, path := range paths {
+		results[i].Path = path
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	return results
+}
+
+func (h *PdqHasher) hashFileLimited(ctx context.Context, path string, lim *Limiter) (*HashResult, error) {
+	logger := h.logger.With("path", path)
+
+	file, err := os.Open(path)
+	if err != nil {
+		logger.Warn("skipping file", "err", err)
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	return h.fromReaderLimited(ctx, file, lim, logger)
+}
@@ -0,0 +1,113 @@
+package gopdq
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHashFiles(t *testing.T) {
+	hasher := NewPdqHasher()
+
+	single, err := hasher.FromFile("cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := hasher.HashFiles(context.Background(), []string{"cat.jpg", "missing.jpg", "cat.jpg"}, Limits{
+		MaxInFlight: 2,
+		MaxBytes:    1,
+	})
+	if len(res) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(res))
+	}
+	if res[1].Err == nil {
+		t.Fatal("expected error for missing file")
+	}
+	for _, i := range []int{0, 2} {
+		if res[i].Err != nil {
+			t.Fatal(res[i].Err)
+		}
+		if !res[i].Result.Hash.Equal(single.Hash) {
+			t.Fatalf("batch hash mismatch: %s != %s", res[i].Result.Hash, single.Hash)
+		}
+	}
+}
+
+func TestHashFilesProgress(t *testing.T) {
+	paths := []string{"cat.jpg", "missing.jpg", "cat.jpg", "testdata/gray.jpg"}
+	var calls []int
+	seen := map[string]int{}
+	hasher := NewPdqHasher(WithProgress(func(done, total int, current string) {
+		if total != len(paths) {
+			t.Errorf("total %d, expected %d", total, len(paths))
+		}
+		calls = append(calls, done)
+		seen[current]++
+	}))
+	hasher.HashFiles(context.Background(), paths, Limits{MaxInFlight: 3})
+
+	if len(calls) != len(paths) {
+		t.Fatalf("%d progress calls for %d files", len(calls), len(paths))
+	}
+	for i, done := range calls {
+		if done != i+1 {
+			t.Fatalf("progress calls went %v", calls)
+		}
+	}
+	if seen["cat.jpg"] != 2 || seen["missing.jpg"] != 1 || seen["testdata/gray.jpg"] != 1 {
+		t.Fatalf("progress reported %v", seen)
+	}
+}
+
+func TestLimiterMaxBytes(t *testing.T) {
+	lim := NewLimiter(Limits{MaxBytes: 100})
+	ctx := context.Background()
+
+	var lk sync.Mutex
+	var cur, peak int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := lim.Acquire(ctx, 40)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			lk.Lock()
+			cur += 40
+			peak = max(peak, cur)
+			lk.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			lk.Lock()
+			cur -= 40
+			lk.Unlock()
+			release()
+		}()
+	}
+	wg.Wait()
+
+	if peak > 80 {
+		t.Fatalf("limiter admitted %d bytes, limit is 100", peak)
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	lim := NewLimiter(Limits{MaxInFlight: 1})
+	release, err := lim.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := lim.Acquire(ctx, 0); err == nil {
+		t.Fatal("expected acquire to fail once context expired")
+	}
+}
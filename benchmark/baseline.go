@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// loadRun reads a run previously written with -json
+func loadRun(path string) (*Run, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r Run
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse baseline %s: %w", path, err)
+	}
+	return &r, nil
+}
+
+// delta returns the relative change from old to cur, positive meaning cur is larger
+func delta(old, cur float64) float64 {
+	if old == 0 {
+		return 0
+	}
+	return (cur - old) / old
+}
+
+// compareBaseline prints per-image and aggregate changes against base and
+// reports whether the aggregate hash time or end-to-end throughput regressed
+// by more than threshold. Per-image regressions are flagged but don't fail
+// the run on their own since single images are noisy.
+func compareBaseline(w io.Writer, base, cur *Run, threshold float64) bool {
+	baseImages := make(map[string]*ImageResult)
+	for _, img := range base.Images {
+		baseImages[img.Path] = img
+	}
+
+	fmt.Fprintf(w, "\nComparison against baseline from %s (%s)\n", base.Env.Timestamp.Format(time.RFC3339), base.Env.Revision)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "IMAGE\tBASE HASH\tHASH\tDELTA\t")
+	for _, img := range cur.Images {
+		old, ok := baseImages[img.Path]
+		if !ok {
+			continue
+		}
+
+		oldHash := perIter(old.HashTime, old.Iterations)
+		curHash := perIter(img.HashTime, img.Iterations)
+		d := delta(float64(oldHash), float64(curHash))
+
+		note := ""
+		if d > threshold {
+			note = "REGRESSION"
+		}
+		if old.Hash != img.Hash {
+			note += " HASH CHANGED"
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%v\t%+.1f%%\t%s\n",
+			img.Path,
+			oldHash.Round(time.Microsecond),
+			curHash.Round(time.Microsecond),
+			d*100,
+			note,
+		)
+	}
+	tw.Flush()
+
+	// hash time per hash: lower is better
+	hashDelta := delta(
+		float64(perIter(base.Summary.HashTime, base.Summary.Hashes)),
+		float64(perIter(cur.Summary.HashTime, cur.Summary.Hashes)),
+	)
+	// end-to-end throughput: higher is better
+	tputDelta := delta(base.Summary.ThroughputPerSec, cur.Summary.ThroughputPerSec)
+
+	fmt.Fprintf(w, "Aggregate hash time per image: %+.1f%%\n", hashDelta*100)
+	fmt.Fprintf(w, "Aggregate end-to-end throughput: %+.1f%%\n", tputDelta*100)
+
+	regressed := hashDelta > threshold || -tputDelta > threshold
+	if regressed {
+		fmt.Fprintf(w, "Regression beyond %.1f%% threshold\n", threshold*100)
+	}
+	return regressed
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/whyrusleeping/gopdq"
+	"github.com/whyrusleeping/gopdq/conformance"
+)
+
+// pythonPdqScript hashes every path given on the command line with the
+// Python pdqhash bindings. pdqhash returns a 256 element bit vector, most
+// significant bit first; it is printed as is and converted with
+// gopdq.FromBits, the conversion hashnpy uses too, rather than formatted in
+// Python.
+const pythonPdqScript = `
+import sys
+import numpy as np
+import pdqhash
+from PIL import Image
+
+for path in sys.argv[1:]:
+    try:
+        img = np.asarray(Image.open(path).convert("RGB"))
+        vec, quality = pdqhash.compute(img)
+        bits = "".join(str(int(b)) for b in vec)
+        print("%s,%d,%s" % (bits, quality, path))
+    except Exception as e:
+        print("error,%s,%s" % (str(e).replace(",", " "), path))
+`
+
+// implResult is one implementation's output for an image
+type implResult struct {
+	Hash    *gopdq.PdqHash256
+	Quality int
+	Err     error
+	// Margins are the DCT coefficients' distances from the median, only
+	// known for the Go pipeline
+	Margins *[256]float32
+}
+
+// compareResult holds every implementation's output for an image
+type compareResult struct {
+	Path   string
+	Go     implResult
+	Ref    implResult
+	Python *implResult
+}
+
+// exit statuses for the compare subcommand
+const (
+	compareOK       = 0
+	compareError    = 1
+	compareDiverged = 2
+)
+
+func runCompare(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	refBin := fs.String("ref", "pdq-photo-hasher", "path to the reference C++ pdq-photo-hasher binary")
+	goldenPath := fs.String("golden", "", "read expected hashes from this golden CSV instead of running the reference binary")
+	writeGoldenPath := fs.String("write-golden", "", "write the reference results to this golden CSV")
+	python := fs.String("python", "", "python interpreter with the pdqhash package installed; enables three-way comparison")
+	maxAvgBits := fs.Float64("max-avg-bits", -1, "fail if the average go/reference bit difference exceeds this; negative disables")
+	workers := fs.Int("workers", runtime.NumCPU(), "number of images compared concurrently")
+	output := fs.String("o", "", "write a JSON report to this file")
+	asJSON := fs.Bool("json", false, "write the JSON report to stdout (or -o) and console output to stderr")
+	maxBitsPerImage := fs.Int("max-bits-per-image", -1, "fail if any image's go/reference bit difference exceeds this; negative disables")
+	modeName := fs.String("mode", "fast", "go pipeline to compare: fast or reference-exact")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s compare [flags] <image-or-directory>...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s compare -golden golden.csv [flags] [image-or-directory...]\n", os.Args[0])
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExit status is %d on success, %d on errors and %d when divergence exceeds a threshold.\n",
+			compareOK, compareError, compareDiverged)
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 && *goldenPath == "" {
+		fs.Usage()
+		return compareError
+	}
+
+	mode, err := gopdq.ParseMode(*modeName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return compareError
+	}
+
+	reference := func(path string) implResult {
+		return hashWithReference(*refBin, path)
+	}
+
+	var paths []string
+	if *goldenPath != "" {
+		corpus, err := conformance.LoadGolden(*goldenPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load golden file: %v\n", err)
+			return compareError
+		}
+		reference = func(path string) implResult {
+			e, ok := corpus.Lookup(path)
+			if !ok {
+				return implResult{Err: fmt.Errorf("no golden entry for %s", path)}
+			}
+			return implResult{Hash: e.Hash, Quality: e.Quality}
+		}
+		for _, e := range corpus.Entries {
+			paths = append(paths, e.Path)
+		}
+	}
+	if fs.NArg() > 0 {
+		paths, err = collectImages(fs.Args())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return compareError
+		}
+	}
+
+	// python hashes the whole corpus in one process, running alongside the
+	// per-image reference and go hashing
+	var pyResults map[string]implResult
+	var pyErr error
+	pyDone := make(chan struct{})
+	go func() {
+		defer close(pyDone)
+		if *python != "" {
+			pyResults, pyErr = hashWithPython(*python, paths)
+		}
+	}()
+
+	hasher := gopdq.NewPdqHasher(gopdq.WithMode(mode), gopdq.WithMargins())
+	results := make([]*compareResult, len(paths))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < max(1, *workers); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ix := range work {
+				results[ix] = compareOne(hasher, reference, paths[ix])
+			}
+		}()
+	}
+	for i := range paths {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	<-pyDone
+	if pyErr != nil {
+		fmt.Fprintf(os.Stderr, "failed to run python pdqhash: %v\n", pyErr)
+		return compareError
+	}
+	if pyResults != nil {
+		for _, cr := range results {
+			py := pyResults[cr.Path]
+			cr.Python = &py
+		}
+	}
+
+	if *writeGoldenPath != "" {
+		if err := writeGolden(*writeGoldenPath, results); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write golden file: %v\n", err)
+			return compareError
+		}
+	}
+
+	var console io.Writer = os.Stdout
+	if *asJSON && *output == "" {
+		console = os.Stderr
+	}
+	pairs := printComparison(console, results)
+	goRef := pairs[0]
+
+	status := compareOK
+	var passed *bool
+	if *maxAvgBits >= 0 || *maxBitsPerImage >= 0 {
+		failed := false
+		if goRef.Failed > 0 {
+			fmt.Fprintf(console, "FAIL: %d images could not be compared\n", goRef.Failed)
+			failed = true
+		}
+		if err := goRef.Within(*maxAvgBits, *maxBitsPerImage); err != nil {
+			fmt.Fprintf(console, "FAIL: %v\n", err)
+			failed = true
+		}
+		if failed {
+			status = compareDiverged
+		} else {
+			fmt.Fprintln(console, "PASS")
+		}
+		ok := !failed
+		passed = &ok
+	}
+
+	if *asJSON || *output != "" {
+		rep := buildCompareReport(results, pairs, passed)
+		if err := writeJSON(*output, rep); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return compareError
+		}
+	}
+	return status
+}
+
+// compareOne hashes a single image with Go and looks up the reference result
+func compareOne(hasher *gopdq.PdqHasher, reference func(string) implResult, path string) *compareResult {
+	cr := &compareResult{
+		Path: path,
+		Ref:  reference(path),
+	}
+	if res, err := hasher.FromFile(path); err != nil {
+		cr.Go.Err = err
+	} else {
+		cr.Go = implResult{Hash: res.Hash, Quality: res.Quality, Margins: res.Stats.Margins}
+	}
+	return cr
+}
+
+// hashWithReference runs the C++ reference hasher on a single image
+func hashWithReference(bin, path string) implResult {
+	out, err := exec.Command(bin, path).Output()
+	if err != nil {
+		return implResult{Err: fmt.Errorf("reference hasher failed: %w", err)}
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if res, ok := parseHasherLine(line); ok {
+			return res
+		}
+	}
+	return implResult{Err: fmt.Errorf("no hash in reference output: %q", out)}
+}
+
+// parseHasherLine parses a "hash,quality,filename" line. The reference
+// binary's verbose "hash=...,quality=..." form is accepted too.
+func parseHasherLine(line string) (implResult, bool) {
+	fields := strings.Split(strings.TrimSpace(line), ",")
+	if len(fields) < 2 {
+		return implResult{}, false
+	}
+
+	hexHash, quality := fields[0], fields[1]
+	for _, f := range fields {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "hash":
+			hexHash = v
+		case "quality":
+			quality = v
+		}
+	}
+
+	if hexHash == "error" {
+		return implResult{Err: fmt.Errorf("%s", quality)}, true
+	}
+
+	h, err := gopdq.ParseHash(hexHash)
+	if err != nil {
+		return implResult{}, false
+	}
+	q, err := strconv.Atoi(quality)
+	if err != nil {
+		return implResult{}, false
+	}
+	return implResult{Hash: h, Quality: q}, true
+}
+
+// hashWithPython hashes all paths in a single python process
+func hashWithPython(interpreter string, paths []string) (map[string]implResult, error) {
+	cmd := exec.Command(interpreter, append([]string{"-c", pythonPdqScript}, paths...)...)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	results := make(map[string]implResult)
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		path, res, ok := parsePythonLine(sc.Text())
+		if ok {
+			results[path] = res
+		}
+	}
+	for _, p := range paths {
+		if _, ok := results[p]; !ok {
+			results[p] = implResult{Err: fmt.Errorf("no output from pdqhash")}
+		}
+	}
+	return results, sc.Err()
+}
+
+// parsePythonLine parses a "bits,quality,path" line from pythonPdqScript,
+// bits being the 256 element vector as a string of 0s and 1s
+func parsePythonLine(line string) (path string, res implResult, ok bool) {
+	fields := strings.SplitN(line, ",", 3)
+	if len(fields) != 3 {
+		return "", implResult{}, false
+	}
+	if fields[0] == "error" {
+		return fields[2], implResult{Err: fmt.Errorf("%s", fields[1])}, true
+	}
+
+	bits := []byte(fields[0])
+	for i, c := range bits {
+		bits[i] = c - '0'
+	}
+	h, err := gopdq.FromBits(bits)
+	if err != nil {
+		return "", implResult{}, false
+	}
+	q, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return "", implResult{}, false
+	}
+	return fields[2], implResult{Hash: h, Quality: q}, true
+}
+
+// bitDiff returns the hamming distance between two results, or -1 if either failed
+func bitDiff(a, b implResult) int {
+	if a.Err != nil || b.Err != nil {
+		return -1
+	}
+	return a.Hash.HammingDistance(b.Hash)
+}
+
+// pairStats accumulates agreement between two implementations
+type pairStats struct {
+	name string
+	conformance.Stats
+}
+
+// add records a bitDiff result, counting failures separately
+func (ps *pairStats) add(d int) {
+	if d < 0 {
+		ps.Failed++
+		return
+	}
+	ps.Add(d)
+}
+
+// printComparison prints every result and the summary, returning the
+// agreement stats for each pair of implementations, go/reference first
+func printComparison(w io.Writer, results []*compareResult) []*pairStats {
+	goRef := &pairStats{name: "go vs reference"}
+	goPy := &pairStats{name: "go vs python"}
+	refPy := &pairStats{name: "reference vs python"}
+	hasPython := false
+
+	for _, cr := range results {
+		fmt.Fprintf(w, "%s\n", cr.Path)
+		printImpl(w, "reference", cr.Ref)
+		printImpl(w, "go       ", cr.Go)
+		d := bitDiff(cr.Go, cr.Ref)
+		goRef.add(d)
+
+		if cr.Python != nil {
+			hasPython = true
+			printImpl(w, "python   ", *cr.Python)
+			goPy.add(bitDiff(cr.Go, *cr.Python))
+			refPy.add(bitDiff(cr.Ref, *cr.Python))
+		}
+
+		switch {
+		case d < 0:
+			fmt.Fprintf(w, "  ⚠️  could not compare\n")
+		case d == 0:
+			fmt.Fprintf(w, "  ✅ exact match\n")
+		default:
+			fmt.Fprintf(w, "  ❌ %d bits differ from reference\n", d)
+		}
+	}
+
+	printHeatmap(w, bitHeatmap(results))
+	printMarginHistogram(w, marginHistogram(results))
+
+	fmt.Fprintf(w, "\nSummary over %d images:\n", len(results))
+	pairs := []*pairStats{goRef}
+	if hasPython {
+		pairs = append(pairs, goPy, refPy)
+	}
+	for _, ps := range pairs {
+		fmt.Fprintf(w, "  %-20s %d/%d exact, avg %.2f bits, max %d bits\n",
+			ps.name+":", ps.Exact, ps.Images, ps.AvgBits(), ps.MaxBits)
+	}
+	return pairs
+}
+
+func printImpl(w io.Writer, name string, r implResult) {
+	if r.Err != nil {
+		fmt.Fprintf(w, "  %s: error: %v\n", name, r.Err)
+		return
+	}
+	fmt.Fprintf(w, "  %s: %s (quality %d)\n", name, r.Hash, r.Quality)
+}
+
+// ImplReport is one implementation's output in the JSON report
+type ImplReport struct {
+	Hash    string `json:"hash,omitempty"`
+	Quality int    `json:"quality"`
+	Error   string `json:"error,omitempty"`
+}
+
+// CompareImageReport is the JSON report entry for a single image. Diffs are
+// null when either side failed to hash.
+type CompareImageReport struct {
+	Path      string      `json:"path"`
+	Reference ImplReport  `json:"reference"`
+	Go        ImplReport  `json:"go"`
+	Python    *ImplReport `json:"python,omitempty"`
+	BitDiff   *int        `json:"bit_diff"`
+	// DiffPositions lists the [row, col] DCT coordinates of differing bits
+	DiffPositions [][2]int `json:"diff_positions,omitempty"`
+	QualityDiff   *int     `json:"quality_diff"`
+	PythonBitDiff *int     `json:"python_bit_diff,omitempty"`
+}
+
+// PairSummary is the agreement between two implementations
+type PairSummary struct {
+	Pair    string  `json:"pair"`
+	Images  int     `json:"images"`
+	Exact   int     `json:"exact"`
+	AvgBits float64 `json:"avg_bits"`
+	MaxBits int     `json:"max_bits"`
+}
+
+// CompareReport is the structured output of the compare subcommand
+type CompareReport struct {
+	Env     Env                   `json:"env"`
+	Images  []*CompareImageReport `json:"images"`
+	Summary []PairSummary         `json:"summary"`
+	// BitHeatmap counts differing go/reference bits per DCT coefficient
+	BitHeatmap conformance.Heatmap `json:"bit_heatmap"`
+	// MarginHistogram counts bits, and differing go/reference bits, by
+	// their coefficient's distance from the median
+	MarginHistogram []MarginBin `json:"margin_histogram"`
+	// Passed is only set when a threshold flag was given
+	Passed *bool `json:"passed,omitempty"`
+}
+
+func implReport(r implResult) ImplReport {
+	if r.Err != nil {
+		return ImplReport{Error: r.Err.Error()}
+	}
+	return ImplReport{Hash: r.Hash.String(), Quality: r.Quality}
+}
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func buildCompareReport(results []*compareResult, pairs []*pairStats, passed *bool) *CompareReport {
+	rep := &CompareReport{
+		Env:        currentEnv(),
+		Passed:     passed,
+		BitHeatmap: bitHeatmap(results),
+
+		MarginHistogram: marginHistogram(results),
+	}
+	for _, cr := range results {
+		ir := &CompareImageReport{
+			Path:      cr.Path,
+			Reference: implReport(cr.Ref),
+			Go:        implReport(cr.Go),
+		}
+		if d := bitDiff(cr.Go, cr.Ref); d >= 0 {
+			ir.BitDiff = intPtr(d)
+			ir.DiffPositions = conformance.DiffPositions(cr.Ref.Hash, cr.Go.Hash)
+			ir.QualityDiff = intPtr(cr.Go.Quality - cr.Ref.Quality)
+		}
+		if cr.Python != nil {
+			py := implReport(*cr.Python)
+			ir.Python = &py
+			if d := bitDiff(cr.Go, *cr.Python); d >= 0 {
+				ir.PythonBitDiff = intPtr(d)
+			}
+		}
+		rep.Images = append(rep.Images, ir)
+	}
+	for _, ps := range pairs {
+		rep.Summary = append(rep.Summary, PairSummary{
+			Pair:    ps.name,
+			Images:  ps.Images,
+			Exact:   ps.Exact,
+			AvgBits: ps.AvgBits(),
+			MaxBits: ps.MaxBits,
+		})
+	}
+	return rep
+}
+
+// writeGolden writes the reference results as a golden file next to which
+// image paths are recorded relatively
+func writeGolden(path string, results []*compareResult) error {
+	var entries []conformance.Expected
+	for _, cr := range results {
+		if cr.Ref.Err != nil {
+			continue
+		}
+		entries = append(entries, conformance.Expected{
+			Path:    cr.Path,
+			Hash:    cr.Ref.Hash,
+			Quality: cr.Ref.Quality,
+		})
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := conformance.WriteGolden(f, filepath.Dir(path), entries); err != nil {
+		return err
+	}
+	return f.Close()
+}
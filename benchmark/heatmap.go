@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/whyrusleeping/gopdq/conformance"
+)
+
+// bitHeatmap counts, per DCT coefficient, how many images had that bit differ
+// between the Go and reference hashes
+func bitHeatmap(results []*compareResult) conformance.Heatmap {
+	var hm conformance.Heatmap
+	for _, cr := range results {
+		if cr.Go.Err != nil || cr.Ref.Err != nil {
+			continue
+		}
+		hm.Add(cr.Ref.Hash, cr.Go.Hash)
+	}
+	return hm
+}
+
+var heatShades = []rune(" .:-=+*#%@")
+
+// printHeatmap renders the heatmap with the DC corner at the top left
+func printHeatmap(w io.Writer, hm conformance.Heatmap) {
+	peak := 0
+	total := 0
+	for _, row := range hm {
+		for _, n := range row {
+			peak = max(peak, n)
+			total += n
+		}
+	}
+	if total == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "\nDiffering bits by DCT coefficient (%d total, darkest = %d):\n", total, peak)
+	fmt.Fprintln(w, "      horizontal frequency ->")
+	fmt.Fprintln(w, "      0 1 2 3 4 5 6 7 8 9 a b c d e f")
+	for i, row := range hm {
+		fmt.Fprintf(w, "  %2d ", i)
+		for _, n := range row {
+			shade := ' '
+			if n > 0 {
+				shade = heatShades[1+(n*(len(heatShades)-2)+peak-1)/peak]
+			}
+			fmt.Fprintf(w, " %c", shade)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// marginBounds are the upper bounds of the margin histogram's bins but the
+// last. Margins are on the float pipeline's scale, where the coefficients of
+// natural images run into the hundreds and float32 rounding moves them by
+// around 0.001.
+var marginBounds = []float64{0.001, 0.01, 0.1, 1, 10, 100}
+
+// MarginBin counts the bits whose coefficient lies within a range of
+// distances from the median
+type MarginBin struct {
+	// Min and Max bound the distance, Max being zero for the last bin
+	Min float64 `json:"min"`
+	Max float64 `json:"max,omitempty"`
+	// Bits counts every bit of the compared images in the bin, Differing
+	// those where the go and reference hashes disagree
+	Bits      int `json:"bits"`
+	Differing int `json:"differing"`
+}
+
+// marginHistogram bins every bit of the images both sides hashed by the go
+// coefficient's distance from the median, counting where the hashes differ.
+// Rounding differences flip bits whose coefficients are close to the
+// median, so divergence from rounding alone is concentrated in the first
+// bins, while a real difference in the pipeline spreads into the large
+// margins.
+func marginHistogram(results []*compareResult) []MarginBin {
+	bins := make([]MarginBin, len(marginBounds)+1)
+	for i := range bins {
+		if i > 0 {
+			bins[i].Min = marginBounds[i-1]
+		}
+		if i < len(marginBounds) {
+			bins[i].Max = marginBounds[i]
+		}
+	}
+	for _, cr := range results {
+		if cr.Go.Err != nil || cr.Ref.Err != nil || cr.Go.Margins == nil {
+			continue
+		}
+		for k, m := range cr.Go.Margins {
+			b := &bins[marginBin(math.Abs(float64(m)))]
+			b.Bits++
+			if cr.Go.Hash.Bit(k/16, k%16) != cr.Ref.Hash.Bit(k/16, k%16) {
+				b.Differing++
+			}
+		}
+	}
+	return bins
+}
+
+func marginBin(m float64) int {
+	for i, b := range marginBounds {
+		if m < b {
+			return i
+		}
+	}
+	return len(marginBounds)
+}
+
+// printMarginHistogram prints the share of bits differing in each bin
+func printMarginHistogram(w io.Writer, bins []MarginBin) {
+	differing := 0
+	for _, b := range bins {
+		differing += b.Differing
+	}
+	if differing == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "\nDiffering bits by coefficient distance from the median:")
+	for _, b := range bins {
+		bound := fmt.Sprintf("%g - %g", b.Min, b.Max)
+		if b.Max == 0 {
+			bound = fmt.Sprintf("%g and up", b.Min)
+		}
+		share := 0.0
+		if b.Bits > 0 {
+			share = float64(b.Differing) / float64(b.Bits)
+		}
+		fmt.Fprintf(w, "  %-14s %6d of %8d bits differ (%.2f%%)\n", bound, b.Differing, b.Bits, 100*share)
+	}
+}
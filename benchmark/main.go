@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+var imageExts = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		os.Exit(runCompare(os.Args[2:]))
+	}
+
+	iterations := flag.Int("n", 1, "number of times to hash each image")
+	output := flag.String("o", "", "write per-image results to this file (CSV unless -json is set)")
+	asJSON := flag.Bool("json", false, "write results as JSON instead of CSV")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile of the measured pass to this file")
+	memProfile := flag.String("memprofile", "", "write an allocation profile to this file on exit")
+	baseline := flag.String("baseline", "", "compare against a previous -json run and exit non-zero on regression")
+	threshold := flag.Float64("threshold", 0.10, "relative slowdown tolerated by -baseline before reporting a regression")
+	hashOnly := flag.Bool("hash-only", false, "decode each image once up front and measure only repeated hashing of the in-memory image")
+	compareDecoders := flag.Bool("compare-decoders", false, "also time libjpeg decode of JPEGs alongside the stdlib decoder")
+	synthetic := flag.Int("synthetic", 0, "benchmark this many generated images per -sizes entry instead of files, hashed in memory")
+	sizes := flag.String("sizes", "640x480,1920x1080,4096x4096", "image sizes generated by -synthetic")
+	syntheticDisk := flag.Bool("synthetic-disk", false, "round-trip -synthetic images through PNG files on disk, measuring read and decode too")
+	robustness := flag.Bool("robustness", false, "instead of timing, measure hash distances under common perturbations versus random pairs")
+	workers := flag.Int("workers", 1, "number of concurrent workers; above 1 a serial pass is also run to compute scaling efficiency")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <image-or-directory>...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] -synthetic N\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s compare [flags] <image-or-directory>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 && *synthetic <= 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	b := &bench{
+		hasher:          gopdq.NewPdqHasher(),
+		compareDecoders: *compareDecoders,
+	}
+
+	var paths []string
+	var err error
+	cleanup := func() {}
+	if *synthetic > 0 {
+		sz, err := parseSizes(*sizes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var images map[string]decodedImage
+		paths, images = syntheticImages(sz, *synthetic)
+
+		if *syntheticDisk {
+			dir, err := os.MkdirTemp("", "pdq-synthetic-")
+			if err != nil {
+				log.Fatal(err)
+			}
+			cleanup = func() { os.RemoveAll(dir) }
+			paths, err = writeSynthetic(dir, paths, images)
+			if err != nil {
+				cleanup()
+				log.Fatal(err)
+			}
+		} else {
+			b.decoded = images
+		}
+	} else {
+		paths, err = collectImages(flag.Args())
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(paths) == 0 {
+		log.Fatal("no images found")
+	}
+
+	if *robustness {
+		if b.decoded == nil {
+			paths = b.predecode(paths)
+		}
+		rep := b.runRobustness(paths)
+		cleanup()
+
+		out := os.Stdout
+		if *asJSON && *output == "" {
+			out = os.Stderr
+		}
+		printRobustness(out, rep)
+		if *output != "" || *asJSON {
+			if err := writeJSON(*output, rep); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
+
+	var base *Run
+	if *baseline != "" {
+		base, err = loadRun(*baseline)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *hashOnly && b.decoded == nil {
+		paths = b.predecode(paths)
+		if len(paths) == 0 {
+			log.Fatal("no images could be decoded")
+		}
+	}
+
+	run := &Run{
+		Env:        currentEnv(),
+		Iterations: *iterations,
+		Workers:    *workers,
+		HashOnly:   b.decoded != nil,
+	}
+
+	var serialWall time.Duration
+	if *workers > 1 {
+		_, _, serialWall = b.runParallel(paths, *iterations, 1)
+	}
+
+	stopCPUProfile := func() {}
+	if *cpuProfile != "" {
+		stopCPUProfile, err = startCPUProfile(*cpuProfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	runtime.GC()
+	before := takeMemSnapshot()
+
+	var wall time.Duration
+	run.Images, run.WorkerStats, wall = b.runParallel(paths, *iterations, *workers)
+	stopCPUProfile()
+	run.Summarize(wall)
+	run.Memory = memDelta(before, takeMemSnapshot(), run.Summary.Hashes)
+	cleanup()
+	if serialWall > 0 {
+		run.Summary.SetSerialBaseline(serialWall, *workers)
+	}
+
+	// keep stdout clean when it carries the machine-readable results
+	table := os.Stdout
+	if *asJSON && *output == "" {
+		table = os.Stderr
+	}
+	printTable(table, run)
+
+	if *output != "" || *asJSON {
+		if err := writeResults(*output, *asJSON, run); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *memProfile != "" {
+		if err := writeMemProfile(*memProfile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if base != nil && compareBaseline(table, base, run, *threshold) {
+		os.Exit(2)
+	}
+}
+
+// collectImages expands directories into the image files they contain
+func collectImages(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+
+		err = filepath.WalkDir(arg, func(p string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && imageExts[strings.ToLower(filepath.Ext(p))] {
+				paths = append(paths, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// sample is the measurement of a single read, decode and hash
+type sample struct {
+	read    time.Duration
+	decode  time.Duration
+	libjpeg time.Duration
+	hash    time.Duration
+	format  string
+	width   int
+	height  int
+	pdq     string
+	quality int
+}
+
+// bench holds the settings shared by every measurement in a run
+type bench struct {
+	hasher *gopdq.PdqHasher
+	// compareDecoders additionally decodes JPEGs with libjpeg so its decode
+	// time can be reported next to the stdlib decoder's
+	compareDecoders bool
+	// decoded holds pre-decoded images in hash-only mode, so measurements
+	// cover just the hash kernel
+	decoded map[string]decodedImage
+}
+
+type decodedImage struct {
+	img    image.Image
+	format string
+}
+
+// predecode decodes every image once up front for hash-only mode, returning
+// the paths that decoded successfully
+func (b *bench) predecode(paths []string) []string {
+	b.decoded = make(map[string]decodedImage)
+
+	var ok []string
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			log.Printf("skipping %s: %v", p, err)
+			continue
+		}
+		img, format, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			log.Printf("skipping %s: %v", p, err)
+			continue
+		}
+		b.decoded[p] = decodedImage{img: img, format: format}
+		ok = append(ok, p)
+	}
+	return ok
+}
+
+// measureOnce reads, decodes and hashes a single image, timing each stage
+func (b *bench) measureOnce(path string) (*sample, error) {
+	if d, ok := b.decoded[path]; ok {
+		return b.hashOnly(d)
+	}
+
+	readStart := time.Now()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	readTime := time.Since(readStart)
+
+	decodeStart := time.Now()
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	decodeTime := time.Since(decodeStart)
+
+	var libjpegTime time.Duration
+	if b.compareDecoders && format == "jpeg" {
+		ljStart := time.Now()
+		if _, err := gopdq.DecodeJpeg(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+		libjpegTime = time.Since(ljStart)
+	}
+
+	hashStart := time.Now()
+	hr, err := b.hasher.HashImage(img)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sample{
+		read:    readTime,
+		decode:  decodeTime,
+		libjpeg: libjpegTime,
+		hash:    time.Since(hashStart),
+		format:  format,
+		width:   img.Bounds().Dx(),
+		height:  img.Bounds().Dy(),
+		pdq:     hr.Hash.String(),
+		quality: hr.Quality,
+	}, nil
+}
+
+// hashOnly measures hashing an already decoded image
+func (b *bench) hashOnly(d decodedImage) (*sample, error) {
+	hashStart := time.Now()
+	hr, err := b.hasher.HashImage(d.img)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sample{
+		hash:    time.Since(hashStart),
+		format:  d.format,
+		width:   d.img.Bounds().Dx(),
+		height:  d.img.Bounds().Dy(),
+		pdq:     hr.Hash.String(),
+		quality: hr.Quality,
+	}, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"runtime"
+	"runtime/metrics"
+	"time"
+)
+
+// MemResult describes allocation and GC behavior over a benchmark pass
+type MemResult struct {
+	AllocBytes        uint64        `json:"alloc_bytes"`
+	AllocObjects      uint64        `json:"alloc_objects"`
+	AllocBytesPerHash float64       `json:"alloc_bytes_per_hash"`
+	AllocsPerHash     float64       `json:"allocs_per_hash"`
+	HeapGrowthBytes   int64         `json:"heap_growth_bytes"`
+	GCCycles          uint64        `json:"gc_cycles"`
+	GCPauseTotal      time.Duration `json:"gc_pause_total_ns"`
+	// GCCPUFraction is the share of available CPU time spent in the GC
+	GCCPUFraction float64 `json:"gc_cpu_fraction"`
+}
+
+var memMetrics = []string{
+	"/gc/heap/allocs:bytes",
+	"/gc/heap/allocs:objects",
+	"/memory/classes/heap/objects:bytes",
+	"/gc/cycles/total:gc-cycles",
+	"/cpu/classes/gc/total:cpu-seconds",
+	"/cpu/classes/total:cpu-seconds",
+}
+
+type memSnapshot struct {
+	samples    []metrics.Sample
+	pauseTotal uint64
+}
+
+func takeMemSnapshot() *memSnapshot {
+	s := &memSnapshot{
+		samples: make([]metrics.Sample, len(memMetrics)),
+	}
+	for i, name := range memMetrics {
+		s.samples[i].Name = name
+	}
+	metrics.Read(s.samples)
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	s.pauseTotal = ms.PauseTotalNs
+	return s
+}
+
+func (s *memSnapshot) uint(i int) uint64 {
+	if s.samples[i].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return s.samples[i].Value.Uint64()
+}
+
+func (s *memSnapshot) float(i int) float64 {
+	if s.samples[i].Value.Kind() != metrics.KindFloat64 {
+		return 0
+	}
+	return s.samples[i].Value.Float64()
+}
+
+// memDelta computes the allocation and GC activity between two snapshots
+func memDelta(before, after *memSnapshot, hashes int) MemResult {
+	r := MemResult{
+		AllocBytes:      after.uint(0) - before.uint(0),
+		AllocObjects:    after.uint(1) - before.uint(1),
+		HeapGrowthBytes: int64(after.uint(2)) - int64(before.uint(2)),
+		GCCycles:        after.uint(3) - before.uint(3),
+		GCPauseTotal:    time.Duration(after.pauseTotal - before.pauseTotal),
+	}
+	if cpu := after.float(5) - before.float(5); cpu > 0 {
+		r.GCCPUFraction = (after.float(4) - before.float(4)) / cpu
+	}
+	if hashes > 0 {
+		r.AllocBytesPerHash = float64(r.AllocBytes) / float64(hashes)
+		r.AllocsPerHash = float64(r.AllocObjects) / float64(hashes)
+	}
+	return r
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// WorkerResult holds the measurements for a single benchmark worker
+type WorkerResult struct {
+	ID           int           `json:"id"`
+	Hashes       int           `json:"hashes"`
+	BusyTime     time.Duration `json:"busy_ns"`
+	HashesPerSec float64       `json:"hashes_per_sec"`
+}
+
+// runParallel hashes every image iterations times, spreading the work over n
+// workers, and returns per-image results in input order, per-worker results
+// and the wall-clock time taken. Images that fail are logged and omitted.
+func (b *bench) runParallel(paths []string, iterations, n int) ([]*ImageResult, []WorkerResult, time.Duration) {
+	if n < 1 {
+		n = 1
+	}
+
+	images := make([]*ImageResult, len(paths))
+	failed := make([]bool, len(paths))
+	for i, p := range paths {
+		images[i] = &ImageResult{Path: p}
+	}
+
+	var lk sync.Mutex
+	tasks := make(chan int)
+	workers := make([]WorkerResult, n)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := range workers {
+		workers[w].ID = w
+		wg.Add(1)
+		go func(wr *WorkerResult) {
+			defer wg.Done()
+			for ix := range tasks {
+				taskStart := time.Now()
+				s, err := b.measureOnce(paths[ix])
+				wr.BusyTime += time.Since(taskStart)
+
+				lk.Lock()
+				if err != nil {
+					if !failed[ix] {
+						log.Printf("skipping %s: %v", paths[ix], err)
+					}
+					failed[ix] = true
+				} else {
+					wr.Hashes++
+					img := images[ix]
+					img.Iterations++
+					img.ReadTime += s.read
+					img.DecodeTime += s.decode
+					img.LibjpegDecodeTime += s.libjpeg
+					img.HashTime += s.hash
+					img.Format = s.format
+					img.Width = s.width
+					img.Height = s.height
+					img.Hash = s.pdq
+					img.Quality = s.quality
+				}
+				lk.Unlock()
+			}
+		}(&workers[w])
+	}
+
+	for it := 0; it < iterations; it++ {
+		for i := range paths {
+			tasks <- i
+		}
+	}
+	close(tasks)
+	wg.Wait()
+	wall := time.Since(start)
+
+	var out []*ImageResult
+	for i, img := range images {
+		if !failed[i] {
+			out = append(out, img)
+		}
+	}
+	for w := range workers {
+		if workers[w].BusyTime > 0 {
+			workers[w].HashesPerSec = float64(workers[w].Hashes) / workers[w].BusyTime.Seconds()
+		}
+	}
+	return out, workers, wall
+}
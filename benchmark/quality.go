@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// qualityBuckets is the number of histogram buckets, each 10 points wide;
+// the last one also holds the maximum quality of 100
+const qualityBuckets = 10
+
+// qualityHistogram counts images per quality bucket
+func qualityHistogram(images []*ImageResult) []int {
+	hist := make([]int, qualityBuckets)
+	for _, img := range images {
+		b := img.Quality / 10
+		if b >= qualityBuckets {
+			b = qualityBuckets - 1
+		}
+		if b < 0 {
+			b = 0
+		}
+		hist[b]++
+	}
+	return hist
+}
+
+// printQualityHistogram shows the distribution of quality scores along with
+// the share of images a minimum-quality threshold at each bucket would keep
+func printQualityHistogram(w io.Writer, hist []int) {
+	total := 0
+	peak := 0
+	for _, n := range hist {
+		total += n
+		peak = max(peak, n)
+	}
+	if total == 0 {
+		return
+	}
+
+	const width = 40
+	fmt.Fprintln(w, "\nQuality distribution:")
+	kept := total
+	for i, n := range hist {
+		hi := i*10 + 9
+		if i == len(hist)-1 {
+			hi = 100
+		}
+		bar := strings.Repeat("#", (n*width+peak-1)/peak)
+		fmt.Fprintf(w, "  %3d-%-3d %6d  %-*s  >=%-3d keeps %5.1f%%\n",
+			i*10, hi, n, width, bar, i*10, float64(kept)*100/float64(total))
+		kept -= n
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// Env describes the machine and build a run was taken on
+type Env struct {
+	Timestamp time.Time `json:"timestamp"`
+	GoVersion string    `json:"go_version"`
+	GOOS      string    `json:"goos"`
+	GOARCH    string    `json:"goarch"`
+	NumCPU    int       `json:"num_cpu"`
+	Hostname  string    `json:"hostname,omitempty"`
+	Revision  string    `json:"revision,omitempty"`
+	Modified  bool      `json:"modified,omitempty"`
+}
+
+// ImageResult holds the measurements for a single image
+type ImageResult struct {
+	Path       string        `json:"path"`
+	Format     string        `json:"format"`
+	Width      int           `json:"width"`
+	Height     int           `json:"height"`
+	Iterations int           `json:"iterations"`
+	ReadTime   time.Duration `json:"read_ns"`
+	DecodeTime time.Duration `json:"decode_ns"`
+	// LibjpegDecodeTime is only set for JPEGs when -compare-decoders is used
+	LibjpegDecodeTime time.Duration `json:"libjpeg_decode_ns,omitempty"`
+	HashTime          time.Duration `json:"hash_ns"`
+	Hash              string        `json:"hash"`
+	Quality           int           `json:"quality"`
+}
+
+// Summary aggregates a run
+type Summary struct {
+	Images     int           `json:"images"`
+	Hashes     int           `json:"hashes"`
+	Pixels     int64         `json:"pixels"`
+	WallTime   time.Duration `json:"wall_ns"`
+	ReadTime   time.Duration `json:"read_ns"`
+	DecodeTime time.Duration `json:"decode_ns"`
+	HashTime   time.Duration `json:"hash_ns"`
+
+	// JPEG decode totals, only set when -compare-decoders is used
+	JpegStdlibDecodeTime  time.Duration `json:"jpeg_stdlib_decode_ns,omitempty"`
+	JpegLibjpegDecodeTime time.Duration `json:"jpeg_libjpeg_decode_ns,omitempty"`
+	HashesPerSec          float64       `json:"hashes_per_sec"`
+	MPixelsPerSec         float64       `json:"mpixels_per_sec"`
+
+	// ThroughputPerSec is end-to-end (read, decode and hash) images per
+	// second of wall time across all workers
+	ThroughputPerSec float64 `json:"throughput_per_sec"`
+	// SerialThroughputPerSec and ScalingEfficiency are only set when more
+	// than one worker was used
+	SerialThroughputPerSec float64 `json:"serial_throughput_per_sec,omitempty"`
+	ScalingEfficiency      float64 `json:"scaling_efficiency,omitempty"`
+}
+
+// Run is the complete, exportable result of a benchmark invocation
+type Run struct {
+	Env         Env            `json:"env"`
+	Iterations  int            `json:"iterations"`
+	Workers     int            `json:"workers"`
+	HashOnly    bool           `json:"hash_only,omitempty"`
+	Images      []*ImageResult `json:"images"`
+	WorkerStats []WorkerResult `json:"worker_stats,omitempty"`
+	Summary     Summary        `json:"summary"`
+	// QualityHistogram counts images per 10-point quality bucket
+	QualityHistogram []int     `json:"quality_histogram"`
+	Memory           MemResult `json:"memory"`
+}
+
+func currentEnv() Env {
+	env := Env{
+		Timestamp: time.Now().UTC(),
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		NumCPU:    runtime.NumCPU(),
+	}
+	env.Hostname, _ = os.Hostname()
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				env.Revision = s.Value
+			case "vcs.modified":
+				env.Modified = s.Value == "true"
+			}
+		}
+	}
+	return env
+}
+
+// Summarize fills in the aggregate numbers for the run
+func (r *Run) Summarize(wall time.Duration) {
+	s := Summary{
+		Images:   len(r.Images),
+		WallTime: wall,
+	}
+	for _, img := range r.Images {
+		s.Hashes += img.Iterations
+		s.Pixels += int64(img.Width) * int64(img.Height) * int64(img.Iterations)
+		s.ReadTime += img.ReadTime
+		s.DecodeTime += img.DecodeTime
+		s.HashTime += img.HashTime
+		if img.LibjpegDecodeTime > 0 {
+			s.JpegStdlibDecodeTime += img.DecodeTime
+			s.JpegLibjpegDecodeTime += img.LibjpegDecodeTime
+		}
+	}
+	if s.HashTime > 0 {
+		s.HashesPerSec = float64(s.Hashes) / s.HashTime.Seconds()
+		s.MPixelsPerSec = float64(s.Pixels) / 1e6 / s.HashTime.Seconds()
+	}
+	if wall > 0 {
+		s.ThroughputPerSec = float64(s.Hashes) / wall.Seconds()
+	}
+	r.Summary = s
+	r.QualityHistogram = qualityHistogram(r.Images)
+}
+
+// SetSerialBaseline records the wall time of the same work done by a single
+// worker and derives the scaling efficiency of the parallel run from it
+func (s *Summary) SetSerialBaseline(serialWall time.Duration, workers int) {
+	s.SerialThroughputPerSec = float64(s.Hashes) / serialWall.Seconds()
+	s.ScalingEfficiency = s.ThroughputPerSec / (s.SerialThroughputPerSec * float64(workers))
+}
+
+func perIter(d time.Duration, n int) time.Duration {
+	if n == 0 {
+		return 0
+	}
+	return d / time.Duration(n)
+}
+
+func printTable(w io.Writer, r *Run) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "IMAGE\tSIZE\tREAD\tDECODE\tLIBJPEG\tHASH\tQUALITY\tPDQ")
+	for _, img := range r.Images {
+		libjpeg := "-"
+		if img.LibjpegDecodeTime > 0 {
+			libjpeg = perIter(img.LibjpegDecodeTime, img.Iterations).Round(time.Microsecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%dx%d\t%v\t%v\t%s\t%v\t%d\t%s\n",
+			img.Path,
+			img.Width, img.Height,
+			perIter(img.ReadTime, img.Iterations).Round(time.Microsecond),
+			perIter(img.DecodeTime, img.Iterations).Round(time.Microsecond),
+			libjpeg,
+			perIter(img.HashTime, img.Iterations).Round(time.Microsecond),
+			img.Quality,
+			img.Hash,
+		)
+	}
+	tw.Flush()
+
+	s := r.Summary
+	fmt.Fprintf(w, "\n%d images, %d hashes in %v\n", s.Images, s.Hashes, s.WallTime.Round(time.Millisecond))
+	fmt.Fprintf(w, "Hash throughput: %.1f hashes/sec, %.1f MP/sec\n", s.HashesPerSec, s.MPixelsPerSec)
+	fmt.Fprintf(w, "Time split: read %v, decode %v, hash %v\n",
+		s.ReadTime.Round(time.Millisecond), s.DecodeTime.Round(time.Millisecond), s.HashTime.Round(time.Millisecond))
+	if s.JpegLibjpegDecodeTime > 0 {
+		fmt.Fprintf(w, "JPEG decode: stdlib %v, libjpeg %v (%.2fx)\n",
+			s.JpegStdlibDecodeTime.Round(time.Millisecond),
+			s.JpegLibjpegDecodeTime.Round(time.Millisecond),
+			float64(s.JpegStdlibDecodeTime)/float64(s.JpegLibjpegDecodeTime))
+	}
+	fmt.Fprintf(w, "End-to-end throughput: %.1f images/sec with %d worker(s)\n", s.ThroughputPerSec, r.Workers)
+
+	printQualityHistogram(w, r.QualityHistogram)
+
+	m := r.Memory
+	fmt.Fprintf(w, "Allocations: %.1f MiB/hash, %.0f allocs/hash, heap growth %.1f MiB\n",
+		m.AllocBytesPerHash/(1<<20), m.AllocsPerHash, float64(m.HeapGrowthBytes)/(1<<20))
+	fmt.Fprintf(w, "GC: %d cycles, %v total pause, %.1f%% of CPU\n",
+		m.GCCycles, m.GCPauseTotal.Round(time.Microsecond), m.GCCPUFraction*100)
+
+	if r.Workers > 1 {
+		for _, wr := range r.WorkerStats {
+			fmt.Fprintf(w, "  worker %d: %d hashes, %.1f images/sec\n", wr.ID, wr.Hashes, wr.HashesPerSec)
+		}
+		fmt.Fprintf(w, "Serial throughput: %.1f images/sec, scaling efficiency %.0f%%\n",
+			s.SerialThroughputPerSec, s.ScalingEfficiency*100)
+	}
+}
+
+// writeResults writes the run to path (stdout if empty) as CSV or JSON
+func writeResults(path string, asJSON bool, r *Run) error {
+	if asJSON {
+		return writeJSON(path, r)
+	}
+
+	var w io.Writer = os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	return writeCSV(w, r)
+}
+
+// writeJSON writes v as indented JSON to path, or stdout if path is empty
+func writeJSON(path string, v any) error {
+	var w io.Writer = os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func writeCSV(w io.Writer, r *Run) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"timestamp", "revision", "goarch", "num_cpu",
+		"path", "format", "width", "height", "iterations",
+		"read_ns_per_iter", "decode_ns_per_iter", "libjpeg_decode_ns_per_iter",
+		"hash_ns_per_iter", "quality", "hash",
+	})
+	for _, img := range r.Images {
+		cw.Write([]string{
+			r.Env.Timestamp.Format(time.RFC3339),
+			r.Env.Revision,
+			r.Env.GOARCH,
+			strconv.Itoa(r.Env.NumCPU),
+			img.Path,
+			img.Format,
+			strconv.Itoa(img.Width),
+			strconv.Itoa(img.Height),
+			strconv.Itoa(img.Iterations),
+			strconv.FormatInt(int64(perIter(img.ReadTime, img.Iterations)), 10),
+			strconv.FormatInt(int64(perIter(img.DecodeTime, img.Iterations)), 10),
+			strconv.FormatInt(int64(perIter(img.LibjpegDecodeTime, img.Iterations)), 10),
+			strconv.FormatInt(int64(perIter(img.HashTime, img.Iterations)), 10),
+			strconv.Itoa(img.Quality),
+			img.Hash,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
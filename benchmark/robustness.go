@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/whyrusleeping/gopdq"
+	"github.com/whyrusleeping/gopdq/corpusgen"
+)
+
+// perturbations are the transforms hashes are compared under
+var perturbations = []corpusgen.Transform{
+	{Name: "jpeg-q90", Apply: corpusgen.JPEG(90)},
+	{Name: "jpeg-q70", Apply: corpusgen.JPEG(70)},
+	{Name: "jpeg-q50", Apply: corpusgen.JPEG(50)},
+	{Name: "jpeg-q30", Apply: corpusgen.JPEG(30)},
+	{Name: "resize-50", Apply: corpusgen.Scale(0.5)},
+	{Name: "resize-25", Apply: corpusgen.Scale(0.25)},
+	{Name: "crop-90", Apply: corpusgen.CenterCrop(0.9)},
+	{Name: "crop-75", Apply: corpusgen.CenterCrop(0.75)},
+	{Name: "rotate-5", Apply: corpusgen.Rotate(5)},
+	{Name: "rotate-90", Apply: corpusgen.Rotate(90)},
+	{Name: "watermark", Apply: corpusgen.Watermark},
+	{Name: "brighten-20", Apply: corpusgen.Brightness(1.2)},
+	{Name: "darken-20", Apply: corpusgen.Brightness(0.8)},
+}
+
+// DistanceStats summarizes a set of hamming distances
+type DistanceStats struct {
+	Count  int     `json:"count"`
+	Mean   float64 `json:"mean"`
+	Median int     `json:"median"`
+	P95    int     `json:"p95"`
+	Max    int     `json:"max"`
+}
+
+func distanceStats(d []int) DistanceStats {
+	if len(d) == 0 {
+		return DistanceStats{}
+	}
+	sorted := slices.Clone(d)
+	slices.Sort(sorted)
+	sum := 0
+	for _, v := range sorted {
+		sum += v
+	}
+	return DistanceStats{
+		Count:  len(sorted),
+		Mean:   float64(sum) / float64(len(sorted)),
+		Median: sorted[len(sorted)/2],
+		P95:    sorted[min(len(sorted)-1, len(sorted)*95/100)],
+		Max:    sorted[len(sorted)-1],
+	}
+}
+
+// ROCPoint is the match rate of same-image and random pairs at a threshold
+type ROCPoint struct {
+	Threshold int `json:"threshold"`
+	// TruePositiveRate is the share of perturbed variants within threshold
+	// of their original
+	TruePositiveRate float64 `json:"tpr"`
+	// FalsePositiveRate is the share of unrelated pairs within threshold
+	FalsePositiveRate float64 `json:"fpr"`
+}
+
+// RobustnessReport is the result of a robustness evaluation
+type RobustnessReport struct {
+	Env           Env                      `json:"env"`
+	Images        int                      `json:"images"`
+	Perturbations map[string]DistanceStats `json:"perturbations"`
+	Perturbed     DistanceStats            `json:"perturbed"`
+	Random        DistanceStats            `json:"random"`
+	ROC           []ROCPoint               `json:"roc"`
+}
+
+// maxRandomPairs caps the number of unrelated pairs so large corpora don't
+// turn the evaluation quadratic
+const maxRandomPairs = 100000
+
+// runRobustness hashes every image and all of its perturbations, comparing
+// variant distances against distances between unrelated images
+func (b *bench) runRobustness(paths []string) *RobustnessReport {
+	rep := &RobustnessReport{
+		Env:           currentEnv(),
+		Perturbations: make(map[string]DistanceStats),
+	}
+
+	perPerturbation := make(map[string][]int)
+	var perturbed []int
+	var originals []*gopdq.PdqHash256
+
+	for _, p := range paths {
+		d, ok := b.decoded[p]
+		if !ok {
+			continue
+		}
+		orig, err := b.hasher.HashImage(d.img)
+		if err != nil {
+			log.Printf("skipping %s: %v", p, err)
+			continue
+		}
+		originals = append(originals, orig.Hash)
+
+		for _, pt := range perturbations {
+			variant, err := pt.Apply(d.img)
+			if err != nil {
+				log.Printf("%s on %s: %v", pt.Name, p, err)
+				continue
+			}
+			res, err := b.hasher.HashImage(variant)
+			if err != nil {
+				log.Printf("%s on %s: %v", pt.Name, p, err)
+				continue
+			}
+			dist := orig.Hash.HammingDistance(res.Hash)
+			perPerturbation[pt.Name] = append(perPerturbation[pt.Name], dist)
+			perturbed = append(perturbed, dist)
+		}
+	}
+	rep.Images = len(originals)
+
+	var random []int
+pairs:
+	for i := 0; i < len(originals); i++ {
+		for j := i + 1; j < len(originals); j++ {
+			random = append(random, originals[i].HammingDistance(originals[j]))
+			if len(random) >= maxRandomPairs {
+				break pairs
+			}
+		}
+	}
+
+	for name, d := range perPerturbation {
+		rep.Perturbations[name] = distanceStats(d)
+	}
+	rep.Perturbed = distanceStats(perturbed)
+	rep.Random = distanceStats(random)
+
+	for t := 0; t <= 256; t++ {
+		rep.ROC = append(rep.ROC, ROCPoint{
+			Threshold:         t,
+			TruePositiveRate:  fractionWithin(perturbed, t),
+			FalsePositiveRate: fractionWithin(random, t),
+		})
+	}
+	return rep
+}
+
+func fractionWithin(d []int, t int) float64 {
+	if len(d) == 0 {
+		return 0
+	}
+	n := 0
+	for _, v := range d {
+		if v <= t {
+			n++
+		}
+	}
+	return float64(n) / float64(len(d))
+}
+
+func printRobustness(w io.Writer, rep *RobustnessReport) {
+	fmt.Fprintf(w, "Robustness over %d images\n\n", rep.Images)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PERTURBATION\tMEAN\tMEDIAN\tP95\tMAX")
+	row := func(name string, s DistanceStats) {
+		fmt.Fprintf(tw, "%s\t%.1f\t%d\t%d\t%d\n", name, s.Mean, s.Median, s.P95, s.Max)
+	}
+	for _, pt := range perturbations {
+		if s, ok := rep.Perturbations[pt.Name]; ok {
+			row(pt.Name, s)
+		}
+	}
+	row("all perturbed", rep.Perturbed)
+	if rep.Random.Count > 0 {
+		row("random pairs", rep.Random)
+	}
+	tw.Flush()
+
+	if rep.Random.Count == 0 {
+		fmt.Fprintln(w, "\nAt least two images are needed to measure random-pair distances")
+		return
+	}
+
+	fmt.Fprintln(w, "\nROC (match if distance <= threshold):")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "THRESHOLD\tTPR\tFPR")
+	for _, pt := range rep.ROC {
+		if pt.Threshold%16 == 0 || pt.Threshold == 31 {
+			fmt.Fprintf(tw, "%d\t%.3f\t%.4f\n", pt.Threshold, pt.TruePositiveRate, pt.FalsePositiveRate)
+		}
+		if pt.Threshold >= 128 {
+			break
+		}
+	}
+	tw.Flush()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// parseSizes parses a comma separated list of WIDTHxHEIGHT sizes
+func parseSizes(s string) ([]image.Point, error) {
+	var sizes []image.Point
+	for _, part := range strings.Split(s, ",") {
+		w, h, ok := strings.Cut(strings.TrimSpace(part), "x")
+		if !ok {
+			return nil, fmt.Errorf("invalid size %q, expected WIDTHxHEIGHT", part)
+		}
+		width, err := strconv.Atoi(w)
+		if err != nil {
+			return nil, fmt.Errorf("invalid width in %q: %w", part, err)
+		}
+		height, err := strconv.Atoi(h)
+		if err != nil {
+			return nil, fmt.Errorf("invalid height in %q: %w", part, err)
+		}
+		if width <= 0 || height <= 0 {
+			return nil, fmt.Errorf("invalid size %q", part)
+		}
+		sizes = append(sizes, image.Pt(width, height))
+	}
+	return sizes, nil
+}
+
+// generateImage draws a deterministic mix of gradients, shapes and noise so
+// the hash has real structure to work with
+func generateImage(width, height int, seed int64) *image.RGBA {
+	rnd := rand.New(rand.NewSource(seed))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	fx := 1 + rnd.Float64()*6
+	fy := 1 + rnd.Float64()*6
+	phase := rnd.Float64() * math.Pi
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			u := float64(x) / float64(width)
+			v := float64(y) / float64(height)
+			r := 127 + 127*math.Sin(2*math.Pi*fx*u+phase)
+			g := 127 + 127*math.Cos(2*math.Pi*fy*v)
+			b := 255 * u * v
+			n := rnd.Float64()*32 - 16
+			img.Pix[img.PixOffset(x, y)+0] = clamp8(r + n)
+			img.Pix[img.PixOffset(x, y)+1] = clamp8(g + n)
+			img.Pix[img.PixOffset(x, y)+2] = clamp8(b + n)
+			img.Pix[img.PixOffset(x, y)+3] = 255
+		}
+	}
+
+	// a few solid rectangles give strong low frequency edges
+	for i := 0; i < 5; i++ {
+		x0, y0 := rnd.Intn(width), rnd.Intn(height)
+		x1, y1 := x0+rnd.Intn(width/3+1), y0+rnd.Intn(height/3+1)
+		c := color.RGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), 255}
+		for y := y0; y < y1 && y < height; y++ {
+			for x := x0; x < x1 && x < width; x++ {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+	return img
+}
+
+func clamp8(v float64) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// syntheticImages generates count images for each size in memory
+func syntheticImages(sizes []image.Point, count int) ([]string, map[string]decodedImage) {
+	var names []string
+	images := make(map[string]decodedImage)
+	for _, size := range sizes {
+		for i := 0; i < count; i++ {
+			name := fmt.Sprintf("synthetic-%dx%d-%d", size.X, size.Y, i)
+			seed := int64(size.X)*1000003 + int64(size.Y)*1009 + int64(i)
+			images[name] = decodedImage{
+				img:    generateImage(size.X, size.Y, seed),
+				format: "synthetic",
+			}
+			names = append(names, name)
+		}
+	}
+	return names, images
+}
+
+// writeSynthetic encodes images as PNGs into dir, returning the file paths
+func writeSynthetic(dir string, names []string, images map[string]decodedImage) ([]string, error) {
+	var paths []string
+	for _, name := range names {
+		p := filepath.Join(dir, name+".png")
+		f, err := os.Create(p)
+		if err != nil {
+			return nil, err
+		}
+		if err := png.Encode(f, images[name].img); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
@@ -0,0 +1,121 @@
+package gopdq
+
+import (
+	"math"
+	"sort"
+)
+
+// BitStats summarizes how the bits of a corpus of hashes are distributed. In
+// a healthy corpus every bit is set about half the time and bits are close to
+// uncorrelated; screenshots, memes with fixed borders and other near-duplicate
+// heavy corpora show up as biased or strongly correlated bits, and inflate
+// false-positive rates when matched against.
+type BitStats struct {
+	// N is the number of hashes counted
+	N int
+	// Set counts, for each bit, the hashes with that bit set
+	Set [256]int
+	// both counts, for each pair of bits i < j, the hashes with both set
+	both [256][256]int32
+}
+
+// BitPair is a pair of bits and the correlation between them
+type BitPair struct {
+	I, J        int
+	Correlation float64
+}
+
+// ComputeBitStats counts bit frequencies and co-occurrences across hashes
+func ComputeBitStats(hashes []*PdqHash256) *BitStats {
+	s := new(BitStats)
+	for _, h := range hashes {
+		s.Add(h)
+	}
+	return s
+}
+
+// Add counts one more hash
+func (s *BitStats) Add(h *PdqHash256) {
+	var set [256]int
+	n := 0
+	for k := 0; k < 256; k++ {
+		if h.w[k>>4]&(1<<(k&15)) != 0 {
+			set[n] = k
+			n++
+		}
+	}
+
+	s.N++
+	for a := 0; a < n; a++ {
+		i := set[a]
+		s.Set[i]++
+		for _, j := range set[a+1 : n] {
+			s.both[i][j]++
+		}
+	}
+}
+
+// Frequency returns the fraction of hashes with bit k set
+func (s *BitStats) Frequency(k int) float64 {
+	if s.N == 0 {
+		return 0
+	}
+	return float64(s.Set[k]) / float64(s.N)
+}
+
+// MeanBias is the mean distance of the bit frequencies from one half. PDQ's
+// median threshold keeps it near zero for varied images.
+func (s *BitStats) MeanBias() float64 {
+	var sum float64
+	for k := 0; k < 256; k++ {
+		sum += math.Abs(s.Frequency(k) - 0.5)
+	}
+	return sum / 256
+}
+
+// BiasedBits returns the bits whose frequency is more than tolerance away
+// from one half
+func (s *BitStats) BiasedBits(tolerance float64) []int {
+	var out []int
+	for k := 0; k < 256; k++ {
+		if math.Abs(s.Frequency(k)-0.5) > tolerance {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// Correlation returns the Pearson (phi) correlation between bits i and j, in
+// [-1, 1]. It is zero if either bit never varies.
+func (s *BitStats) Correlation(i, j int) float64 {
+	if i == j {
+		return 1
+	}
+	if i > j {
+		i, j = j, i
+	}
+	n := float64(s.N)
+	ni, nj := float64(s.Set[i]), float64(s.Set[j])
+	denom := math.Sqrt(ni * (n - ni) * nj * (n - nj))
+	if denom == 0 {
+		return 0
+	}
+	return (float64(s.both[i][j])*n - ni*nj) / denom
+}
+
+// CorrelatedPairs returns the pairs of bits whose correlation has magnitude
+// at least threshold, strongest first
+func (s *BitStats) CorrelatedPairs(threshold float64) []BitPair {
+	var out []BitPair
+	for i := 0; i < 256; i++ {
+		for j := i + 1; j < 256; j++ {
+			if c := s.Correlation(i, j); math.Abs(c) >= threshold {
+				out = append(out, BitPair{I: i, J: j, Correlation: c})
+			}
+		}
+	}
+	sort.Slice(out, func(a, b int) bool {
+		return math.Abs(out[a].Correlation) > math.Abs(out[b].Correlation)
+	})
+	return out
+}
@@ -0,0 +1,61 @@
+package gopdq
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestBitStats(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	var random []*PdqHash256
+	for i := 0; i < 2000; i++ {
+		h := NewPdqHash256()
+		for k := 0; k < 256; k++ {
+			if rng.Intn(2) == 1 {
+				h.SetBit(k)
+			}
+		}
+		random = append(random, h)
+	}
+
+	s := ComputeBitStats(random)
+	if s.N != 2000 {
+		t.Fatalf("counted %d hashes", s.N)
+	}
+	if b := s.MeanBias(); b > 0.03 {
+		t.Fatalf("random corpus has mean bias %v", b)
+	}
+	if pairs := s.CorrelatedPairs(0.2); len(pairs) != 0 {
+		t.Fatalf("random corpus has correlated bits: %v", pairs[0])
+	}
+
+	// a fixed border pins bit 0 on, and bit 5 always copies bit 7
+	var skewed []*PdqHash256
+	for _, h := range random {
+		h = h.Clone()
+		h.SetBit(0)
+		if h.w[0]&(1<<7) != 0 {
+			h.w[0] |= 1 << 5
+		} else {
+			h.w[0] &^= 1 << 5
+		}
+		skewed = append(skewed, h)
+	}
+
+	s = ComputeBitStats(skewed)
+	if f := s.Frequency(0); f != 1 {
+		t.Fatalf("bit 0 frequency %v", f)
+	}
+	if biased := s.BiasedBits(0.2); len(biased) != 1 || biased[0] != 0 {
+		t.Fatalf("unexpected biased bits %v", biased)
+	}
+	if c := s.Correlation(7, 5); math.Abs(c-1) > 1e-9 {
+		t.Fatalf("copied bit correlation %v", c)
+	}
+	pairs := s.CorrelatedPairs(0.5)
+	if len(pairs) != 1 || pairs[0].I != 5 || pairs[0].J != 7 {
+		t.Fatalf("unexpected correlated pairs %v", pairs)
+	}
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// Digest keys a cache entry: the SHA-256 of an image file's contents followed
+// by the ConfigKey of the hasher, so hashers configured to compute different
+// hashes don't share entries
+type Digest [sha256.Size]byte
+
+// NewDigest returns the digest of data hashed with h
+func NewDigest(data []byte, h *gopdq.PdqHasher) Digest {
+	s := sha256.New()
+	s.Write(data)
+	s.Write([]byte{0})
+	s.Write([]byte(h.ConfigKey()))
+	return Digest(s.Sum(nil))
+}
+
+// String returns the hex encoding of the digest
+func (d Digest) String() string {
+	return hex.EncodeToString(d[:])
+}
+
+// Cache memoizes PDQ results by content digest. Implementations must be safe
+// for concurrent use.
+type Cache interface {
+	// Get returns the cached result for d, if any
+	Get(d Digest) (*gopdq.HashResult, bool)
+	// Put stores the result for d
+	Put(d Digest, res *gopdq.HashResult) error
+}
+
+// Hasher wraps a PdqHasher so results are looked up in and stored to a Cache
+type Hasher struct {
+	Hasher *gopdq.PdqHasher
+	Cache  Cache
+}
+
+// NewHasher creates a caching hasher
+func NewHasher(h *gopdq.PdqHasher, c Cache) *Hasher {
+	return &Hasher{
+		Hasher: h,
+		Cache:  c,
+	}
+}
+
+// FromFile hashes the file at path, skipping decode when its digest is cached
+func (h *Hasher) FromFile(path string) (*gopdq.HashResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return h.FromBytes(data)
+}
+
+// FromReader reads r fully and hashes it, consulting the cache first
+func (h *Hasher) FromReader(r io.Reader) (*gopdq.HashResult, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return h.FromBytes(data)
+}
+
+// FromBytes hashes an encoded image, consulting the cache first
+func (h *Hasher) FromBytes(data []byte) (*gopdq.HashResult, error) {
+	d := NewDigest(data, h.Hasher)
+	if res, ok := h.Cache.Get(d); ok {
+		return res, nil
+	}
+
+	res, err := h.Hasher.FromReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.Cache.Put(d, res); err != nil {
+		return nil, fmt.Errorf("failed to store cache entry: %w", err)
+	}
+	return res, nil
+}
+
+// copyResult returns a result that shares no state with res
+func copyResult(res *gopdq.HashResult) *gopdq.HashResult {
+	out := *res
+	out.Hash = res.Hash.Clone()
+	if m := res.Stats.Margins; m != nil {
+		margins := *m
+		out.Stats.Margins = &margins
+	}
+	return &out
+}
@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"os"
+	"testing"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+func testCache(t *testing.T, c Cache) {
+	h := NewHasher(gopdq.NewPdqHasher(), c)
+
+	first, err := h.FromFile("../cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := h.FromFile("../cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Hash.Equal(second.Hash) || first.Quality != second.Quality {
+		t.Fatalf("cached result differs: %s/%d != %s/%d", second.Hash, second.Quality, first.Hash, first.Quality)
+	}
+
+	if _, ok := c.Get(Digest(sha256.Sum256([]byte("nope")))); ok {
+		t.Fatal("unexpected hit for unknown digest")
+	}
+
+	// a hasher computing different hashes doesn't get the first's entry
+	other := NewHasher(gopdq.NewPdqHasher(gopdq.WithWindowDivisor(32)), c)
+	want, err := other.Hasher.FromFile("../cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := other.FromFile("../cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Hash.Equal(want.Hash) || got.Hash.Equal(first.Hash) {
+		t.Fatalf("hasher with another window got %s, expected %s", got.Hash, want.Hash)
+	}
+}
+
+func TestCopyResult(t *testing.T) {
+	res := &gopdq.HashResult{
+		Hash:     gopdq.NewPdqHash256(),
+		Quality:  80,
+		Degraded: true,
+		Stats:    gopdq.HashStats{Decoder: "jpeg", Width: 10, Height: 20},
+	}
+	c := NewLRU(1)
+	if err := c.Put(Digest{}, res); err != nil {
+		t.Fatal(err)
+	}
+	res.Hash.SetBit(3)
+	got, _ := c.Get(Digest{})
+	if got.Hash.Equal(res.Hash) {
+		t.Fatal("cached hash shares its bits with the original")
+	}
+	got.Hash = res.Hash
+	if *got != *res {
+		t.Fatalf("cached result %+v, expected %+v", got, res)
+	}
+}
+
+func TestLRU(t *testing.T) {
+	testCache(t, NewLRU(10))
+
+	c := NewLRU(2)
+	res := &gopdq.HashResult{Hash: gopdq.NewPdqHash256()}
+	for i := 0; i < 3; i++ {
+		if err := c.Put(Digest{byte(i)}, res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", c.Len())
+	}
+	if _, ok := c.Get(Digest{0}); ok {
+		t.Fatal("expected oldest entry to be evicted")
+	}
+}
+
+func TestDisk(t *testing.T) {
+	c, err := NewDisk(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCache(t, c)
+
+	res := &gopdq.HashResult{Hash: gopdq.NewPdqHash256(), Quality: 40, Degraded: true}
+	res.Hash.SetBit(7)
+	if err := c.Put(Digest{1}, res); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := c.Get(Digest{1})
+	if !ok || !got.Hash.Equal(res.Hash) || got.Quality != res.Quality || !got.Degraded {
+		t.Fatalf("disk entry read back as %+v, expected %+v", got, res)
+	}
+
+	// entries from before the degraded flag was stored are misses
+	if err := os.WriteFile(c.path(Digest{1}), []byte(res.Hash.String()+" 40\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get(Digest{1}); ok {
+		t.Fatal("hit for an entry without the degraded flag")
+	}
+}
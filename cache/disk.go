@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// Disk is a Cache storing one small file per entry under a directory,
+// sharded by the first byte of the digest
+type Disk struct {
+	dir string
+}
+
+// NewDisk creates a disk cache rooted at dir, creating it if needed
+func NewDisk(dir string) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return &Disk{dir: dir}, nil
+}
+
+func (c *Disk) path(d Digest) string {
+	key := d.String()
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// Get returns the cached result for d. Unreadable or corrupt entries are
+// treated as misses, as are entries written before the degraded flag was
+// stored.
+func (c *Disk) Get(d Digest) (*gopdq.HashResult, bool) {
+	data, err := os.ReadFile(c.path(d))
+	if err != nil {
+		return nil, false
+	}
+
+	var hexHash string
+	var quality int
+	var degraded bool
+	if _, err := fmt.Sscanf(strings.TrimSpace(string(data)), "%s %d %t", &hexHash, &quality, &degraded); err != nil {
+		return nil, false
+	}
+	hash, err := gopdq.FromHexString(hexHash)
+	if err != nil {
+		return nil, false
+	}
+
+	return &gopdq.HashResult{
+		Hash:     hash,
+		Quality:  quality,
+		Degraded: degraded,
+	}, true
+}
+
+// Put writes the entry atomically so concurrent readers never see partial data
+func (c *Disk) Put(d Digest, res *gopdq.HashResult) error {
+	p := c.path(d)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := fmt.Fprintf(tmp, "%s %d %t\n", res.Hash.String(), res.Quality, res.Degraded); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// LRU is an in-memory Cache holding at most a fixed number of entries
+type LRU struct {
+	lk      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[Digest]*list.Element
+}
+
+type lruEntry struct {
+	key Digest
+	res *gopdq.HashResult
+}
+
+// NewLRU creates an LRU cache holding up to size entries
+func NewLRU(size int) *LRU {
+	return &LRU{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[Digest]*list.Element),
+	}
+}
+
+func (c *LRU) Get(d Digest) (*gopdq.HashResult, bool) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	el, ok := c.entries[d]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return copyResult(el.Value.(*lruEntry).res), true
+}
+
+func (c *LRU) Put(d Digest, res *gopdq.HashResult) error {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if el, ok := c.entries[d]; ok {
+		el.Value.(*lruEntry).res = copyResult(res)
+		c.order.MoveToFront(el)
+		return nil
+	}
+
+	c.entries[d] = c.order.PushFront(&lruEntry{key: d, res: copyResult(res)})
+	for c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.entries, last.Value.(*lruEntry).key)
+	}
+	return nil
+}
+
+// Len returns the number of cached entries
+func (c *LRU) Len() int {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.order.Len()
+}
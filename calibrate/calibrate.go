@@ -0,0 +1,218 @@
+// Package calibrate picks a distance threshold from evidence: given pairs
+// of hashes known to match or not, it computes precision and recall at
+// every threshold and recommends an operating point.
+//
+// Pairs can come from any labeled source; PairsFromCorpus derives them from
+// a corpus written by corpusgen, matching every variant with its original
+// and pairing it with every other original as a non-match.
+package calibrate
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+
+	"github.com/whyrusleeping/gopdq"
+	"github.com/whyrusleeping/gopdq/corpusgen"
+)
+
+// MaxDistance is the largest distance between two hashes
+const MaxDistance = 256
+
+// Pair is the distance between two hashes and whether they are labeled as
+// the same image
+type Pair struct {
+	Distance int
+	Match    bool
+}
+
+// Point is the confusion matrix at one threshold, counting pairs at or
+// below it as predicted matches, and the rates derived from it
+type Point struct {
+	Threshold int `json:"threshold"`
+
+	TruePositives  int `json:"tp"`
+	FalsePositives int `json:"fp"`
+	FalseNegatives int `json:"fn"`
+	TrueNegatives  int `json:"tn"`
+
+	// Precision is 1 where nothing is predicted to match
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+	// FalsePositiveRate is the fraction of non-matching pairs accepted
+	FalsePositiveRate float64 `json:"fpr"`
+}
+
+// Curve returns the point at every threshold from 0 to MaxDistance
+func Curve(pairs []Pair) []Point {
+	var match, nonMatch [MaxDistance + 1]int
+	positives, negatives := 0, 0
+	for _, p := range pairs {
+		d := min(max(p.Distance, 0), MaxDistance)
+		if p.Match {
+			match[d]++
+			positives++
+		} else {
+			nonMatch[d]++
+			negatives++
+		}
+	}
+
+	curve := make([]Point, MaxDistance+1)
+	tp, fp := 0, 0
+	for t := range curve {
+		tp += match[t]
+		fp += nonMatch[t]
+		p := Point{
+			Threshold:      t,
+			TruePositives:  tp,
+			FalsePositives: fp,
+			FalseNegatives: positives - tp,
+			TrueNegatives:  negatives - fp,
+			Precision:      1,
+		}
+		if tp+fp > 0 {
+			p.Precision = float64(tp) / float64(tp+fp)
+		}
+		if positives > 0 {
+			p.Recall = float64(tp) / float64(positives)
+		}
+		if p.Precision+p.Recall > 0 {
+			p.F1 = 2 * p.Precision * p.Recall / (p.Precision + p.Recall)
+		}
+		if negatives > 0 {
+			p.FalsePositiveRate = float64(fp) / float64(negatives)
+		}
+		curve[t] = p
+	}
+	return curve
+}
+
+// Recommend returns the point with the highest recall whose precision is at
+// least minPrecision, preferring the lowest threshold among equals. Points
+// accepting no matching pair don't count, however precise. If no threshold
+// qualifies it returns the point with the best F1 instead and false.
+func Recommend(curve []Point, minPrecision float64) (Point, bool) {
+	best, found := -1, false
+	for i, p := range curve {
+		if p.TruePositives > 0 && p.Precision >= minPrecision && (best < 0 || p.Recall > curve[best].Recall) {
+			best, found = i, true
+		}
+	}
+	if found {
+		return curve[best], true
+	}
+	for i, p := range curve {
+		if best < 0 || p.F1 > curve[best].F1 {
+			best = i
+		}
+	}
+	if best < 0 {
+		return Point{}, false
+	}
+	return curve[best], false
+}
+
+// PairsFromCorpus hashes the corpus written by corpusgen.Generate to dir.
+// Each variant makes a matching pair with its original and a non-matching
+// pair with every other original.
+func PairsFromCorpus(hasher *gopdq.PdqHasher, dir string, labels []corpusgen.Label) ([]Pair, error) {
+	originals := map[string]*gopdq.PdqHash256{}
+	var order []string
+	for _, l := range labels {
+		if _, ok := originals[l.Original]; ok {
+			continue
+		}
+		r, err := hasher.FromFile(l.Original)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", l.Original, err)
+		}
+		originals[l.Original] = r.Hash
+		order = append(order, l.Original)
+	}
+
+	var pairs []Pair
+	for _, l := range labels {
+		r, err := hasher.FromFile(filepath.Join(dir, filepath.FromSlash(l.Variant)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", l.Variant, err)
+		}
+		for _, o := range order {
+			pairs = append(pairs, Pair{Distance: r.Hash.HammingDistance(originals[o]), Match: o == l.Original})
+		}
+	}
+	return pairs, nil
+}
+
+// ReadPairs reads CSV records of two hashes and a label, such as
+// "<hash>,<hash>,true". Labels are anything strconv.ParseBool accepts. A
+// first line that doesn't parse is taken for a header and skipped.
+func ReadPairs(r io.Reader) ([]Pair, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 3
+	cr.TrimLeadingSpace = true
+	var pairs []Pair
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return pairs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		a, errA := gopdq.ParseHash(rec[0])
+		b, errB := gopdq.ParseHash(rec[1])
+		match, errM := strconv.ParseBool(rec[2])
+		if err := errors.Join(errA, errB, errM); err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		pairs = append(pairs, Pair{Distance: a.HammingDistance(b), Match: match})
+	}
+}
+
+// WriteCSV writes the curve with a header line
+func WriteCSV(w io.Writer, curve []Point) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"threshold", "tp", "fp", "fn", "tn", "precision", "recall", "f1", "fpr"})
+	for _, p := range curve {
+		cw.Write([]string{
+			strconv.Itoa(p.Threshold),
+			strconv.Itoa(p.TruePositives),
+			strconv.Itoa(p.FalsePositives),
+			strconv.Itoa(p.FalseNegatives),
+			strconv.Itoa(p.TrueNegatives),
+			strconv.FormatFloat(p.Precision, 'f', 6, 64),
+			strconv.FormatFloat(p.Recall, 'f', 6, 64),
+			strconv.FormatFloat(p.F1, 'f', 6, 64),
+			strconv.FormatFloat(p.FalsePositiveRate, 'f', 6, 64),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Report is the JSON form of a calibration
+type Report struct {
+	MinPrecision float64 `json:"min_precision"`
+	// Recommended is the operating point, and MetTarget whether it reaches
+	// MinPrecision or is only the best F1
+	Recommended Point   `json:"recommended"`
+	MetTarget   bool    `json:"met_target"`
+	Curve       []Point `json:"curve"`
+}
+
+// WriteJSON writes the curve and the point recommended for minPrecision
+func WriteJSON(w io.Writer, curve []Point, minPrecision float64) error {
+	rec, ok := Recommend(curve, minPrecision)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&Report{MinPrecision: minPrecision, Recommended: rec, MetTarget: ok, Curve: curve})
+}
@@ -0,0 +1,119 @@
+package calibrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/whyrusleeping/gopdq"
+	"github.com/whyrusleeping/gopdq/corpusgen"
+)
+
+func TestCurve(t *testing.T) {
+	pairs := []Pair{
+		{0, true}, {4, true}, {10, true}, {40, true},
+		{20, false}, {60, false}, {100, false}, {120, false},
+	}
+	curve := Curve(pairs)
+	if len(curve) != MaxDistance+1 {
+		t.Fatalf("curve has %d points", len(curve))
+	}
+
+	p := curve[10]
+	if p.TruePositives != 3 || p.FalsePositives != 0 || p.FalseNegatives != 1 || p.TrueNegatives != 4 {
+		t.Fatalf("threshold 10: %+v", p)
+	}
+	if p.Precision != 1 || p.Recall != 0.75 {
+		t.Fatalf("threshold 10: precision %v, recall %v", p.Precision, p.Recall)
+	}
+	p = curve[40]
+	if p.Precision != 0.8 || p.Recall != 1 || p.FalsePositiveRate != 0.25 {
+		t.Fatalf("threshold 40: %+v", p)
+	}
+	if p := curve[MaxDistance]; p.TruePositives != 4 || p.FalsePositives != 4 {
+		t.Fatalf("the last threshold should accept everything: %+v", p)
+	}
+
+	rec, ok := Recommend(curve, 0.99)
+	if !ok || rec.Threshold != 10 {
+		t.Fatalf("recommended %+v (%v), expected threshold 10", rec, ok)
+	}
+	rec, ok = Recommend(curve, 0.8)
+	if !ok || rec.Threshold != 40 {
+		t.Fatalf("recommended %+v (%v), expected threshold 40", rec, ok)
+	}
+
+	// a target nothing reaches falls back to the best F1
+	rec, ok = Recommend(Curve([]Pair{{5, false}, {7, true}}), 0.9)
+	if ok || rec.Threshold != 7 {
+		t.Fatalf("recommended %+v (%v), expected the F1 optimum at 7", rec, ok)
+	}
+}
+
+func TestReadPairs(t *testing.T) {
+	a, err := gopdq.FromHexString("02704e1ddd10f333c0e6df833130b07f99e36701383d333ac7c6078fe736dccc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := a.Fuzz(12)
+	in := "a,b,match\n" + a.String() + "," + b.String() + ",true\n" + a.String() + ", " + a.String() + ",0\n"
+	pairs, err := ReadPairs(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 2 || pairs[0] != (Pair{a.HammingDistance(b), true}) || pairs[1] != (Pair{0, false}) {
+		t.Fatalf("read %+v", pairs)
+	}
+
+	if _, err := ReadPairs(strings.NewReader(in + "zz,zz,true\n")); err == nil {
+		t.Fatal("expected an error for a bad line after the header")
+	}
+}
+
+func TestPairsFromCorpus(t *testing.T) {
+	dir := t.TempDir()
+	transforms := []corpusgen.Transform{
+		{Name: "jpeg-q70", Apply: corpusgen.JPEG(70)},
+		{Name: "flip-h", Apply: corpusgen.FlipHorizontal},
+	}
+	labels, err := corpusgen.Generate(dir, []string{"../cat.jpg", "../testdata/rgb.jpg"}, transforms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairs, err := PairsFromCorpus(gopdq.NewPdqHasher(), dir, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != len(labels)*2 {
+		t.Fatalf("%d pairs from %d variants of 2 originals", len(pairs), len(labels))
+	}
+	matches := 0
+	for _, p := range pairs {
+		if p.Match {
+			matches++
+		}
+	}
+	if matches != len(labels) {
+		t.Fatalf("%d matching pairs, expected %d", matches, len(labels))
+	}
+
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, Curve(pairs), 1); err != nil {
+		t.Fatal(err)
+	}
+	var rep Report
+	if err := json.Unmarshal(buf.Bytes(), &rep); err != nil {
+		t.Fatal(err)
+	}
+	if !rep.MetTarget || rep.Recommended.Recall < 0.5 || len(rep.Curve) != MaxDistance+1 {
+		t.Fatalf("recommended %+v", rep.Recommended)
+	}
+	buf.Reset()
+	if err := WriteCSV(&buf, rep.Curve); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buf.String(), "\n"); n != MaxDistance+2 {
+		t.Fatalf("CSV has %d lines", n)
+	}
+}
@@ -0,0 +1,187 @@
+// Package cluster groups near duplicate hashes as they arrive, for
+// ingestion pipelines that need a cluster id for each image right away
+// instead of from a periodic batch job.
+//
+// A Clusterer keeps one representative hash per cluster. Each hash joins
+// the cluster whose representative is nearest, if within the radius, and
+// otherwise starts a new one. A representative is the per-bit majority of
+// the cluster's members, updated with each, so it moves toward the
+// cluster's center as members arrive rather than staying wherever the first
+// one happened to lie.
+package cluster
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// Config configures a Clusterer
+type Config struct {
+	// Radius is the largest distance from a hash to a representative at
+	// which the hash joins that cluster. Zero joins only identical hashes;
+	// below zero takes the default 31, the usual PDQ match threshold.
+	Radius int
+	// FixedRepresentatives keeps each cluster's first member as its
+	// representative instead of updating it, so membership never depends
+	// on arrival order beyond the first member
+	FixedRepresentatives bool
+}
+
+// cluster is the state of one cluster
+type cluster struct {
+	rep    *gopdq.PdqHash256
+	size   int
+	counts [256]uint32 // members with each bit set
+}
+
+// Clusterer assigns hashes to clusters. Assignments are final: a cluster's
+// id never changes, and a hash is never moved once assigned, even if a
+// representative later drifts away from it. Lookups scan every
+// representative with the packed distance kernel, which keeps up with
+// ingestion for up to a few hundred thousand clusters. Its clusters live in
+// memory; Snapshot and Restore carry them across restarts. It is safe for
+// concurrent use.
+type Clusterer struct {
+	cfg Config
+
+	mu       sync.Mutex
+	clusters []*cluster
+	reps     *gopdq.PackedHashes
+}
+
+// New creates a Clusterer with no clusters
+func New(cfg Config) *Clusterer {
+	if cfg.Radius < 0 {
+		cfg.Radius = 31
+	}
+	return &Clusterer{cfg: cfg, reps: gopdq.PackHashes(nil)}
+}
+
+// State is the clusters of a Clusterer, for carrying them across a restart
+// so ids issued before it aren't issued again. It encodes as JSON.
+type State struct {
+	// Clusters holds each cluster in order of id
+	Clusters []ClusterState `json:"clusters"`
+}
+
+// ClusterState is the state of one cluster
+type ClusterState struct {
+	Representative *gopdq.PdqHash256 `json:"representative"`
+	Size           int               `json:"size"`
+	// BitCounts is how many members have each bit set, as numbered by
+	// PdqHash256.SetBit, which the representative's majority is kept from
+	BitCounts [256]uint32 `json:"bit_counts"`
+}
+
+// Snapshot returns a copy of the clusters as they are now
+func (c *Clusterer) Snapshot() *State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := &State{Clusters: make([]ClusterState, len(c.clusters))}
+	for i, cl := range c.clusters {
+		s.Clusters[i] = ClusterState{Representative: cl.rep.Clone(), Size: cl.size, BitCounts: cl.counts}
+	}
+	return s
+}
+
+// Restore creates a Clusterer holding the clusters of s, as returned by
+// Snapshot, so new clusters take ids after them. The configuration needn't
+// match the one s was taken under.
+func Restore(cfg Config, s *State) (*Clusterer, error) {
+	c := New(cfg)
+	for i, cs := range s.Clusters {
+		if cs.Representative == nil {
+			return nil, fmt.Errorf("cluster %d has no representative", i)
+		}
+		if cs.Size < 1 {
+			return nil, fmt.Errorf("cluster %d has %d members", i, cs.Size)
+		}
+		for k, n := range cs.BitCounts {
+			if int64(n) > int64(cs.Size) {
+				return nil, fmt.Errorf("cluster %d counts %d members with bit %d of %d", i, n, k, cs.Size)
+			}
+		}
+		cl := &cluster{rep: cs.Representative.Clone(), size: cs.Size, counts: cs.BitCounts}
+		c.clusters = append(c.clusters, cl)
+		c.reps.Append(cl.rep)
+	}
+	return c, nil
+}
+
+// AssignCluster adds h to the cluster with the nearest representative
+// within the radius, or to a new cluster, returning the cluster's id and
+// whether it is new. Ids count up from 0 in order of creation.
+func (c *Clusterer) AssignCluster(h *gopdq.PdqHash256) (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id, d := c.reps.Nearest(h)
+	if id < 0 || d > c.cfg.Radius {
+		id = len(c.clusters)
+		cl := &cluster{rep: h.Clone()}
+		c.clusters = append(c.clusters, cl)
+		c.reps.Append(cl.rep)
+		c.add(cl, h)
+		return uint64(id), true
+	}
+
+	cl := c.clusters[id]
+	if c.add(cl, h) {
+		c.reps.Set(id, cl.rep)
+	}
+	return uint64(id), false
+}
+
+// add counts h as a member of cl, reporting whether its representative
+// changed. A bit is set once more than half the members have it and
+// cleared once fewer than half do; on a tie it keeps its value, so a
+// second member doesn't clear every bit the two disagree on.
+func (c *Clusterer) add(cl *cluster, h *gopdq.PdqHash256) bool {
+	cl.size++
+	changed := false
+	for k := 0; k < 256; k++ {
+		row, col := k/16, k%16
+		if h.Bit(row, col) {
+			cl.counts[k]++
+		}
+		if c.cfg.FixedRepresentatives {
+			continue
+		}
+		n := 2 * int(cl.counts[k])
+		if want := cl.rep.Bit(row, col); n > cl.size && !want || n < cl.size && want {
+			cl.rep.FlipBit(k)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Len returns the number of clusters
+func (c *Clusterer) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.clusters)
+}
+
+// Representative returns a copy of the representative of cluster id, or
+// nil if there is no such cluster
+func (c *Clusterer) Representative(id uint64) *gopdq.PdqHash256 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id >= uint64(len(c.clusters)) {
+		return nil
+	}
+	return c.clusters[id].rep.Clone()
+}
+
+// Size returns the number of hashes assigned to cluster id
+func (c *Clusterer) Size(id uint64) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id >= uint64(len(c.clusters)) {
+		return 0
+	}
+	return c.clusters[id].size
+}
@@ -0,0 +1,145 @@
+package cluster
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+func randomHash(rng *rand.Rand) *gopdq.PdqHash256 {
+	h := gopdq.NewPdqHash256()
+	for k := 0; k < 256; k++ {
+		if rng.Intn(2) == 1 {
+			h.SetBit(k)
+		}
+	}
+	return h
+}
+
+func flipped(rng *rand.Rand, h *gopdq.PdqHash256, n int) *gopdq.PdqHash256 {
+	h = h.Clone()
+	for _, k := range rng.Perm(256)[:n] {
+		h.FlipBit(k)
+	}
+	return h
+}
+
+func TestAssignCluster(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	seeds := make([]*gopdq.PdqHash256, 20)
+	for i := range seeds {
+		seeds[i] = randomHash(rng)
+	}
+
+	for _, fixed := range []bool{false, true} {
+		c := New(Config{Radius: -1, FixedRepresentatives: fixed})
+		ids := make(map[int]uint64)
+		for n := 0; n < 30; n++ {
+			for i, s := range seeds {
+				id, isNew := c.AssignCluster(flipped(rng, s, 12))
+				if want, seen := ids[i]; seen && (isNew || id != want) {
+					t.Fatalf("fixed %v: copy %d of seed %d went to cluster %d (new %v), expected %d", fixed, n, i, id, isNew, want)
+				} else if !seen && !isNew {
+					t.Fatalf("fixed %v: first copy of seed %d joined cluster %d", fixed, i, id)
+				}
+				ids[i] = id
+			}
+		}
+		if c.Len() != len(seeds) {
+			t.Fatalf("fixed %v: %d clusters, expected %d", fixed, c.Len(), len(seeds))
+		}
+
+		for i, s := range seeds {
+			id := ids[i]
+			if c.Size(id) != 30 {
+				t.Errorf("fixed %v: cluster %d has %d members", fixed, id, c.Size(id))
+			}
+			// the majority of 30 copies recovers the seed, where the first
+			// copy is 12 bits off
+			d := c.Representative(id).HammingDistance(s)
+			if fixed && d != 12 || !fixed && d > 2 {
+				t.Errorf("fixed %v: representative of cluster %d is %d bits from its seed", fixed, id, d)
+			}
+		}
+		if c.Representative(uint64(len(seeds))) != nil || c.Size(uint64(len(seeds))) != 0 {
+			t.Errorf("fixed %v: expected no cluster %d", fixed, len(seeds))
+		}
+	}
+}
+
+func TestRadius(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	base := randomHash(rng)
+	c := New(Config{Radius: 10})
+	c.AssignCluster(base)
+	if id, isNew := c.AssignCluster(flipped(rng, base, 10)); isNew || id != 0 {
+		t.Errorf("hash 10 bits away started cluster %d", id)
+	}
+	if id, isNew := c.AssignCluster(flipped(rng, base, 40)); !isNew || id != 1 {
+		t.Errorf("hash 40 bits away joined cluster %d", id)
+	}
+
+	exact := New(Config{})
+	exact.AssignCluster(base)
+	if id, isNew := exact.AssignCluster(base.Clone()); isNew || id != 0 {
+		t.Errorf("radius 0: identical hash went to cluster %d (new %v)", id, isNew)
+	}
+	if id, isNew := exact.AssignCluster(flipped(rng, base, 1)); !isNew || id != 1 {
+		t.Errorf("radius 0: hash 1 bit away joined cluster %d", id)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	seeds := []*gopdq.PdqHash256{randomHash(rng), randomHash(rng)}
+	c := New(Config{Radius: -1})
+	for n := 0; n < 5; n++ {
+		for _, s := range seeds {
+			c.AssignCluster(flipped(rng, s, 12))
+		}
+	}
+
+	body, err := json.Marshal(c.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state State
+	if err := json.Unmarshal(body, &state); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Restore(Config{Radius: -1}, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != 2 {
+		t.Fatalf("restored %d clusters", r.Len())
+	}
+	for id := uint64(0); id < 2; id++ {
+		if r.Size(id) != 5 || !r.Representative(id).Equal(c.Representative(id)) {
+			t.Errorf("cluster %d restored with %d members, representative %v, expected %v", id, r.Size(id), r.Representative(id), c.Representative(id))
+		}
+	}
+
+	// both go on in step after the restore, and new ids follow the old
+	for _, h := range []*gopdq.PdqHash256{flipped(rng, seeds[1], 12), randomHash(rng)} {
+		id, isNew := c.AssignCluster(h)
+		rid, risNew := r.AssignCluster(h)
+		if id != rid || isNew != risNew {
+			t.Errorf("restored clusterer assigned %d (new %v), expected %d (new %v)", rid, risNew, id, isNew)
+		}
+	}
+	if r.Len() != 3 || !r.Representative(1).Equal(c.Representative(1)) {
+		t.Errorf("restored clusterer diverged: %d clusters", r.Len())
+	}
+
+	bad := c.Snapshot()
+	bad.Clusters[0].BitCounts[7] = 100
+	if _, err := Restore(Config{}, bad); err == nil {
+		t.Error("restored a cluster counting more members than it has")
+	}
+	if _, err := Restore(Config{}, &State{Clusters: []ClusterState{{Size: 1}}}); err == nil {
+		t.Error("restored a cluster without a representative")
+	}
+}
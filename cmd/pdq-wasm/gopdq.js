@@ -0,0 +1,32 @@
+// gopdq.js loads gopdq.wasm, the WebAssembly build of ./cmd/pdq-wasm, and
+// exposes its hasher. wasm_exec.js from the same Go release must be loaded
+// first, as it defines the Go class used to run the module.
+//
+//   import { load } from "./gopdq.js";
+//   const pdq = await load();
+//   const { hash, quality } = pdq.hashImageData(ctx.getImageData(0, 0, w, h));
+
+export async function load(wasmURL = new URL("gopdq.wasm", import.meta.url)) {
+  if (typeof globalThis.Go !== "function") {
+    throw new Error("gopdq: load wasm_exec.js before gopdq.js");
+  }
+  const go = new Go();
+  const { instance } = await WebAssembly.instantiateStreaming(fetch(wasmURL), go.importObject);
+  // run only returns when the Go program exits, which it doesn't; main has
+  // registered globalThis.gopdq by the time run yields
+  go.run(instance);
+  const api = globalThis.gopdq;
+
+  return {
+    // hashImageData hashes the pixels of an ImageData, such as one from
+    // CanvasRenderingContext2D.getImageData, returning the hash as 64 hex
+    // digits and its quality from 0 to 100
+    hashImageData(imageData) {
+      const res = api.hashImageData(imageData);
+      if (res.error) {
+        throw new Error("gopdq: " + res.error);
+      }
+      return { hash: res.hash, quality: res.quality };
+    },
+  };
+}
@@ -0,0 +1,58 @@
+//go:build js && wasm
+
+// Command pdq-wasm is the WebAssembly build of the hasher, for hashing
+// images in the browser before they are uploaded. Build it with
+//
+//	GOOS=js GOARCH=wasm go build -o gopdq.wasm ./cmd/pdq-wasm
+//
+// and load it through gopdq.js, next to the wasm_exec.js shipped with Go in
+// $(go env GOROOT)/lib/wasm. Only the pure Go decoders are available, but
+// hashImageData takes pixels the browser has already decoded.
+package main
+
+import (
+	"image"
+	"syscall/js"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+func main() {
+	js.Global().Set("gopdq", js.ValueOf(map[string]any{
+		"hashImageData": js.FuncOf(hashImageData),
+	}))
+	select {}
+}
+
+// hashImageData hashes an ImageData, or anything with its width, height and
+// data fields, returning {hash, quality} or {error}
+func hashImageData(this js.Value, args []js.Value) any {
+	if len(args) != 1 || args[0].Type() != js.TypeObject {
+		return errorResult("hashImageData takes one ImageData")
+	}
+	v := args[0]
+	w, h, data := v.Get("width"), v.Get("height"), v.Get("data")
+	if w.Type() != js.TypeNumber || h.Type() != js.TypeNumber || data.Type() != js.TypeObject {
+		return errorResult("argument is not an ImageData")
+	}
+	width, height := w.Int(), h.Int()
+	if width <= 0 || height <= 0 || data.Get("length").Int() != width*height*4 {
+		return errorResult("ImageData size doesn't match its data")
+	}
+
+	// ImageData holds non-premultiplied RGBA rows, as NRGBA does
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	js.CopyBytesToGo(img.Pix, data)
+	res, err := gopdq.NewPdqHasher().HashImage(img)
+	if err != nil {
+		return errorResult(err.Error())
+	}
+	return js.ValueOf(map[string]any{
+		"hash":    res.Hash.String(),
+		"quality": res.Quality,
+	})
+}
+
+func errorResult(msg string) js.Value {
+	return js.ValueOf(map[string]any{"error": msg})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/whyrusleeping/gopdq"
+	"github.com/whyrusleeping/gopdq/calibrate"
+	"github.com/whyrusleeping/gopdq/corpusgen"
+)
+
+func runCalibrate(args []string) int {
+	fs := flag.NewFlagSet("calibrate", flag.ExitOnError)
+	corpus := fs.String("corpus", "", "corpus directory written by gen-corpus to take pairs from")
+	minPrecision := fs.Float64("min-precision", 0.99, "precision the recommended threshold must reach")
+	format := fs.String("format", "csv", "format of the curve written to stdout: csv or json")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pdq calibrate [flags] [pairs.csv]...\n\n")
+		fmt.Fprintf(os.Stderr, "Computes precision and recall at every distance threshold over labeled pairs,\n")
+		fmt.Fprintf(os.Stderr, "given as CSV lines of <hash>,<hash>,<true|false> or by -corpus, and\n")
+		fmt.Fprintf(os.Stderr, "recommends the threshold with the best recall at min-precision.\n\n")
+		fs.PrintDefaults()
+	}
+	files := parseInterspersed(fs, args)
+	if (len(files) == 0 && *corpus == "") || (*format != "csv" && *format != "json") {
+		fs.Usage()
+		return 1
+	}
+
+	var pairs []calibrate.Pair
+	if *corpus != "" {
+		f, err := os.Open(filepath.Join(*corpus, corpusgen.LabelFile))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		labels, err := corpusgen.ReadLabels(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		p, err := calibrate.PairsFromCorpus(gopdq.NewPdqHasher(), *corpus, labels)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		pairs = append(pairs, p...)
+	}
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		p, err := calibrate.ReadPairs(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			return 1
+		}
+		pairs = append(pairs, p...)
+	}
+
+	curve := calibrate.Curve(pairs)
+	var err error
+	if *format == "json" {
+		err = calibrate.WriteJSON(os.Stdout, curve, *minPrecision)
+	} else {
+		err = calibrate.WriteCSV(os.Stdout, curve)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	p, ok := calibrate.Recommend(curve, *minPrecision)
+	fmt.Fprintf(os.Stderr, "%d pairs\n", len(pairs))
+	if !ok {
+		fmt.Fprintf(os.Stderr, "no threshold reaches precision %g; the best F1 is at ", *minPrecision)
+	} else {
+		fmt.Fprintf(os.Stderr, "recommended threshold: ")
+	}
+	fmt.Fprintf(os.Stderr, "%d (precision %.4f, recall %.4f, false positive rate %.4f)\n",
+		p.Threshold, p.Precision, p.Recall, p.FalsePositiveRate)
+	return 0
+}
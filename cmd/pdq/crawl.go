@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/whyrusleeping/gopdq/crawl"
+	"github.com/whyrusleeping/gopdq/worker"
+)
+
+func runCrawl(args []string) int {
+	fs := flag.NewFlagSet("crawl", flag.ExitOnError)
+	state := fs.String("state", "", "crawl state file, created if missing (required)")
+	out := fs.String("o", "", "file to append results to as JSON lines, instead of stdout")
+	list := fs.String("list", "", "file of paths or http(s) URLs to hash, one per line")
+	concurrency := fs.Int("concurrency", runtime.NumCPU(), "images hashed at once")
+	retryFailed := fs.Bool("retry-failed", false, "hash the refs that failed in earlier runs again")
+	status := fs.Bool("status", false, "print how many refs are pending, done and failed, and exit")
+	export := fs.Bool("export", false, "write every result recorded so far, and exit")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pdq crawl -state <file> [flags] [dir...]\n\n")
+		fmt.Fprintf(os.Stderr, "Hashes every image under each dir and every ref in -list, recording progress\n")
+		fmt.Fprintf(os.Stderr, "in the state file. Run it again with the same state file after a crash or\n")
+		fmt.Fprintf(os.Stderr, "interrupt to carry on where it stopped.\n\n")
+		fs.PrintDefaults()
+	}
+	dirs := parseInterspersed(fs, args)
+	if *state == "" {
+		fs.Usage()
+		return 1
+	}
+
+	c, err := crawl.Open(*state)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer c.Close()
+
+	if *status {
+		stats, err := c.Stats()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("%d pending, %d done, %d failed\n", stats.Pending, stats.Done, stats.Failed)
+		return 0
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		if *export {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		}
+		f, err := os.OpenFile(*out, flags, 0o644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	results := &resultWriter{w: bufio.NewWriter(w)}
+
+	if *export {
+		err := c.Results(func(res *worker.Result) error {
+			return results.Publish(context.Background(), res)
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	added := 0
+	for _, dir := range dirs {
+		n, err := c.AddDir(ctx, dir, func(p string) bool {
+			return imageExts[strings.ToLower(filepath.Ext(p))]
+		})
+		added += n
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if *list != "" {
+		n, err := addRefList(c, *list)
+		added += n
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if *retryFailed {
+		n, err := c.RetryFailed()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "retrying %d failed refs\n", n)
+	}
+	if added > 0 {
+		fmt.Fprintf(os.Stderr, "queued %d new refs\n", added)
+	}
+
+	err = c.Run(ctx, worker.Config{
+		Results:     results,
+		Concurrency: *concurrency,
+	})
+	stats, serr := c.Stats()
+	if serr == nil {
+		fmt.Fprintf(os.Stderr, "%d hashed this run; %d pending, %d done, %d failed\n",
+			results.n, stats.Pending, stats.Done, stats.Failed)
+	}
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Fprintln(os.Stderr, "interrupted, run again with the same -state to resume")
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return 1
+	}
+	return 0
+}
+
+// addRefList queues the refs listed in the file at path, skipping blank
+// lines
+func addRefList(c *crawl.Crawl, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	added := 0
+	var batch []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if ref := strings.TrimSpace(sc.Text()); ref != "" {
+			batch = append(batch, ref)
+		}
+		if len(batch) == 1000 {
+			n, err := c.Add(batch...)
+			added += n
+			if err != nil {
+				return added, err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return added, err
+	}
+	n, err := c.Add(batch...)
+	return added + n, err
+}
+
+// resultWriter writes results as JSON lines, flushing each so a crash loses
+// none that the crawl has recorded as done
+type resultWriter struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+	n  int
+}
+
+func (rw *resultWriter) Publish(ctx context.Context, res *worker.Result) error {
+	line, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.w.Write(line)
+	rw.w.WriteByte('\n')
+	rw.n++
+	return rw.w.Flush()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// pairDistance is the comparison of two hashes. transform is the dihedral
+// estimate of b that was closest to a, Original unless -dihedral is set.
+type pairDistance struct {
+	a, b      *gopdq.PdqHash256
+	distance  int
+	transform gopdq.Dihedral
+}
+
+func (p pairDistance) similarity() float64 {
+	return 1 - float64(p.distance)/256
+}
+
+func comparePair(a, b *gopdq.PdqHash256, dihedral bool) pairDistance {
+	p := pairDistance{a: a, b: b}
+	if dihedral {
+		p.distance, p.transform = a.DihedralDistance(b)
+	} else {
+		p.distance = a.HammingDistance(b)
+	}
+	return p
+}
+
+func runDistance(args []string) int {
+	fs := flag.NewFlagSet("distance", flag.ExitOnError)
+	file := fs.String("file", "", "CSV file of hash pairs, one pair in the first two columns of each line")
+	dihedral := fs.Bool("dihedral", false, "compare against rotations and flips of the second hash too, reporting the closest")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pdq distance [flags] <hash-a> <hash-b>\n")
+		fmt.Fprintf(os.Stderr, "       pdq distance [flags] -file <pairs.csv>\n\n")
+		fmt.Fprintf(os.Stderr, "Prints the Hamming distance between hashes and their similarity, the\n")
+		fmt.Fprintf(os.Stderr, "fraction of the 256 bits that agree. Given a file, prints CSV.\n\n")
+		fs.PrintDefaults()
+	}
+	pos := parseInterspersed(fs, args)
+
+	if *file == "" {
+		if len(pos) != 2 {
+			fs.Usage()
+			return 1
+		}
+		a, errA := gopdq.ParseHash(pos[0])
+		b, errB := gopdq.ParseHash(pos[1])
+		if err := errors.Join(errA, errB); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		p := comparePair(a, b, *dihedral)
+		fmt.Printf("distance %d, similarity %.4f", p.distance, p.similarity())
+		if *dihedral {
+			fmt.Printf(", closest as %s", p.transform)
+		}
+		fmt.Println()
+		return 0
+	}
+
+	if len(pos) != 0 {
+		fs.Usage()
+		return 1
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+	pairs, err := readHashPairs(f, *dihedral)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *file, err)
+		return 1
+	}
+	if err := writeDistancesCSV(os.Stdout, pairs, *dihedral); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// readHashPairs compares the hashes in the first two columns of every line
+// of a CSV file. Further columns are ignored, and so is a first line that
+// doesn't parse, taking it for a header.
+func readHashPairs(r io.Reader, dihedral bool) ([]pairDistance, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	var pairs []pairDistance
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return pairs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 2 {
+			return nil, fmt.Errorf("line %d: expected two hashes, got %d fields", line, len(rec))
+		}
+		a, errA := gopdq.ParseHash(rec[0])
+		b, errB := gopdq.ParseHash(rec[1])
+		if err := errors.Join(errA, errB); err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		pairs = append(pairs, comparePair(a, b, dihedral))
+	}
+}
+
+func writeDistancesCSV(w io.Writer, pairs []pairDistance, dihedral bool) error {
+	cw := csv.NewWriter(w)
+	header := []string{"hash_a", "hash_b", "distance", "similarity"}
+	if dihedral {
+		header = append(header, "transform")
+	}
+	cw.Write(header)
+	for _, p := range pairs {
+		rec := []string{p.a.String(), p.b.String(), strconv.Itoa(p.distance), strconv.FormatFloat(p.similarity(), 'f', 4, 64)}
+		if dihedral {
+			rec = append(rec, p.transform.String())
+		}
+		cw.Write(rec)
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/whyrusleeping/gopdq/corpusgen"
+)
+
+func runGenCorpus(args []string) int {
+	fs := flag.NewFlagSet("gen-corpus", flag.ExitOnError)
+	out := fs.String("o", "", "directory to write the variants and "+corpusgen.LabelFile+" to")
+	only := fs.String("transforms", "", "comma separated transforms to apply, instead of all of them")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pdq gen-corpus [flags] <seed image|dir>... -o <dir>\n\n")
+		fmt.Fprintf(os.Stderr, "Writes transformed variants of every seed image, with a label file mapping\nthem to their originals. Transforms:\n\n")
+		var names []string
+		for _, t := range corpusgen.Default() {
+			names = append(names, t.Name)
+		}
+		fmt.Fprintf(os.Stderr, "  %s\n\n", strings.Join(names, " "))
+		fs.PrintDefaults()
+	}
+	args = parseInterspersed(fs, args)
+	if len(args) == 0 || *out == "" {
+		fs.Usage()
+		return 1
+	}
+
+	transforms := corpusgen.Default()
+	if *only != "" {
+		byName := map[string]corpusgen.Transform{}
+		for _, t := range transforms {
+			byName[t.Name] = t
+		}
+		transforms = nil
+		for _, name := range strings.Split(*only, ",") {
+			t, ok := byName[strings.TrimSpace(name)]
+			if !ok {
+				fmt.Fprintf(os.Stderr, "unknown transform %q\n", name)
+				return 1
+			}
+			transforms = append(transforms, t)
+		}
+	}
+
+	var seeds []string
+	for _, arg := range args {
+		st, err := os.Stat(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if !st.IsDir() {
+			seeds = append(seeds, arg)
+			continue
+		}
+		paths, err := listImages(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		seeds = append(seeds, paths...)
+	}
+
+	labels, err := corpusgen.Generate(*out, seeds, transforms)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d variants of %d seeds to %s\n", len(labels), len(seeds), *out)
+	return 0
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/whyrusleeping/gopdq"
+	"github.com/whyrusleeping/gopdq/conformance"
+)
+
+func runGolden(args []string) int {
+	if len(args) > 0 && args[0] == "verify" {
+		return runGoldenVerify(args[1:])
+	}
+
+	fs := flag.NewFlagSet("golden", flag.ExitOnError)
+	out := fs.String("o", "", "golden file to write, instead of stdout")
+	modeName := fs.String("mode", "fast", "hashing pipeline, fast or reference-exact")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pdq golden [flags] <dir>\n")
+		fmt.Fprintf(os.Stderr, "       pdq golden verify [flags] <golden.csv>\n\n")
+		fmt.Fprintf(os.Stderr, "Hashes every image under dir into a golden file recording the hash, quality\n")
+		fmt.Fprintf(os.Stderr, "and path of each, headed by the file format version, pipeline and build.\n")
+		fmt.Fprintf(os.Stderr, "Paths are relative to the golden file's directory. verify hashes the images\n")
+		fmt.Fprintf(os.Stderr, "again and fails if any has drifted.\n\n")
+		fs.PrintDefaults()
+	}
+	pos := parseInterspersed(fs, args)
+	if len(pos) != 1 {
+		fs.Usage()
+		return 1
+	}
+	mode, err := gopdq.ParseMode(*modeName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	paths, err := listImages(pos[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(paths) == 0 {
+		fmt.Fprintf(os.Stderr, "no images under %s\n", pos[0])
+		return 1
+	}
+
+	corpus := &conformance.Corpus{Meta: conformance.CurrentMeta(mode)}
+	hasher := gopdq.NewPdqHasher(gopdq.WithMode(mode))
+	failed := 0
+	for _, br := range hasher.HashFiles(context.Background(), paths, gopdq.Limits{}) {
+		if br.Err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", br.Path, br.Err)
+			failed++
+			continue
+		}
+		corpus.Entries = append(corpus.Entries, conformance.Expected{
+			Path:    br.Path,
+			Hash:    br.Result.Hash,
+			Quality: br.Result.Quality,
+		})
+	}
+
+	w, dir := io.Writer(os.Stdout), "."
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		w, dir = f, filepath.Dir(*out)
+	}
+	if err := corpus.Write(w, dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "%d images recorded, %d failed\n", len(corpus.Entries), failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runGoldenVerify(args []string) int {
+	fs := flag.NewFlagSet("golden verify", flag.ExitOnError)
+	maxBits := fs.Int("max-bits", 0, "bits an image's hash may differ by before it counts as drifted")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pdq golden verify [flags] <golden.csv>\n\n")
+		fmt.Fprintf(os.Stderr, "Hashes the images of a golden file with the pipeline it records and lists\n")
+		fmt.Fprintf(os.Stderr, "those whose hash or quality changed. Exits 1 on any drift.\n\n")
+		fs.PrintDefaults()
+	}
+	pos := parseInterspersed(fs, args)
+	if len(pos) != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	corpus, err := conformance.LoadGolden(pos[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	mode := gopdq.Fast
+	if corpus.Meta != nil {
+		mode = corpus.Meta.Mode
+		if diffs := corpus.Meta.BuildDifferences(conformance.CurrentMeta(mode)); len(diffs) > 0 {
+			fmt.Fprintf(os.Stderr, "warning: recorded with a different build (%s), so hashes may differ without drift\n",
+				strings.Join(diffs, "; "))
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, "warning: no gopdq-golden header, verifying with the fast pipeline")
+	}
+
+	rep := conformance.Check(gopdq.NewPdqHasher(gopdq.WithMode(mode)), corpus)
+	drifted := 0
+	for _, ir := range rep.Images {
+		switch {
+		case ir.Err != nil:
+			fmt.Printf("%s: %v\n", ir.Expected.Path, ir.Err)
+		case ir.BitDiff > *maxBits || (*maxBits == 0 && ir.QualityDiff != 0):
+			fmt.Printf("%s: %d bits differ, quality %d -> %d\n", ir.Expected.Path, ir.BitDiff, ir.Expected.Quality, ir.Quality)
+			drifted++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d images checked with %s: %d exact, %d drifted, %d failed, mean %.2f bits\n",
+		len(rep.Images), mode, rep.Stats.Exact, drifted, rep.Stats.Failed, rep.Stats.AvgBits())
+	if drifted > 0 || rep.Stats.Failed > 0 {
+		return 1
+	}
+	return 0
+}
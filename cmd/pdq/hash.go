@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+func runHash(args []string) int {
+	fs := flag.NewFlagSet("hash", flag.ExitOnError)
+	modeName := fs.String("mode", "fast", "hashing pipeline, fast or reference-exact")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pdq hash [flags] <image>...\n\n")
+		fmt.Fprintf(os.Stderr, "Prints a hash,quality,path line for each image, as the C++ reference's\n")
+		fmt.Fprintf(os.Stderr, "pdq-photo-hasher does, so the two outputs can be compared directly.\n\n")
+		fs.PrintDefaults()
+	}
+	pos := parseInterspersed(fs, args)
+	if len(pos) == 0 {
+		fs.Usage()
+		return 1
+	}
+	mode, err := gopdq.ParseMode(*modeName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	hasher := gopdq.NewPdqHasher(gopdq.WithMode(mode))
+	status := 0
+	for _, br := range hasher.HashFiles(context.Background(), pos, gopdq.Limits{}) {
+		if br.Err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", br.Path, br.Err)
+			status = 1
+			continue
+		}
+		fmt.Fprintf(out, "%s,%d,%s\n", br.Result.Hash, br.Result.Quality, br.Path)
+	}
+	return status
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/whyrusleeping/gopdq"
+	"github.com/whyrusleeping/gopdq/index"
+	"github.com/whyrusleeping/gopdq/index/boltstore"
+)
+
+var indexCommands = []command{
+	{"build", "add the hashes in a hash list to an index file", runIndexBuild},
+	{"query", "list the entries of an index file near a hash or image", runIndexQuery},
+	{"merge", "combine index files into a new one", runIndexMerge},
+	{"compact", "rewrite an index file without the space of deleted entries", runIndexCompact},
+}
+
+func runIndex(args []string) int {
+	if len(args) > 0 {
+		for _, c := range indexCommands {
+			if c.name == args[0] {
+				return c.run(args[1:])
+			}
+		}
+		if args[0] != "help" && args[0] != "-h" && args[0] != "--help" {
+			fmt.Fprintf(os.Stderr, "unknown index command %q\n\n", args[0])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Usage: pdq index <command> [flags] [args]\n\nCommands:\n")
+	for _, c := range indexCommands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", c.name, c.usage)
+	}
+	return 1
+}
+
+// parseInterspersed parses args with fs, allowing flags after positional
+// arguments as in "build hashes.txt -o corpus.idx", and returns the
+// positional ones
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var pos []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return pos
+		}
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func runIndexBuild(args []string) int {
+	fs := flag.NewFlagSet("index build", flag.ExitOnError)
+	out := fs.String("o", "", "index file to create or add to")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pdq index build [flags] <hashes.txt>... -o <corpus.idx>\n\n")
+		fmt.Fprintf(os.Stderr, "Adds every hash in the hash lists, in any form gopdq.ReadTaggedHashes reads, to the index.\n\n")
+		fs.PrintDefaults()
+	}
+	lists := parseInterspersed(fs, args)
+	if len(lists) == 0 || *out == "" {
+		fs.Usage()
+		return 1
+	}
+
+	store, err := boltstore.Open(*out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	ix := index.New(store)
+	defer ix.Close()
+
+	added := 0
+	for _, path := range lists {
+		n, err := addList(ix, path)
+		added += n
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	total, err := ix.Len()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "added %d hashes to %s, which now holds %d\n", added, *out, total)
+	return 0
+}
+
+func addList(ix *index.Index, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	hashes, err := gopdq.ReadTaggedHashes(f)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, t := range hashes {
+		if t.Source == "" {
+			t.Source = path
+		}
+		if _, err := ix.Add(t); err != nil {
+			return i, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return len(hashes), nil
+}
+
+func runIndexQuery(args []string) int {
+	fs := flag.NewFlagSet("index query", flag.ExitOnError)
+	maxDistance := fs.Int("max-distance", 31, "largest distance of the entries listed")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pdq index query [flags] <corpus.idx> <hash|image>...\n\n")
+		fmt.Fprintf(os.Stderr, "Lists the entries within max-distance of each query, nearest first.\n")
+		fmt.Fprintf(os.Stderr, "A query that doesn't parse as a hash is hashed as an image file.\n\n")
+		fs.PrintDefaults()
+	}
+	pos := parseInterspersed(fs, args)
+	if len(pos) < 2 {
+		fs.Usage()
+		return 1
+	}
+
+	store, err := boltstore.OpenReadOnly(pos[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	ix := index.New(store)
+	defer ix.Close()
+
+	hasher := gopdq.NewPdqHasher()
+	status := 0
+	for _, q := range pos[1:] {
+		h, err := queryHash(hasher, q)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", q, err)
+			status = 1
+			continue
+		}
+		matches, err := ix.Query(h, *maxDistance)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		printIndexMatches(os.Stdout, q, h, matches)
+	}
+	return status
+}
+
+// queryHash parses q as a hash, or failing that hashes it as an image file
+func queryHash(hasher *gopdq.PdqHasher, q string) (*gopdq.PdqHash256, error) {
+	if h, err := gopdq.ParseHash(q); err == nil {
+		return h, nil
+	}
+	res, err := hasher.FromFile(q)
+	if err != nil {
+		return nil, err
+	}
+	return res.Hash, nil
+}
+
+func printIndexMatches(w io.Writer, q string, h *gopdq.PdqHash256, matches []index.Match) {
+	fmt.Fprintf(w, "%s (%s): %d matches\n", q, h, len(matches))
+	if len(matches) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DISTANCE\tHASH\tQUALITY\tID\tSOURCE\t")
+	for _, m := range matches {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\t\n", m.Distance, m.Entry.Hash, m.Entry.Quality, m.Entry.ID, m.Entry.Source)
+	}
+	tw.Flush()
+}
+
+func runIndexMerge(args []string) int {
+	fs := flag.NewFlagSet("index merge", flag.ExitOnError)
+	out := fs.String("o", "", "index file to create")
+	dedupe := fs.Bool("dedupe", false, "skip entries with the hash, ID and source of one already merged")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pdq index merge [flags] <a.idx> <b.idx>... -o <merged.idx>\n\n")
+		fmt.Fprintf(os.Stderr, "Writes the entries of every input, in order and renumbered, to a new index file.\n\n")
+		fs.PrintDefaults()
+	}
+	srcs := parseInterspersed(fs, args)
+	if len(srcs) == 0 || *out == "" {
+		fs.Usage()
+		return 1
+	}
+
+	n, err := boltstore.Rewrite(*out, srcs, *dedupe)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "merged %d entries from %d files into %s\n", n, len(srcs), *out)
+	return 0
+}
+
+func runIndexCompact(args []string) int {
+	fs := flag.NewFlagSet("index compact", flag.ExitOnError)
+	out := fs.String("o", "", "index file to create, instead of replacing the input")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pdq index compact [flags] <corpus.idx>\n\n")
+		fmt.Fprintf(os.Stderr, "Rewrites the index with its entries renumbered and packed, dropping the space\n")
+		fmt.Fprintf(os.Stderr, "left by deleted ones. Nothing else may have the file open.\n\n")
+		fs.PrintDefaults()
+	}
+	pos := parseInterspersed(fs, args)
+	if len(pos) != 1 {
+		fs.Usage()
+		return 1
+	}
+	src := pos[0]
+
+	before, err := os.Stat(src)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	dst := *out
+	if dst == "" {
+		dst = src + ".compact"
+	}
+	n, err := boltstore.Rewrite(dst, []string{src}, false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *out == "" {
+		if err := os.Rename(dst, src); err != nil {
+			os.Remove(dst)
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		dst = src
+	}
+	after, err := os.Stat(dst)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d entries to %s, %d bytes down from %d\n", n, dst, after.Size(), before.Size())
+	return 0
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// command is a pdq subcommand
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) int
+}
+
+var commands = []command{
+	{"hash", "print the hash and quality of images", runHash},
+	{"match-dirs", "report the best match in one directory for every image in another", runMatchDirs},
+	{"index", "build and query on-disk multi-index hashing indexes", runIndex},
+	{"gen-corpus", "write labeled transformed variants of seed images", runGenCorpus},
+	{"calibrate", "recommend a distance threshold from labeled pairs", runCalibrate},
+	{"golden", "record the hashes of a corpus, and check them again for drift", runGolden},
+	{"crawl", "hash large trees and URL lists, resuming after interruptions", runCrawl},
+	{"manifest", "record the size, checksum and hash of every image in a directory", runManifest},
+	{"distance", "print the distance and similarity of pairs of hashes", runDistance},
+	{"review", "label query and match image pairs from the keyboard", runReview},
+	{"serve", "serve hashing over HTTP", runServe},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	name := os.Args[1]
+	for _, c := range commands {
+		if c.name == name {
+			os.Exit(c.run(os.Args[2:]))
+		}
+	}
+
+	if name != "help" && name != "-h" && name != "--help" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	}
+	usage()
+	os.Exit(1)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: pdq <command> [flags] [args]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", c.name, c.usage)
+	}
+}
+
+var imageExts = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+	".pbm":  true,
+	".pgm":  true,
+	".ppm":  true,
+	".pnm":  true,
+}
+
+// listImages returns the image files under dir in lexical order
+func listImages(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && imageExts[strings.ToLower(filepath.Ext(p))] {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
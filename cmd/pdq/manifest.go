@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// archiveEntry is one line of an archive manifest. Hash and Quality are
+// empty when the file couldn't be hashed as an image, in which case Error
+// says why; the size and checksum are recorded either way.
+type archiveEntry struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+	Hash    string `json:"pdq,omitempty"`
+	Quality int    `json:"quality"`
+	Error   string `json:"error,omitempty"`
+}
+
+func runManifest(args []string) int {
+	fs := flag.NewFlagSet("manifest", flag.ExitOnError)
+	out := fs.String("o", "", "file to write the manifest to, instead of stdout")
+	asCSV := fs.Bool("csv", false, "write CSV instead of JSON lines")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pdq manifest [flags] <dir>\n\n")
+		fmt.Fprintf(os.Stderr, "Records the path, size, SHA-256, PDQ hash and quality of every image under\n")
+		fmt.Fprintf(os.Stderr, "dir, reading each file once. Paths are relative to dir.\n\n")
+		fs.PrintDefaults()
+	}
+	pos := parseInterspersed(fs, args)
+	if len(pos) != 1 {
+		fs.Usage()
+		return 1
+	}
+	dir := pos[0]
+
+	paths, err := listImages(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	entries := archiveEntries(gopdq.NewPdqHasher(), paths)
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+
+	failed := 0
+	for i := range entries {
+		e := &entries[i]
+		if rel, err := filepath.Rel(dir, paths[i]); err == nil {
+			e.Path = filepath.ToSlash(rel)
+		}
+		if e.Error != "" {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s: %s\n", paths[i], e.Error)
+		}
+	}
+	if *asCSV {
+		err = writeArchiveCSV(bw, entries)
+	} else {
+		err = writeArchiveJSON(bw, entries)
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "%d files, %d not hashed\n", len(entries), failed)
+	return 0
+}
+
+// archiveEntries hashes every file in paths concurrently, returning their
+// entries in the same order
+func archiveEntries(hasher *gopdq.PdqHasher, paths []string) []archiveEntry {
+	entries := make([]archiveEntry, len(paths))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ix := range work {
+				entries[ix] = archiveFile(hasher, paths[ix])
+			}
+		}()
+	}
+	for i := range paths {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return entries
+}
+
+// archiveFile checksums and hashes the file at path in one read: the decoder
+// reads through a tee into the checksum, and whatever it leaves unread is
+// drained into the checksum afterwards
+func archiveFile(hasher *gopdq.PdqHasher, path string) archiveEntry {
+	e := archiveEntry{Path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		e.Error = err.Error()
+		return e
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+	counted := &countingReader{r: f}
+	res, hashErr := hasher.FromReader(io.TeeReader(counted, sum))
+	if _, err := io.Copy(sum, counted); err != nil {
+		e.Error = err.Error()
+		return e
+	}
+	e.Size = counted.n
+	e.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	if hashErr != nil {
+		e.Error = hashErr.Error()
+		return e
+	}
+	e.Hash = res.Hash.String()
+	e.Quality = res.Quality
+	return e
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func writeArchiveJSON(w io.Writer, entries []archiveEntry) error {
+	enc := json.NewEncoder(w)
+	for i := range entries {
+		if err := enc.Encode(&entries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeArchiveCSV(w io.Writer, entries []archiveEntry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"path", "size", "sha256", "pdq", "quality", "error"})
+	for _, e := range entries {
+		quality := ""
+		if e.Hash != "" {
+			quality = strconv.Itoa(e.Quality)
+		}
+		cw.Write([]string{e.Path, strconv.FormatInt(e.Size, 10), e.SHA256, e.Hash, quality, e.Error})
+	}
+	cw.Flush()
+	return cw.Error()
+}
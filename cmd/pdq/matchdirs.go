@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+type dirMatch struct {
+	path     string
+	best     string
+	distance int
+	err      error
+}
+
+func runMatchDirs(args []string) int {
+	fs := flag.NewFlagSet("match-dirs", flag.ExitOnError)
+	maxDistance := fs.Int("max-distance", 31, "distance at or below which an image counts as matched")
+	asCSV := fs.Bool("csv", false, "print results as CSV")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pdq match-dirs [flags] <dir-a> <dir-b>\n\n")
+		fmt.Fprintf(os.Stderr, "For every image in dir-b, reports the closest image in dir-a.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 1
+	}
+
+	hasher := gopdq.NewPdqHasher()
+	a, err := hashDir(hasher, fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	b, err := hashDir(hasher, fs.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var usable []*gopdq.TaggedHash
+	for _, r := range a {
+		if t := r.Tagged(); t != nil {
+			usable = append(usable, t)
+		} else {
+			fmt.Fprintf(os.Stderr, "skipping %s: %v\n", r.Path, r.Err)
+		}
+	}
+
+	packed := gopdq.PackHashes(nil)
+	for _, ta := range usable {
+		packed.Append(ta.Hash)
+	}
+
+	var matches []dirMatch
+	for _, rb := range b {
+		m := dirMatch{path: rb.Path, distance: -1, err: rb.Err}
+		if rb.Err == nil {
+			if i, d := packed.Nearest(rb.Result.Hash); i >= 0 {
+				m.best = usable[i].Source
+				m.distance = d
+			}
+		}
+		matches = append(matches, m)
+	}
+
+	if *asCSV {
+		writeMatchesCSV(os.Stdout, matches)
+	} else {
+		printMatches(os.Stdout, matches, *maxDistance)
+	}
+	return 0
+}
+
+func hashDir(hasher *gopdq.PdqHasher, dir string) ([]gopdq.BatchResult, error) {
+	paths, err := listImages(dir)
+	if err != nil {
+		return nil, err
+	}
+	return hasher.HashFiles(context.Background(), paths, gopdq.Limits{}), nil
+}
+
+func printMatches(w io.Writer, matches []dirMatch, maxDistance int) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "IMAGE\tBEST MATCH\tDISTANCE\t")
+
+	matched, failed, unmatched := 0, 0, 0
+	for _, m := range matches {
+		switch {
+		case m.err != nil:
+			failed++
+			fmt.Fprintf(tw, "%s\t-\t-\terror: %v\n", m.path, m.err)
+		case m.distance < 0:
+			unmatched++
+			fmt.Fprintf(tw, "%s\t-\t-\tno candidates\n", m.path)
+		case m.distance <= maxDistance:
+			matched++
+			fmt.Fprintf(tw, "%s\t%s\t%d\t\n", m.path, filepath.Base(m.best), m.distance)
+		default:
+			unmatched++
+			fmt.Fprintf(tw, "%s\t%s\t%d\tNO MATCH\n", m.path, filepath.Base(m.best), m.distance)
+		}
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\n%d images: %d matched within %d bits, %d unmatched, %d failed\n",
+		len(matches), matched, maxDistance, unmatched, failed)
+}
+
+func writeMatchesCSV(w io.Writer, matches []dirMatch) {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"image", "best_match", "distance", "error"})
+	for _, m := range matches {
+		errStr := ""
+		if m.err != nil {
+			errStr = m.err.Error()
+		}
+		cw.Write([]string{m.path, m.best, strconv.Itoa(m.distance), errStr})
+	}
+	cw.Flush()
+}
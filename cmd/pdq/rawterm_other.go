@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+import "errors"
+
+func cbreak(fd int) (func(), error) {
+	return nil, errors.New("cbreak mode is not supported on this platform")
+}
+
+func terminalSize(fd int) (cols, rows int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/whyrusleeping/gopdq/corpusgen"
+)
+
+// reviewPair is one line of a match file: a JSON object naming a query
+// image and the image it matched. Any other fields, such as the distance,
+// are shown to the reviewer and copied to the labeled output.
+type reviewPair struct {
+	query, match string
+	fields       map[string]json.RawMessage
+}
+
+func (p *reviewPair) key() string {
+	return p.query + "\x00" + p.match
+}
+
+func runReview(args []string) int {
+	fs := flag.NewFlagSet("review", flag.ExitOnError)
+	out := fs.String("o", "", "labeled output file (default <matches>.labels.ndjson)")
+	viewer := fs.String("viewer", "", "program to open each pair with, instead of drawing them in the terminal")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pdq review [flags] <matches.ndjson>\n\n")
+		fmt.Fprintf(os.Stderr, "Shows each query/match pair for a keyboard verdict: a to accept, r to reject,\n")
+		fmt.Fprintf(os.Stderr, "s to skip and q to quit. Each line of the match file is a JSON object with\n")
+		fmt.Fprintf(os.Stderr, "\"query\" and \"match\" image paths. Verdicts are appended to the output as the\n")
+		fmt.Fprintf(os.Stderr, "same object with an \"accepted\" field, and pairs already in the output are\n")
+		fmt.Fprintf(os.Stderr, "not shown again, so a review can be resumed.\n\n")
+		fs.PrintDefaults()
+	}
+	pos := parseInterspersed(fs, args)
+	if len(pos) != 1 {
+		fs.Usage()
+		return 1
+	}
+	in := pos[0]
+	if *out == "" {
+		*out = strings.TrimSuffix(in, filepath.Ext(in)) + ".labels.ndjson"
+	}
+
+	pairs, err := readReviewPairs(in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	done := map[string]bool{}
+	if labeled, err := readReviewPairs(*out); err == nil {
+		for _, p := range labeled {
+			done[p.key()] = true
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var pending []*reviewPair
+	for _, p := range pairs {
+		if !done[p.key()] {
+			pending = append(pending, p)
+		}
+	}
+	if len(pending) == 0 {
+		fmt.Fprintf(os.Stderr, "all %d pairs are labeled in %s\n", len(pairs), *out)
+		return 0
+	}
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+
+	keys := newKeyReader(os.Stdin)
+	defer keys.restore()
+
+	accepted, rejected := 0, 0
+	for i, p := range pending {
+		var view *exec.Cmd
+		if *viewer != "" {
+			fmt.Printf("\n%s\n", reviewTitle(i, len(pending), p))
+			view = exec.Command(*viewer, p.query, p.match)
+			if err := view.Start(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		} else {
+			drawPair(os.Stdout, i, len(pending), p)
+		}
+		fmt.Print("[a]ccept [r]eject [s]kip [q]uit: ")
+
+		verdict, err := keys.choose("arsq")
+		if view != nil {
+			view.Process.Kill()
+			view.Wait()
+		}
+		fmt.Println()
+		if err != nil && err != io.EOF {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if err == io.EOF || verdict == 'q' {
+			break
+		}
+		if verdict == 's' {
+			continue
+		}
+
+		if err := writeVerdict(f, p, verdict == 'a'); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if verdict == 'a' {
+			accepted++
+		} else {
+			rejected++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "accepted %d and rejected %d pairs, written to %s\n", accepted, rejected, *out)
+	return 0
+}
+
+// readReviewPairs reads a match file, skipping blank lines
+func readReviewPairs(path string) ([]*reviewPair, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pairs []*reviewPair
+	for n, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		p := &reviewPair{}
+		if err := json.Unmarshal(line, &p.fields); err != nil {
+			return nil, fmt.Errorf("%s: line %d: %w", path, n+1, err)
+		}
+		errQ := json.Unmarshal(p.fields["query"], &p.query)
+		errM := json.Unmarshal(p.fields["match"], &p.match)
+		if errQ != nil || errM != nil || p.query == "" || p.match == "" {
+			return nil, fmt.Errorf("%s: line %d: expected string \"query\" and \"match\" fields", path, n+1)
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs, nil
+}
+
+// writeVerdict appends p with its verdict to f, synced so that quitting at
+// any point keeps every verdict given
+func writeVerdict(f *os.File, p *reviewPair, accepted bool) error {
+	fields := make(map[string]any, len(p.fields)+1)
+	for k, v := range p.fields {
+		fields[k] = v
+	}
+	fields["accepted"] = accepted
+	line, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// reviewTitle describes p and every field of it other than the paths
+func reviewTitle(i, n int, p *reviewPair) string {
+	var extra []string
+	for k, v := range p.fields {
+		if k != "query" && k != "match" {
+			extra = append(extra, k+"="+string(v))
+		}
+	}
+	title := fmt.Sprintf("%d/%d  query %s  match %s", i+1, n, p.query, p.match)
+	if len(extra) > 0 {
+		sort.Strings(extra)
+		title += "  " + strings.Join(extra, " ")
+	}
+	return title
+}
+
+// drawPair clears the terminal and draws the pair side by side, two pixel
+// rows to a character cell using 24-bit colour half blocks
+func drawPair(w io.Writer, i, n int, p *reviewPair) {
+	cols, rows, ok := terminalSize(int(os.Stdout.Fd()))
+	if !ok {
+		cols, rows = 80, 24
+	}
+	boxW, boxH := max(1, (cols-2)/2), max(2, (rows-3)*2)
+
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	fmt.Fprintf(bw, "\x1b[H\x1b[2J%s\n", reviewTitle(i, n, p))
+
+	var imgs [2]image.Image
+	var errs [2]error
+	for k, path := range []string{p.query, p.match} {
+		imgs[k], errs[k] = loadFitted(path, boxW, boxH)
+	}
+	for y := 0; y < boxH; y += 2 {
+		for k := range imgs {
+			if k > 0 {
+				bw.WriteString("  ")
+			}
+			if errs[k] != nil {
+				if y == 0 {
+					msg := errs[k].Error()
+					if len(msg) > boxW {
+						msg = msg[:boxW]
+					}
+					fmt.Fprintf(bw, "%-*s", boxW, msg)
+				} else {
+					fmt.Fprintf(bw, "%*s", boxW, "")
+				}
+				continue
+			}
+			drawRow(bw, imgs[k], y, boxW)
+		}
+		bw.WriteString("\n")
+	}
+}
+
+// drawRow draws pixel rows y and y+1 of img, padded to width cells
+func drawRow(w *bufio.Writer, img image.Image, y, width int) {
+	b := img.Bounds()
+	for x := 0; x < width; x++ {
+		if x >= b.Dx() || y >= b.Dy() {
+			w.WriteString(" ")
+			continue
+		}
+		tr, tg, tb, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+		if y+1 < b.Dy() {
+			br, bg, bb, _ := img.At(b.Min.X+x, b.Min.Y+y+1).RGBA()
+			fmt.Fprintf(w, "\x1b[48;2;%d;%d;%dm", br>>8, bg>>8, bb>>8)
+		}
+		fmt.Fprintf(w, "\x1b[38;2;%d;%d;%dm▀\x1b[0m", tr>>8, tg>>8, tb>>8)
+	}
+}
+
+// loadFitted decodes the image at path and scales it to fit in w x h
+// pixels, keeping its aspect ratio
+func loadFitted(path string, w, h int) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	iw, ih := img.Bounds().Dx(), img.Bounds().Dy()
+	scale := min(float64(w)/float64(iw), float64(h)/float64(ih))
+	return corpusgen.Resample(img, max(1, int(float64(iw)*scale)), max(1, int(float64(ih)*scale))), nil
+}
+
+// keyReader reads single key presses from a terminal, or whole lines when
+// the input isn't one the terminal can be put into cbreak mode on
+type keyReader struct {
+	r       *bufio.Reader
+	restore func()
+	lines   bool
+}
+
+func newKeyReader(f *os.File) *keyReader {
+	k := &keyReader{r: bufio.NewReader(f), restore: func() {}}
+	restore, err := cbreak(int(f.Fd()))
+	if err != nil {
+		k.lines = true
+	} else {
+		k.restore = restore
+	}
+	return k
+}
+
+// choose waits for one of the keys in valid, returning 'q' on ctrl-C
+func (k *keyReader) choose(valid string) (byte, error) {
+	for {
+		var c byte
+		if k.lines {
+			line, err := k.r.ReadString('\n')
+			line = strings.TrimSpace(line)
+			if line == "" {
+				if err != nil {
+					return 0, err
+				}
+				continue
+			}
+			c = line[0]
+		} else {
+			var err error
+			if c, err = k.r.ReadByte(); err != nil {
+				return 0, err
+			}
+			if c == 3 || c == 4 {
+				return 'q', nil
+			}
+		}
+		c = byte(strings.ToLower(string(c))[0])
+		if strings.IndexByte(valid, c) >= 0 {
+			return c, nil
+		}
+	}
+}
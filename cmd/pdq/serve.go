@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/whyrusleeping/gopdq"
+	"github.com/whyrusleeping/gopdq/index"
+	"github.com/whyrusleeping/gopdq/index/boltstore"
+	"github.com/whyrusleeping/gopdq/index/remote"
+	"github.com/whyrusleeping/gopdq/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	concurrency := fs.Int("concurrency", 0, "images hashed at once per batch (default the number of CPUs)")
+	maxBatch := fs.Int("max-batch", 1000, "images accepted per batch request")
+	indexPath := fs.String("index", "", "index file built by pdq index build to serve /match from")
+	hashesPath := fs.String("hashes", "", "hash list file or URL to load into memory and serve /match from, reloaded on SIGHUP or POST /admin/reload")
+	kind := fs.String("matcher", "mih", "in-memory matcher for -hashes: flat, mih, bktree or hnsw (approximate), optionally sharded across cores as in mih/8")
+	hnswM := fs.Int("hnsw-m", 0, "neighbours each node of an hnsw -matcher links to (default 16)")
+	hnswEfConstruction := fs.Int("hnsw-ef-construction", 0, "candidates an insert into an hnsw -matcher considers (default 200)")
+	hnswEfSearch := fs.Int("hnsw-ef-search", 0, "candidates an hnsw -matcher query starts with, trading speed for recall (default 64)")
+	sweepInterval := fs.Duration("sweep-interval", time.Hour, "how often to delete expired entries from the -index file, reading every entry, or 0 to never delete them; queries skip them either way")
+	maxDistance := fs.Int("max-distance", 31, "default and largest radius of /match queries")
+	keysPath := fs.String("keys", "", "JSON file listing the API keys accepted, as objects with name, key, rate_per_second, burst and admin fields")
+	maxBody := fs.Int64("max-body", 64<<20, "largest request body accepted, in bytes, or -1 for no limit")
+	drainDelay := fs.Duration("drain-delay", 0, "time to keep serving after SIGTERM with /readyz failing, before closing the listener")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 30*time.Second, "time allowed for requests in flight to finish on shutdown")
+	grpcAddr := fs.String("grpc-addr", "", "address to also serve the index of -index or -hashes on as a remote shard over gRPC")
+	shardAddrs := fs.String("shards", "", "comma separated gRPC addresses of remote shards to serve /match from, instead of -index or -hashes")
+	grpcCert := fs.String("grpc-cert", "", "PEM certificate the gRPC shard server presents, and -shards presents to shards as a client certificate")
+	grpcKey := fs.String("grpc-key", "", "PEM private key of -grpc-cert")
+	grpcCA := fs.String("grpc-ca", "", "PEM CA certificates the gRPC shard server requires client certificates to be signed by, and -shards verifies shards against instead of the system roots")
+	minShards := fs.Int("min-shards", 0, "shards that must answer a -shards query, answering from those up when others are down (default all)")
+	fetchAllow := fs.String("fetch-allow", "", "comma separated CIDR prefixes batch URLs may be fetched from besides public addresses, such as 10.0.0.0/8 for an internal image store")
+	requireFormats := fs.String("require-formats", "", "comma separated image formats, such as jpeg,png,webp, to refuse to start without a decoder for")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pdq serve [flags]\n\n")
+		fmt.Fprintf(os.Stderr, "Serves POST /hash, taking an image body, and POST /hash/batch, taking\n")
+		fmt.Fprintf(os.Stderr, "multipart images or NDJSON lines of {\"id\", \"url\"} and streaming NDJSON back, or\n")
+		fmt.Fprintf(os.Stderr, "Server-Sent Events with progress to clients accepting text/event-stream.\n")
+		fmt.Fprintf(os.Stderr, "With -index or -hashes, also serves POST /match, taking a JSON {\"hash\"} or an\n")
+		fmt.Fprintf(os.Stderr, "image body and listing the entries near it. With -shards, /match queries\n")
+		fmt.Fprintf(os.Stderr, "every shard of a distributed index, each a pdq serve with -grpc-addr.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 1
+	}
+
+	sources := 0
+	for _, v := range []string{*indexPath, *hashesPath, *shardAddrs} {
+		if v != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		fmt.Fprintln(os.Stderr, "-index, -hashes and -shards are mutually exclusive")
+		return 1
+	}
+	if *grpcAddr != "" && *indexPath == "" && *hashesPath == "" {
+		fmt.Fprintln(os.Stderr, "-grpc-addr needs -index or -hashes")
+		return 1
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	for _, d := range gopdq.Decoders() {
+		logger.Info("decoder available", "format", d.Format, "library", d.Library, "cgo", d.Cgo)
+	}
+	if *requireFormats != "" {
+		if err := gopdq.RequireDecoders(strings.Split(*requireFormats, ",")...); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	cfg := server.Config{
+		Logger:       logger,
+		Concurrency:  *concurrency,
+		MaxBatch:     *maxBatch,
+		MaxDistance:  *maxDistance,
+		MaxBodyBytes: *maxBody,
+	}
+	if *fetchAllow != "" {
+		var allowed []netip.Prefix
+		for _, p := range strings.Split(*fetchAllow, ",") {
+			prefix, err := netip.ParsePrefix(strings.TrimSpace(p))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "-fetch-allow: %v\n", err)
+				return 1
+			}
+			allowed = append(allowed, prefix)
+		}
+		cfg.AllowFetch = func(addr netip.AddrPort) bool {
+			for _, p := range allowed {
+				if p.Contains(addr.Addr()) {
+					return true
+				}
+			}
+			return server.PublicAddr(addr)
+		}
+	}
+	if *keysPath != "" {
+		data, err := os.ReadFile(*keysPath)
+		if err == nil {
+			err = json.Unmarshal(data, &cfg.Keys)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *keysPath, err)
+			return 1
+		}
+		logger.Info("loaded API keys", "count", len(cfg.Keys))
+	}
+	switch {
+	case *indexPath != "":
+		store, err := boltstore.Open(*indexPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer store.Close()
+		ix := index.New(store)
+		cfg.Matcher = ix
+		n, _ := store.Len()
+		logger.Info("opened index", "path", *indexPath, "entries", n)
+		if *sweepInterval > 0 {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go ix.RunSweeper(ctx, *sweepInterval, func(n int, err error) {
+				if err != nil {
+					logger.Warn("sweeping expired entries failed", "err", err)
+				} else if n > 0 {
+					logger.Info("swept expired entries", "deleted", n)
+				}
+			})
+		}
+	case *hashesPath != "":
+		cfg.Reload = func(ctx context.Context) (index.Matcher, error) {
+			m, n, err := server.LoadHashList(ctx, nil, *hashesPath, *kind, index.HNSWConfig{
+				M:              *hnswM,
+				EfConstruction: *hnswEfConstruction,
+				EfSearch:       *hnswEfSearch,
+			})
+			if err == nil {
+				logger.Info("loaded hash list", "ref", *hashesPath, "entries", n)
+			}
+			return m, err
+		}
+		m, err := cfg.Reload(context.Background())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		cfg.Matcher = m
+	case *shardAddrs != "":
+		tc, err := grpcTLS(*grpcCert, *grpcKey, *grpcCA, false)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		var opts []grpc.DialOption
+		if tc != nil {
+			opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tc)))
+		}
+		cluster, err := remote.Dial(remote.ClusterConfig{
+			Addrs:       strings.Split(*shardAddrs, ","),
+			DialOptions: opts,
+			MinShards:   *minShards,
+			Logger:      logger,
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer cluster.Close()
+		cfg.Matcher = cluster
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if cfg.Reload != nil {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				srv.Reload(context.Background())
+			}
+		}()
+	}
+
+	var gs *grpc.Server
+	if *grpcAddr != "" {
+		tc, err := grpcTLS(*grpcCert, *grpcKey, *grpcCA, true)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		var opts []grpc.ServerOption
+		if tc != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tc)))
+		} else {
+			logger.Warn("serving shard over plaintext gRPC, open to anyone who can reach it; give -grpc-cert, -grpc-key and -grpc-ca for mutual TLS", "addr", *grpcAddr)
+		}
+		lis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		gs = grpc.NewServer(opts...)
+		remote.NewServer(remote.ServerConfig{Matcher: srv.Matcher, MaxDistance: *maxDistance}).Register(gs)
+		go func() {
+			logger.Info("serving shard over gRPC", "addr", *grpcAddr, "tls", tc != nil, "client_certs", tc != nil && tc.ClientCAs != nil)
+			if err := gs.Serve(lis); err != nil {
+				logger.Error("gRPC server failed", "err", err)
+			}
+		}()
+	}
+
+	hs := &http.Server{Addr: *addr, Handler: srv}
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("listening", "addr", *addr)
+		serveErr <- hs.ListenAndServe()
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err := <-serveErr:
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	case sig := <-stop:
+		logger.Info("shutting down", "signal", sig, "drain_delay", *drainDelay, "timeout", *shutdownTimeout)
+	}
+	signal.Stop(stop)
+
+	srv.Drain()
+	time.Sleep(*drainDelay)
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	// both servers finish their requests in flight at once, within the
+	// same timeout, and are closed on the ones left at its end
+	grpcDone := make(chan struct{})
+	go func() {
+		if gs != nil {
+			gs.GracefulStop()
+		}
+		close(grpcDone)
+	}()
+	code := 0
+	if err := hs.Shutdown(ctx); err != nil {
+		logger.Error("requests still in flight at the shutdown timeout", "err", err)
+		hs.Close()
+		code = 1
+	}
+	select {
+	case <-grpcDone:
+	case <-ctx.Done():
+		if gs != nil {
+			logger.Error("gRPC queries still in flight at the shutdown timeout")
+			gs.Stop()
+			code = 1
+		}
+		<-grpcDone
+	}
+	if code == 0 {
+		logger.Info("shut down")
+	}
+	return code
+}
@@ -0,0 +1,194 @@
+// Package conformance checks a PdqHasher against reference hashes so forks and
+// optimization branches can assert parity with the reference implementation
+// from their own tests.
+//
+// A hasher created with gopdq.WithMode(gopdq.ReferenceExact) is expected to
+// match the reference exactly given the same decoded pixels, so it can be
+// checked with no tolerance; gopdq.Mode lists where the default pipeline
+// diverges.
+package conformance
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// Expected is the reference output for a single image
+type Expected struct {
+	Path    string
+	Hash    *gopdq.PdqHash256
+	Quality int
+}
+
+// Corpus is a set of images with their reference hashes
+type Corpus struct {
+	Entries []Expected
+	// Meta describes the hasher that produced the hashes, if the golden
+	// file recorded it
+	Meta *Meta
+}
+
+// Lookup returns the expected result for an image path
+func (c *Corpus) Lookup(path string) (Expected, bool) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	for _, e := range c.Entries {
+		if e.Path == path {
+			return e, true
+		}
+	}
+	return Expected{}, false
+}
+
+// ImageResult is the outcome of checking a single image
+type ImageResult struct {
+	Expected Expected
+	Hash     *gopdq.PdqHash256
+	Quality  int
+	Err      error
+
+	// BitDiff and QualityDiff are only meaningful when Err is nil
+	BitDiff     int
+	QualityDiff int
+	// DiffPositions lists the [row, col] DCT coordinates of differing bits
+	DiffPositions [][2]int
+}
+
+// Report summarizes a conformance run
+type Report struct {
+	Images []ImageResult
+	Stats  Stats
+	// Heatmap counts differing bits per DCT coefficient across the corpus
+	Heatmap Heatmap
+}
+
+// Check hashes every image in the corpus and compares it to the reference
+func Check(h *gopdq.PdqHasher, corpus *Corpus) *Report {
+	rep := &Report{
+		Images: make([]ImageResult, len(corpus.Entries)),
+	}
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ix := range work {
+				rep.Images[ix] = checkOne(h, corpus.Entries[ix])
+			}
+		}()
+	}
+	for i := range corpus.Entries {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	for _, ir := range rep.Images {
+		if ir.Err != nil {
+			rep.Stats.Failed++
+			continue
+		}
+		rep.Stats.Add(ir.BitDiff)
+		rep.Heatmap.Add(ir.Expected.Hash, ir.Hash)
+	}
+	return rep
+}
+
+func checkOne(h *gopdq.PdqHasher, exp Expected) ImageResult {
+	ir := ImageResult{Expected: exp}
+
+	res, err := h.FromFile(exp.Path)
+	if err != nil {
+		ir.Err = err
+		return ir
+	}
+
+	ir.Hash = res.Hash
+	ir.Quality = res.Quality
+	ir.BitDiff = exp.Hash.HammingDistance(res.Hash)
+	ir.QualityDiff = res.Quality - exp.Quality
+	ir.DiffPositions = DiffPositions(exp.Hash, res.Hash)
+	return ir
+}
+
+// Within returns an error if any image failed to hash, the average bit
+// difference exceeds maxAvgBits, or any image differs by more than
+// maxBitsPerImage. A negative limit disables that check.
+func (r *Report) Within(maxAvgBits float64, maxBitsPerImage int) error {
+	if r.Stats.Failed > 0 {
+		return fmt.Errorf("%d images could not be compared", r.Stats.Failed)
+	}
+	return r.Stats.Within(maxAvgBits, maxBitsPerImage)
+}
+
+// Stats accumulates agreement between two implementations
+type Stats struct {
+	Images    int
+	Exact     int
+	TotalBits int
+	MaxBits   int
+	// Failed counts images that could not be compared
+	Failed int
+}
+
+// Add records the bit difference for one image
+func (s *Stats) Add(bitDiff int) {
+	s.Images++
+	s.TotalBits += bitDiff
+	if bitDiff == 0 {
+		s.Exact++
+	}
+	s.MaxBits = max(s.MaxBits, bitDiff)
+}
+
+// AvgBits returns the mean bit difference over compared images
+func (s *Stats) AvgBits() float64 {
+	if s.Images == 0 {
+		return 0
+	}
+	return float64(s.TotalBits) / float64(s.Images)
+}
+
+// Within returns an error describing the first limit exceeded, if any. A
+// negative limit disables that check.
+func (s *Stats) Within(maxAvgBits float64, maxBitsPerImage int) error {
+	if maxAvgBits >= 0 && s.AvgBits() > maxAvgBits {
+		return fmt.Errorf("average difference %.2f bits exceeds %.2f", s.AvgBits(), maxAvgBits)
+	}
+	if maxBitsPerImage >= 0 && s.MaxBits > maxBitsPerImage {
+		return fmt.Errorf("maximum difference %d bits exceeds %d", s.MaxBits, maxBitsPerImage)
+	}
+	return nil
+}
+
+// Bit k of a PDQ hash is set from DCT coefficient (k/16, k%16), so row and
+// column index vertical and horizontal frequency respectively.
+
+// DiffPositions returns the DCT coordinates of every bit that differs
+func DiffPositions(a, b *gopdq.PdqHash256) [][2]int {
+	words := a.Xor(b).Words()
+	var pos [][2]int
+	for k := 0; k < 256; k++ {
+		if words[k>>4]&(1<<(k&15)) != 0 {
+			pos = append(pos, [2]int{k / 16, k % 16})
+		}
+	}
+	return pos
+}
+
+// Heatmap counts differing bits per DCT coefficient
+type Heatmap [16][16]int
+
+// Add counts the bits differing between a and b
+func (hm *Heatmap) Add(a, b *gopdq.PdqHash256) {
+	for _, p := range DiffPositions(a, b) {
+		hm[p[0]][p[1]]++
+	}
+}
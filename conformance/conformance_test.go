@@ -0,0 +1,111 @@
+package conformance
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+func TestCheck(t *testing.T) {
+	hasher := gopdq.NewPdqHasher()
+	res, err := hasher.FromFile("../cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one exact entry, one with three flipped bits, one missing file
+	off := res.Hash.Clone()
+	off.FlipBit(0)
+	off.FlipBit(17)
+	off.FlipBit(255)
+
+	buf := new(bytes.Buffer)
+	err = WriteGolden(buf, "..", []Expected{
+		{Path: "../cat.jpg", Hash: res.Hash, Quality: res.Quality},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := buf.String() +
+		off.String() + ",100,cat.jpg\n" +
+		res.Hash.String() + ",100,missing.jpg\n"
+
+	corpus, err := ReadGolden(strings.NewReader(golden), "..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the second line for cat.jpg replaces the first
+	if len(corpus.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(corpus.Entries))
+	}
+
+	rep := Check(hasher, corpus)
+	if rep.Stats.Failed != 1 || rep.Stats.Images != 1 {
+		t.Fatalf("unexpected stats: %+v", rep.Stats)
+	}
+	if rep.Stats.MaxBits != 3 {
+		t.Fatalf("expected 3 differing bits, got %d", rep.Stats.MaxBits)
+	}
+	if rep.Heatmap[0][0] != 1 || rep.Heatmap[1][1] != 1 || rep.Heatmap[15][15] != 1 {
+		t.Fatalf("unexpected heatmap: %v", rep.Heatmap)
+	}
+
+	if err := rep.Within(-1, 3); err == nil {
+		t.Fatal("expected failure for missing image")
+	}
+	if err := rep.Stats.Within(-1, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := rep.Stats.Within(2.5, -1); err == nil {
+		t.Fatal("expected average limit to be exceeded")
+	}
+}
+
+func TestReadGoldenVerbose(t *testing.T) {
+	const line = "hash=06704e1dd910f233c0e6df833130b0ff99e36701383d333ac7c6078fe736dccc,norm=128,delta=0,quality=100,filename=reg-test-input/dih/x.jpg\n"
+
+	corpus, err := ReadGolden(strings.NewReader(line), "/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(corpus.Entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(corpus.Entries))
+	}
+	e := corpus.Entries[0]
+	if e.Path != "/data/reg-test-input/dih/x.jpg" || e.Quality != 100 {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+}
+
+func TestGoldenMeta(t *testing.T) {
+	meta := CurrentMeta(gopdq.ReferenceExact)
+	corpus := &Corpus{Meta: meta, Entries: []Expected{{Path: "../cat.jpg", Hash: gopdq.NewPdqHash256(), Quality: 90}}}
+	buf := new(bytes.Buffer)
+	if err := corpus.Write(buf, ".."); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadGolden(bytes.NewReader(buf.Bytes()), "..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Meta == nil || *got.Meta != *meta {
+		t.Fatalf("meta %+v read back as %+v", meta, got.Meta)
+	}
+	if len(got.Entries) != 1 || got.Entries[0].Quality != 90 {
+		t.Fatalf("unexpected entries: %+v", got.Entries)
+	}
+
+	other := *meta
+	other.Arch, other.GoVersion = "arm64", "go1.0"
+	if diffs := meta.BuildDifferences(&other); len(diffs) != 1 || !strings.Contains(diffs[0], "arm64") {
+		t.Fatalf("unexpected build differences %v", diffs)
+	}
+
+	newer := strings.Replace(buf.String(), "version=1", "version=2", 1)
+	if _, err := ReadGolden(strings.NewReader(newer), ".."); err == nil {
+		t.Fatal("expected an error for a newer golden file version")
+	}
+}
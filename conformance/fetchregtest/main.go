@@ -0,0 +1,141 @@
+// Command fetchregtest downloads the reference implementation's regression
+// vectors for the regtest-tagged conformance test. It fetches one commit of
+// github.com/facebook/ThreatExchange as a tarball, refuses it unless its
+// SHA-256 matches the pin, and unpacks its pdq/ directory into
+// conformance/testdata/regtest:
+//
+//	go run ./conformance/fetchregtest
+//	go test -tags regtest ./conformance
+//
+// The pin is the commit, the tarball's SHA-256 and the path of the expected
+// hasher output within the repository. Moving it to a newer commit is done
+// with -commit and -expected plus -sha256 "", which prints the digest of what
+// was downloaded for review before it is written into the constants below.
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The pinned vectors. Until they are filled in the tool only fetches with
+// all three given as flags.
+const (
+	pinnedCommit   = ""
+	pinnedSHA256   = ""
+	pinnedExpected = ""
+)
+
+// expectedFile is the file, within the output directory, naming the expected
+// hasher output relative to it. The regtest reads it.
+const expectedFile = "expected"
+
+func main() {
+	commit := flag.String("commit", pinnedCommit, "ThreatExchange commit to fetch")
+	sum := flag.String("sha256", pinnedSHA256, `SHA-256 of the commit's tarball; "" prints it without unpacking`)
+	expected := flag.String("expected", pinnedExpected, "path of the expected hasher output within the repository, under pdq/")
+	out := flag.String("o", "conformance/testdata/regtest", "directory to unpack into")
+	flag.Parse()
+
+	if err := run(*commit, *sum, *expected, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "fetchregtest:", err)
+		os.Exit(1)
+	}
+}
+
+func run(commit, sum, expected, out string) error {
+	if commit == "" || expected == "" {
+		return fmt.Errorf("no vectors pinned; give -commit and -expected")
+	}
+	if !strings.HasPrefix(expected, "pdq/") {
+		return fmt.Errorf("-expected %q isn't under pdq/", expected)
+	}
+
+	tarball, err := download("https://codeload.github.com/facebook/ThreatExchange/tar.gz/" + commit)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(tarball)
+	got := hex.EncodeToString(digest[:])
+	if sum == "" {
+		fmt.Printf("commit %s tarball sha256 %s\n", commit, got)
+		return nil
+	}
+	if got != sum {
+		return fmt.Errorf("tarball sha256 is %s, pinned %s", got, sum)
+	}
+
+	if err := os.RemoveAll(out); err != nil {
+		return err
+	}
+	if err := unpack(tarball, out); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(out, expected)); err != nil {
+		return fmt.Errorf("expected output not in the tarball: %w", err)
+	}
+	return os.WriteFile(filepath.Join(out, expectedFile), []byte(expected+"\n"), 0644)
+}
+
+func download(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// unpack writes the regular files under pdq/ in the tarball to out, dropping
+// the tarball's top level directory
+func unpack(tarball []byte, out string) error {
+	zr, err := gzip.NewReader(bytes.NewReader(tarball))
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, name, ok := strings.Cut(hdr.Name, "/")
+		if !ok || !strings.HasPrefix(name, "pdq/") || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		path := filepath.Join(out, filepath.FromSlash(name))
+		if !strings.HasPrefix(path, filepath.Clean(out)+string(filepath.Separator)) {
+			return fmt.Errorf("tarball entry %q escapes the output directory", hdr.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
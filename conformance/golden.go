@@ -0,0 +1,246 @@
+package conformance
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// GoldenVersion is the version of the golden file format Corpus.Write
+// records. It changes with the meaning of the file's columns, not with the
+// hasher; Meta says which hasher produced the hashes.
+const GoldenVersion = 1
+
+// goldenMarker starts the comment line holding a golden file's Meta
+const goldenMarker = "# gopdq-golden "
+
+// Meta records what produced a golden file, so a later check can tell
+// algorithm drift from a change of build. It is written as a comment line,
+// which other readers of the CSV skip.
+type Meta struct {
+	Version int
+	Mode    gopdq.Mode
+	// Libjpeg is set when JPEGs were decoded with libjpeg, whose pixels
+	// differ from the standard library decoder's
+	Libjpeg bool
+	// Arch is the GOARCH of the build, which decides whether float sums
+	// may be fused into multiply-adds
+	Arch      string
+	GoVersion string
+	Created   time.Time
+}
+
+// CurrentMeta describes a hasher in mode running in this build
+func CurrentMeta(mode gopdq.Mode) *Meta {
+	m := &Meta{
+		Version:   GoldenVersion,
+		Mode:      mode,
+		Arch:      runtime.GOARCH,
+		GoVersion: runtime.Version(),
+		Created:   time.Now().UTC().Truncate(time.Second),
+	}
+	for _, d := range gopdq.Decoders() {
+		if d.Library == "libjpeg" {
+			m.Libjpeg = true
+		}
+	}
+	return m
+}
+
+// BuildDifferences lists how the builds m and other describe differ in ways
+// that can change hashes without any change to the algorithm
+func (m *Meta) BuildDifferences(other *Meta) []string {
+	var diffs []string
+	if m.Libjpeg != other.Libjpeg {
+		diffs = append(diffs, fmt.Sprintf("libjpeg %v, now %v", m.Libjpeg, other.Libjpeg))
+	}
+	if m.Arch != other.Arch {
+		diffs = append(diffs, fmt.Sprintf("arch %s, now %s", m.Arch, other.Arch))
+	}
+	return diffs
+}
+
+func (m *Meta) String() string {
+	return fmt.Sprintf("version=%d mode=%s libjpeg=%v arch=%s go=%s created=%s",
+		m.Version, m.Mode, m.Libjpeg, m.Arch, m.GoVersion, m.Created.Format(time.RFC3339))
+}
+
+// parseMeta parses the fields of a Meta line after goldenMarker
+func parseMeta(s string) (*Meta, error) {
+	m := &Meta{}
+	for _, f := range strings.Fields(s) {
+		k, v, _ := strings.Cut(f, "=")
+		var err error
+		switch k {
+		case "version":
+			m.Version, err = strconv.Atoi(v)
+		case "mode":
+			m.Mode, err = gopdq.ParseMode(v)
+		case "libjpeg":
+			m.Libjpeg, err = strconv.ParseBool(v)
+		case "arch":
+			m.Arch = v
+		case "go":
+			m.GoVersion = v
+		case "created":
+			m.Created, err = time.Parse(time.RFC3339, v)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", k, err)
+		}
+	}
+	if m.Version < 1 || m.Version > GoldenVersion {
+		return nil, fmt.Errorf("unsupported golden file version %d, this build reads up to %d", m.Version, GoldenVersion)
+	}
+	return m, nil
+}
+
+// LoadGolden reads a corpus from a CSV file whose lines have the same
+// "hash,quality,filename" shape pdq-photo-hasher prints, so a golden file can
+// be produced with
+//
+//	pdq-photo-hasher images/*.jpg > golden.csv
+//
+// The verbose "hash=...,norm=...,quality=...,filename=..." form is accepted
+// as well.
+//
+// Relative filenames are resolved against the directory holding the golden
+// file, and all paths in the returned corpus are absolute.
+func LoadGolden(path string) (*Corpus, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c, err := ReadGolden(f, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// ReadGolden parses a golden CSV, resolving relative filenames against dir
+func ReadGolden(r io.Reader, dir string) (*Corpus, error) {
+	c := &Corpus{}
+	seen := make(map[string]int)
+
+	sc := bufio.NewScanner(r)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		if rest, ok := strings.CutPrefix(line, goldenMarker); ok {
+			m, err := parseMeta(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			c.Meta = m
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hexHash, qualityStr, p, err := splitGoldenLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if hexHash == "hash" {
+			continue // header
+		}
+
+		hash, err := gopdq.ParseHash(hexHash)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		quality, err := strconv.Atoi(qualityStr)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid quality: %w", lineNo, err)
+		}
+
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		p, err = filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+
+		e := Expected{Path: p, Hash: hash, Quality: quality}
+		if ix, dup := seen[p]; dup {
+			c.Entries[ix] = e
+			continue
+		}
+		seen[p] = len(c.Entries)
+		c.Entries = append(c.Entries, e)
+	}
+	return c, sc.Err()
+}
+
+// splitGoldenLine extracts the hash, quality and filename from either the
+// positional or the key=value form of a hasher output line
+func splitGoldenLine(line string) (hash, quality, filename string, err error) {
+	if strings.HasPrefix(line, "hash=") {
+		for _, f := range strings.Split(line, ",") {
+			k, v, _ := strings.Cut(f, "=")
+			switch k {
+			case "hash":
+				hash = v
+			case "quality":
+				quality = v
+			case "filename":
+				filename = v
+			}
+		}
+		if hash == "" || quality == "" || filename == "" {
+			return "", "", "", fmt.Errorf("missing hash, quality or filename field")
+		}
+		return hash, quality, filename, nil
+	}
+
+	fields := strings.SplitN(line, ",", 3)
+	if len(fields) != 3 {
+		return "", "", "", fmt.Errorf("expected hash,quality,filename")
+	}
+	return fields[0], fields[1], fields[2], nil
+}
+
+// WriteGolden writes entries as a golden CSV, making paths relative to dir
+// where possible so the file can be committed next to its images
+func WriteGolden(w io.Writer, dir string, entries []Expected) error {
+	return (&Corpus{Entries: entries}).Write(w, dir)
+}
+
+// Write writes c as a golden CSV like WriteGolden, preceded by c.Meta if
+// set
+func (c *Corpus) Write(w io.Writer, dir string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if c.Meta != nil {
+		fmt.Fprintf(bw, "%s%s\n", goldenMarker, c.Meta)
+	}
+	fmt.Fprintln(bw, "hash,quality,filename")
+	for _, e := range c.Entries {
+		name := e.Path
+		if abs, err := filepath.Abs(e.Path); err == nil {
+			if rel, err := filepath.Rel(absDir, abs); err == nil {
+				name = rel
+			}
+		}
+		fmt.Fprintf(bw, "%s,%d,%s\n", e.Hash, e.Quality, filepath.ToSlash(name))
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,87 @@
+//go:build regtest
+
+package conformance
+
+// The regression vectors come from the reference implementation in
+// github.com/facebook/ThreatExchange under pdq/. They are too large to vendor
+// here; fetchregtest downloads the pinned commit and checks its digest:
+//
+//	go run ./conformance/fetchregtest
+//	go test -tags regtest ./conformance
+//
+// Built with the tag, the test fails rather than skips when they are
+// missing. PDQ_REGTEST_EXPECTED points it at some other expected hasher
+// output instead, and PDQ_REGTEST_BASE is the directory filenames in the
+// expected output are relative to, defaulting to the directory holding the
+// expected file.
+// PDQ_REGTEST_MAX_BITS is the per-image bit tolerance, defaulting to 0.
+// PDQ_REGTEST_MODE picks the pipeline, as parsed by gopdq.ParseMode,
+// defaulting to reference-exact; use fast to measure the default pipeline's
+// divergence with a tolerance.
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+func TestReferenceRegression(t *testing.T) {
+	expected := os.Getenv("PDQ_REGTEST_EXPECTED")
+	if expected == "" {
+		name, err := os.ReadFile("testdata/regtest/expected")
+		if err != nil {
+			t.Fatalf("no regression vectors, fetch them with go run ./conformance/fetchregtest: %v", err)
+		}
+		expected = filepath.Join("testdata/regtest", strings.TrimSpace(string(name)))
+	}
+	base := os.Getenv("PDQ_REGTEST_BASE")
+	if base == "" {
+		base = filepath.Dir(expected)
+	}
+	maxBits := 0
+	if v := os.Getenv("PDQ_REGTEST_MAX_BITS"); v != "" {
+		var err error
+		if maxBits, err = strconv.Atoi(v); err != nil {
+			t.Fatalf("invalid PDQ_REGTEST_MAX_BITS: %v", err)
+		}
+	}
+
+	f, err := os.Open(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	corpus, err := ReadGolden(f, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(corpus.Entries) == 0 {
+		t.Fatal("no regression vectors found")
+	}
+
+	mode := gopdq.ReferenceExact
+	if v := os.Getenv("PDQ_REGTEST_MODE"); v != "" {
+		var err error
+		if mode, err = gopdq.ParseMode(v); err != nil {
+			t.Fatalf("invalid PDQ_REGTEST_MODE: %v", err)
+		}
+	}
+
+	hasher := gopdq.NewPdqHasher(gopdq.WithMode(mode))
+	for _, exp := range corpus.Entries {
+		t.Run(filepath.Base(exp.Path), func(t *testing.T) {
+			res := checkOne(hasher, exp)
+			if res.Err != nil {
+				t.Fatal(res.Err)
+			}
+			if res.BitDiff > maxBits {
+				t.Errorf("%d bits differ (max %d): got %s, want %s", res.BitDiff, maxBits, res.Hash, exp.Hash)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+// Package corpusgen builds labeled test corpora for evaluating matchers and
+// distance thresholds: every seed image is run through a set of transforms
+// and the variants are written out with a label file recording which
+// original, and which transform, each came from.
+//
+// The transforms are deterministic, so the same seeds always give the same
+// corpus, and the label file is all an evaluation needs to know which pairs
+// should match.
+package corpusgen
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LabelFile is the name of the label file Generate writes
+const LabelFile = "labels.jsonl"
+
+// Label maps a generated variant to the image it was derived from
+type Label struct {
+	// Variant is the variant's path relative to the corpus directory
+	Variant string `json:"variant"`
+	// Original is the seed's path, as given to Generate
+	Original string `json:"original"`
+	// Transform is the Name of the transform applied
+	Transform string `json:"transform"`
+}
+
+// Generate writes every transform of every seed to outDir, as PNG so no
+// further loss is added, along with a LabelFile listing them in seed then
+// transform order. Variants of the i'th seed go in a directory named after
+// i and the seed's base name, so seeds sharing a name don't collide. If
+// transforms is empty, Default is used.
+func Generate(outDir string, seeds []string, transforms []Transform) ([]Label, error) {
+	if len(transforms) == 0 {
+		transforms = Default()
+	}
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	var labels []Label
+	for i, seed := range seeds {
+		img, err := decodeFile(seed)
+		if err != nil {
+			return nil, err
+		}
+		base := strings.TrimSuffix(filepath.Base(seed), filepath.Ext(seed))
+		dir := fmt.Sprintf("%04d-%s", i, base)
+		if err := os.MkdirAll(filepath.Join(outDir, dir), 0o755); err != nil {
+			return nil, err
+		}
+
+		for _, t := range transforms {
+			v, err := t.Apply(img)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", seed, t.Name, err)
+			}
+			rel := filepath.Join(dir, t.Name+".png")
+			if err := writePNG(filepath.Join(outDir, rel), v); err != nil {
+				return nil, err
+			}
+			labels = append(labels, Label{Variant: filepath.ToSlash(rel), Original: seed, Transform: t.Name})
+		}
+	}
+
+	f, err := os.Create(filepath.Join(outDir, LabelFile))
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteLabels(f, labels); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return labels, f.Close()
+}
+
+func decodeFile(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return img, nil
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// WriteLabels writes labels as JSON lines
+func WriteLabels(w io.Writer, labels []Label) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for i := range labels {
+		if err := enc.Encode(&labels[i]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadLabels reads a label file written by WriteLabels
+func ReadLabels(r io.Reader) ([]Label, error) {
+	var out []Label
+	dec := json.NewDecoder(r)
+	for {
+		var l Label
+		err := dec.Decode(&l)
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("label %d: %w", len(out)+1, err)
+		}
+		out = append(out, l)
+	}
+}
@@ -0,0 +1,117 @@
+package corpusgen
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+func TestGenerate(t *testing.T) {
+	seeds := []string{"../cat.jpg", "../testdata/rgb.jpg"}
+	dir := t.TempDir()
+	labels, err := Generate(dir, seeds, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != len(seeds)*len(Default()) {
+		t.Fatalf("%d labels for %d seeds and %d transforms", len(labels), len(seeds), len(Default()))
+	}
+
+	f, err := os.Open(filepath.Join(dir, LabelFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadLabels(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, labels) {
+		t.Fatalf("label file holds %+v, expected %+v", read, labels)
+	}
+
+	// the variants the hash is meant to survive land near their original
+	hasher := gopdq.NewPdqHasher()
+	originals := map[string]*gopdq.PdqHash256{}
+	for _, s := range seeds {
+		r, err := hasher.FromFile(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		originals[s] = r.Hash
+	}
+	for _, l := range labels {
+		r, err := hasher.FromFile(filepath.Join(dir, l.Variant))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := r.Hash.HammingDistance(originals[l.Original])
+		switch l.Transform {
+		case "jpeg-q90", "resize-50", "resize-200":
+			if d > 16 {
+				t.Errorf("%s is %d bits from its original", l.Variant, d)
+			}
+		}
+	}
+
+	// and the same seeds give the same corpus
+	again := t.TempDir()
+	if _, err := Generate(again, seeds, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range labels {
+		a, err := os.ReadFile(filepath.Join(dir, l.Variant))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(filepath.Join(again, l.Variant))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(a, b) {
+			t.Fatalf("%s differs between runs", l.Variant)
+		}
+	}
+}
+
+func TestTransforms(t *testing.T) {
+	img, err := decodeFile("../cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := img.Bounds()
+	apply := func(fn func(image.Image) (image.Image, error), img image.Image) image.Image {
+		t.Helper()
+		out, err := fn(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	for _, flip := range []func(image.Image) (image.Image, error){FlipHorizontal, FlipVertical} {
+		once, twice := apply(flip, img), apply(flip, apply(flip, img))
+		if reflect.DeepEqual(toRGBA(once).Pix, toRGBA(img).Pix) {
+			t.Fatal("flip left the image unchanged")
+		}
+		if !reflect.DeepEqual(toRGBA(twice).Pix, toRGBA(img).Pix) {
+			t.Fatal("flipping twice doesn't give the original")
+		}
+	}
+
+	if r := apply(Rotate(90), img).Bounds(); r.Dx() != b.Dy() || r.Dy() != b.Dx() {
+		t.Fatalf("quarter turn of %v gave %v", b, r)
+	}
+	r := apply(CropAspect(16, 9), img).Bounds()
+	if r.Dx() > b.Dx() || r.Dy() > b.Dy() || (r.Dx() != b.Dx() && r.Dy() != b.Dy()) || r.Dx()*9/16 != r.Dy() {
+		t.Fatalf("16:9 crop of %v gave %v", b, r)
+	}
+	if _, err := CropAspect(0, 1)(img); err == nil {
+		t.Fatal("expected an error for a zero aspect ratio")
+	}
+}
@@ -0,0 +1,265 @@
+package corpusgen
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+)
+
+// Transform is a named, deterministic edit of an image. Transforms never
+// modify their input.
+type Transform struct {
+	Name  string
+	Apply func(image.Image) (image.Image, error)
+}
+
+// Default is the set of transforms Generate applies when given none: the
+// edits near-duplicate detection is expected to see through, and a few it
+// usually can't, such as quarter turns, flips and heavy crops
+func Default() []Transform {
+	return []Transform{
+		{"rotate-90", Rotate(90)},
+		{"rotate-180", Rotate(180)},
+		{"rotate-270", Rotate(270)},
+		{"rotate-5", Rotate(5)},
+		{"flip-h", FlipHorizontal},
+		{"flip-v", FlipVertical},
+		{"crop-90", CenterCrop(0.9)},
+		{"crop-75", CenterCrop(0.75)},
+		{"crop-50", CenterCrop(0.5)},
+		{"crop-1x1", CropAspect(1, 1)},
+		{"crop-16x9", CropAspect(16, 9)},
+		{"jpeg-q90", JPEG(90)},
+		{"jpeg-q70", JPEG(70)},
+		{"jpeg-q50", JPEG(50)},
+		{"jpeg-q30", JPEG(30)},
+		{"resize-50", Scale(0.5)},
+		{"resize-25", Scale(0.25)},
+		{"resize-200", Scale(2)},
+		{"watermark", Watermark},
+		{"banner", Banner},
+		{"brighten-20", Brightness(1.2)},
+		{"darken-20", Brightness(0.8)},
+	}
+}
+
+// JPEG re-encodes the image as a JPEG of the given quality and decodes it
+// again
+func JPEG(quality int) func(image.Image) (image.Image, error) {
+	return func(img image.Image) (image.Image, error) {
+		buf := new(bytes.Buffer)
+		if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		}
+		return jpeg.Decode(buf)
+	}
+}
+
+// Scale resizes the image by a factor of f in both dimensions
+func Scale(f float64) func(image.Image) (image.Image, error) {
+	return func(img image.Image) (image.Image, error) {
+		b := img.Bounds()
+		w := max(1, int(float64(b.Dx())*f))
+		h := max(1, int(float64(b.Dy())*f))
+		return Resample(img, w, h), nil
+	}
+}
+
+// Resample scales img to w x h, averaging the source pixels covered by each
+// destination pixel
+func Resample(img image.Image, w, h int) image.Image {
+	src := toRGBA(img)
+	sb := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := sb.Min.Y + y*sb.Dy()/h
+		y1 := max(y0+1, sb.Min.Y+(y+1)*sb.Dy()/h)
+		for x := 0; x < w; x++ {
+			x0 := sb.Min.X + x*sb.Dx()/w
+			x1 := max(x0+1, sb.Min.X+(x+1)*sb.Dx()/w)
+			var r, g, b, n int
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					o := src.PixOffset(sx, sy)
+					r += int(src.Pix[o])
+					g += int(src.Pix[o+1])
+					b += int(src.Pix[o+2])
+					n++
+				}
+			}
+			o := dst.PixOffset(x, y)
+			dst.Pix[o] = uint8(r / n)
+			dst.Pix[o+1] = uint8(g / n)
+			dst.Pix[o+2] = uint8(b / n)
+			dst.Pix[o+3] = 255
+		}
+	}
+	return dst
+}
+
+// CenterCrop keeps the central fraction f of each dimension
+func CenterCrop(f float64) func(image.Image) (image.Image, error) {
+	return func(img image.Image) (image.Image, error) {
+		b := img.Bounds()
+		return crop(img, max(1, int(float64(b.Dx())*f)), max(1, int(float64(b.Dy())*f))), nil
+	}
+}
+
+// CropAspect keeps the largest central region with a w:h aspect ratio
+func CropAspect(w, h int) func(image.Image) (image.Image, error) {
+	return func(img image.Image) (image.Image, error) {
+		if w <= 0 || h <= 0 {
+			return nil, fmt.Errorf("invalid aspect ratio %d:%d", w, h)
+		}
+		b := img.Bounds()
+		cw, ch := b.Dx(), b.Dx()*h/w
+		if ch > b.Dy() {
+			cw, ch = b.Dy()*w/h, b.Dy()
+		}
+		return crop(img, max(1, cw), max(1, ch)), nil
+	}
+}
+
+// crop copies the central w x h region of img
+func crop(img image.Image, w, h int) image.Image {
+	b := img.Bounds()
+	x0 := b.Min.X + (b.Dx()-w)/2
+	y0 := b.Min.Y + (b.Dy()-h)/2
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), img, image.Pt(x0, y0), draw.Src)
+	return dst
+}
+
+// Rotate turns the image by deg degrees around its center, keeping the
+// original canvas size and filling uncovered areas with black
+func Rotate(deg float64) func(image.Image) (image.Image, error) {
+	return func(img image.Image) (image.Image, error) {
+		src := toRGBA(img)
+		b := src.Bounds()
+		w, h := b.Dx(), b.Dy()
+
+		// quarter turns swap the dimensions so nothing is lost
+		if math.Mod(deg, 90) == 0 && math.Mod(deg, 180) != 0 {
+			w, h = h, w
+		}
+
+		sin, cos := math.Sincos(-deg * math.Pi / 180)
+		cx, cy := float64(b.Dx())/2, float64(b.Dy())/2
+		dcx, dcy := float64(w)/2, float64(h)/2
+
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				dx, dy := float64(x)+0.5-dcx, float64(y)+0.5-dcy
+				sx := int(math.Floor(dx*cos - dy*sin + cx))
+				sy := int(math.Floor(dx*sin + dy*cos + cy))
+				if sx < 0 || sy < 0 || sx >= b.Dx() || sy >= b.Dy() {
+					continue
+				}
+				so := src.PixOffset(b.Min.X+sx, b.Min.Y+sy)
+				do := dst.PixOffset(x, y)
+				copy(dst.Pix[do:do+4], src.Pix[so:so+4])
+			}
+		}
+		return dst, nil
+	}
+}
+
+// FlipHorizontal mirrors the image left to right
+func FlipHorizontal(img image.Image) (image.Image, error) {
+	src := toRGBA(img)
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			so := src.PixOffset(b.Max.X-1-x, b.Min.Y+y)
+			do := dst.PixOffset(x, y)
+			copy(dst.Pix[do:do+4], src.Pix[so:so+4])
+		}
+	}
+	return dst, nil
+}
+
+// FlipVertical mirrors the image top to bottom
+func FlipVertical(img image.Image) (image.Image, error) {
+	src := toRGBA(img)
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		so := src.PixOffset(b.Min.X, b.Max.Y-1-y)
+		do := dst.PixOffset(0, y)
+		copy(dst.Pix[do:do+4*b.Dx()], src.Pix[so:so+4*b.Dx()])
+	}
+	return dst, nil
+}
+
+// Watermark blends a translucent white band with a dark stripe pattern into
+// the lower right corner, roughly where logos and captions end up
+func Watermark(img image.Image) (image.Image, error) {
+	dst := toRGBA(img)
+	b := dst.Bounds()
+	rect := image.Rect(b.Max.X-b.Dx()/3, b.Max.Y-b.Dy()/8, b.Max.X-b.Dx()/40, b.Max.Y-b.Dy()/40)
+
+	white := image.NewUniform(color.NRGBA{255, 255, 255, 128})
+	draw.Draw(dst, rect, white, image.Point{}, draw.Over)
+
+	dark := image.NewUniform(color.NRGBA{0, 0, 0, 160})
+	for x := rect.Min.X; x < rect.Max.X; x += 6 {
+		stripe := image.Rect(x, rect.Min.Y+rect.Dy()/4, min(x+3, rect.Max.X), rect.Max.Y-rect.Dy()/4)
+		draw.Draw(dst, stripe, dark, image.Point{}, draw.Over)
+	}
+	return dst, nil
+}
+
+// Banner covers the top sixth of the image with an opaque black bar holding
+// blocks of white, like a caption added to a meme
+func Banner(img image.Image) (image.Image, error) {
+	dst := toRGBA(img)
+	b := dst.Bounds()
+	bar := image.Rect(b.Min.X, b.Min.Y, b.Max.X, b.Min.Y+max(1, b.Dy()/6))
+	draw.Draw(dst, bar, image.Black, image.Point{}, draw.Src)
+
+	glyph := max(2, bar.Dy()/2)
+	y0 := bar.Min.Y + (bar.Dy()-glyph)/2
+	for x := bar.Min.X + glyph; x+glyph <= bar.Max.X-glyph; x += glyph * 3 / 2 {
+		draw.Draw(dst, image.Rect(x, y0, x+glyph, y0+glyph), image.White, image.Point{}, draw.Src)
+	}
+	return dst, nil
+}
+
+// Brightness scales every channel by f, clamping to the valid range
+func Brightness(f float64) func(image.Image) (image.Image, error) {
+	return func(img image.Image) (image.Image, error) {
+		src := toRGBA(img)
+		dst := image.NewRGBA(src.Bounds())
+		for i := 0; i < len(src.Pix); i += 4 {
+			dst.Pix[i] = clamp8(float64(src.Pix[i]) * f)
+			dst.Pix[i+1] = clamp8(float64(src.Pix[i+1]) * f)
+			dst.Pix[i+2] = clamp8(float64(src.Pix[i+2]) * f)
+			dst.Pix[i+3] = src.Pix[i+3]
+		}
+		return dst, nil
+	}
+}
+
+// toRGBA returns a copy of img as *image.RGBA so transforms never modify
+// their input
+func toRGBA(img image.Image) *image.RGBA {
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+	return dst
+}
+
+func clamp8(v float64) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
@@ -0,0 +1,448 @@
+// Package crawl runs hashing jobs over large directory trees and URL lists,
+// checkpointing progress in a bbolt file so a crawl interrupted by a crash
+// or restart resumes where it stopped instead of rehashing everything.
+//
+// A Crawl holds three sets of refs, each a file path or http(s) URL: those
+// pending, those hashed and those that failed, the latter two with their
+// worker.Result. Run feeds the pending refs to a worker.Worker and moves
+// each to done or failed as its result is published, so at most the jobs
+// in flight when the process died are hashed again.
+package crawl
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/whyrusleeping/gopdq/worker"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	pendingBucket = []byte("pending")
+	doneBucket    = []byte("done")
+	failedBucket  = []byte("failed")
+	rootsBucket   = []byte("roots")
+	// countsBucket holds the number of refs in each of the three state
+	// buckets, under the bucket's name, kept in step by every transaction
+	// that moves a ref
+	countsBucket = []byte("counts")
+)
+
+// stateBuckets are the buckets countsBucket counts
+var stateBuckets = [][]byte{pendingBucket, doneBucket, failedBucket}
+
+// addBatch is how many refs are added per transaction while walking a tree
+const addBatch = 1000
+
+// Crawl is the persistent state of a crawl
+type Crawl struct {
+	db *bolt.DB
+}
+
+// Stats counts the refs in each state
+type Stats struct {
+	Pending int `json:"pending"`
+	Done    int `json:"done"`
+	Failed  int `json:"failed"`
+}
+
+// Open opens or creates the crawl state file at path. bbolt holds an
+// exclusive lock on the file, so only one process can run a crawl at once.
+func Open(path string) (*Crawl, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{pendingBucket, doneBucket, failedBucket, rootsBucket, countsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		// files written before the counts existed are counted once
+		counts := tx.Bucket(countsBucket)
+		for _, name := range stateBuckets {
+			if counts.Get(name) == nil {
+				if err := putCount(tx, name, tx.Bucket(name).Stats().KeyN); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initializing %s: %w", path, err)
+	}
+	return &Crawl{db: db}, nil
+}
+
+// count returns the number of refs in the state bucket name
+func count(tx *bolt.Tx, name []byte) int {
+	v := tx.Bucket(countsBucket).Get(name)
+	if len(v) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(v))
+}
+
+func putCount(tx *bolt.Tx, name []byte, n int) error {
+	return tx.Bucket(countsBucket).Put(name, binary.BigEndian.AppendUint64(nil, uint64(n)))
+}
+
+func addCount(tx *bolt.Tx, name []byte, delta int) error {
+	if delta == 0 {
+		return nil
+	}
+	return putCount(tx, name, count(tx, name)+delta)
+}
+
+func (c *Crawl) Close() error {
+	return c.db.Close()
+}
+
+// Add queues refs not already pending, done or failed, returning how many
+// were new
+func (c *Crawl) Add(refs ...string) (int, error) {
+	added := 0
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		pending := tx.Bucket(pendingBucket)
+		done, failed := tx.Bucket(doneBucket), tx.Bucket(failedBucket)
+		for _, ref := range refs {
+			k := []byte(ref)
+			if pending.Get(k) != nil || done.Get(k) != nil || failed.Get(k) != nil {
+				continue
+			}
+			if err := pending.Put(k, []byte{}); err != nil {
+				return err
+			}
+			added++
+		}
+		return addCount(tx, pendingBucket, added)
+	})
+	return added, err
+}
+
+// AddDir queues the files under dir for which match returns true, or all
+// of them if match is nil. A tree is only walked until it has been added completely
+// once, so resuming a crawl doesn't walk every root again; files created
+// since then need another root, or Add.
+func (c *Crawl) AddDir(ctx context.Context, dir string, match func(path string) bool) (int, error) {
+	root := []byte(filepath.Clean(dir))
+	var walked bool
+	c.db.View(func(tx *bolt.Tx) error {
+		walked = tx.Bucket(rootsBucket).Get(root) != nil
+		return nil
+	})
+	if walked {
+		return 0, nil
+	}
+
+	added := 0
+	batch := make([]string, 0, addBatch)
+	flush := func() error {
+		n, err := c.Add(batch...)
+		added += n
+		batch = batch[:0]
+		return err
+	}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || (match != nil && !match(p)) {
+			return nil
+		}
+		if batch = append(batch, p); len(batch) == addBatch {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return added, err
+	}
+	return added, c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(rootsBucket).Put(root, []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+}
+
+// Stats counts the refs in each state
+func (c *Crawl) Stats() (Stats, error) {
+	var s Stats
+	err := c.db.View(func(tx *bolt.Tx) error {
+		s.Pending = count(tx, pendingBucket)
+		s.Done = count(tx, doneBucket)
+		s.Failed = count(tx, failedBucket)
+		return nil
+	})
+	return s, err
+}
+
+// RetryFailed moves every failed ref back to pending, for another Run after
+// the cause of the failures has been fixed
+func (c *Crawl) RetryFailed() (int, error) {
+	n := 0
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		pending, failed := tx.Bucket(pendingBucket), tx.Bucket(failedBucket)
+		n = count(tx, failedBucket)
+		queued := 0
+		err := failed.ForEach(func(k, _ []byte) error {
+			if pending.Get(k) != nil {
+				return nil
+			}
+			queued++
+			return pending.Put(k, []byte{})
+		})
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteBucket(failedBucket); err != nil {
+			return err
+		}
+		if _, err = tx.CreateBucket(failedBucket); err != nil {
+			return err
+		}
+		if err := putCount(tx, failedBucket, 0); err != nil {
+			return err
+		}
+		return addCount(tx, pendingBucket, queued)
+	})
+	return n, err
+}
+
+// Results calls fn with the result of every ref hashed so far, successful
+// ones first, in lexical order of ref
+func (c *Crawl) Results(fn func(*worker.Result) error) error {
+	return c.db.View(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{doneBucket, failedBucket} {
+			err := tx.Bucket(name).ForEach(func(k, v []byte) error {
+				var res worker.Result
+				if err := json.Unmarshal(v, &res); err != nil {
+					return fmt.Errorf("result for %s: %w", k, err)
+				}
+				return fn(&res)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Run hashes the pending refs with a worker configured by cfg, returning
+// once none are left. cfg.Queue is replaced by the crawl; cfg.Results, if
+// set, is given each result before the crawl records it, so a result may
+// be published twice if the process dies in between. Cancelling ctx stops
+// the crawl with the unfinished refs left pending.
+func (c *Crawl) Run(ctx context.Context, cfg worker.Config) error {
+	q := &queue{c: c, notify: make(chan struct{}, 1), inflight: make(map[string]bool)}
+	cfg.Queue = q
+	cfg.Results = &recorder{c: c, next: cfg.Results}
+	w, err := worker.New(cfg)
+	if err != nil {
+		return err
+	}
+	if err := w.Run(ctx); !errors.Is(err, errDrained) {
+		return err
+	}
+	return nil
+}
+
+// recorder moves each published result's ref out of pending
+type recorder struct {
+	c    *Crawl
+	next worker.Publisher
+}
+
+func (r *recorder) Publish(ctx context.Context, res *worker.Result) error {
+	if r.next != nil {
+		if err := r.next.Publish(ctx, res); err != nil {
+			return err
+		}
+	}
+	val, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	to := doneBucket
+	if res.Error != "" {
+		to = failedBucket
+	}
+	// Batch coalesces the commits of concurrent jobs into one fsync
+	return r.c.db.Batch(func(tx *bolt.Tx) error {
+		k := []byte(res.Ref)
+		pending, dst := tx.Bucket(pendingBucket), tx.Bucket(to)
+		if pending.Get(k) != nil {
+			if err := pending.Delete(k); err != nil {
+				return err
+			}
+			if err := addCount(tx, pendingBucket, -1); err != nil {
+				return err
+			}
+		}
+		if dst.Get(k) == nil {
+			if err := addCount(tx, to, 1); err != nil {
+				return err
+			}
+		}
+		return dst.Put(k, val)
+	})
+}
+
+// errDrained ends a Run once every pending ref has a result
+var errDrained = errors.New("crawl: no refs pending")
+
+// scanBatch is how many pending refs the queue reads at a time
+const scanBatch = 256
+
+// queue is a worker.Queue over the pending refs. It reads them in key order,
+// a batch at a time, and hands retries back out after their delay. It is
+// drained when a pass over the keys finds nothing and no job is out.
+type queue struct {
+	c      *Crawl
+	notify chan struct{}
+
+	mu          sync.Mutex
+	next        [][]byte
+	after       []byte
+	retries     []*message
+	inflight    map[string]bool
+	outstanding int
+	// rescanned is set when a pass started with no jobs out, so finding
+	// nothing in it means there is nothing left
+	rescanned bool
+}
+
+func (q *queue) Receive(ctx context.Context) (worker.Message, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		q.mu.Lock()
+		if len(q.retries) > 0 {
+			m := q.retries[0]
+			q.retries = q.retries[1:]
+			q.mu.Unlock()
+			m.attempts++
+			return m, nil
+		}
+		if len(q.next) == 0 {
+			if err := q.scan(); err != nil {
+				q.mu.Unlock()
+				return nil, err
+			}
+		}
+		if len(q.next) > 0 {
+			ref := string(q.next[0])
+			q.next = q.next[1:]
+			q.inflight[ref] = true
+			q.outstanding++
+			q.mu.Unlock()
+			return &message{q: q, ref: ref, attempts: 1}, nil
+		}
+		if q.outstanding == 0 && q.rescanned {
+			q.mu.Unlock()
+			return nil, errDrained
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// scan reads the next batch of pending refs after the last one handed out.
+// At the end of the keys it starts again from the first, which finds refs
+// added during the run and those whose jobs were still out last time;
+// refs still out are skipped.
+func (q *queue) scan() error {
+	return q.c.db.View(func(tx *bolt.Tx) error {
+		for pass := 0; pass < 2 && len(q.next) == 0; pass++ {
+			if q.after == nil {
+				q.rescanned = q.outstanding == 0
+			}
+			cur := tx.Bucket(pendingBucket).Cursor()
+			k, _ := cur.First()
+			if q.after != nil {
+				k, _ = cur.Seek(q.after)
+				if k != nil && string(k) == string(q.after) {
+					k, _ = cur.Next()
+				}
+			}
+			for ; k != nil && len(q.next) < scanBatch; k, _ = cur.Next() {
+				if !q.inflight[string(k)] {
+					q.next = append(q.next, append([]byte(nil), k...))
+				}
+			}
+			if len(q.next) > 0 {
+				q.after = q.next[len(q.next)-1]
+				q.rescanned = false
+				return nil
+			}
+			if q.after == nil {
+				return nil
+			}
+			q.after = nil
+		}
+		return nil
+	})
+}
+
+func (q *queue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// message is a pending ref handed to a worker
+type message struct {
+	q        *queue
+	ref      string
+	attempts int
+}
+
+func (m *message) Body() []byte {
+	body, _ := json.Marshal(worker.Job{ID: m.ref, Ref: m.ref})
+	return body
+}
+
+func (m *message) Attempts() int { return m.attempts }
+
+func (m *message) Ack() error {
+	m.q.mu.Lock()
+	delete(m.q.inflight, m.ref)
+	m.q.outstanding--
+	m.q.mu.Unlock()
+	m.q.wake()
+	return nil
+}
+
+// Nack redelivers the ref after delay. Attempts are only counted within a
+// Run; a resumed crawl starts every pending ref at its first attempt.
+func (m *message) Nack(delay time.Duration) error {
+	time.AfterFunc(delay, func() {
+		m.q.mu.Lock()
+		m.q.retries = append(m.q.retries, m)
+		m.q.mu.Unlock()
+		m.q.wake()
+	})
+	return nil
+}
@@ -0,0 +1,142 @@
+package crawl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/whyrusleeping/gopdq/worker"
+	bolt "go.etcd.io/bbolt"
+)
+
+type collector struct {
+	lk      sync.Mutex
+	results []*worker.Result
+	onPub   func()
+}
+
+func (c *collector) Publish(ctx context.Context, res *worker.Result) error {
+	c.lk.Lock()
+	c.results = append(c.results, res)
+	c.lk.Unlock()
+	if c.onPub != nil {
+		c.onPub()
+	}
+	return nil
+}
+
+func TestResume(t *testing.T) {
+	dir := t.TempDir()
+	src, err := os.ReadFile("../cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.jpg", "b.jpg", "sub/c.jpg", "sub/d.jpg", "e.jpg"} {
+		p := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		if err := os.WriteFile(p, src, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "broken.jpg"), src[:100], 0o644)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("skip me"), 0o644)
+	isJpeg := func(p string) bool { return strings.HasSuffix(p, ".jpg") }
+
+	state := filepath.Join(t.TempDir(), "crawl.db")
+	c, err := Open(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.AddDir(context.Background(), dir, isJpeg)
+	if err != nil || n != 6 {
+		t.Fatalf("added %d, %v; expected 6", n, err)
+	}
+
+	// stop the first run after two results
+	ctx, cancel := context.WithCancel(context.Background())
+	first := &collector{}
+	first.onPub = func() {
+		if len(first.results) == 2 {
+			cancel()
+		}
+	}
+	if err := c.Run(ctx, worker.Config{Results: first}); err != context.Canceled {
+		t.Fatalf("expected the first run to be cancelled, got %v", err)
+	}
+	if len(first.results) != 2 {
+		t.Fatalf("expected the first run to stop after 2 results, got %d", len(first.results))
+	}
+	c.Close()
+
+	c, err = Open(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if n, err := c.AddDir(context.Background(), dir, isJpeg); err != nil || n != 0 {
+		t.Fatalf("expected the walked root to add nothing, added %d, %v", n, err)
+	}
+	second := &collector{}
+	if err := c.Run(context.Background(), worker.Config{Results: second, Concurrency: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]int)
+	for _, res := range append(first.results, second.results...) {
+		seen[res.Ref]++
+	}
+	if len(seen) != 6 {
+		t.Fatalf("expected results for 6 files, got %v", seen)
+	}
+	for ref, n := range seen {
+		if n != 1 {
+			t.Errorf("%s hashed %d times", ref, n)
+		}
+	}
+
+	stats, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (Stats{Done: 5, Failed: 1}) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// recorded results survive, and failures can be requeued
+	var stored int
+	if err := c.Results(func(*worker.Result) error { stored++; return nil }); err != nil || stored != 6 {
+		t.Fatalf("expected 6 stored results, got %d, %v", stored, err)
+	}
+	if n, err := c.RetryFailed(); err != nil || n != 1 {
+		t.Fatalf("RetryFailed: %d, %v", n, err)
+	}
+	if stats, err := c.Stats(); err != nil || stats != (Stats{Pending: 1, Done: 5}) {
+		t.Fatalf("stats after RetryFailed %+v, %v", stats, err)
+	}
+	third := &collector{}
+	if err := c.Run(context.Background(), worker.Config{Results: third}); err != nil {
+		t.Fatal(err)
+	}
+	if len(third.results) != 1 || !strings.HasSuffix(third.results[0].Ref, "broken.jpg") || third.results[0].Error == "" {
+		t.Fatalf("expected only the broken file to be retried, got %+v", third.results)
+	}
+
+	// a state file from before the counts is counted when opened
+	err = c.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(countsBucket)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if c, err = Open(state); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if stats, err := c.Stats(); err != nil || stats != (Stats{Done: 5, Failed: 1}) {
+		t.Fatalf("stats after reopening %+v, %v", stats, err)
+	}
+}
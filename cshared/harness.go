@@ -0,0 +1,22 @@
+package main
+
+// #include <stdint.h>
+import "C"
+
+import "unsafe"
+
+// hashRGBA calls pdq_hash_rgba with Go values, for the tests, which can't
+// use cgo themselves. A nil pix passes a NULL pointer.
+func hashRGBA(pix []byte, width, height, stride int32) (hash string, quality int, code int) {
+	var p *C.uint8_t
+	if len(pix) > 0 {
+		p = (*C.uint8_t)(unsafe.Pointer(&pix[0]))
+	}
+	var out [hashHexSize]byte
+	var q C.int
+	code = int(pdq_hash_rgba(p, C.int(width), C.int(height), C.int(stride), (*C.char)(unsafe.Pointer(&out[0])), &q))
+	if code != 0 {
+		return "", 0, code
+	}
+	return C.GoString((*C.char)(unsafe.Pointer(&out[0]))), int(q), code
+}
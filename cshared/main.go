@@ -0,0 +1,146 @@
+// Command cshared is the hasher as a C shared library for other languages,
+// built with
+//
+//	go build -buildmode=c-shared -o libpdq.so ./cshared
+//
+// pdq.h declares the exported functions and their return codes.
+package main
+
+// #include <stdint.h>
+import "C"
+
+import (
+	"errors"
+	"image"
+	"math"
+	"os"
+	"unsafe"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// Return codes and sizes, as defined in pdq.h. cgo's own prototypes drop
+// the header's const qualifiers, so it can't be included here.
+const (
+	abiVersion  = 1
+	hashHexSize = 65
+
+	errArgument = -1
+	errOpen     = -2
+	errDecode   = -3
+	errHash     = -4
+)
+
+// maxPixels is the hasher's own limit on image size, checked here before
+// the pixels are copied
+const maxPixels = 1 << 28
+
+var hasher = gopdq.NewPdqHasher()
+
+// messages are the pdq_strerror strings, indexed by the negated code. They
+// are allocated once and never freed, so callers may keep them.
+var messages = cStrings(
+	"success",
+	"invalid argument",
+	"file could not be read",
+	"unsupported or corrupt image",
+	"image rejected by the hasher",
+	"unknown error",
+)
+
+func cStrings(s ...string) []*C.char {
+	out := make([]*C.char, len(s))
+	for i, v := range s {
+		out[i] = C.CString(v)
+	}
+	return out
+}
+
+//export pdq_abi_version
+func pdq_abi_version() C.int {
+	return abiVersion
+}
+
+//export pdq_strerror
+func pdq_strerror(code C.int) *C.char {
+	if code > 0 || code < errHash {
+		return messages[len(messages)-1]
+	}
+	return messages[-code]
+}
+
+//export pdq_hash_file
+func pdq_hash_file(path *C.char, hashOut *C.char, qualityOut *C.int) C.int {
+	if path == nil || hashOut == nil {
+		return errArgument
+	}
+	f, err := os.Open(C.GoString(path))
+	if err != nil {
+		return errOpen
+	}
+	defer f.Close()
+	res, err := hasher.FromReader(f)
+	if err != nil {
+		if errors.Is(err, gopdq.ErrUnsupportedFormat) || errors.Is(err, gopdq.ErrDecodeFailed) {
+			return errDecode
+		}
+		return errHash
+	}
+	return writeResult(res, hashOut, qualityOut)
+}
+
+//export pdq_hash_rgba
+func pdq_hash_rgba(pixels *C.uint8_t, width, height, stride C.int, hashOut *C.char, qualityOut *C.int) C.int {
+	// sizes are worked out in 64 bits, where C ints can't overflow them
+	w, h, s := int64(width), int64(height), int64(stride)
+	if s == 0 {
+		s = w * 4
+	}
+	if pixels == nil || hashOut == nil || w <= 0 || h <= 0 || s < w*4 {
+		return errArgument
+	}
+	// the last row needn't extend to a full stride
+	n := s*(h-1) + w*4
+	if n > math.MaxInt32 {
+		return errArgument
+	}
+	if w*h > maxPixels {
+		return errHash
+	}
+	img := &image.NRGBA{
+		Pix:    C.GoBytes(unsafe.Pointer(pixels), C.int(n)),
+		Stride: int(s),
+		Rect:   image.Rect(0, 0, int(w), int(h)),
+	}
+	res, err := hasher.HashImage(img)
+	if err != nil {
+		return errHash
+	}
+	return writeResult(res, hashOut, qualityOut)
+}
+
+//export pdq_distance
+func pdq_distance(a, b *C.char) C.int {
+	if a == nil || b == nil {
+		return errArgument
+	}
+	ha, errA := gopdq.ParseHash(C.GoString(a))
+	hb, errB := gopdq.ParseHash(C.GoString(b))
+	if errA != nil || errB != nil {
+		return errArgument
+	}
+	return C.int(ha.HammingDistance(hb))
+}
+
+// writeResult copies the hash, NUL terminated, into the caller's
+// PDQ_HASH_HEX_SIZE byte buffer
+func writeResult(res *gopdq.HashResult, hashOut *C.char, qualityOut *C.int) C.int {
+	out := unsafe.Slice((*byte)(unsafe.Pointer(hashOut)), hashHexSize)
+	out[copy(out, res.Hash.String())] = 0
+	if qualityOut != nil {
+		*qualityOut = C.int(res.Quality)
+	}
+	return 0
+}
+
+func main() {}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+func TestHashRGBA(t *testing.T) {
+	const w, h = 97, 61
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 7 % 251)
+	}
+	want, err := gopdq.NewPdqHasher().HashImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, quality, code := hashRGBA(img.Pix, w, h, 0)
+	if code != 0 || hash != want.Hash.String() || quality != want.Quality {
+		t.Fatalf("packed rows: %s, quality %d, code %d; expected %s, quality %d", hash, quality, code, want.Hash, want.Quality)
+	}
+
+	// padded rows, with the last one cut short
+	const stride = w*4 + 12
+	padded := make([]byte, stride*(h-1)+w*4)
+	for y := 0; y < h; y++ {
+		copy(padded[y*stride:], img.Pix[y*img.Stride:(y+1)*img.Stride])
+	}
+	if hash, _, code := hashRGBA(padded, w, h, stride); code != 0 || hash != want.Hash.String() {
+		t.Fatalf("padded rows: %s, code %d; expected %s", hash, code, want.Hash)
+	}
+
+	// none of these may read past the small buffer given
+	for _, c := range []struct {
+		name                  string
+		width, height, stride int32
+		code                  int
+	}{
+		{"zero width", 0, h, 0, errArgument},
+		{"negative height", w, -1, 0, errArgument},
+		{"short stride", w, h, w*4 - 1, errArgument},
+		{"width overflowing the stride", 1 << 30, 1, 0, errArgument},
+		{"stride overflowing the size", 1, 1 << 20, 1 << 12, errArgument},
+		{"largest stride", 1, 2, math.MaxInt32, errArgument},
+		{"too many pixels", 1 << 14, 1<<14 + 1, 0, errHash},
+	} {
+		if _, _, code := hashRGBA(img.Pix, c.width, c.height, c.stride); code != c.code {
+			t.Errorf("%s: code %d, expected %d", c.name, code, c.code)
+		}
+	}
+	if _, _, code := hashRGBA(nil, w, h, 0); code != errArgument {
+		t.Errorf("NULL pixels: code %d", code)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+ * pdq.h is the C interface of libpdq, the gopdq hasher built as a shared
+ * library:
+ *
+ *     go build -buildmode=c-shared -o libpdq.so ./cshared
+ *
+ * The functions below are the stable ABI; the header go build writes next
+ * to the library declares the same functions alongside cgo internals, and
+ * needn't be used. Every function is safe to call from several threads at
+ * once.
+ */
+#ifndef GOPDQ_PDQ_H
+#define GOPDQ_PDQ_H
+
+#include <stdint.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+/* PDQ_ABI_VERSION is the version of this interface, returned by
+ * pdq_abi_version. It changes only when an existing function does. */
+#define PDQ_ABI_VERSION 1
+
+/* PDQ_HASH_HEX_SIZE is the size of a hash output buffer: 64 lowercase hex
+ * digits and the terminating NUL */
+#define PDQ_HASH_HEX_SIZE 65
+
+/* Return codes. Distances and successes are never negative. */
+#define PDQ_OK 0
+#define PDQ_ERR_ARGUMENT -1 /* a NULL pointer, bad size or malformed hash */
+#define PDQ_ERR_OPEN -2     /* the file couldn't be opened or read */
+#define PDQ_ERR_DECODE -3   /* the data isn't an image in a supported format */
+#define PDQ_ERR_HASH -4     /* the image was rejected, such as for its size */
+
+int pdq_abi_version(void);
+
+/* pdq_strerror describes a return code. The string is static. */
+const char *pdq_strerror(int code);
+
+/* pdq_hash_file hashes the image file at path, a NUL terminated UTF-8
+ * string, writing the hash to hash_out and its quality, from 0 to 100, to
+ * quality_out unless that is NULL. */
+int pdq_hash_file(const char *path, char *hash_out, int *quality_out);
+
+/* pdq_hash_rgba hashes width x height pixels of non-premultiplied RGBA,
+ * four bytes each, with rows stride bytes apart. A stride of 0 means rows
+ * are packed, width * 4 bytes apart. Buffers of 2 GiB or more are refused
+ * with PDQ_ERR_ARGUMENT. */
+int pdq_hash_rgba(const uint8_t *pixels, int width, int height, int stride,
+                  char *hash_out, int *quality_out);
+
+/* pdq_distance returns the Hamming distance, from 0 to 256, between two
+ * hashes given as NUL terminated hex strings, or PDQ_ERR_ARGUMENT if either
+ * doesn't parse. */
+int pdq_distance(const char *a, const char *b);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif
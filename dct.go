@@ -0,0 +1,125 @@
+package gopdq
+
+import (
+	"math"
+	"math/bits"
+)
+
+// The hash only needs DCT-II coefficients 1 through 16 of each 64 point
+// row and column. Rather than multiplying by the dense 16x64 matrix, the
+// transform is computed by repeated even/odd folding: with u[n] = x[n] +
+// x[N-1-n] and v[n] = x[n] - x[N-1-n], the even coefficients of x are the
+// DCT-II of u at half the length and the odd ones are a product of v with
+// an (N/2)-wide table. Only the outputs still needed are kept at each
+// level, which takes about a third of the multiplies of the matrix.
+
+// dctOutputs is the number of coefficients computed, DC included
+const dctOutputs = 17
+
+// dctScale is the orthonormal scale factor applied to every coefficient
+var dctScale = float32(math.Sqrt(2.0 / 64.0))
+
+// dctOddTables holds, indexed by log2 of the transform length n, the table
+// giving the odd coefficients needed at that level from the n/2 folded
+// differences
+var dctOddTables = func() (tables [7][]float32) {
+	for n, k := 64, dctOutputs; n > 1; n, k = n/2, (k+1)/2 {
+		half := n / 2
+		odd := k / 2
+		t := make([]float32, odd*half)
+		for m := 0; m < odd; m++ {
+			for i := 0; i < half; i++ {
+				t[m*half+i] = float32(math.Cos(math.Pi * float64((2*i+1)*(2*m+1)) / float64(2*n)))
+			}
+		}
+		tables[bits.TrailingZeros(uint(n))] = t
+	}
+	return tables
+}()
+
+// dctPrefix transforms the n rows of width w in x, writing the first k
+// unscaled DCT-II coefficients of each column to rows 0, stride, 2*stride,
+// ... of out. tmp must hold 2*n*w values. Working on whole rows keeps the
+// accumulations of different columns independent of each other.
+func dctPrefix(x []float32, n, w, k int, out []float32, stride int, tmp []float32) {
+	if k == 1 || n == 1 {
+		s := out[:w]
+		copy(s, x[:w])
+		for r := 1; r < n; r++ {
+			row := x[r*w : (r+1)*w]
+			for c, v := range row {
+				s[c] += v
+			}
+		}
+		return
+	}
+
+	half := n / 2
+	u, v := tmp[:half*w], tmp[half*w:n*w]
+	for r := 0; r < half; r++ {
+		a, b := x[r*w:(r+1)*w], x[(n-1-r)*w:(n-r)*w]
+		ur, vr := u[r*w:(r+1)*w], v[r*w:(r+1)*w]
+		for c := range ur {
+			ur[c] = a[c] + b[c]
+			vr[c] = a[c] - b[c]
+		}
+	}
+
+	t := dctOddTables[bits.TrailingZeros(uint(n))]
+	for m := 0; m < k/2; m++ {
+		s := out[(2*m+1)*stride*w : ((2*m+1)*stride+1)*w]
+		clear(s)
+		tm := t[m*half : (m+1)*half]
+		// four rows at a time where possible, cutting the passes over s
+		r := 0
+		for ; r+4 <= half; r += 4 {
+			dctAccum4(s, v[r*w:(r+4)*w], (*[4]float32)(tm[r:r+4]))
+		}
+		for ; r < half; r += 2 {
+			c0, c1 := tm[r], tm[r+1]
+			v0, v1 := v[r*w:(r+1)*w], v[(r+1)*w:(r+2)*w]
+			v0, v1 = v0[:len(s)], v1[:len(s)]
+			for c := range s {
+				s[c] += v0[c]*c0 + v1[c]*c1
+			}
+		}
+	}
+	dctPrefix(u, half, w, (k+1)/2, out, 2*stride, tmp[n*w:])
+}
+
+// dctAccum4Generic adds the four rows of v, weighted by c, to s. dctAccum4
+// calls it unless the CPU has a SIMD kernel.
+func dctAccum4Generic(s, v []float32, c *[4]float32) {
+	w := len(s)
+	v0, v1, v2, v3 := v[:w], v[w:2*w], v[2*w:3*w], v[3*w:4*w]
+	c0, c1, c2, c3 := c[0], c[1], c[2], c[3]
+	for i := range s {
+		s[i] += v0[i]*c0 + v1[i]*c1 + v2[i]*c2 + v3[i]*c3
+	}
+}
+
+// dct64To16 computes the 16x16 block of DCT coefficients of the 64x64
+// buffer A that the hash is made from, DC row and column excluded, into B
+func dct64To16(A, B []float32) {
+	var (
+		coef [dctOutputs * 64]float32
+		T    [64 * 16]float32
+		tmp  [2 * 64 * 64]float32
+	)
+
+	// transform the columns of A, then transpose the 16 rows kept so the
+	// second pass can work down columns too
+	dctPrefix(A, 64, 64, dctOutputs, coef[:], 1, tmp[:])
+	for i := 0; i < 16; i++ {
+		for j, v := range coef[(i+1)*64 : (i+2)*64] {
+			T[j*16+i] = dctScale * v
+		}
+	}
+
+	dctPrefix(T[:], 64, 16, dctOutputs, coef[:], 1, tmp[:])
+	for j := 0; j < 16; j++ {
+		for i, v := range coef[(j+1)*16 : (j+2)*16] {
+			B[i*16+j] = dctScale * v
+		}
+	}
+}
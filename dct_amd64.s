@@ -0,0 +1,38 @@
+#include "textflag.h"
+
+// func dctAccum4Asm(s, v *float32, n int, c *[4]float32)
+//
+// s[i] += v0[i]*c[0] + v1[i]*c[1] + v2[i]*c[2] + v3[i]*c[3], where v0-v3 are
+// the consecutive n long rows at v and n is a positive multiple of 8
+TEXT ·dctAccum4Asm(SB), NOSPLIT, $0-32
+	MOVQ s+0(FP), DI
+	MOVQ v+8(FP), SI
+	MOVQ n+16(FP), CX
+	MOVQ c+24(FP), AX
+	VBROADCASTSS (AX), Y4
+	VBROADCASTSS 4(AX), Y5
+	VBROADCASTSS 8(AX), Y6
+	VBROADCASTSS 12(AX), Y7
+
+	SHLQ $2, CX
+	LEAQ (SI)(CX*1), R9
+	LEAQ (R9)(CX*1), R10
+	LEAQ (R10)(CX*1), R11
+	XORQ BX, BX
+
+loop:
+	VMULPS  (SI)(BX*1), Y4, Y0
+	VMULPS  (R9)(BX*1), Y5, Y1
+	VADDPS  Y1, Y0, Y0
+	VMULPS  (R10)(BX*1), Y6, Y1
+	VADDPS  Y1, Y0, Y0
+	VMULPS  (R11)(BX*1), Y7, Y1
+	VADDPS  Y1, Y0, Y0
+	VADDPS  (DI)(BX*1), Y0, Y0
+	VMOVUPS Y0, (DI)(BX*1)
+	ADDQ    $32, BX
+	CMPQ    BX, CX
+	JLT     loop
+
+	VZEROUPPER
+	RET
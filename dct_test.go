@@ -0,0 +1,145 @@
+package gopdq
+
+import (
+	"image"
+	_ "image/jpeg"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// dctMatrix is the 16x64 DCT matrix, without its DC row
+var dctMatrix = func() []float32 {
+	D := make([]float32, 16*64)
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 64; j++ {
+			D[i*64+j] = dctScale * float32(math.Cos((math.Pi/2.0/64.0)*float64(i+1)*float64(2*j+1)))
+		}
+	}
+	return D
+}()
+
+// dct64To16Matrix is the dense matrix product dct64To16 replaced, kept as
+// the reference it is checked against
+func dct64To16Matrix(A, B []float32) {
+	D := dctMatrix
+	T := make([]float32, 16*64)
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 64; j++ {
+			var tij float32
+			for k := 0; k < 64; k++ {
+				tij += D[i*64+k] * A[k*64+j]
+			}
+			T[i*64+j] = tij
+		}
+	}
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 16; j++ {
+			var sumk float32
+			for k := 0; k < 64; k++ {
+				sumk += T[i*64+k] * D[j*64+k]
+			}
+			B[i*16+j] = sumk
+		}
+	}
+}
+
+// decimated runs the hashing pipeline on img up to the DCT
+func decimated(img image.Image) []float32 {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	buffer1 := make([]float32, w*h)
+	buffer2 := make([]float32, w*h)
+	NewPdqHasher().fillFloatLumaFromImage(img, buffer1)
+	jaroszFilterFloat(buffer1, buffer2, h, w, computeJaroszFilterWindowSize(w), computeJaroszFilterWindowSize(h), PDQ_NUM_JAROSZ_XY_PASSES)
+	out := make([]float32, 64*64)
+	decimateFloat(buffer1, h, w, out)
+	return out
+}
+
+func noiseImage(rng *rand.Rand, w, h int) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rng.Intn(256))
+	}
+	return img
+}
+
+func TestFastDCT(t *testing.T) {
+	var inputs [][]float32
+	for _, path := range []string{"cat.jpg", "testdata/rgb.jpg", "testdata/gray.jpg", "testdata/cmyk.jpg", "testdata/progressive.jpg"} {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, _, err := image.Decode(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, decimated(img))
+	}
+	inputs = append(inputs, decimated(testPattern(517, 389)))
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		inputs = append(inputs, decimated(noiseImage(rng, 64+rng.Intn(400), 64+rng.Intn(400))))
+	}
+	// and raw buffers, which have far more high frequency content than
+	// anything that comes out of the filter
+	for i := 0; i < 50; i++ {
+		buf := make([]float32, 64*64)
+		for j := range buf {
+			buf[j] = rng.Float32() * 255
+		}
+		inputs = append(inputs, buf)
+	}
+	want := make([]float32, 16*16)
+	got := make([]float32, 16*16)
+	for n, in := range inputs {
+		dct64To16Matrix(in, want)
+		dct64To16(in, got)
+
+		var peak float64
+		for _, v := range want {
+			peak = max(peak, math.Abs(float64(v)))
+		}
+		for i := range want {
+			if d := math.Abs(float64(got[i] - want[i])); d > 1e-5*max(peak, 1) {
+				t.Fatalf("input %d, coefficient %d: got %v, expected %v", n, i, got[i], want[i])
+			}
+		}
+		if gh, wh := pdqBuffer16x16ToBits(got), pdqBuffer16x16ToBits(want); !gh.Equal(wh) {
+			t.Fatalf("input %d: hash %s differs from the matrix hash %s", n, gh, wh)
+		}
+	}
+
+	// a flat block has no AC energy at all. The matrix only got there up to
+	// rounding, leaving its hash to noise; folding cancels it exactly.
+	flat := make([]float32, 64*64)
+	for i := range flat {
+		flat[i] = 128
+	}
+	dct64To16(flat, got)
+	for i, v := range got {
+		if v != 0 {
+			t.Fatalf("flat block has coefficient %d of %v", i, v)
+		}
+	}
+}
+
+func BenchmarkDCT(b *testing.B) {
+	in := decimated(testPattern(517, 389))
+	out := make([]float32, 16*16)
+	b.Run("fast", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			dct64To16(in, out)
+		}
+	})
+	b.Run("matrix", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			dct64To16Matrix(in, out)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package gopdq
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"time"
+)
+
+// headerPeekSize is how much input is buffered to find an image's dimensions
+// before decoding it
+const headerPeekSize = 64 * 1024
+
+// maxHeaderSize is how far into the input peekConfig reads looking for a
+// header that isn't within headerPeekSize
+const maxHeaderSize = 1 << 20
+
+// peekConfig reads the image dimensions from the start of r, returning a
+// reader that still yields all of r. The first headerPeekSize bytes are
+// buffered; a header further in, behind large metadata segments, is read on
+// to and everything before it kept in memory to replay, up to maxHeaderSize.
+// ok is false if r isn't in a format the image package knows or its header
+// can't be read within that.
+func peekConfig(r io.Reader) (br *bufio.Reader, cfg image.Config, ok bool, err error) {
+	br = bufio.NewReaderSize(r, headerPeekSize)
+	peek, err := br.Peek(headerPeekSize)
+	if len(peek) == 0 && err != nil && err != io.EOF {
+		return nil, cfg, false, err
+	}
+	cfg, _, cerr := image.DecodeConfig(bytes.NewReader(peek))
+	if cerr == nil || len(peek) < headerPeekSize || errors.Is(cerr, image.ErrFormat) {
+		return br, cfg, cerr == nil, nil
+	}
+
+	// otherwise skipping the check would let the decoder allocate whatever
+	// the header claims
+	head := new(bytes.Buffer)
+	cfg, _, cerr = image.DecodeConfig(io.TeeReader(io.LimitReader(br, maxHeaderSize), head))
+	br = bufio.NewReaderSize(io.MultiReader(head, br), headerPeekSize)
+	return br, cfg, cerr == nil, nil
+}
+
+// checkSize applies checkBounds plus the limits set with WithMaxPixels,
+// WithMaxDecodedBytes and WithSmallImages
+func (h *PdqHasher) checkSize(b image.Rectangle) error {
+	if err := checkBounds(b); err != nil {
+		return err
+	}
+	w, ht := b.Dx(), b.Dy()
+	if err := h.checkSmall(w, ht); err != nil {
+		return err
+	}
+	if h.maxPixels > 0 && int64(w)*int64(ht) > h.maxPixels {
+		return fmt.Errorf("%w: %dx%d exceeds %d pixels", ErrImageTooLarge, w, ht, h.maxPixels)
+	}
+	if size := EstimateDecodedBytes(w, ht); h.maxDecodedBytes > 0 && size > h.maxDecodedBytes {
+		return fmt.Errorf("%w: %dx%d needs about %d bytes, limit is %d", ErrImageTooLarge, w, ht, size, h.maxDecodedBytes)
+	}
+	return nil
+}
+
+// decodeTimeoutError is returned when a decode runs past its deadline
+func decodeTimeoutError(timeout time.Duration) error {
+	return fmt.Errorf("%w: decode took longer than %v: %w", ErrImageTooLarge, timeout, context.DeadlineExceeded)
+}
+
+// decodeBefore runs decode in the background and gives up on it at deadline.
+// A zero deadline runs decode directly. Go decoders can't be interrupted, so
+// the abandoned decode carries on until it finishes or, when reading through
+// a deadlineReader, next touches its input.
+func (h *PdqHasher) decodeBefore(deadline time.Time, decode func() (image.Image, string, error)) (image.Image, string, error) {
+	if deadline.IsZero() {
+		return decode()
+	}
+
+	type result struct {
+		img    image.Image
+		format string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		img, format, err := decode()
+		done <- result{img, format, err}
+	}()
+
+	t := time.NewTimer(time.Until(deadline))
+	defer t.Stop()
+	select {
+	case res := <-done:
+		return res.img, res.format, res.err
+	case <-t.C:
+		return nil, "", decodeTimeoutError(h.decodeTimeout)
+	}
+}
+
+// deadlineReader fails every read once its deadline has passed, so a slow
+// input can't hold a decode open
+type deadlineReader struct {
+	r        io.Reader
+	deadline time.Time
+	timeout  time.Duration
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	if time.Now().After(d.deadline) {
+		return 0, decodeTimeoutError(d.timeout)
+	}
+	return d.r.Read(p)
+}
@@ -0,0 +1,95 @@
+package gopdq
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+)
+
+// DecoderInfo describes a decoder available in this build
+type DecoderInfo struct {
+	// Format is the name HashStats.Decoder reports for images it decodes
+	Format string `json:"format"`
+	// Library is the package or library doing the decoding. Formats added
+	// with image.RegisterFormat by some other package report
+	// "image.RegisterFormat", as the image package doesn't say which.
+	Library string `json:"library"`
+	// Cgo is set for decoders implemented in C
+	Cgo bool `json:"cgo,omitempty"`
+	// Scaled is set when the decoder can decode at reduced size, which
+	// WithMaxDimension takes advantage of
+	Scaled bool `json:"scaled,omitempty"`
+	// Registered is set for decoders added with RegisterDecoder
+	Registered bool `json:"registered,omitempty"`
+}
+
+// probedFormats are formats the hasher doesn't link in itself but decodes
+// once some package registers them with the image package, such as
+// golang.org/x/image/webp, with a header each is recognized by
+var probedFormats = []struct {
+	format string
+	header string
+}{
+	{"gif", "GIF89a"},
+	{"bmp", "BM"},
+	{"tiff", "II*\x00"},
+	{"webp", "RIFF\x00\x00\x00\x00WEBPVP8 "},
+	{"heic", "\x00\x00\x00\x18ftypheic"},
+	{"avif", "\x00\x00\x00\x1cftypavif"},
+}
+
+// Decoders lists the decoders FromReader, FromBytes and FromFile can use,
+// in the order they are tried: those added with RegisterDecoder, then the
+// built-in JPEG, PNG and NetPBM decoders, then other formats registered
+// with the image package. A format can have more than one: built with cgo,
+// JPEGs read by FromJpeg, truncated ones and those decoded scaled go
+// through libjpeg, and other JPEGs through image/jpeg.
+func Decoders() []DecoderInfo {
+	var out []DecoderInfo
+	decodersLk.RLock()
+	for _, d := range decoders {
+		out = append(out, DecoderInfo{Format: d.name, Library: "RegisterDecoder", Registered: true})
+	}
+	decodersLk.RUnlock()
+
+	if canScaleJpeg {
+		out = append(out, DecoderInfo{Format: "jpeg", Library: "libjpeg", Cgo: true, Scaled: true})
+	}
+	out = append(out,
+		DecoderInfo{Format: "jpeg", Library: "image/jpeg"},
+		DecoderInfo{Format: "png", Library: "image/png"},
+		DecoderInfo{Format: "pbm", Library: "gopdq"},
+		DecoderInfo{Format: "pgm", Library: "gopdq"},
+		DecoderInfo{Format: "ppm", Library: "gopdq"},
+	)
+	for _, p := range probedFormats {
+		// the image package fails with ErrFormat when no decoder claims
+		// the header, and the decoder with some other error otherwise
+		if _, _, err := image.DecodeConfig(bytes.NewReader([]byte(p.header))); !errors.Is(err, image.ErrFormat) {
+			out = append(out, DecoderInfo{Format: p.format, Library: "image.RegisterFormat"})
+		}
+	}
+	return out
+}
+
+// RequireDecoders returns an error wrapping ErrUnsupportedFormat naming
+// those of formats Decoders doesn't list, so a service can refuse to start
+// in a build that can't decode what it will be sent
+func RequireDecoders(formats ...string) error {
+	have := make(map[string]bool)
+	for _, d := range Decoders() {
+		have[d.Format] = true
+	}
+	var missing []string
+	for _, f := range formats {
+		if !have[f] {
+			missing = append(missing, f)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: no decoder for %s in this build", ErrUnsupportedFormat, strings.Join(missing, ", "))
+	}
+	return nil
+}
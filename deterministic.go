@@ -0,0 +1,297 @@
+package gopdq
+
+import (
+	"image"
+	"image/color"
+	"slices"
+)
+
+// The deterministic pipeline mirrors the float pipeline step for step but
+// uses only integer arithmetic, so a given image hashes to the same bits on
+// every architecture, compiler and GOARCH (including wasm). Its hashes are
+// within a few bits of the float pipeline's, not bit-identical to them.
+
+// lumaScale is the fixed-point scale of integer luma values, such as 299*R +
+// 587*G + 114*B for BT.601: luma times 1000
+const lumaScale = 1000
+
+// intCoefScale converts integer DCT coefficients to the float pipeline's
+// scale: luma is times lumaScale, the DCT's second matrix product is left in
+// Q14 and its 2/64 scale factor is dropped
+const intCoefScale = 2.0 / 64 / (lumaScale * (1 << 14))
+
+// cosQuarterWave holds round(cos(pi*k/128) * 2^14) for k in [0, 64]. It is
+// written out rather than computed so it cannot vary with the platform's math
+// library.
+var cosQuarterWave = [65]int64{
+	16384, 16379, 16364, 16340, 16305, 16261, 16207, 16143,
+	16069, 15986, 15893, 15791, 15679, 15557, 15426, 15286,
+	15137, 14978, 14811, 14635, 14449, 14256, 14053, 13842,
+	13623, 13395, 13160, 12916, 12665, 12406, 12140, 11866,
+	11585, 11297, 11003, 10702, 10394, 10080, 9760, 9434,
+	9102, 8765, 8423, 8076, 7723, 7366, 7005, 6639,
+	6270, 5897, 5520, 5139, 4756, 4370, 3981, 3590,
+	3196, 2801, 2404, 2006, 1606, 1205, 804, 402,
+	0,
+}
+
+// dctMatrixInt is the 16x64 DCT matrix in Q14, without the sqrt(2/64) scale
+// factor. Scaling every coefficient by the same positive factor doesn't move
+// any coefficient across the median, so it can be dropped.
+var dctMatrixInt = func() []int64 {
+	m := make([]int64, 16*64)
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 64; j++ {
+			m[i*64+j] = cosPi128((i + 1) * (2*j + 1))
+		}
+	}
+	return m
+}()
+
+// cosPi128 returns cos(pi*k/128) in Q14
+func cosPi128(k int) int64 {
+	k &= 255
+	switch {
+	case k <= 64:
+		return cosQuarterWave[k]
+	case k <= 128:
+		return -cosQuarterWave[128-k]
+	case k <= 192:
+		return -cosQuarterWave[k-128]
+	default:
+		return cosQuarterWave[256-k]
+	}
+}
+
+// hashImageInt computes the hash using the integer pipeline
+func (h *PdqHasher) hashImageInt(img image.Image) (*HashResult, error) {
+	numCols := img.Bounds().Dx()
+	numRows := img.Bounds().Dy()
+
+	buffer1 := make([]int64, numRows*numCols)
+	buffer2 := make([]int64, numRows*numCols)
+	fillIntLumaFromImage(img, buffer1, h.luma)
+
+	windowSizeAlongRows, windowSizeAlongCols, passes := h.filterParams(numRows, numCols)
+	for i := 0; i < passes; i++ {
+		boxAlongRowsInt(buffer1, buffer2, numRows, numCols, windowSizeAlongRows)
+		boxAlongColsInt(buffer2, buffer1, numRows, numCols, windowSizeAlongCols)
+	}
+
+	buffer64x64 := make([]int64, 64*64)
+	decimateInt(buffer1, numRows, numCols, buffer64x64)
+	quality := computeIntQualityMetric(buffer64x64)
+
+	buffer16x16 := make([]int64, 16*16)
+	dct64To16Int(buffer64x64, buffer16x16)
+
+	sorted := slices.Clone(buffer16x16)
+	slices.Sort(sorted)
+	// the lower median, matching torbenMedian
+	median := sorted[(len(sorted)-1)/2]
+
+	hash := NewPdqHash256()
+	for i, v := range buffer16x16 {
+		if v > median {
+			hash.SetBit(i)
+		}
+	}
+
+	res := &HashResult{
+		Hash:    hash,
+		Quality: quality,
+	}
+	if h.margins {
+		res.Stats.Margins = coefMargins(buffer16x16, intCoefScale)
+	}
+	return res, checkSplit(h, buffer16x16, hash)
+}
+
+// fillIntLumaFromImage converts image pixels to fixed-point luminance values
+func fillIntLumaFromImage(img image.Image, luma []int64, standard LumaStandard) {
+	numCols := img.Bounds().Dx()
+	numRows := img.Bounds().Dy()
+	wr, wg, wb := lumaWeights[standard].ri, lumaWeights[standard].gi, lumaWeights[standard].bi
+
+	switch src := img.(type) {
+	case *image.Gray:
+		for row := 0; row < numRows; row++ {
+			pix := src.Pix[row*src.Stride:]
+			for col := 0; col < numCols; col++ {
+				luma[row*numCols+col] = lumaScale * int64(pix[col])
+			}
+		}
+		return
+	case *image.CMYK:
+		for row := 0; row < numRows; row++ {
+			pix := src.Pix[row*src.Stride:]
+			for col := 0; col < numCols; col++ {
+				r, g, b := color.CMYKToRGB(pix[col*4], pix[col*4+1], pix[col*4+2], pix[col*4+3])
+				luma[row*numCols+col] = wr*int64(r) + wg*int64(g) + wb*int64(b)
+			}
+		}
+		return
+	case *image.Paletted:
+		var table [256]int64
+		for i, c := range src.Palette[:min(len(src.Palette), 256)] {
+			r, g, b, _ := c.RGBA()
+			table[i] = wr*int64(r>>8) + wg*int64(g>>8) + wb*int64(b>>8)
+		}
+		for row := 0; row < numRows; row++ {
+			pix := src.Pix[row*src.Stride:]
+			for col := 0; col < numCols; col++ {
+				luma[row*numCols+col] = table[pix[col]]
+			}
+		}
+		return
+	}
+
+	rgbaImg := toRGBA(img)
+	stride := rgbaImg.Stride
+
+	for row := 0; row < numRows; row++ {
+		for col := 0; col < numCols; col++ {
+			offs := (row * stride) + (col * 4)
+			r8 := int64(rgbaImg.Pix[offs])
+			g8 := int64(rgbaImg.Pix[offs+1])
+			b8 := int64(rgbaImg.Pix[offs+2])
+
+			luma[row*numCols+col] = wr*r8 + wg*g8 + wb*b8
+		}
+	}
+}
+
+// boxAlongRowsInt applies 1D box filter along rows
+func boxAlongRowsInt(input, output []int64, numRows, numCols, windowSize int) {
+	for i := 0; i < numRows; i++ {
+		box1DInt(input[i*numCols:], output[i*numCols:], numCols, 1, windowSize)
+	}
+}
+
+// boxAlongColsInt applies 1D box filter along columns
+func boxAlongColsInt(input, output []int64, numRows, numCols, windowSize int) {
+	for j := 0; j < numCols; j++ {
+		box1DInt(input[j:], output[j:], numRows, numCols, windowSize)
+	}
+}
+
+// box1DInt is box1DFloat with exact sums and rounded integer division
+func box1DInt(invec []int64, outVec []int64, vectorLength, stride, fullWindowSize int) {
+	halfWindowSize := (fullWindowSize + 2) / 2
+	phase1Nreps := halfWindowSize - 1
+	phase2Nreps := fullWindowSize - halfWindowSize + 1
+	phase3Nreps := vectorLength - fullWindowSize
+	phase4Nreps := halfWindowSize - 1
+
+	li := 0
+	ri := 0
+	oi := 0
+	var sum, currentWindowSize int64
+
+	for i := 0; i < phase1Nreps; i++ {
+		sum += invec[ri]
+		currentWindowSize++
+		ri += stride
+	}
+
+	for i := 0; i < phase2Nreps; i++ {
+		sum += invec[ri]
+		currentWindowSize++
+		outVec[oi] = divRound(sum, currentWindowSize)
+		ri += stride
+		oi += stride
+	}
+
+	for i := 0; i < phase3Nreps; i++ {
+		sum += invec[ri]
+		sum -= invec[li]
+		outVec[oi] = divRound(sum, currentWindowSize)
+		li += stride
+		ri += stride
+		oi += stride
+	}
+
+	for i := 0; i < phase4Nreps; i++ {
+		sum -= invec[li]
+		currentWindowSize--
+		outVec[oi] = divRound(sum, currentWindowSize)
+		li += stride
+		oi += stride
+	}
+}
+
+// divRound divides a non-negative sum by n, rounding half up
+func divRound(sum, n int64) int64 {
+	return (sum + n/2) / n
+}
+
+// decimateInt downsamples from input resolution to 64x64
+func decimateInt(in []int64, inNumRows, inNumCols int, output []int64) {
+	for i := 0; i < 64; i++ {
+		ini := (2*i + 1) * inNumRows / 128
+		for j := 0; j < 64; j++ {
+			inj := (2*j + 1) * inNumCols / 128
+			output[i*64+j] = in[ini*inNumCols+inj]
+		}
+	}
+}
+
+// computeIntQualityMetric is computePDQImageDomainQualityMetric on
+// fixed-point luma
+func computeIntQualityMetric(buffer64x64 []int64) int {
+	const denom = 255 * lumaScale
+	var gradientSum int64
+
+	for i := 0; i < 63; i++ {
+		for j := 0; j < 64; j++ {
+			d := (buffer64x64[i*64+j] - buffer64x64[(i+1)*64+j]) * 100 / denom
+			if d < 0 {
+				d = -d
+			}
+			gradientSum += d
+		}
+	}
+
+	for i := 0; i < 64; i++ {
+		for j := 0; j < 63; j++ {
+			d := (buffer64x64[i*64+j] - buffer64x64[i*64+j+1]) * 100 / denom
+			if d < 0 {
+				d = -d
+			}
+			gradientSum += d
+		}
+	}
+
+	quality := int(gradientSum / 90)
+	if quality > 100 {
+		quality = 100
+	}
+	return quality
+}
+
+// dct64To16Int performs the DCT in fixed point, accumulating in a fixed
+// order. The intermediate is rounded back down from Q14 to keep the second
+// multiplication well inside int64.
+func dct64To16Int(A, B []int64) {
+	T := make([]int64, 16*64)
+
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 64; j++ {
+			var tij int64
+			for k := 0; k < 64; k++ {
+				tij += dctMatrixInt[i*64+k] * A[k*64+j]
+			}
+			T[i*64+j] = (tij + 1<<13) >> 14
+		}
+	}
+
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 16; j++ {
+			var sumk int64
+			for k := 0; k < 64; k++ {
+				sumk += T[i*64+k] * dctMatrixInt[j*64+k]
+			}
+			B[i*16+j] = sumk
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package gopdq
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"testing"
+)
+
+// The expected hashes below were produced on amd64 and checked under wasm
+// (GOOS=js GOARCH=wasm with go_js_wasm_exec). CI runs the test on arm64 too,
+// where the generic kernels are used.
+func TestDeterministic(t *testing.T) {
+	f, err := os.Open("cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// the standard library decoder is integer-only, so it is deterministic too
+	cat, err := jpeg.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		img     image.Image
+		hash    string
+		quality int
+	}{
+		{"cat", cat, "02704e1ddd10f333c0e6df833130b07f99e36701383d333ac7c6078fe736dccc", 100},
+		{"pattern", testPattern(517, 389), "ed495949b319769b4cb398b3b17343638e42be87708e800e9f7e7e3ce000e7f0", 100},
+	}
+
+	det := NewPdqHasher(WithDeterministic())
+	float := NewPdqHasher()
+	for _, c := range cases {
+		res, err := det.HashImage(c.img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Hash.String(); got != c.hash || res.Quality != c.quality {
+			t.Errorf("%s: expected %s (quality %d), got %s (quality %d)", c.name, c.hash, c.quality, got, res.Quality)
+		}
+
+		fres, err := float.HashImage(c.img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := res.Hash.HammingDistance(fres.Hash); d > 8 {
+			t.Errorf("%s: deterministic hash is %d bits from the float hash", c.name, d)
+		}
+	}
+}
+
+// testPattern draws an image with detail at several frequencies using only
+// integer arithmetic
+func testPattern(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{
+				R: uint8(x * 255 / w),
+				G: uint8((x*y)>>6 ^ y),
+				B: uint8((x / 37 % 2) * 200),
+				A: 255,
+			})
+		}
+	}
+	return img
+}
@@ -0,0 +1,109 @@
+package gopdq
+
+import "fmt"
+
+// Dihedral is one of the eight rotations and reflections of an image
+type Dihedral int
+
+const (
+	Original Dihedral = iota
+	Rotate90
+	Rotate180
+	Rotate270
+	FlipHorizontal
+	FlipVertical
+	// Transpose reflects about the main diagonal, swapping x and y
+	Transpose
+	// Transverse reflects about the anti-diagonal
+	Transverse
+)
+
+// Dihedrals lists every Dihedral, Original first
+var Dihedrals = []Dihedral{Original, Rotate90, Rotate180, Rotate270, FlipHorizontal, FlipVertical, Transpose, Transverse}
+
+var dihedralNames = [...]string{"original", "rotate90", "rotate180", "rotate270", "flip-horizontal", "flip-vertical", "transpose", "transverse"}
+
+func (d Dihedral) String() string {
+	if d < 0 || int(d) >= len(dihedralNames) {
+		return fmt.Sprintf("Dihedral(%d)", int(d))
+	}
+	return dihedralNames[d]
+}
+
+// ParseDihedral returns the Dihedral named s, as printed by String
+func ParseDihedral(s string) (Dihedral, error) {
+	for i, name := range dihedralNames {
+		if name == s {
+			return Dihedral(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown dihedral transform %q", s)
+}
+
+// parts gives d as an optional transpose followed by optional mirrorings of
+// the horizontal and vertical axes. Rotating clockwise by 90 degrees is a
+// transpose and then a horizontal flip.
+func (d Dihedral) parts() (transposed, flipH, flipV bool) {
+	switch d {
+	case Rotate90:
+		return true, true, false
+	case Rotate180:
+		return false, true, true
+	case Rotate270:
+		return true, false, true
+	case FlipHorizontal:
+		return false, true, false
+	case FlipVertical:
+		return false, false, true
+	case Transpose:
+		return true, false, false
+	case Transverse:
+		return true, true, true
+	}
+	return false, false, false
+}
+
+// Dihedral estimates the hash of the image transformed by d from the hash
+// of the original, without the image. Transposing the image transposes its
+// DCT coefficients, and mirroring an axis negates the coefficients of odd
+// frequency along it, which mostly flips their bits. A negated coefficient
+// next to the median can land on the same side of it, so the estimate is
+// usually within 16 bits of hashing the transformed image rather than equal
+// to it, and thresholds applied to it should allow for that.
+func (h *PdqHash256) Dihedral(d Dihedral) *PdqHash256 {
+	transposed, flipH, flipV := d.parts()
+	rv := NewPdqHash256()
+	for row := 0; row < 16; row++ {
+		for col := 0; col < 16; col++ {
+			r, c := row, col
+			if transposed {
+				r, c = col, row
+			}
+			bit := h.Bit(r, c)
+			// bit (row, col) holds frequencies row+1 and col+1, so the odd
+			// ones are at even positions
+			if flipH && col%2 == 0 {
+				bit = !bit
+			}
+			if flipV && row%2 == 0 {
+				bit = !bit
+			}
+			if bit {
+				rv.SetBitRC(row, col)
+			}
+		}
+	}
+	return rv
+}
+
+// DihedralDistance returns the smallest distance between h and any of the
+// Dihedral estimates of other, and the transform giving it
+func (h *PdqHash256) DihedralDistance(other *PdqHash256) (int, Dihedral) {
+	best, bestD := h.HammingDistance(other), Original
+	for _, d := range Dihedrals[1:] {
+		if dist := h.HammingDistance(other.Dihedral(d)); dist < best {
+			best, bestD = dist, d
+		}
+	}
+	return best, bestD
+}
@@ -0,0 +1,88 @@
+package gopdq
+
+import (
+	"image"
+	"os"
+	"testing"
+)
+
+// transformImage applies d to img pixel by pixel
+func transformImage(img image.Image, d Dihedral) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	transposed, flipH, flipV := d.parts()
+	ow, oh := w, h
+	if transposed {
+		ow, oh = h, w
+	}
+	out := image.NewRGBA(image.Rect(0, 0, ow, oh))
+	for y := 0; y < oh; y++ {
+		for x := 0; x < ow; x++ {
+			sx, sy := x, y
+			if flipH {
+				sx = ow - 1 - sx
+			}
+			if flipV {
+				sy = oh - 1 - sy
+			}
+			if transposed {
+				sx, sy = sy, sx
+			}
+			out.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return out
+}
+
+func TestDihedral(t *testing.T) {
+	f, err := os.Open("cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cat, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, img := range []image.Image{cat, testPattern(517, 389)} {
+		orig, err := NewPdqHasher().HashImage(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range Dihedrals {
+			res, err := NewPdqHasher().HashImage(transformImage(img, d))
+			if err != nil {
+				t.Fatal(err)
+			}
+			est := orig.Hash.Dihedral(d)
+			if dist := est.HammingDistance(res.Hash); dist > 24 {
+				t.Errorf("%s: estimate is %d bits from the hash of the transformed image", d, dist)
+			}
+			if dist, got := res.Hash.DihedralDistance(orig.Hash); got != d || dist > 24 {
+				t.Errorf("%s: DihedralDistance gave %s at %d", d, got, dist)
+			}
+		}
+	}
+
+	h := &randomHashes(1)[0]
+	for _, d := range Dihedrals {
+		// every transform is undone by applying its inverse
+		inv := d
+		switch d {
+		case Rotate90:
+			inv = Rotate270
+		case Rotate270:
+			inv = Rotate90
+		}
+		if !h.Dihedral(d).Dihedral(inv).Equal(h) {
+			t.Errorf("%s then %s doesn't restore the hash", d, inv)
+		}
+		if p, err := ParseDihedral(d.String()); err != nil || p != d {
+			t.Errorf("ParseDihedral(%q) = %v, %v", d, p, err)
+		}
+	}
+	if !h.Dihedral(Rotate90).Dihedral(Rotate90).Equal(h.Dihedral(Rotate180)) {
+		t.Error("two quarter turns differ from a half turn")
+	}
+}
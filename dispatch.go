@@ -0,0 +1,74 @@
+package gopdq
+
+import (
+	"maps"
+	"os"
+)
+
+// cpuFeatures are the instruction set extensions kernels can use
+type cpuFeatures struct {
+	AVX2         bool
+	AVX512       bool
+	AVX512POPCNT bool
+}
+
+// useKernel says which SIMD kernels are installed. The kernels' entry points
+// branch on these rather than being function variables, which would make
+// every buffer passed to them escape to the heap.
+var useKernel struct {
+	popcountAVX512 bool
+	boxfilterAVX2  bool
+	dctAVX2        bool
+}
+
+// kernelImpl is one implementation of a kernel. install switches the
+// kernel's entry point over to it.
+type kernelImpl struct {
+	name    string
+	usable  func(cpuFeatures) bool
+	install func()
+}
+
+// genericKernels install the portable Go implementation of each kernel.
+// archKernels, defined per architecture, lists the faster ones, best first.
+var genericKernels = map[string]func(){
+	"popcount":  func() { useKernel.popcountAVX512 = false },
+	"boxfilter": func() { useKernel.boxfilterAVX2 = false },
+	"dct":       func() { useKernel.dctAVX2 = false },
+}
+
+// selectedKernels records the implementation in use for each kernel
+var selectedKernels = map[string]string{}
+
+// Setting GOPDQ_KERNELS=generic in the environment disables every SIMD
+// kernel, to rule them out when chasing a difference between machines.
+func init() {
+	selectKernels(detectCPU(), os.Getenv("GOPDQ_KERNELS") == "generic")
+}
+
+// selectKernels installs, for every kernel, the first implementation f
+// supports, or the generic one if genericOnly is set
+func selectKernels(f cpuFeatures, genericOnly bool) {
+	for name, generic := range genericKernels {
+		generic()
+		selectedKernels[name] = "generic"
+		if genericOnly {
+			continue
+		}
+		for _, impl := range archKernels[name] {
+			if impl.usable(f) {
+				impl.install()
+				selectedKernels[name] = impl.name
+				break
+			}
+		}
+	}
+}
+
+// Kernels reports which implementation of each optimized inner loop this
+// process uses, for example "popcount": "avx512". They are chosen at startup
+// from the CPU's features, so one binary runs the best kernels each machine
+// supports. Every implementation produces the same hashes and distances.
+func Kernels() map[string]string {
+	return maps.Clone(selectedKernels)
+}
@@ -0,0 +1,82 @@
+package gopdq
+
+import "golang.org/x/sys/cpu"
+
+func detectCPU() cpuFeatures {
+	return cpuFeatures{
+		AVX2:         cpu.X86.HasAVX2,
+		AVX512:       cpu.X86.HasAVX512F,
+		AVX512POPCNT: cpu.X86.HasAVX512F && cpu.X86.HasAVX512VPOPCNTDQ,
+	}
+}
+
+var archKernels = map[string][]kernelImpl{
+	"popcount": {
+		{"avx512", func(f cpuFeatures) bool { return f.AVX512POPCNT }, func() { useKernel.popcountAVX512 = true }},
+	},
+	"boxfilter": {
+		{"avx2", func(f cpuFeatures) bool { return f.AVX2 }, func() { useKernel.boxfilterAVX2 = true }},
+	},
+	"dct": {
+		{"avx2", func(f cpuFeatures) bool { return f.AVX2 }, func() { useKernel.dctAVX2 = true }},
+	},
+}
+
+func distances8(q *[4]uint64, t *[4 * blockSize]uint64, out *[blockSize]uint16) {
+	if useKernel.popcountAVX512 {
+		distances8AVX512(q, t, out)
+		return
+	}
+	distances8Generic(q, t, out)
+}
+
+func boxColsRun32(input, output []float32, li, ri, oi, numCols, reps int, sum []float32, denom float32) {
+	if useKernel.boxfilterAVX2 {
+		boxColsRun32AVX2(input, output, li, ri, oi, numCols, reps, sum, denom)
+		return
+	}
+	boxColsRunGeneric(input, output, li, ri, oi, numCols, reps, sum, denom)
+}
+
+func dctAccum4(s, v []float32, c *[4]float32) {
+	if useKernel.dctAVX2 {
+		dctAccum4AVX2(s, v, c)
+		return
+	}
+	dctAccum4Generic(s, v, c)
+}
+
+// distances8AVX512 XORs two hashes per 512-bit register with the query and
+// counts bits with VPOPCNTQ
+//
+//go:noescape
+func distances8AVX512(q *[4]uint64, t *[4 * blockSize]uint64, out *[blockSize]uint16)
+
+//go:noescape
+func boxColsRun32Asm(in, old, out *float32, stride, reps int, sum *float32, denom float32)
+
+// boxColsRun32AVX2 keeps the 32 sums in four registers while sliding down
+// the rows. It adds, subtracts and multiplies in the same order as the
+// generic loop, so its output is identical.
+func boxColsRun32AVX2(input, output []float32, li, ri, oi, numCols, reps int, sum []float32, denom float32) {
+	last := (reps - 1) * numCols
+	_ = input[ri+last+colBlock-1]
+	_ = input[li+last+colBlock-1]
+	_ = output[oi+last+colBlock-1]
+	_ = sum[colBlock-1]
+	boxColsRun32Asm(&input[ri], &input[li], &output[oi], numCols, reps, &sum[0], denom)
+}
+
+//go:noescape
+func dctAccum4Asm(s, v *float32, n int, c *[4]float32)
+
+// dctAccum4AVX2 handles eight columns per instruction, keeping the generic
+// loop's order of operations
+func dctAccum4AVX2(s, v []float32, c *[4]float32) {
+	if len(s) == 0 || len(s)%8 != 0 {
+		dctAccum4Generic(s, v, c)
+		return
+	}
+	_ = v[4*len(s)-1]
+	dctAccum4Asm(&s[0], &v[0], len(s), c)
+}
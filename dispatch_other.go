@@ -0,0 +1,24 @@
+//go:build !amd64
+
+package gopdq
+
+// Only amd64 has hand written kernels. Elsewhere, arm64 included, the
+// generic ones run; there bits.OnesCount64 still compiles to a vector
+// population count, which covers the distance kernel.
+func detectCPU() cpuFeatures {
+	return cpuFeatures{}
+}
+
+var archKernels = map[string][]kernelImpl{}
+
+func distances8(q *[4]uint64, t *[4 * blockSize]uint64, out *[blockSize]uint16) {
+	distances8Generic(q, t, out)
+}
+
+func boxColsRun32(input, output []float32, li, ri, oi, numCols, reps int, sum []float32, denom float32) {
+	boxColsRunGeneric(input, output, li, ri, oi, numCols, reps, sum, denom)
+}
+
+func dctAccum4(s, v []float32, c *[4]float32) {
+	dctAccum4Generic(s, v, c)
+}
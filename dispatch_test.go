@@ -0,0 +1,61 @@
+package gopdq
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+)
+
+// TestKernelsAgree hashes the same images with the kernels selected for
+// this CPU and with the generic ones, which must give identical results
+func TestKernelsAgree(t *testing.T) {
+	kernels := Kernels()
+	for _, name := range []string{"popcount", "boxfilter", "dct"} {
+		if kernels[name] == "" {
+			t.Fatalf("no implementation selected for %s", name)
+		}
+	}
+	t.Logf("kernels: %v", kernels)
+
+	rng := rand.New(rand.NewSource(1))
+	imgs := []image.Image{testPattern(517, 389), testPattern(1920, 1080), testPattern(40, 300)}
+	for i := 0; i < 10; i++ {
+		imgs = append(imgs, noiseImage(rng, 64+rng.Intn(600), 64+rng.Intn(600)))
+	}
+	hashes := randomHashes(101)
+	query := hashes[3].Fuzz(20)
+
+	run := func() ([]*HashResult, []uint16) {
+		var res []*HashResult
+		for _, img := range imgs {
+			r, err := NewPdqHasher().HashImage(img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res = append(res, r)
+		}
+		dists := make([]uint16, len(hashes))
+		HammingDistanceMany(query, hashes, dists)
+		return res, dists
+	}
+
+	best, bestDists := run()
+	selectKernels(detectCPU(), true)
+	defer selectKernels(detectCPU(), false)
+	if k := Kernels(); k["dct"] != "generic" || k["popcount"] != "generic" || k["boxfilter"] != "generic" {
+		t.Fatalf("generic selection gave %v", k)
+	}
+	generic, genericDists := run()
+
+	for i := range best {
+		if !best[i].Hash.Equal(generic[i].Hash) || best[i].Quality != generic[i].Quality {
+			t.Errorf("image %d: %s (quality %d) with %v, %s (quality %d) with generic kernels",
+				i, best[i].Hash, best[i].Quality, kernels, generic[i].Hash, generic[i].Quality)
+		}
+	}
+	for i := range bestDists {
+		if bestDists[i] != genericDists[i] {
+			t.Fatalf("distance %d is %d with %v, %d with generic kernels", i, bestDists[i], kernels, genericDists[i])
+		}
+	}
+}
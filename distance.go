@@ -0,0 +1,181 @@
+package gopdq
+
+import (
+	"math/bits"
+	"slices"
+)
+
+// packed returns the hash as four 64-bit words, w[0] in the low bits of the
+// first
+func (h *PdqHash256) packed() [4]uint64 {
+	var p [4]uint64
+	for i := range p {
+		p[i] = packWords(&h.w, i*4)
+	}
+	return p
+}
+
+func packWords(w *[HASH256NUMSLOTS]int, i int) uint64 {
+	return uint64(uint16(w[i])) |
+		uint64(uint16(w[i+1]))<<16 |
+		uint64(uint16(w[i+2]))<<32 |
+		uint64(uint16(w[i+3]))<<48
+}
+
+// packedDistance is the Hamming distance between a packed query and t
+func packedDistance(q *[4]uint64, t *PdqHash256) int {
+	w := &t.w
+	return bits.OnesCount64(q[0]^packWords(w, 0)) +
+		bits.OnesCount64(q[1]^packWords(w, 4)) +
+		bits.OnesCount64(q[2]^packWords(w, 8)) +
+		bits.OnesCount64(q[3]^packWords(w, 12))
+}
+
+// blockSize is the number of hashes a distance kernel handles per call
+const blockSize = 8
+
+// distances8Generic writes the distances from q to each of the 8 packed
+// hashes in t into out. distances8 calls it unless the CPU has a faster
+// kernel.
+func distances8Generic(q *[4]uint64, t *[4 * blockSize]uint64, out *[blockSize]uint16) {
+	for i := range out {
+		p := t[i*4 : i*4+4 : i*4+4]
+		out[i] = uint16(bits.OnesCount64(q[0]^p[0]) +
+			bits.OnesCount64(q[1]^p[1]) +
+			bits.OnesCount64(q[2]^p[2]) +
+			bits.OnesCount64(q[3]^p[3]))
+	}
+}
+
+// distancesPacked writes the distances from q to the hashes packed in words
+// into out, which must hold one entry per hash
+func distancesPacked(q *[4]uint64, words []uint64, out []uint16) {
+	for len(words) >= 4*blockSize {
+		distances8(q, (*[4 * blockSize]uint64)(words), (*[blockSize]uint16)(out))
+		words, out = words[4*blockSize:], out[blockSize:]
+	}
+	if len(words) > 0 {
+		var t [4 * blockSize]uint64
+		var o [blockSize]uint16
+		copy(t[:], words)
+		distances8(q, &t, &o)
+		copy(out, o[:])
+	}
+}
+
+// packBlock packs up to a block of targets into t, returning how many
+func packBlock(t *[4 * blockSize]uint64, targets []PdqHash256) int {
+	n := min(blockSize, len(targets))
+	for i := range targets[:n] {
+		w := &targets[i].w
+		t[i*4] = packWords(w, 0)
+		t[i*4+1] = packWords(w, 4)
+		t[i*4+2] = packWords(w, 8)
+		t[i*4+3] = packWords(w, 12)
+	}
+	return n
+}
+
+// HammingDistanceMany writes the distance from query to each of targets into
+// out, which must be at least as long as targets
+func HammingDistanceMany(query *PdqHash256, targets []PdqHash256, out []uint16) {
+	q := query.packed()
+	out = out[:len(targets)]
+	var t [4 * blockSize]uint64
+	var o [blockSize]uint16
+	for off := 0; off < len(targets); off += blockSize {
+		n := packBlock(&t, targets[off:])
+		distances8(&q, &t, &o)
+		copy(out[off:], o[:n])
+	}
+}
+
+// CountWithin returns how many of targets are within Hamming distance d of
+// query
+func CountWithin(query *PdqHash256, targets []PdqHash256, d int) int {
+	q := query.packed()
+	var t [4 * blockSize]uint64
+	var o [blockSize]uint16
+	count := 0
+	for off := 0; off < len(targets); off += blockSize {
+		n := packBlock(&t, targets[off:])
+		distances8(&q, &t, &o)
+		for _, v := range o[:n] {
+			if int(v) <= d {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// PackedHashes holds a list of hashes in the packed form the distance
+// kernels scan. Callers matching many queries against the same list should
+// pack it once rather than going through HammingDistanceMany.
+type PackedHashes struct {
+	words []uint64
+	// shared is set once Snapshot has handed out words, so Set copies them
+	// rather than changing the snapshot
+	shared bool
+}
+
+// PackHashes packs hashes, in order
+func PackHashes(hashes []*PdqHash256) *PackedHashes {
+	p := &PackedHashes{words: make([]uint64, 0, 4*len(hashes))}
+	for _, h := range hashes {
+		p.Append(h)
+	}
+	return p
+}
+
+// Append adds h to the end of the list
+func (p *PackedHashes) Append(h *PdqHash256) {
+	w := h.packed()
+	p.words = append(p.words, w[:]...)
+}
+
+// Set replaces the hash at position i with h
+func (p *PackedHashes) Set(i int, h *PdqHash256) {
+	if p.shared {
+		p.words, p.shared = slices.Clone(p.words), false
+	}
+	w := h.packed()
+	copy(p.words[4*i:4*i+4], w[:])
+}
+
+// Snapshot returns a copy of the list as it is now, sharing its memory
+// until either is changed by Set. Appends to one don't show in the other.
+func (p *PackedHashes) Snapshot() *PackedHashes {
+	p.shared = true
+	return &PackedHashes{words: p.words[:len(p.words):len(p.words)], shared: true}
+}
+
+// Len returns the number of hashes
+func (p *PackedHashes) Len() int {
+	return len(p.words) / 4
+}
+
+// Distances writes the distance from query to each hash into out, which
+// must be at least Len long
+func (p *PackedHashes) Distances(query *PdqHash256, out []uint16) {
+	q := query.packed()
+	distancesPacked(&q, p.words, out[:p.Len()])
+}
+
+// Nearest returns the position of the hash nearest query, the first if
+// several tie, and its distance. It returns -1, -1 for an empty list.
+func (p *PackedHashes) Nearest(query *PdqHash256) (int, int) {
+	q := query.packed()
+	best, bestDist := -1, -1
+	var o [blockSize]uint16
+	for off := 0; off < p.Len(); off += blockSize {
+		n := min(blockSize, p.Len()-off)
+		distancesPacked(&q, p.words[off*4:(off+n)*4], o[:n])
+		for i, d := range o[:n] {
+			if bestDist < 0 || int(d) < bestDist {
+				best, bestDist = off+i, int(d)
+			}
+		}
+	}
+	return best, bestDist
+}
@@ -0,0 +1,34 @@
+#include "textflag.h"
+
+// func distances8AVX512(q *[4]uint64, t *[32]uint64, out *[8]uint16)
+TEXT ·distances8AVX512(SB), NOSPLIT, $0-24
+	MOVQ q+0(FP), AX
+	MOVQ t+8(FP), BX
+	MOVQ out+16(FP), CX
+
+	// the query in both halves of Z0
+	VBROADCASTI64X4 (AX), Z0
+
+	// Z1-Z4 each hold the bit counts of the four words of two hashes
+	VPXORQ  (BX), Z0, Z1
+	VPXORQ  64(BX), Z0, Z2
+	VPXORQ  128(BX), Z0, Z3
+	VPXORQ  192(BX), Z0, Z4
+	VPOPCNTQ Z1, Z1
+	VPOPCNTQ Z2, Z2
+	VPOPCNTQ Z3, Z3
+	VPOPCNTQ Z4, Z4
+
+	// narrow the counts to 16 bits and sum each hash's four of them with
+	// two rounds of pairwise adds
+	VPMOVQW Z1, X1
+	VPMOVQW Z2, X2
+	VPMOVQW Z3, X3
+	VPMOVQW Z4, X4
+	VPHADDW X2, X1, X1
+	VPHADDW X4, X3, X3
+	VPHADDW X3, X1, X1
+	VMOVDQU X1, (CX)
+
+	VZEROUPPER
+	RET
@@ -0,0 +1,166 @@
+package gopdq
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func randomHashes(n int) []PdqHash256 {
+	base := NewPdqHash256()
+	out := make([]PdqHash256, n)
+	for i := range out {
+		out[i] = *base.Fuzz(i % 128)
+	}
+	return out
+}
+
+func TestHammingDistanceMany(t *testing.T) {
+	targets := randomHashes(500)
+	query := targets[7].Fuzz(5)
+
+	out := make([]uint16, len(targets))
+	HammingDistanceMany(query, targets, out)
+
+	within := 0
+	for i := range targets {
+		d := query.HammingDistance(&targets[i])
+		if int(out[i]) != d {
+			t.Fatalf("target %d: got %d, expected %d", i, out[i], d)
+		}
+		if d <= 31 {
+			within++
+		}
+	}
+	if got := CountWithin(query, targets, 31); got != within {
+		t.Fatalf("CountWithin = %d, expected %d", got, within)
+	}
+}
+
+func TestDistanceKernel(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var q [4]uint64
+	var targets [4 * blockSize]uint64
+	for n := 0; n < 1000; n++ {
+		for i := range q {
+			q[i] = rng.Uint64()
+		}
+		for i := range targets {
+			switch n % 3 {
+			case 0:
+				targets[i] = rng.Uint64()
+			case 1:
+				targets[i] = q[i%4] ^ 1<<rng.Intn(64)
+			default:
+				targets[i] = ^q[i%4]
+			}
+		}
+
+		var want, got [blockSize]uint16
+		distances8Generic(&q, &targets, &want)
+		distances8(&q, &targets, &got)
+		if got != want {
+			t.Fatalf("kernel distances %v, expected %v", got, want)
+		}
+	}
+}
+
+func TestPackedHashes(t *testing.T) {
+	targets := randomHashes(37)
+	ptrs := make([]*PdqHash256, len(targets))
+	for i := range targets {
+		ptrs[i] = &targets[i]
+	}
+	query := targets[20].Fuzz(3)
+
+	p := PackHashes(ptrs)
+	if p.Len() != len(targets) {
+		t.Fatalf("Len = %d, expected %d", p.Len(), len(targets))
+	}
+	out := make([]uint16, p.Len())
+	p.Distances(query, out)
+	best, bestDist := -1, -1
+	for i := range targets {
+		d := query.HammingDistance(&targets[i])
+		if int(out[i]) != d {
+			t.Fatalf("target %d: got %d, expected %d", i, out[i], d)
+		}
+		if bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	if i, d := p.Nearest(query); i != best || d != bestDist {
+		t.Fatalf("Nearest = %d, %d, expected %d, %d", i, d, best, bestDist)
+	}
+	if i, d := PackHashes(nil).Nearest(query); i != -1 || d != -1 {
+		t.Fatalf("Nearest of an empty list = %d, %d", i, d)
+	}
+
+	// a snapshot keeps the list as it was through appends and sets to
+	// either
+	snap := p.Snapshot()
+	p.Append(query)
+	p.Set(best, query.BitwiseNOT())
+	snap.Set(0, query)
+	if snap.Len() != len(targets) || p.Len() != len(targets)+1 {
+		t.Fatalf("Len = %d and %d after appending to one", snap.Len(), p.Len())
+	}
+	if i, d := snap.Nearest(query); i != 0 || d != 0 {
+		t.Fatalf("snapshot Nearest = %d, %d after Set, expected 0, 0", i, d)
+	}
+	if i, d := p.Nearest(query); i != len(targets) || d != 0 {
+		t.Fatalf("Nearest = %d, %d after Append, expected %d, 0", i, d, len(targets))
+	}
+	out = make([]uint16, p.Len())
+	p.Distances(query, out)
+	if int(out[0]) != query.HammingDistance(&targets[0]) || out[best] != 256 {
+		t.Fatal("a snapshot's Set changed the list it was taken from")
+	}
+}
+
+func BenchmarkHammingDistance(b *testing.B) {
+	targets := randomHashes(10000)
+	query := targets[0].Fuzz(10)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n := 0
+		for j := range targets {
+			if query.HammingDistance(&targets[j]) <= 31 {
+				n++
+			}
+		}
+	}
+}
+
+func BenchmarkCountWithin(b *testing.B) {
+	targets := randomHashes(10000)
+	query := targets[0].Fuzz(10)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		CountWithin(query, targets, 31)
+	}
+}
+
+func BenchmarkPackedDistances(b *testing.B) {
+	targets := randomHashes(10000)
+	ptrs := make([]*PdqHash256, len(targets))
+	for i := range targets {
+		ptrs[i] = &targets[i]
+	}
+	query := targets[0].Fuzz(10)
+	out := make([]uint16, len(targets))
+
+	for _, k := range []struct {
+		name    string
+		generic bool
+	}{{"generic", true}, {"best", false}} {
+		b.Run(k.name, func(b *testing.B) {
+			selectKernels(detectCPU(), k.generic)
+			defer selectKernels(detectCPU(), false)
+			p := PackHashes(ptrs)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p.Distances(query, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package gopdq
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"reflect"
+)
+
+// Errors returned by the hashing pipeline. They wrap the underlying cause, so
+// callers should test for them with errors.Is.
+var (
+	// ErrUnsupportedFormat means the input is not in any registered image format
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	// ErrDecodeFailed means the input looked like an image but could not be decoded
+	ErrDecodeFailed = errors.New("failed to decode image")
+	// ErrImageTooSmall means the image has no pixels to hash, or a side under
+	// 64 pixels when hashing with SmallImagesReject
+	ErrImageTooSmall = errors.New("image too small")
+	// ErrImageTooLarge means the image exceeds a size limit or took too long
+	// to decode
+	ErrImageTooLarge = errors.New("image too large")
+	// ErrInvalidImage means the image is nil, or its pixel buffer is too
+	// small for its bounds
+	ErrInvalidImage = errors.New("invalid image")
+	// ErrSplitAnomaly means the hash failed the check WithSplitCheck
+	// enables
+	ErrSplitAnomaly = errors.New("hash breaks the median split")
+	// ErrLowQuality means the hash quality fell below the configured minimum
+	ErrLowQuality = errors.New("hash quality too low")
+)
+
+// maxImagePixels bounds the working buffers HashImage allocates, which take
+// 8 bytes per pixel on top of the decoded image
+const maxImagePixels = 1 << 28
+
+// decodeError classifies an error from an image decoder
+func decodeError(err error) error {
+	if errors.Is(err, image.ErrFormat) {
+		return fmt.Errorf("%w: %w", ErrUnsupportedFormat, err)
+	}
+	return fmt.Errorf("%w: %w", ErrDecodeFailed, err)
+}
+
+// checkBounds rejects images the pipeline cannot hash
+func checkBounds(b image.Rectangle) error {
+	w, h := b.Dx(), b.Dy()
+	if w <= 0 || h <= 0 {
+		return fmt.Errorf("%w: %dx%d", ErrImageTooSmall, w, h)
+	}
+	if int64(w)*int64(h) > maxImagePixels {
+		return fmt.Errorf("%w: %dx%d exceeds %d pixels", ErrImageTooLarge, w, h, maxImagePixels)
+	}
+	return nil
+}
+
+// checkImage rejects nil images, including nil pointers of image types, and
+// images of the standard library's types whose buffers don't cover their
+// bounds, which would otherwise fail with an index out of range partway
+// through hashing. It then applies checkBounds.
+func checkImage(img image.Image) error {
+	if img == nil {
+		return fmt.Errorf("%w: nil image", ErrInvalidImage)
+	}
+	if v := reflect.ValueOf(img); v.Kind() == reflect.Pointer && v.IsNil() {
+		return fmt.Errorf("%w: nil %T", ErrInvalidImage, img)
+	}
+	b := img.Bounds()
+	if err := checkBounds(b); err != nil {
+		return err
+	}
+
+	ok := true
+	switch m := img.(type) {
+	case *image.RGBA:
+		ok = pixFits(len(m.Pix), m.Stride, 4, b)
+	case *image.NRGBA:
+		ok = pixFits(len(m.Pix), m.Stride, 4, b)
+	case *image.RGBA64:
+		ok = pixFits(len(m.Pix), m.Stride, 8, b)
+	case *image.NRGBA64:
+		ok = pixFits(len(m.Pix), m.Stride, 8, b)
+	case *image.Gray:
+		ok = pixFits(len(m.Pix), m.Stride, 1, b)
+	case *image.Gray16:
+		ok = pixFits(len(m.Pix), m.Stride, 2, b)
+	case *image.Alpha:
+		ok = pixFits(len(m.Pix), m.Stride, 1, b)
+	case *image.Alpha16:
+		ok = pixFits(len(m.Pix), m.Stride, 2, b)
+	case *image.CMYK:
+		ok = pixFits(len(m.Pix), m.Stride, 4, b)
+	case *image.Paletted:
+		ok = pixFits(len(m.Pix), m.Stride, 1, b)
+	case *image.NYCbCrA:
+		ok = pixFits(len(m.A), m.AStride, 1, b) && yCbCrFits(&m.YCbCr)
+	case *image.YCbCr:
+		ok = yCbCrFits(m)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %T pixel buffer doesn't cover its %dx%d bounds", ErrInvalidImage, img, b.Dx(), b.Dy())
+	}
+	return nil
+}
+
+// pixFits reports whether a buffer of n bytes holds the rows of r, stride
+// bytes apart with bpp bytes per pixel, starting at its first byte
+func pixFits(n, stride, bpp int, r image.Rectangle) bool {
+	return stride >= r.Dx()*bpp && n >= (r.Dy()-1)*stride+r.Dx()*bpp
+}
+
+func yCbCrFits(m *image.YCbCr) bool {
+	last := image.Pt(m.Rect.Max.X-1, m.Rect.Max.Y-1)
+	if m.YStride < m.Rect.Dx() || m.YOffset(last.X, last.Y) >= len(m.Y) {
+		return false
+	}
+	c := m.COffset(last.X, last.Y)
+	return c < len(m.Cb) && c < len(m.Cr)
+}
@@ -28,9 +28,10 @@ func main() {
 	// Print results
 	fmt.Printf("PDQ Hash: %s\n", result.Hash.String())
 	fmt.Printf("Quality: %d\n", result.Quality)
-	fmt.Printf("Read time: %.3f seconds\n", result.Stats.ReadSeconds)
-	fmt.Printf("Hash time: %.3f seconds\n", result.Stats.HashSeconds)
-	fmt.Printf("Image size: %d pixels\n", result.Stats.NumPixels)
+	fmt.Printf("Decoder: %s, %d bytes\n", result.Stats.Decoder, result.Stats.BytesRead)
+	fmt.Printf("Read time: %v\n", result.Stats.ReadTime)
+	fmt.Printf("Hash time: %v\n", result.Stats.HashTime)
+	fmt.Printf("Image size: %d pixels\n", result.Stats.NumPixels())
 
 	// Demonstrate some hash operations
 	fmt.Println("\nHash operations:")
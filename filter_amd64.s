@@ -0,0 +1,51 @@
+#include "textflag.h"
+
+// func boxColsRun32Asm(in, old, out *float32, stride, reps int, sum *float32, denom float32)
+TEXT ·boxColsRun32Asm(SB), NOSPLIT, $0-52
+	MOVQ in+0(FP), SI
+	MOVQ old+8(FP), DI
+	MOVQ out+16(FP), DX
+	MOVQ stride+24(FP), R8
+	SHLQ $2, R8
+	MOVQ reps+32(FP), CX
+	MOVQ sum+40(FP), AX
+	VBROADCASTSS denom+48(FP), Y7
+
+	VMOVUPS (AX), Y0
+	VMOVUPS 32(AX), Y1
+	VMOVUPS 64(AX), Y2
+	VMOVUPS 96(AX), Y3
+
+	TESTQ CX, CX
+	JLE   done
+
+loop:
+	VADDPS  (SI), Y0, Y0
+	VADDPS  32(SI), Y1, Y1
+	VADDPS  64(SI), Y2, Y2
+	VADDPS  96(SI), Y3, Y3
+	VSUBPS  (DI), Y0, Y0
+	VSUBPS  32(DI), Y1, Y1
+	VSUBPS  64(DI), Y2, Y2
+	VSUBPS  96(DI), Y3, Y3
+	VMULPS  Y7, Y0, Y4
+	VMULPS  Y7, Y1, Y5
+	VMULPS  Y7, Y2, Y6
+	VMULPS  Y7, Y3, Y8
+	VMOVUPS Y4, (DX)
+	VMOVUPS Y5, 32(DX)
+	VMOVUPS Y6, 64(DX)
+	VMOVUPS Y8, 96(DX)
+	ADDQ    R8, SI
+	ADDQ    R8, DI
+	ADDQ    R8, DX
+	DECQ    CX
+	JNZ     loop
+
+done:
+	VMOVUPS Y0, (AX)
+	VMOVUPS Y1, 32(AX)
+	VMOVUPS Y2, 64(AX)
+	VMOVUPS Y3, 96(AX)
+	VZEROUPPER
+	RET
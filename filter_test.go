@@ -0,0 +1,105 @@
+package gopdq
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func randomLuma(rng *rand.Rand, n int) []float32 {
+	buf := make([]float32, n)
+	for i := range buf {
+		buf[i] = rng.Float32() * 255
+	}
+	return buf
+}
+
+func TestBoxAlongCols(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	sizes := [][2]int{{64, 64}, {1, 1}, {3, 97}, {200, 33}, {517, 389}, {64, colBlock + 1}}
+	for _, sz := range sizes {
+		rows, cols := sz[0], sz[1]
+		in := randomLuma(rng, rows*cols)
+		want := make([]float32, rows*cols)
+		got := make([]float32, rows*cols)
+
+		window := computeJaroszFilterWindowSize(rows)
+		for j := 0; j < cols; j++ {
+			box1DFloat(in[j:], want[j:], rows, cols, window)
+		}
+		boxAlongColsFloat(in, got, rows, cols, window)
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%dx%d: element %d is %v, expected %v", rows, cols, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func BenchmarkBoxAlongCols(b *testing.B) {
+	for _, sz := range [][2]int{{1080, 1920}, {4096, 4096}} {
+		rows, cols := sz[0], sz[1]
+		in := randomLuma(rand.New(rand.NewSource(1)), rows*cols)
+		out := make([]float32, rows*cols)
+		window := computeJaroszFilterWindowSize(rows)
+
+		b.Run(fmt.Sprintf("%dx%d", cols, rows), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				boxAlongColsFloat(in, out, rows, cols, window)
+			}
+		})
+		b.Run(fmt.Sprintf("%dx%d/strided", cols, rows), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < cols; j++ {
+					box1DFloat(in[j:], out[j:], rows, cols, window)
+				}
+			}
+		})
+	}
+}
+
+func TestJaroszFilterDecimate(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	sizes := [][2]int{{64, 64}, {1, 1}, {10, 30}, {200, 63}, {389, 517}, {1080, 1920}, {67, 3000}}
+	for _, sz := range sizes {
+		rows, cols := sz[0], sz[1]
+		luma := randomLuma(rng, rows*cols)
+		wr, wc := computeJaroszFilterWindowSize(cols), computeJaroszFilterWindowSize(rows)
+
+		b1, b2 := append([]float32(nil), luma...), make([]float32, rows*cols)
+		want := make([]float32, 64*64)
+		jaroszFilterFloat(b1, b2, rows, cols, wr, wc, PDQ_NUM_JAROSZ_XY_PASSES)
+		decimateFloat(b1, rows, cols, want)
+
+		b1, b2 = append([]float32(nil), luma...), make([]float32, rows*cols)
+		got := make([]float32, 64*64)
+		jaroszFilterDecimateFloat(b1, b2, rows, cols, wr, wc, PDQ_NUM_JAROSZ_XY_PASSES, got)
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%dx%d: sample %d is %v, expected %v", cols, rows, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func BenchmarkJaroszFilterDecimate(b *testing.B) {
+	rows, cols := 1080, 1920
+	luma := randomLuma(rand.New(rand.NewSource(1)), rows*cols)
+	b1, b2 := make([]float32, rows*cols), make([]float32, rows*cols)
+	out := make([]float32, 64*64)
+	wr, wc := computeJaroszFilterWindowSize(cols), computeJaroszFilterWindowSize(rows)
+
+	b.Run("fused", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			copy(b1, luma)
+			jaroszFilterDecimateFloat(b1, b2, rows, cols, wr, wc, PDQ_NUM_JAROSZ_XY_PASSES, out)
+		}
+	})
+	b.Run("separate", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			copy(b1, luma)
+			jaroszFilterFloat(b1, b2, rows, cols, wr, wc, PDQ_NUM_JAROSZ_XY_PASSES)
+			decimateFloat(b1, rows, cols, out)
+		}
+	})
+}
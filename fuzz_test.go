@@ -0,0 +1,80 @@
+package gopdq
+
+import (
+	"bytes"
+	"image"
+	"image/gif"
+	"image/png"
+	"os"
+	"strings"
+	"testing"
+)
+
+func FuzzFromHexString(f *testing.F) {
+	f.Add("06704e1dd910f233c0e6df833130b0ff99e36701383d333ac7c6078fe736dccc")
+	f.Add(strings.Repeat("f", 64))
+	f.Add(strings.Repeat("0", 63))
+	f.Add("+6704e1dd910f233c0e6df833130b0ff99e36701383d333ac7c6078fe736dccc")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		h, err := FromHexString(s)
+		if err != nil {
+			return
+		}
+		if got := h.String(); !strings.EqualFold(got, s) {
+			t.Fatalf("FromHexString(%q) round-tripped to %q", s, got)
+		}
+	})
+}
+
+func FuzzParseHash(f *testing.F) {
+	f.Add("06704e1dd910f233c0e6df833130b0ff99e36701383d333ac7c6078fe736dccc")
+	f.Add("PDQ:06704E1DD910F233C0E6DF833130B0FF99E36701383D333AC7C6078FE736DCCC")
+	f.Add("0670 4e1d d910 f233 c0e6 df83 3130 b0ff 99e3 6701 383d 333a c7c6 078f e736 dccc")
+	f.Add("06:70:4e:1d")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		h, err := ParseHash(s)
+		if err != nil {
+			return
+		}
+		again, err := ParseHashStrict(h.String())
+		if err != nil {
+			t.Fatalf("canonical form %q of %q rejected: %v", h, s, err)
+		}
+		if !again.Equal(h) {
+			t.Fatalf("%q did not round-trip", s)
+		}
+	})
+}
+
+func FuzzFromReader(f *testing.F) {
+	if data, err := os.ReadFile("cat.jpg"); err == nil {
+		f.Add(data)
+		f.Add(data[:len(data)/3])
+	}
+	small := testPattern(20, 13)
+	for _, enc := range []func(*bytes.Buffer, image.Image) error{
+		func(b *bytes.Buffer, img image.Image) error { return png.Encode(b, img) },
+		func(b *bytes.Buffer, img image.Image) error { return gif.Encode(b, img, nil) },
+	} {
+		buf := new(bytes.Buffer)
+		if err := enc(buf, small); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Bytes())
+	}
+	f.Add([]byte("\x89PNG\r\n\x1a\n"))
+
+	hasher := NewPdqHasher()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// keep the fuzzer away from legitimately huge images
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil && cfg.Width*cfg.Height > 1<<20 {
+			return
+		}
+		res, err := hasher.FromReader(bytes.NewReader(data))
+		if err == nil && (res.Quality < 0 || res.Quality > 100) {
+			t.Fatalf("quality %d out of range", res.Quality)
+		}
+	})
+}
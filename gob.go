@@ -0,0 +1,48 @@
+package gopdq
+
+import (
+	"encoding/gob"
+	"fmt"
+)
+
+// The hash types are registered so they can be gob encoded as
+// PerceptualHash interface values, such as the results of ComputeAll.
+// PdqHash256 and TaggedHash need no registration when encoded directly.
+func init() {
+	gob.Register(&PdqHash256{})
+	gob.Register(Hash64(0))
+}
+
+// GobEncode implements gob.GobEncoder. The hashes are written in Compare
+// order so equal sets encode identically.
+func (s *HashSet) GobEncode() ([]byte, error) {
+	hashes := make([]*PdqHash256, 0, s.Len())
+	for h := range s.All() {
+		hashes = append(hashes, h)
+	}
+	SortHashes(hashes)
+
+	out := make([]byte, 0, len(hashes)*2*HASH256NUMSLOTS)
+	for _, h := range hashes {
+		out = append(out, h.Bytes()...)
+	}
+	return out, nil
+}
+
+// GobDecode implements gob.GobDecoder, replacing the contents of s
+func (s *HashSet) GobDecode(data []byte) error {
+	const size = 2 * HASH256NUMSLOTS
+	if len(data)%size != 0 {
+		return fmt.Errorf("hash set encoding of %d bytes is not a multiple of %d", len(data), size)
+	}
+
+	s.m = make(map[hashKey]struct{}, len(data)/size)
+	for off := 0; off < len(data); off += size {
+		h, err := FromBytes(data[off : off+size])
+		if err != nil {
+			return err
+		}
+		s.Add(h)
+	}
+	return nil
+}
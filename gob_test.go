@@ -0,0 +1,68 @@
+package gopdq
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGob(t *testing.T) {
+	res, err := NewPdqHasher().FromFile("cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile("cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := DecodeJpeg(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	multi, err := ComputeAll(img, NewPdqHasher(), DHash{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type snapshot struct {
+		Tagged []*TaggedHash
+		Set    *HashSet
+		Multi  []PerceptualHash
+	}
+	tagged := res.Tag("cat", "cat.jpg")
+	tagged.Timestamp = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	in := snapshot{
+		Tagged: []*TaggedHash{tagged},
+		Set:    NewHashSet(res.Hash, res.Hash.BitwiseNOT()),
+		Multi:  multi,
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(&in); err != nil {
+		t.Fatal(err)
+	}
+	var out snapshot
+	if err := gob.NewDecoder(buf).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := out.Tagged[0]; !got.Hash.Equal(res.Hash) || got.ID != "cat" || !got.Timestamp.Equal(tagged.Timestamp) {
+		t.Fatalf("tagged hash changed: %+v", got)
+	}
+	if out.Set.Len() != 2 || !out.Set.Contains(res.Hash) {
+		t.Fatal("hash set changed")
+	}
+	for i, h := range out.Multi {
+		if h.Distance(multi[i]) != 0 {
+			t.Fatalf("%T changed: %s, expected %s", h, h, multi[i])
+		}
+	}
+
+	a, _ := in.Set.GobEncode()
+	b, _ := out.Set.GobEncode()
+	if !bytes.Equal(a, b) {
+		t.Fatal("equal sets encoded differently")
+	}
+}
@@ -0,0 +1,104 @@
+// Package gopdqpb holds the protobuf wire format for hashes and results,
+// plus conversions to and from the gopdq types, and the IndexShard gRPC
+// service of index/remote.
+package gopdqpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative gopdq.proto index.proto
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/whyrusleeping/gopdq"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// FromHash converts a hash to its wire form
+func FromHash(h *gopdq.PdqHash256) *PdqHash {
+	if h == nil {
+		return nil
+	}
+	return &PdqHash{Hash: h.Bytes()}
+}
+
+// ToHash converts the wire form back to a hash
+func (x *PdqHash) ToHash() (*gopdq.PdqHash256, error) {
+	if x == nil {
+		return nil, fmt.Errorf("missing pdq hash")
+	}
+	return gopdq.FromBytes(x.GetHash())
+}
+
+// FromHashResult converts a result with no metadata
+func FromHashResult(r *gopdq.HashResult) *HashResult {
+	return &HashResult{
+		Hash:     FromHash(r.Hash),
+		Quality:  int32(r.Quality),
+		Degraded: r.Degraded,
+	}
+}
+
+// ToHashResult converts back to a result, dropping any metadata
+func (x *HashResult) ToHashResult() (*gopdq.HashResult, error) {
+	h, err := x.GetHash().ToHash()
+	if err != nil {
+		return nil, err
+	}
+	return &gopdq.HashResult{
+		Hash:     h,
+		Quality:  int(x.GetQuality()),
+		Degraded: x.GetDegraded(),
+	}, nil
+}
+
+// FromTaggedHash converts a hash and its metadata
+func FromTaggedHash(t *gopdq.TaggedHash) *HashResult {
+	x := &HashResult{
+		Hash:    FromHash(t.Hash),
+		Quality: int32(t.Quality),
+		Id:      t.ID,
+		Source:  t.Source,
+		Labels:  maps.Clone(t.Labels),
+		Payload: slices.Clone(t.Payload),
+	}
+	if !t.Timestamp.IsZero() {
+		x.Timestamp = timestamppb.New(t.Timestamp)
+	}
+	if !t.Expires.IsZero() {
+		x.Expires = timestamppb.New(t.Expires)
+	}
+	return x
+}
+
+// ToTaggedHash converts back to a hash and its metadata
+func (x *HashResult) ToTaggedHash() (*gopdq.TaggedHash, error) {
+	h, err := x.GetHash().ToHash()
+	if err != nil {
+		return nil, err
+	}
+	t := &gopdq.TaggedHash{
+		Hash:    h,
+		Quality: int(x.GetQuality()),
+		ID:      x.GetId(),
+		Source:  x.GetSource(),
+		Labels:  maps.Clone(x.GetLabels()),
+		Payload: slices.Clone(x.GetPayload()),
+	}
+	if x.Timestamp != nil {
+		t.Timestamp = x.Timestamp.AsTime()
+	}
+	if x.Expires != nil {
+		t.Expires = x.Expires.AsTime()
+	}
+	return t, nil
+}
+
+// NewMatchResult builds the wire form of a match between two tagged hashes
+func NewMatchResult(query, match *gopdq.TaggedHash) *MatchResult {
+	return &MatchResult{
+		Query:    FromTaggedHash(query),
+		Match:    FromTaggedHash(match),
+		Distance: int32(query.Hash.HammingDistance(match.Hash)),
+	}
+}
@@ -0,0 +1,69 @@
+package gopdqpb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/whyrusleeping/gopdq"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRoundTrip(t *testing.T) {
+	h, err := gopdq.FromHexString("02704e1ddd10f333c0e6df833130b07f99e36701383d333ac7c6078fe736dccc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := &gopdq.TaggedHash{
+		Hash:      h,
+		Quality:   100,
+		ID:        "cat",
+		Source:    "cat.jpg",
+		Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Expires:   time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC),
+		Labels:    map[string]string{"set": "test"},
+		Payload:   []byte(`{"case":1}`),
+	}
+
+	far := h.BitwiseNOT()
+	data, err := proto.Marshal(NewMatchResult(in, &gopdq.TaggedHash{Hash: far}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mr MatchResult
+	if err := proto.Unmarshal(data, &mr); err != nil {
+		t.Fatal(err)
+	}
+	if mr.GetDistance() != 256 {
+		t.Fatalf("distance %d, expected 256", mr.GetDistance())
+	}
+	if b := mr.GetQuery().GetHash().GetHash(); b[0] != 0x02 || b[1] != 0x70 {
+		t.Fatalf("hash bytes not in hex string order: %x", b)
+	}
+
+	out, err := mr.GetQuery().ToTaggedHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out.Hash.Equal(h) || out.Quality != in.Quality || out.ID != in.ID || out.Source != in.Source ||
+		!out.Timestamp.Equal(in.Timestamp) || !out.Expires.Equal(in.Expires) || out.Labels["set"] != "test" ||
+		string(out.Payload) != string(in.Payload) {
+		t.Fatalf("round trip changed the record: %+v", out)
+	}
+
+	m, err := mr.GetMatch().ToHashResult()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Hash.Equal(far) {
+		t.Fatalf("match hash %s, expected %s", m.Hash, far)
+	}
+
+	if _, err := (&PdqHash{Hash: []byte{1, 2, 3}}).ToHash(); err == nil {
+		t.Fatal("expected error for a short hash")
+	}
+	if _, err := (&HashResult{}).ToHashResult(); err == nil {
+		t.Fatal("expected error for a missing hash")
+	}
+}
@@ -0,0 +1,133 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: index.proto
+
+package gopdqpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	IndexShard_Query_FullMethodName = "/gopdq.v1.IndexShard/Query"
+)
+
+// IndexShardClient is the client API for IndexShard service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// IndexShard answers match queries against one shard of a corpus too large
+// for one machine. Shards also serve the standard grpc.health.v1.Health
+// service, reporting NOT_SERVING while they have no index loaded.
+type IndexShardClient interface {
+	// Query returns the shard's entries within max_distance of hash, nearest
+	// first and by id among equal distances
+	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error)
+}
+
+type indexShardClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewIndexShardClient(cc grpc.ClientConnInterface) IndexShardClient {
+	return &indexShardClient{cc}
+}
+
+func (c *indexShardClient) Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(QueryResponse)
+	err := c.cc.Invoke(ctx, IndexShard_Query_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// IndexShardServer is the server API for IndexShard service.
+// All implementations must embed UnimplementedIndexShardServer
+// for forward compatibility.
+//
+// IndexShard answers match queries against one shard of a corpus too large
+// for one machine. Shards also serve the standard grpc.health.v1.Health
+// service, reporting NOT_SERVING while they have no index loaded.
+type IndexShardServer interface {
+	// Query returns the shard's entries within max_distance of hash, nearest
+	// first and by id among equal distances
+	Query(context.Context, *QueryRequest) (*QueryResponse, error)
+	mustEmbedUnimplementedIndexShardServer()
+}
+
+// UnimplementedIndexShardServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedIndexShardServer struct{}
+
+func (UnimplementedIndexShardServer) Query(context.Context, *QueryRequest) (*QueryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Query not implemented")
+}
+func (UnimplementedIndexShardServer) mustEmbedUnimplementedIndexShardServer() {}
+func (UnimplementedIndexShardServer) testEmbeddedByValue()                    {}
+
+// UnsafeIndexShardServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to IndexShardServer will
+// result in compilation errors.
+type UnsafeIndexShardServer interface {
+	mustEmbedUnimplementedIndexShardServer()
+}
+
+func RegisterIndexShardServer(s grpc.ServiceRegistrar, srv IndexShardServer) {
+	// If the following call pancis, it indicates UnimplementedIndexShardServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&IndexShard_ServiceDesc, srv)
+}
+
+func _IndexShard_Query_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IndexShardServer).Query(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: IndexShard_Query_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IndexShardServer).Query(ctx, req.(*QueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// IndexShard_ServiceDesc is the grpc.ServiceDesc for IndexShard service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var IndexShard_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gopdq.v1.IndexShard",
+	HandlerType: (*IndexShardServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Query",
+			Handler:    _IndexShard_Query_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "index.proto",
+}
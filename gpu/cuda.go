@@ -0,0 +1,249 @@
+//go:build cuda && cgo
+
+package gpu
+
+/*
+#cgo LDFLAGS: -lcuda -lnvrtc
+#include <cuda.h>
+#include <nvrtc.h>
+#include <stdlib.h>
+#include <string.h>
+
+typedef struct {
+	CUdevice dev;
+	CUcontext ctx;
+	CUmodule mod;
+	CUfunction rows, cols, finish;
+	CUdeviceptr dct;
+} pdqGPU;
+
+static const char* cuErrorString(CUresult r) {
+	const char* s = NULL;
+	cuGetErrorString(r, &s);
+	return s ? s : "unknown CUDA error";
+}
+
+// compilePTX compiles src, returning the PTX in *ptx or the build log in
+// *log, either to be freed by the caller
+static nvrtcResult compilePTX(const char* src, char** ptx, char** log) {
+	const char* opts[] = {"--fmad=false", "--prec-div=true", "--ftz=false"};
+	nvrtcProgram prog;
+	size_t n;
+	nvrtcResult r = nvrtcCreateProgram(&prog, src, "kernels.cu", 0, NULL, NULL);
+	if (r != NVRTC_SUCCESS) {
+		return r;
+	}
+	r = nvrtcCompileProgram(prog, 3, opts);
+	if (r != NVRTC_SUCCESS) {
+		if (nvrtcGetProgramLogSize(prog, &n) == NVRTC_SUCCESS) {
+			*log = malloc(n);
+			nvrtcGetProgramLog(prog, *log);
+		}
+	} else if ((r = nvrtcGetPTXSize(prog, &n)) == NVRTC_SUCCESS) {
+		*ptx = malloc(n);
+		r = nvrtcGetPTX(prog, *ptx);
+	}
+	nvrtcDestroyProgram(&prog);
+	return r;
+}
+
+static void pdqClose(pdqGPU* g) {
+	if (g->ctx == NULL) {
+		return;
+	}
+	cuCtxSetCurrent(g->ctx);
+	if (g->dct) {
+		cuMemFree(g->dct);
+	}
+	if (g->mod) {
+		cuModuleUnload(g->mod);
+	}
+	cuDevicePrimaryCtxRelease(g->dev);
+	memset(g, 0, sizeof *g);
+}
+
+static CUresult pdqOpen(pdqGPU* g, int device, const char* ptx, const float* dct) {
+	CUresult r;
+	if ((r = cuInit(0)) != CUDA_SUCCESS) return r;
+	if ((r = cuDeviceGet(&g->dev, device)) != CUDA_SUCCESS) return r;
+	if ((r = cuDevicePrimaryCtxRetain(&g->ctx, g->dev)) != CUDA_SUCCESS) return r;
+	if ((r = cuCtxSetCurrent(g->ctx)) != CUDA_SUCCESS) goto fail;
+	if ((r = cuModuleLoadData(&g->mod, ptx)) != CUDA_SUCCESS) goto fail;
+	if ((r = cuModuleGetFunction(&g->rows, g->mod, "boxRows")) != CUDA_SUCCESS) goto fail;
+	if ((r = cuModuleGetFunction(&g->cols, g->mod, "boxCols")) != CUDA_SUCCESS) goto fail;
+	if ((r = cuModuleGetFunction(&g->finish, g->mod, "finish")) != CUDA_SUCCESS) goto fail;
+	if ((r = cuMemAlloc(&g->dct, 16 * 64 * sizeof(float))) != CUDA_SUCCESS) goto fail;
+	if ((r = cuMemcpyHtoD(g->dct, dct, 16 * 64 * sizeof(float))) != CUDA_SUCCESS) goto fail;
+	return CUDA_SUCCESS;
+fail:
+	pdqClose(g);
+	return r;
+}
+
+static CUresult launch(CUfunction f, unsigned int threads, void** args) {
+	return cuLaunchKernel(f, (threads + 127) / 128, 1, 1, 128, 1, 1, 0, NULL, args, NULL);
+}
+
+// pdqHashBatch uploads frames luma planes of rows x cols, runs passes of
+// the Jarosz filter over them and downloads 16 hash words and a quality
+// per frame. Everything is done on the calling thread, which the context
+// is made current on.
+static CUresult pdqHashBatch(pdqGPU* g, const float* luma, int frames, int rows, int cols,
+	int wr, int wc, int passes, unsigned short* words, int* quality)
+{
+	size_t n = (size_t)frames * rows * cols * sizeof(float);
+	CUdeviceptr a = 0, b = 0, w = 0, q = 0;
+	CUresult r;
+
+	if ((r = cuCtxSetCurrent(g->ctx)) != CUDA_SUCCESS) return r;
+	if ((r = cuMemAlloc(&a, n)) != CUDA_SUCCESS) goto done;
+	if ((r = cuMemAlloc(&b, n)) != CUDA_SUCCESS) goto done;
+	if ((r = cuMemAlloc(&w, (size_t)frames * 16 * sizeof(unsigned short))) != CUDA_SUCCESS) goto done;
+	if ((r = cuMemAlloc(&q, (size_t)frames * sizeof(int))) != CUDA_SUCCESS) goto done;
+	if ((r = cuMemcpyHtoD(a, luma, n)) != CUDA_SUCCESS) goto done;
+
+	for (int i = 0; i < passes; i++) {
+		void* rowArgs[] = {&a, &b, &frames, &rows, &cols, &wr};
+		void* colArgs[] = {&b, &a, &frames, &rows, &cols, &wc};
+		if ((r = launch(g->rows, frames * rows, rowArgs)) != CUDA_SUCCESS) goto done;
+		if ((r = launch(g->cols, frames * cols, colArgs)) != CUDA_SUCCESS) goto done;
+	}
+	void* finishArgs[] = {&a, &rows, &cols, &g->dct, &w, &q};
+	if ((r = cuLaunchKernel(g->finish, frames, 1, 1, 256, 1, 1, 0, NULL, finishArgs, NULL)) != CUDA_SUCCESS) goto done;
+
+	if ((r = cuMemcpyDtoH(words, w, (size_t)frames * 16 * sizeof(unsigned short))) != CUDA_SUCCESS) goto done;
+	r = cuMemcpyDtoH(quality, q, (size_t)frames * sizeof(int));
+
+done:
+	if (a) cuMemFree(a);
+	if (b) cuMemFree(b);
+	if (w) cuMemFree(w);
+	if (q) cuMemFree(q);
+	return r;
+}
+*/
+import "C"
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"sync"
+	"unsafe"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+//go:embed kernels.cu
+var kernelSource string
+
+// dctMatrix is the scaled 16x64 DCT matrix, without its DC row, used by the
+// finish kernel
+var dctMatrix = func() []float32 {
+	scale := float32(math.Sqrt(2.0 / 64.0))
+	D := make([]float32, 16*64)
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 64; j++ {
+			D[i*64+j] = scale * float32(math.Cos((math.Pi/2.0/64.0)*float64(i+1)*float64(2*j+1)))
+		}
+	}
+	return D
+}()
+
+// windowSize is the Jarosz filter window along a dimension of n pixels
+func windowSize(n int) int {
+	return (n + gopdq.PDQ_JAROSZ_WINDOW_SIZE_DIVISOR - 1) / gopdq.PDQ_JAROSZ_WINDOW_SIZE_DIVISOR
+}
+
+func cudaError(op string, r C.CUresult) error {
+	return fmt.Errorf("%s: %s", op, C.GoString(C.cuErrorString(r)))
+}
+
+// Hasher hashes batches of frames on a CUDA device. It is safe for
+// concurrent use, though batches are run one at a time.
+type Hasher struct {
+	mu sync.Mutex
+	g  C.pdqGPU
+}
+
+// Open compiles the kernels and loads them on the given CUDA device. The
+// result wraps ErrUnavailable if the driver or device can't be used.
+func Open(device int) (*Hasher, error) {
+	src := C.CString(kernelSource)
+	defer C.free(unsafe.Pointer(src))
+
+	var ptx, log *C.char
+	if r := C.compilePTX(src, &ptx, &log); r != C.NVRTC_SUCCESS {
+		msg := C.GoString(C.nvrtcGetErrorString(r))
+		if log != nil {
+			msg += "\n" + C.GoString(log)
+			C.free(unsafe.Pointer(log))
+		}
+		return nil, fmt.Errorf("compiling kernels: %s", msg)
+	}
+	defer C.free(unsafe.Pointer(ptx))
+
+	h := &Hasher{}
+	if r := C.pdqOpen(&h.g, C.int(device), ptx, (*C.float)(&dctMatrix[0])); r != C.CUDA_SUCCESS {
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, cudaError("opening device", r))
+	}
+	return h, nil
+}
+
+// HashBatch hashes frames, which must all be the same size. All of them are
+// resident on the device at once, taking 8 bytes per pixel, so callers
+// bound the batch to what their device holds.
+func (h *Hasher) HashBatch(frames []Frame) ([]*gopdq.HashResult, error) {
+	if len(frames) == 0 {
+		return nil, nil
+	}
+	if err := checkBatch(frames); err != nil {
+		return nil, err
+	}
+	width, height := frames[0].Width, frames[0].Height
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("can't hash an empty %dx%d frame", width, height)
+	}
+
+	luma := make([]float32, 0, len(frames)*width*height)
+	for _, f := range frames {
+		luma = append(luma, f.Luma...)
+	}
+	words := make([]uint16, len(frames)*16)
+	quality := make([]int32, len(frames))
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.g.ctx == nil {
+		return nil, fmt.Errorf("hasher is closed")
+	}
+	r := C.pdqHashBatch(&h.g, (*C.float)(&luma[0]), C.int(len(frames)), C.int(height), C.int(width),
+		C.int(windowSize(width)), C.int(windowSize(height)), gopdq.PDQ_NUM_JAROSZ_XY_PASSES,
+		(*C.ushort)(&words[0]), (*C.int)(&quality[0]))
+	if r != C.CUDA_SUCCESS {
+		return nil, cudaError("hashing batch", r)
+	}
+
+	results := make([]*gopdq.HashResult, len(frames))
+	b := make([]byte, 32)
+	for i := range results {
+		w := words[i*16 : (i+1)*16]
+		for k := 0; k < 16; k++ {
+			b[2*k], b[2*k+1] = byte(w[15-k]>>8), byte(w[15-k])
+		}
+		hash, err := gopdq.FromBytes(b)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = &gopdq.HashResult{Hash: hash, Quality: int(quality[i])}
+	}
+	return results, nil
+}
+
+// Close releases the device. The hasher can't be used afterwards.
+func (h *Hasher) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	C.pdqClose(&h.g)
+	return nil
+}
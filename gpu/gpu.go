@@ -0,0 +1,73 @@
+// Package gpu is an experimental backend hashing batches of images on a
+// GPU. Frames are decoded and converted to luma on the CPU, uploaded
+// together, and filtered, decimated, transformed and thresholded on the
+// device. It only pays off for large batches, where the filter passes over
+// full resolution luma dominate.
+//
+// The CUDA implementation is built with the cuda build tag and needs cgo,
+// the CUDA driver and NVRTC. Without them Open returns ErrUnavailable.
+//
+// The CPU hasher stays the reference: the filter and quality computation
+// match it exactly, but the device uses the dense DCT matrix, so a
+// coefficient lying right on the median can occasionally flip a bit.
+package gpu
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// ErrUnavailable is returned by Open when the package was built without a
+// GPU backend or no usable device was found
+var ErrUnavailable = errors.New("no GPU hashing backend available")
+
+// Frame is a decoded luma plane, height rows of width values, as produced
+// by gopdq.LumaPlane
+type Frame struct {
+	Luma          []float32
+	Width, Height int
+}
+
+// FrameFromImage converts img to a Frame
+func FrameFromImage(img image.Image) Frame {
+	b := img.Bounds()
+	return Frame{Luma: gopdq.LumaPlane(img), Width: b.Dx(), Height: b.Dy()}
+}
+
+// checkBatch checks every frame in a batch has the size of the first, which
+// the kernels rely on to process them in one launch
+func checkBatch(frames []Frame) error {
+	for i, f := range frames {
+		if len(f.Luma) != f.Width*f.Height {
+			return fmt.Errorf("frame %d: %d luma values for %dx%d", i, len(f.Luma), f.Width, f.Height)
+		}
+		if f.Width != frames[0].Width || f.Height != frames[0].Height {
+			return fmt.Errorf("frame %d is %dx%d, batch is %dx%d", i, f.Width, f.Height, frames[0].Width, frames[0].Height)
+		}
+	}
+	return nil
+}
+
+// Verify hashes frames on the CPU and compares them with results from
+// HashBatch, returning an error for any hash further than maxDistance from
+// the reference or with a different quality
+func Verify(frames []Frame, results []*gopdq.HashResult, maxDistance int) error {
+	if len(frames) != len(results) {
+		return fmt.Errorf("%d results for %d frames", len(results), len(frames))
+	}
+	h := gopdq.NewPdqHasher()
+	for i, f := range frames {
+		want, err := h.HashLuma(f.Luma, f.Width, f.Height)
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", i, err)
+		}
+		if d := want.Hash.HammingDistance(results[i].Hash); d > maxDistance || want.Quality != results[i].Quality {
+			return fmt.Errorf("frame %d: GPU hash %s (quality %d) is %d bits from CPU hash %s (quality %d)",
+				i, results[i].Hash, results[i].Quality, d, want.Hash, want.Quality)
+		}
+	}
+	return nil
+}
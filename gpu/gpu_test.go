@@ -0,0 +1,74 @@
+package gpu
+
+import (
+	"errors"
+	"image"
+	_ "image/jpeg"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func testFrames(t *testing.T) []Frame {
+	f, err := os.Open("../cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cat := FrameFromImage(img)
+
+	frames := []Frame{cat}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 7; i++ {
+		luma := make([]float32, len(cat.Luma))
+		for j := range luma {
+			luma[j] = float32(rng.Intn(256))
+		}
+		frames = append(frames, Frame{Luma: luma, Width: cat.Width, Height: cat.Height})
+	}
+	return frames
+}
+
+// TestHashBatch checks the device against the CPU hasher, skipping when no
+// backend is built in or no device is present
+func TestHashBatch(t *testing.T) {
+	h, err := Open(0)
+	if errors.Is(err, ErrUnavailable) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	frames := testFrames(t)
+	results, err := h.HashBatch(frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(frames, results, 4); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckBatch(t *testing.T) {
+	frames := []Frame{
+		{Luma: make([]float32, 6), Width: 3, Height: 2},
+		{Luma: make([]float32, 6), Width: 2, Height: 3},
+	}
+	if err := checkBatch(frames); err == nil {
+		t.Fatal("mixed sizes accepted")
+	}
+	frames[1] = Frame{Luma: make([]float32, 5), Width: 3, Height: 2}
+	if err := checkBatch(frames); err == nil {
+		t.Fatal("short luma plane accepted")
+	}
+	frames[1].Luma = make([]float32, 6)
+	if err := checkBatch(frames); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,209 @@
+// PDQ hashing kernels, compiled at runtime by NVRTC with FMA contraction
+// off so the filter arithmetic rounds exactly as the Go code does.
+
+// box1d mirrors box1DFloat: the same four phases with the same operations
+// in the same order
+__device__ void box1d(const float* in, float* out, int n, int stride, int window)
+{
+	int half = (window + 2) / 2;
+	int p1 = half - 1;
+	int p2 = window - half + 1;
+	int p3 = n - window;
+	int p4 = half - 1;
+
+	int li = 0, ri = 0, oi = 0;
+	float sum = 0.0f;
+	float cur = 0.0f;
+
+	for (int i = 0; i < p1; i++) {
+		sum += in[ri];
+		cur++;
+		ri += stride;
+	}
+	for (int i = 0; i < p2; i++) {
+		sum += in[ri];
+		cur++;
+		out[oi] = sum / cur;
+		ri += stride;
+		oi += stride;
+	}
+	float denom = 1.0f / cur;
+	for (int i = 0; i < p3; i++) {
+		sum += in[ri];
+		sum -= in[li];
+		out[oi] = sum * denom;
+		li += stride;
+		ri += stride;
+		oi += stride;
+	}
+	for (int i = 0; i < p4; i++) {
+		sum -= in[li];
+		cur--;
+		out[oi] = sum / cur;
+		li += stride;
+		oi += stride;
+	}
+}
+
+// boxRows filters every row of every frame, a thread per row
+extern "C" __global__ void boxRows(const float* in, float* out, int frames, int rows, int cols, int window)
+{
+	int t = blockIdx.x * blockDim.x + threadIdx.x;
+	if (t >= frames * rows) {
+		return;
+	}
+	size_t base = (size_t)(t / rows) * rows * cols + (size_t)(t % rows) * cols;
+	box1d(in + base, out + base, cols, 1, window);
+}
+
+// boxCols filters every column of every frame, a thread per column.
+// Neighbouring threads read neighbouring addresses, so each step of the
+// window is a coalesced load.
+extern "C" __global__ void boxCols(const float* in, float* out, int frames, int rows, int cols, int window)
+{
+	int t = blockIdx.x * blockDim.x + threadIdx.x;
+	if (t >= frames * cols) {
+		return;
+	}
+	size_t base = (size_t)(t / cols) * rows * cols + t % cols;
+	box1d(in + base, out + base, rows, cols, window);
+}
+
+// decimationIndex matches the Go function of the same name
+__device__ int decimationIndex(int i, int n)
+{
+	return (int)(((float)i + 0.5f) * (float)n / 64.0f);
+}
+
+// torbenMedian is a port of the Go torbenMedian, run by a single thread
+__device__ float torbenMedian(const float* m, int n)
+{
+	float min = m[0], max = m[0];
+	for (int i = 1; i < n; i++) {
+		if (m[i] < min) {
+			min = m[i];
+		}
+		if (m[i] > max) {
+			max = m[i];
+		}
+	}
+
+	int less, greater, equal;
+	float maxltguess, mingtguess, guess;
+	for (;;) {
+		guess = (min + max) / 2;
+		less = 0;
+		greater = 0;
+		equal = 0;
+		maxltguess = min;
+		mingtguess = max;
+		for (int i = 0; i < n; i++) {
+			if (m[i] < guess) {
+				less++;
+				if (m[i] > maxltguess) {
+					maxltguess = m[i];
+				}
+			} else if (m[i] > guess) {
+				greater++;
+				if (m[i] < mingtguess) {
+					mingtguess = m[i];
+				}
+			} else {
+				equal++;
+			}
+		}
+		if (less <= (n + 1) / 2 && greater <= (n + 1) / 2) {
+			break;
+		} else if (less > greater) {
+			max = maxltguess;
+		} else {
+			min = mingtguess;
+		}
+	}
+
+	if (less >= (n + 1) / 2) {
+		return maxltguess;
+	} else if (less + equal >= (n + 1) / 2) {
+		return guess;
+	}
+	return mingtguess;
+}
+
+// finish runs a block of 256 threads per frame, taking the filtered luma
+// to its quality and 16 hash words. dct is the scaled 16x64 DCT matrix.
+extern "C" __global__ void finish(const float* in, int rows, int cols, const float* dct,
+	unsigned short* words, int* quality)
+{
+	__shared__ float a[64 * 64];
+	__shared__ float t[16 * 64];
+	__shared__ float b[16 * 16];
+	__shared__ int gradients;
+	__shared__ float median;
+	__shared__ unsigned int w[16];
+
+	const float* frame = in + (size_t)blockIdx.x * rows * cols;
+	int tid = threadIdx.x;
+
+	if (tid == 0) {
+		gradients = 0;
+	}
+	if (tid < 16) {
+		w[tid] = 0;
+	}
+	for (int k = tid; k < 64 * 64; k += blockDim.x) {
+		int i = k / 64, j = k % 64;
+		a[k] = frame[decimationIndex(i, rows) * cols + decimationIndex(j, cols)];
+	}
+	__syncthreads();
+
+	// gradients between vertical, then horizontal, neighbours
+	int g = 0;
+	for (int k = tid; k < 63 * 64; k += blockDim.x) {
+		int d = (int)((a[k] - a[k + 64]) * 100.0f / 255.0f);
+		g += d < 0 ? -d : d;
+	}
+	for (int k = tid; k < 64 * 63; k += blockDim.x) {
+		int i = k / 63, j = k % 63;
+		int d = (int)((a[i * 64 + j] - a[i * 64 + j + 1]) * 100.0f / 255.0f);
+		g += d < 0 ? -d : d;
+	}
+	atomicAdd(&gradients, g);
+
+	for (int k = tid; k < 16 * 64; k += blockDim.x) {
+		int i = k / 64, j = k % 64;
+		float s = 0.0f;
+		for (int l = 0; l < 64; l++) {
+			s += dct[i * 64 + l] * a[l * 64 + j];
+		}
+		t[k] = s;
+	}
+	__syncthreads();
+
+	for (int k = tid; k < 16 * 16; k += blockDim.x) {
+		int i = k / 16, j = k % 16;
+		float s = 0.0f;
+		for (int l = 0; l < 64; l++) {
+			s += t[i * 64 + l] * dct[j * 64 + l];
+		}
+		b[k] = s;
+	}
+	__syncthreads();
+
+	if (tid == 0) {
+		median = torbenMedian(b, 256);
+		int q = gradients / 90;
+		quality[blockIdx.x] = q > 100 ? 100 : q;
+	}
+	__syncthreads();
+
+	for (int k = tid; k < 16 * 16; k += blockDim.x) {
+		if (b[k] > median) {
+			atomicOr(&w[k / 16], 1u << (k % 16));
+		}
+	}
+	__syncthreads();
+
+	if (tid < 16) {
+		words[blockIdx.x * 16 + tid] = (unsigned short)w[tid];
+	}
+}
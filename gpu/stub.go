@@ -0,0 +1,23 @@
+//go:build !cuda || !cgo
+
+package gpu
+
+import "github.com/whyrusleeping/gopdq"
+
+// Hasher hashes batches of frames on a GPU
+type Hasher struct{}
+
+// Open returns ErrUnavailable, as this build has no GPU backend
+func Open(device int) (*Hasher, error) {
+	return nil, ErrUnavailable
+}
+
+// HashBatch hashes frames, which must all be the same size
+func (h *Hasher) HashBatch(frames []Frame) ([]*gopdq.HashResult, error) {
+	return nil, ErrUnavailable
+}
+
+// Close releases the device
+func (h *Hasher) Close() error {
+	return nil
+}
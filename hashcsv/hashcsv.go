@@ -0,0 +1,336 @@
+// Package hashcsv reads and writes hash lists as CSV with a configurable
+// column layout.
+//
+// Labels are stored in a single column in URL query form
+// ("key=value&other=x"), so values may hold commas, quotes and equals signs.
+// Timestamps and expiry times are RFC 3339.
+package hashcsv
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// Column is a field of a TaggedHash stored in a CSV column
+type Column int
+
+const (
+	// Skip ignores the column when reading and leaves it empty when writing
+	Skip Column = iota
+	Hash
+	Quality
+	ID
+	Source
+	Timestamp
+	Labels
+	Expires
+)
+
+var columnNames = []string{"-", "hash", "quality", "id", "source", "timestamp", "labels", "expires"}
+
+func (c Column) String() string {
+	if c < 0 || int(c) >= len(columnNames) {
+		return fmt.Sprintf("Column(%d)", int(c))
+	}
+	return columnNames[c]
+}
+
+// Layout is the sequence of columns in a file. It must contain Hash.
+type Layout []Column
+
+// DefaultLayout is hash, quality, id
+var DefaultLayout = Layout{Hash, Quality, ID}
+
+// ParseLayout parses a comma separated list of column names, such as
+// "hash,quality,id". A "-" marks a column to skip.
+func ParseLayout(s string) (Layout, error) {
+	var l Layout
+	for _, name := range strings.Split(s, ",") {
+		c, ok := columnByName(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		l = append(l, c)
+	}
+	if err := l.validate(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func columnByName(name string) (Column, bool) {
+	for i, n := range columnNames {
+		if strings.EqualFold(n, name) {
+			return Column(i), true
+		}
+	}
+	return Skip, false
+}
+
+func (l Layout) String() string {
+	names := make([]string, len(l))
+	for i, c := range l {
+		names[i] = c.String()
+	}
+	return strings.Join(names, ",")
+}
+
+func (l Layout) validate() error {
+	seen := make(map[Column]bool)
+	for _, c := range l {
+		if c < Skip || c > Expires {
+			return fmt.Errorf("invalid column %v", c)
+		}
+		if c != Skip && seen[c] {
+			return fmt.Errorf("column %v appears more than once", c)
+		}
+		seen[c] = true
+	}
+	if !seen[Hash] {
+		return errors.New("layout has no hash column")
+	}
+	return nil
+}
+
+// Writer writes records as CSV
+type Writer struct {
+	cw     *csv.Writer
+	layout Layout
+	header bool
+	record []string
+}
+
+// NewWriter creates a Writer using layout, or DefaultLayout if it is nil. A
+// header row naming the columns is written before the first record unless
+// NoHeader is called.
+func NewWriter(w io.Writer, layout Layout) (*Writer, error) {
+	if layout == nil {
+		layout = DefaultLayout
+	}
+	if err := layout.validate(); err != nil {
+		return nil, err
+	}
+	return &Writer{
+		cw:     csv.NewWriter(w),
+		layout: layout,
+		header: true,
+		record: make([]string, len(layout)),
+	}, nil
+}
+
+// NoHeader suppresses the header row
+func (w *Writer) NoHeader() {
+	w.header = false
+}
+
+// Write writes a single record
+func (w *Writer) Write(t *gopdq.TaggedHash) error {
+	if w.header {
+		w.header = false
+		for i, c := range w.layout {
+			w.record[i] = c.String()
+		}
+		if err := w.cw.Write(w.record); err != nil {
+			return err
+		}
+	}
+
+	for i, c := range w.layout {
+		w.record[i] = formatField(c, t)
+	}
+	return w.cw.Write(w.record)
+}
+
+// Flush writes any buffered data and reports any error from earlier writes
+func (w *Writer) Flush() error {
+	w.cw.Flush()
+	return w.cw.Error()
+}
+
+func formatField(c Column, t *gopdq.TaggedHash) string {
+	switch c {
+	case Hash:
+		return t.Hash.String()
+	case Quality:
+		return strconv.Itoa(t.Quality)
+	case ID:
+		return t.ID
+	case Source:
+		return t.Source
+	case Timestamp:
+		if t.Timestamp.IsZero() {
+			return ""
+		}
+		return t.Timestamp.Format(time.RFC3339Nano)
+	case Expires:
+		if t.Expires.IsZero() {
+			return ""
+		}
+		return t.Expires.Format(time.RFC3339Nano)
+	case Labels:
+		v := make(url.Values, len(t.Labels))
+		for k, l := range t.Labels {
+			v.Set(k, l)
+		}
+		return v.Encode()
+	}
+	return ""
+}
+
+// Reader reads records from CSV
+type Reader struct {
+	cr     *csv.Reader
+	layout Layout
+	first  bool
+}
+
+// NewReader creates a Reader. If layout is nil the first row must be a
+// header, and columns are matched to fields by name with unknown names
+// skipped. Otherwise a first row that names the layout's columns is skipped
+// as a header. Lines starting with '#' are ignored.
+func NewReader(r io.Reader, layout Layout) (*Reader, error) {
+	if layout != nil {
+		if err := layout.validate(); err != nil {
+			return nil, err
+		}
+	}
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+	cr.ReuseRecord = true
+	cr.FieldsPerRecord = -1
+	return &Reader{cr: cr, layout: layout, first: true}, nil
+}
+
+// Layout returns the columns being read, which is only known from the
+// header after the first call to Read if no layout was given
+func (r *Reader) Layout() Layout {
+	return r.layout
+}
+
+// Read returns the next record, or io.EOF at the end of the input
+func (r *Reader) Read() (*gopdq.TaggedHash, error) {
+	rec, err := r.cr.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	if r.first {
+		r.first = false
+		if r.layout == nil {
+			if r.layout, err = r.headerLayout(rec); err != nil {
+				return nil, err
+			}
+			return r.Read()
+		}
+		if r.isHeader(rec) {
+			return r.Read()
+		}
+	}
+
+	if len(rec) != len(r.layout) {
+		line, _ := r.cr.FieldPos(0)
+		return nil, fmt.Errorf("line %d: expected %d fields, got %d", line, len(r.layout), len(rec))
+	}
+
+	t := &gopdq.TaggedHash{}
+	for i, c := range r.layout {
+		if err := parseField(c, rec[i], t); err != nil {
+			line, col := r.cr.FieldPos(i)
+			return nil, fmt.Errorf("line %d, column %d: %v: %w", line, col, c, err)
+		}
+	}
+	return t, nil
+}
+
+func (r *Reader) headerLayout(rec []string) (Layout, error) {
+	l := make(Layout, len(rec))
+	for i, name := range rec {
+		l[i], _ = columnByName(strings.TrimSpace(name))
+	}
+	if err := l.validate(); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	return l, nil
+}
+
+func (r *Reader) isHeader(rec []string) bool {
+	if len(rec) != len(r.layout) {
+		return false
+	}
+	for i, c := range r.layout {
+		if c != Skip && !strings.EqualFold(strings.TrimSpace(rec[i]), c.String()) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseField(c Column, s string, t *gopdq.TaggedHash) error {
+	var err error
+	switch c {
+	case Hash:
+		t.Hash, err = gopdq.ParseHash(s)
+	case Quality:
+		if s == "" {
+			return nil
+		}
+		t.Quality, err = strconv.Atoi(s)
+		if err == nil && (t.Quality < 0 || t.Quality > 100) {
+			err = fmt.Errorf("quality %d out of range", t.Quality)
+		}
+	case ID:
+		t.ID = s
+	case Source:
+		t.Source = s
+	case Timestamp:
+		if s == "" {
+			return nil
+		}
+		t.Timestamp, err = time.Parse(time.RFC3339Nano, s)
+	case Expires:
+		if s == "" {
+			return nil
+		}
+		t.Expires, err = time.Parse(time.RFC3339Nano, s)
+	case Labels:
+		if s == "" {
+			return nil
+		}
+		var v url.Values
+		if v, err = url.ParseQuery(s); err == nil {
+			for k := range v {
+				t.SetLabel(k, v.Get(k))
+			}
+		}
+	}
+	return err
+}
+
+// ReadAll reads every record from r
+func ReadAll(r io.Reader, layout Layout) ([]*gopdq.TaggedHash, error) {
+	cr, err := NewReader(r, layout)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*gopdq.TaggedHash
+	for {
+		t, err := cr.Read()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+}
@@ -0,0 +1,107 @@
+package hashcsv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+const catHash = "02704e1ddd10f333c0e6df833130b07f99e36701383d333ac7c6078fe736dccc"
+
+func TestRoundTrip(t *testing.T) {
+	h, err := gopdq.FromHexString(catHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := &gopdq.TaggedHash{
+		Hash:      h,
+		Quality:   87,
+		ID:        `cat, "the" original`,
+		Source:    "s3://bucket/cat.jpg",
+		Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Expires:   time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC),
+		Labels:    map[string]string{"set": "a&b=c", "note": "x,y"},
+	}
+
+	layout, err := ParseLayout("id,hash,-,quality,source,timestamp,labels,expires")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf, layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(in); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "id,hash,-,quality,source,timestamp,labels,expires\n") {
+		t.Fatalf("missing header:\n%s", buf)
+	}
+
+	// once with the header driving the layout, once with it given explicitly
+	for _, l := range []Layout{nil, layout} {
+		got, err := ReadAll(bytes.NewReader(buf.Bytes()), l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 {
+			t.Fatalf("read %d records, expected 1", len(got))
+		}
+		r := got[0]
+		if !r.Hash.Equal(h) || r.Quality != in.Quality || r.ID != in.ID || r.Source != in.Source || !r.Timestamp.Equal(in.Timestamp) || !r.Expires.Equal(in.Expires) {
+			t.Fatalf("round trip changed the record: %+v", r)
+		}
+		if len(r.Labels) != 2 || r.Labels["set"] != "a&b=c" || r.Labels["note"] != "x,y" {
+			t.Fatalf("labels changed in round trip: %v", r.Labels)
+		}
+	}
+}
+
+func TestValidation(t *testing.T) {
+	if _, err := ParseLayout("quality,id"); err == nil {
+		t.Error("layout without a hash accepted")
+	}
+	if _, err := ParseLayout("hash,id,id"); err == nil {
+		t.Error("layout with a repeated column accepted")
+	}
+	if _, err := ParseLayout("hash,colour"); err == nil {
+		t.Error("layout with an unknown column accepted")
+	}
+
+	// headerless input in the default layout, with comments
+	got, err := ReadAll(strings.NewReader("# exported\n"+catHash+",100,a\n PDQ:"+strings.ToUpper(catHash)+", 50,b\n"), DefaultLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[1].Quality != 50 || got[1].ID != "b" {
+		t.Fatalf("unexpected records %+v", got)
+	}
+
+	for input, want := range map[string]string{
+		catHash + ",100\n":                            "line 1: expected 3 fields, got 2",
+		"x,1,a\n":                                     "line 1, column 1: hash:",
+		catHash + ",101,a\n":                          "line 1, column 66: quality: quality 101 out of range",
+		"id,quality\nx,1\n":                           "header: layout has no hash column",
+		catHash + ",1,a\n00,1,b\n":                    "line 2, column 1: hash:",
+		catHash + ",\"1\n\",a\n":                      "line 1, column 66: quality:",
+		catHash + ",high,a\n":                         "line 1, column 66: quality:",
+		"hash,timestamp\n" + catHash + ",yesterday\n": "line 2, column 66: timestamp:",
+	} {
+		layout := DefaultLayout
+		if strings.HasPrefix(input, "id,") || strings.HasPrefix(input, "hash,") {
+			layout = nil
+		}
+		_, err := ReadAll(strings.NewReader(input), layout)
+		if err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("%q: expected error starting %q, got %v", input, want, err)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+// Package hashnpy exchanges hashes with Python tooling through NumPy .npy
+// files.
+//
+// python-pdqhash returns each hash as a vector of 256 bits, most significant
+// first, and its quality separately. A corpus saved from Python is typically
+// an (N, 256) bit matrix, written with
+//
+//	np.save("hashes.npy", np.stack(vectors))
+//
+// plus an optional (N,) array of qualities. Both can be read here, and
+// WriteHashes and WriteQualities produce files np.load reads back.
+package hashnpy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+var magic = []byte("\x93NUMPY")
+
+// maxElements bounds the arrays read, at 4M hashes
+const maxElements = 1 << 30
+
+// header is the parsed header of a .npy file
+type header struct {
+	descr   string
+	fortran bool
+	shape   []int
+}
+
+var (
+	descrRe   = regexp.MustCompile(`'descr'\s*:\s*'([^']*)'`)
+	fortranRe = regexp.MustCompile(`'fortran_order'\s*:\s*(True|False)`)
+	shapeRe   = regexp.MustCompile(`'shape'\s*:\s*\(([^)]*)\)`)
+)
+
+func readHeader(r io.Reader) (*header, error) {
+	pre := make([]byte, 8)
+	if _, err := io.ReadFull(r, pre); err != nil {
+		return nil, fmt.Errorf("reading npy preamble: %w", err)
+	}
+	if !bytes.Equal(pre[:6], magic) {
+		return nil, errors.New("not an npy file")
+	}
+
+	var n int
+	switch pre[6] {
+	case 1:
+		var l uint16
+		if err := binary.Read(r, binary.LittleEndian, &l); err != nil {
+			return nil, err
+		}
+		n = int(l)
+	case 2, 3:
+		var l uint32
+		if err := binary.Read(r, binary.LittleEndian, &l); err != nil {
+			return nil, err
+		}
+		n = int(l)
+	default:
+		return nil, fmt.Errorf("unsupported npy version %d.%d", pre[6], pre[7])
+	}
+	if n > 1<<20 {
+		return nil, fmt.Errorf("npy header of %d bytes is implausibly large", n)
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("reading npy header: %w", err)
+	}
+	s := string(buf)
+
+	h := &header{}
+	m := descrRe.FindStringSubmatch(s)
+	if m == nil {
+		return nil, errors.New("npy header has no descr")
+	}
+	h.descr = m[1]
+	if m := fortranRe.FindStringSubmatch(s); m != nil {
+		h.fortran = m[1] == "True"
+	}
+	m = shapeRe.FindStringSubmatch(s)
+	if m == nil {
+		return nil, errors.New("npy header has no shape")
+	}
+	for _, dim := range strings.Split(m[1], ",") {
+		dim = strings.TrimSpace(dim)
+		if dim == "" {
+			continue
+		}
+		d, err := strconv.Atoi(dim)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid npy shape (%s)", m[1])
+		}
+		h.shape = append(h.shape, d)
+	}
+	return h, nil
+}
+
+// elementReader decodes elements of one dtype into float64
+type elementReader struct {
+	size   int
+	decode func(b []byte) float64
+}
+
+func dtype(descr string) (elementReader, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if len(descr) < 2 {
+		return elementReader{}, fmt.Errorf("unsupported dtype %q", descr)
+	}
+	switch descr[0] {
+	case '<', '|', '=':
+	case '>':
+		order = binary.BigEndian
+	default:
+		return elementReader{}, fmt.Errorf("unsupported dtype %q", descr)
+	}
+
+	switch descr[1:] {
+	case "b1", "u1":
+		return elementReader{1, func(b []byte) float64 { return float64(b[0]) }}, nil
+	case "i1":
+		return elementReader{1, func(b []byte) float64 { return float64(int8(b[0])) }}, nil
+	case "u2":
+		return elementReader{2, func(b []byte) float64 { return float64(order.Uint16(b)) }}, nil
+	case "i2":
+		return elementReader{2, func(b []byte) float64 { return float64(int16(order.Uint16(b))) }}, nil
+	case "u4":
+		return elementReader{4, func(b []byte) float64 { return float64(order.Uint32(b)) }}, nil
+	case "i4":
+		return elementReader{4, func(b []byte) float64 { return float64(int32(order.Uint32(b))) }}, nil
+	case "u8":
+		return elementReader{8, func(b []byte) float64 { return float64(order.Uint64(b)) }}, nil
+	case "i8":
+		return elementReader{8, func(b []byte) float64 { return float64(int64(order.Uint64(b))) }}, nil
+	case "f4":
+		return elementReader{4, func(b []byte) float64 { return float64(math.Float32frombits(order.Uint32(b))) }}, nil
+	case "f8":
+		return elementReader{8, func(b []byte) float64 { return math.Float64frombits(order.Uint64(b)) }}, nil
+	}
+	return elementReader{}, fmt.Errorf("unsupported dtype %q", descr)
+}
+
+// readRows calls fn with each row of the array in turn, treating a 1-D
+// array as a single row when rowLen is its length and as a column otherwise.
+// C order arrays are streamed; Fortran order ones are read whole first.
+func readRows(r io.Reader, h *header, rowLen int, fn func(row int, vals []float64) error) error {
+	et, err := dtype(h.descr)
+	if err != nil {
+		return err
+	}
+
+	n := 1
+	for _, d := range h.shape {
+		if d > 0 && n > maxElements/d {
+			return fmt.Errorf("npy array of shape %v is too large", h.shape)
+		}
+		n *= d
+	}
+	if n%rowLen != 0 {
+		return fmt.Errorf("npy array of shape %v doesn't split into rows of %d", h.shape, rowLen)
+	}
+	rows := n / rowLen
+
+	br := bufio.NewReader(r)
+	buf := make([]byte, et.size)
+	next := func(i int) (float64, error) {
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return 0, fmt.Errorf("reading element %d of %d: %w", i, n, err)
+		}
+		return et.decode(buf), nil
+	}
+
+	if h.fortran && rows > 1 && rowLen > 1 {
+		all := make([]float64, n)
+		for i := range all {
+			if all[i], err = next(i); err != nil {
+				return err
+			}
+		}
+		row := make([]float64, rowLen)
+		for i := 0; i < rows; i++ {
+			for j := range row {
+				row[j] = all[j*rows+i]
+			}
+			if err := fn(i, row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	row := make([]float64, rowLen)
+	for i := 0; i < rows; i++ {
+		for j := range row {
+			if row[j], err = next(i*rowLen + j); err != nil {
+				return err
+			}
+		}
+		if err := fn(i, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadHashes reads an (N, 256) bit matrix, or a single (256,) vector, of
+// any integer, boolean or float dtype whose elements are all 0 or 1
+func ReadHashes(r io.Reader) ([]*gopdq.PdqHash256, error) {
+	h, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(h.shape) == 1 && h.shape[0] == 256:
+	case len(h.shape) == 2 && h.shape[1] == 256:
+	default:
+		return nil, fmt.Errorf("expected an (N, 256) bit matrix, got shape %v", h.shape)
+	}
+
+	var out []*gopdq.PdqHash256
+	bits := make([]byte, 256)
+	err = readRows(r, h, 256, func(i int, vals []float64) error {
+		for j, v := range vals {
+			if v != 0 && v != 1 {
+				return fmt.Errorf("row %d, bit %d: value %v is not 0 or 1", i, j, v)
+			}
+			bits[j] = byte(v)
+		}
+		hash, err := gopdq.FromBits(bits)
+		if err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+		out = append(out, hash)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ReadQualities reads an (N,) array of qualities
+func ReadQualities(r io.Reader) ([]int, error) {
+	h, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(h.shape) != 1 {
+		return nil, fmt.Errorf("expected a 1-dimensional quality array, got shape %v", h.shape)
+	}
+
+	var out []int
+	err = readRows(r, h, 1, func(i int, vals []float64) error {
+		v := vals[0]
+		if v != math.Trunc(v) || v < 0 || v > 100 {
+			return fmt.Errorf("element %d: invalid quality %v", i, v)
+		}
+		out = append(out, int(v))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Load reads the bit matrix at hashPath and, unless qualityPath is empty,
+// the qualities at qualityPath. The i'th record gets ID "i", its row number,
+// and Source hashPath.
+func Load(hashPath, qualityPath string) ([]*gopdq.TaggedHash, error) {
+	hashes, err := readFile(hashPath, ReadHashes)
+	if err != nil {
+		return nil, err
+	}
+	var qualities []int
+	if qualityPath != "" {
+		if qualities, err = readFile(qualityPath, ReadQualities); err != nil {
+			return nil, err
+		}
+		if len(qualities) != len(hashes) {
+			return nil, fmt.Errorf("%d qualities for %d hashes", len(qualities), len(hashes))
+		}
+	}
+
+	out := make([]*gopdq.TaggedHash, len(hashes))
+	for i, h := range hashes {
+		out[i] = &gopdq.TaggedHash{Hash: h, ID: strconv.Itoa(i), Source: hashPath}
+		if qualities != nil {
+			out[i].Quality = qualities[i]
+		}
+	}
+	return out, nil
+}
+
+func readFile[T any](path string, read func(io.Reader) ([]T, error)) ([]T, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	v, err := read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return v, nil
+}
+
+func writeHeader(w io.Writer, descr string, shape string) error {
+	dict := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': %s, }", descr, shape)
+	// the preamble, header and its terminating newline are padded to a
+	// multiple of 64 bytes
+	pad := 64 - (10+len(dict)+1)%64
+	if pad == 64 {
+		pad = 0
+	}
+	hdr := dict + strings.Repeat(" ", pad) + "\n"
+
+	buf := append([]byte(nil), magic...)
+	buf = append(buf, 1, 0)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(hdr)))
+	buf = append(buf, hdr...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// WriteHashes writes hashes as an (N, 256) uint8 bit matrix
+func WriteHashes(w io.Writer, hashes []*gopdq.PdqHash256) error {
+	if err := writeHeader(w, "|u1", fmt.Sprintf("(%d, 256)", len(hashes))); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for _, h := range hashes {
+		if _, err := bw.Write(h.ToBits()); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteQualities writes qualities as an (N,) int32 array
+func WriteQualities(w io.Writer, qualities []int) error {
+	if err := writeHeader(w, "<i4", fmt.Sprintf("(%d,)", len(qualities))); err != nil {
+		return err
+	}
+	buf := make([]byte, 0, 4*len(qualities))
+	for _, q := range qualities {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(int32(q)))
+	}
+	_, err := w.Write(buf)
+	return err
+}
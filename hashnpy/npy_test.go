@@ -0,0 +1,138 @@
+package hashnpy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// npyFile builds a version 1.0 file the way numpy.save lays it out
+func npyFile(dict string, data []byte) []byte {
+	hdr := dict + strings.Repeat(" ", 63-(10+len(dict))%64) + "\n"
+	out := append([]byte("\x93NUMPY\x01\x00"), 0, 0)
+	binary.LittleEndian.PutUint16(out[8:], uint16(len(hdr)))
+	out = append(out, hdr...)
+	return append(out, data...)
+}
+
+func testHashes(t *testing.T) []*gopdq.PdqHash256 {
+	a, err := gopdq.FromHexString("02704e1ddd10f333c0e6df833130b07f99e36701383d333ac7c6078fe736dccc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []*gopdq.PdqHash256{a, a.BitwiseNOT(), a.Fuzz(20)}
+}
+
+func TestReadNumpyLayouts(t *testing.T) {
+	hashes := testHashes(t)
+
+	// bool, C order, as np.save(np.stack(vectors).astype(bool)) writes it
+	var bits []byte
+	for _, h := range hashes {
+		bits = append(bits, h.ToBits()...)
+	}
+	data := npyFile("{'descr': '|b1', 'fortran_order': False, 'shape': (3, 256), }", bits)
+	if len(data)%64 != 0 {
+		t.Fatalf("fixture not aligned: %d bytes", len(data))
+	}
+
+	// float64, Fortran order
+	var f64 []byte
+	for j := 0; j < 256; j++ {
+		for _, h := range hashes {
+			f64 = binary.LittleEndian.AppendUint64(f64, math.Float64bits(float64(h.ToBits()[j])))
+		}
+	}
+	fortran := npyFile("{'descr': '<f8', 'fortran_order': True, 'shape': (3, 256), }", f64)
+
+	// a single vector, as returned by pdqhash.compute
+	vector := npyFile("{'descr': '|u1', 'fortran_order': False, 'shape': (256,), }", hashes[0].ToBits())
+
+	for name, c := range map[string]struct {
+		data []byte
+		want []*gopdq.PdqHash256
+	}{
+		"bool":    {data, hashes},
+		"fortran": {fortran, hashes},
+		"vector":  {vector, hashes[:1]},
+	} {
+		got, err := ReadHashes(bytes.NewReader(c.data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(got) != len(c.want) {
+			t.Fatalf("%s: read %d hashes, expected %d", name, len(got), len(c.want))
+		}
+		for i := range got {
+			if !got[i].Equal(c.want[i]) {
+				t.Fatalf("%s: row %d is %s, expected %s", name, i, got[i], c.want[i])
+			}
+		}
+	}
+
+	bad := append([]byte(nil), bits...)
+	bad[300] = 2
+	for name, data := range map[string][]byte{
+		"value": npyFile("{'descr': '|u1', 'fortran_order': False, 'shape': (3, 256), }", bad),
+		"shape": npyFile("{'descr': '|u1', 'fortran_order': False, 'shape': (3, 128), }", bits[:384]),
+		"short": npyFile("{'descr': '|u1', 'fortran_order': False, 'shape': (3, 256), }", bits[:700]),
+		"dtype": npyFile("{'descr': '<c16', 'fortran_order': False, 'shape': (3, 256), }", bits),
+		"magic": []byte("hash,quality\n"),
+	} {
+		if _, err := ReadHashes(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestWriteAndLoad(t *testing.T) {
+	hashes := testHashes(t)
+	dir := t.TempDir()
+
+	buf := new(bytes.Buffer)
+	if err := WriteHashes(buf, hashes); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes()[10:], []byte("{'descr': '|u1', 'fortran_order': False, 'shape': (3, 256), }")) {
+		t.Fatalf("unexpected header %q", buf.Bytes()[:80])
+	}
+	if hl := binary.LittleEndian.Uint16(buf.Bytes()[8:]); (10+int(hl))%64 != 0 {
+		t.Fatalf("header length %d leaves the data unaligned", hl)
+	}
+	hashPath := filepath.Join(dir, "hashes.npy")
+	if err := os.WriteFile(hashPath, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if err := WriteQualities(buf, []int{100, 0, 73}); err != nil {
+		t.Fatal(err)
+	}
+	qualityPath := filepath.Join(dir, "quality.npy")
+	if err := os.WriteFile(qualityPath, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Load(hashPath, qualityPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, q := range []int{100, 0, 73} {
+		if !got[i].Hash.Equal(hashes[i]) || got[i].Quality != q || got[i].Source != hashPath {
+			t.Fatalf("record %d: %+v", i, got[i])
+		}
+	}
+	if got[2].ID != "2" {
+		t.Fatalf("record 2 has ID %q", got[2].ID)
+	}
+
+	if _, err := Load(hashPath, hashPath); err == nil {
+		t.Fatal("expected an error loading a bit matrix as qualities")
+	}
+}
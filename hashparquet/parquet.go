@@ -0,0 +1,186 @@
+// Package hashparquet writes hash datasets as Parquet files, so large corpora
+// can be queried directly from Spark, DuckDB and similar tools.
+//
+// Each row holds one gopdq.TaggedHash. The hash is a 32 byte fixed-size
+// binary column in the byte order of its hex string, so in SQL
+// hex(hash) gives the usual form. The metadata columns are typed: quality is
+// int32, timestamp and expires are millisecond UTC timestamps and labels is
+// a string map.
+package hashparquet
+
+import (
+	"io"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+	"github.com/whyrusleeping/gopdq"
+)
+
+// DefaultRowGroupSize is the number of rows per row group. Rows of a group
+// are buffered in memory until it is written out.
+const DefaultRowGroupSize = 1 << 17
+
+// row is the Parquet schema of a record
+type row struct {
+	Hash    [32]byte `parquet:"hash"`
+	Quality int32    `parquet:"quality"`
+	ID      string   `parquet:"id"`
+	Source  string   `parquet:"source,dict"`
+	// Timestamp is in Unix milliseconds, with zero stored as null
+	Timestamp int64             `parquet:"timestamp,timestamp(millisecond),optional"`
+	Labels    map[string]string `parquet:"labels"`
+	// Expires is in Unix milliseconds like Timestamp. Files written before
+	// it was added read as never expiring.
+	Expires int64 `parquet:"expires,timestamp(millisecond),optional"`
+}
+
+func toRow(t *gopdq.TaggedHash) row {
+	r := row{
+		Quality: int32(t.Quality),
+		ID:      t.ID,
+		Source:  t.Source,
+		Labels:  t.Labels,
+	}
+	copy(r.Hash[:], t.Hash.Bytes())
+	if !t.Timestamp.IsZero() {
+		r.Timestamp = t.Timestamp.UnixMilli()
+	}
+	if !t.Expires.IsZero() {
+		r.Expires = t.Expires.UnixMilli()
+	}
+	return r
+}
+
+func (r *row) tagged() (*gopdq.TaggedHash, error) {
+	h, err := gopdq.FromBytes(r.Hash[:])
+	if err != nil {
+		return nil, err
+	}
+	t := &gopdq.TaggedHash{
+		Hash:    h,
+		Quality: int(r.Quality),
+		ID:      r.ID,
+		Source:  r.Source,
+		Labels:  r.Labels,
+	}
+	if r.Timestamp != 0 {
+		t.Timestamp = time.UnixMilli(r.Timestamp).UTC()
+	}
+	if r.Expires != 0 {
+		t.Expires = time.UnixMilli(r.Expires).UTC()
+	}
+	return t, nil
+}
+
+// Writer streams records into a Parquet file
+type Writer struct {
+	pw           *parquet.GenericWriter[row]
+	buf          []row
+	rowGroupSize int
+	// grouped counts the rows written since the last row group was cut
+	grouped int
+}
+
+// WriterOption configures a Writer
+type WriterOption func(*Writer)
+
+// WithRowGroupSize sets the number of rows per row group, trading memory for
+// fewer, larger groups
+func WithRowGroupSize(n int) WriterOption {
+	return func(w *Writer) {
+		if n > 0 {
+			w.rowGroupSize = n
+		}
+	}
+}
+
+// NewWriter creates a Writer writing a zstd compressed Parquet file to w.
+// Close must be called to write the file footer.
+func NewWriter(w io.Writer, opts ...WriterOption) *Writer {
+	pw := &Writer{rowGroupSize: DefaultRowGroupSize}
+	for _, opt := range opts {
+		opt(pw)
+	}
+	pw.pw = parquet.NewGenericWriter[row](w,
+		parquet.Compression(&zstd.Codec{}),
+		parquet.CreatedBy("gopdq", "", ""),
+	)
+	pw.buf = make([]row, 0, min(pw.rowGroupSize, 1024))
+	return pw
+}
+
+// Write adds a record, flushing a row group once enough rows are buffered
+func (w *Writer) Write(t *gopdq.TaggedHash) error {
+	w.buf = append(w.buf, toRow(t))
+	w.grouped++
+	if len(w.buf) < cap(w.buf) && w.grouped < w.rowGroupSize {
+		return nil
+	}
+
+	if _, err := w.pw.Write(w.buf); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	if w.grouped >= w.rowGroupSize {
+		w.grouped = 0
+		return w.pw.Flush()
+	}
+	return nil
+}
+
+// Close writes any buffered rows and the file footer. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if len(w.buf) > 0 {
+		if _, err := w.pw.Write(w.buf); err != nil {
+			return err
+		}
+		w.buf = w.buf[:0]
+	}
+	return w.pw.Close()
+}
+
+// Reader reads back records from a file written by Writer
+type Reader struct {
+	pr  *parquet.GenericReader[row]
+	buf []row
+	pos int
+}
+
+// NewReader opens a Parquet file of records
+func NewReader(r io.ReaderAt) *Reader {
+	return &Reader{pr: parquet.NewGenericReader[row](r)}
+}
+
+// NumRows returns the number of records in the file
+func (r *Reader) NumRows() int64 {
+	return r.pr.NumRows()
+}
+
+// Read returns the next record, or io.EOF once all have been read
+func (r *Reader) Read() (*gopdq.TaggedHash, error) {
+	if r.pos == len(r.buf) {
+		if r.buf == nil {
+			r.buf = make([]row, 1024)
+		}
+		r.buf = r.buf[:cap(r.buf)]
+		clear(r.buf)
+		n, err := r.pr.Read(r.buf)
+		r.buf, r.pos = r.buf[:n], 0
+		if n == 0 {
+			if err == nil {
+				err = io.EOF
+			}
+			return nil, err
+		}
+	}
+	t, err := r.buf[r.pos].tagged()
+	r.pos++
+	return t, err
+}
+
+// Close releases the reader
+func (r *Reader) Close() error {
+	return r.pr.Close()
+}
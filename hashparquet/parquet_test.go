@@ -0,0 +1,100 @@
+package hashparquet
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/whyrusleeping/gopdq"
+)
+
+func TestRoundTrip(t *testing.T) {
+	base, err := gopdq.FromHexString("02704e1ddd10f333c0e6df833130b07f99e36701383d333ac7c6078fe736dccc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var in []*gopdq.TaggedHash
+	for i := 0; i < 2500; i++ {
+		th := &gopdq.TaggedHash{
+			Hash:    base.Fuzz(i % 40),
+			Quality: i % 101,
+			ID:      fmt.Sprintf("img-%d", i),
+			Source:  fmt.Sprintf("shard-%d", i%3),
+		}
+		if i%2 == 0 {
+			th.Timestamp = time.Date(2024, 5, 1, 0, 0, i, 0, time.UTC)
+			th.SetLabel("even", "yes")
+		}
+		if i%5 == 0 {
+			th.Expires = time.Date(2024, 8, 1, 0, 0, i, 0, time.UTC)
+		}
+		in = append(in, th)
+	}
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf, WithRowGroupSize(1000))
+	for _, th := range in {
+		if err := w.Write(th); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	defer r.Close()
+	if r.NumRows() != int64(len(in)) {
+		t.Fatalf("file has %d rows, expected %d", r.NumRows(), len(in))
+	}
+
+	for i, want := range in {
+		got, err := r.Read()
+		if err != nil {
+			t.Fatalf("row %d: %v", i, err)
+		}
+		if !got.Hash.Equal(want.Hash) || got.Quality != want.Quality || got.ID != want.ID || got.Source != want.Source {
+			t.Fatalf("row %d: got %+v, expected %+v", i, got, want)
+		}
+		if !got.Timestamp.Equal(want.Timestamp) || !got.Expires.Equal(want.Expires) || len(got.Labels) != len(want.Labels) || got.Labels["even"] != want.Labels["even"] {
+			t.Fatalf("row %d: metadata got %+v, expected %+v", i, got, want)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Fatalf("expected io.EOF after the last row, got %v", err)
+	}
+}
+
+// TestReadWithoutExpires reads a file written before rows had an expiry
+func TestReadWithoutExpires(t *testing.T) {
+	type oldRow struct {
+		Hash      [32]byte          `parquet:"hash"`
+		Quality   int32             `parquet:"quality"`
+		ID        string            `parquet:"id"`
+		Source    string            `parquet:"source,dict"`
+		Timestamp int64             `parquet:"timestamp,timestamp(millisecond),optional"`
+		Labels    map[string]string `parquet:"labels"`
+	}
+	buf := new(bytes.Buffer)
+	pw := parquet.NewGenericWriter[oldRow](buf)
+	if _, err := pw.Write([]oldRow{{Quality: 90, ID: "old"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	defer r.Close()
+	got, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != "old" || !got.Expires.IsZero() {
+		t.Fatalf("read %+v", got)
+	}
+}
@@ -0,0 +1,140 @@
+package gopdq
+
+import (
+	"iter"
+	"math/bits"
+)
+
+// hashKey is the comparable form of a hash, for use as a map key
+type hashKey [HASH256NUMSLOTS]uint16
+
+func (h *PdqHash256) key() hashKey {
+	var k hashKey
+	for i, w := range h.w {
+		k[i] = uint16(w)
+	}
+	return k
+}
+
+func (k hashKey) hash() *PdqHash256 {
+	h := NewPdqHash256()
+	for i, w := range k {
+		h.w[i] = int(w)
+	}
+	return h
+}
+
+func (k hashKey) distanceLE(o hashKey, d int) bool {
+	e := 0
+	for i := range k {
+		e += bits.OnesCount16(k[i] ^ o[i])
+		if e > d {
+			return false
+		}
+	}
+	return true
+}
+
+// HashSet is a set of distinct hashes, such as an allow or deny list. The
+// zero value is an empty set ready to use.
+type HashSet struct {
+	m map[hashKey]struct{}
+}
+
+// NewHashSet creates a set holding hashes
+func NewHashSet(hashes ...*PdqHash256) *HashSet {
+	s := &HashSet{m: make(map[hashKey]struct{}, len(hashes))}
+	for _, h := range hashes {
+		s.Add(h)
+	}
+	return s
+}
+
+// Add inserts h, reporting whether it was not already present
+func (s *HashSet) Add(h *PdqHash256) bool {
+	if s.m == nil {
+		s.m = make(map[hashKey]struct{})
+	}
+	k := h.key()
+	if _, ok := s.m[k]; ok {
+		return false
+	}
+	s.m[k] = struct{}{}
+	return true
+}
+
+// Remove deletes h, reporting whether it was present
+func (s *HashSet) Remove(h *PdqHash256) bool {
+	k := h.key()
+	if _, ok := s.m[k]; !ok {
+		return false
+	}
+	delete(s.m, k)
+	return true
+}
+
+// Contains reports whether h is in the set
+func (s *HashSet) Contains(h *PdqHash256) bool {
+	_, ok := s.m[h.key()]
+	return ok
+}
+
+// ContainsWithin reports whether the set holds a hash within Hamming distance
+// d of h. Anything but an exact hit scans the whole set; use an index for
+// large sets.
+func (s *HashSet) ContainsWithin(h *PdqHash256, d int) bool {
+	k := h.key()
+	if _, ok := s.m[k]; ok {
+		return true
+	}
+	for o := range s.m {
+		if k.distanceLE(o, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of hashes in the set
+func (s *HashSet) Len() int {
+	return len(s.m)
+}
+
+// Union returns a new set holding the hashes in either s or o
+func (s *HashSet) Union(o *HashSet) *HashSet {
+	rv := &HashSet{m: make(map[hashKey]struct{}, len(s.m)+len(o.m))}
+	for k := range s.m {
+		rv.m[k] = struct{}{}
+	}
+	for k := range o.m {
+		rv.m[k] = struct{}{}
+	}
+	return rv
+}
+
+// Intersection returns a new set holding the hashes in both s and o
+func (s *HashSet) Intersection(o *HashSet) *HashSet {
+	small, large := s, o
+	if len(large.m) < len(small.m) {
+		small, large = large, small
+	}
+	rv := &HashSet{m: make(map[hashKey]struct{})}
+	for k := range small.m {
+		if _, ok := large.m[k]; ok {
+			rv.m[k] = struct{}{}
+		}
+	}
+	return rv
+}
+
+// All iterates over the hashes in the set in no particular order. Each hash
+// yielded is a fresh copy.
+func (s *HashSet) All() iter.Seq[*PdqHash256] {
+	return func(yield func(*PdqHash256) bool) {
+		for k := range s.m {
+			if !yield(k.hash()) {
+				return
+			}
+		}
+	}
+}
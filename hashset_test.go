@@ -0,0 +1,49 @@
+package gopdq
+
+import "testing"
+
+func TestHashSet(t *testing.T) {
+	base, err := FromHexString("06704e1dd910f233c0e6df833130b0ff99e36701383d333ac7c6078fe736dccc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	near := base.Clone()
+	near.FlipBit(3)
+	near.FlipBit(200)
+	far := base.BitwiseNOT()
+
+	var s HashSet
+	if !s.Add(base) || s.Add(base.Clone()) {
+		t.Fatal("Add should only report new hashes")
+	}
+	if !s.Contains(base) || s.Contains(near) {
+		t.Fatal("Contains wrong")
+	}
+	if !s.ContainsWithin(near, 2) || s.ContainsWithin(near, 1) || s.ContainsWithin(far, 31) {
+		t.Fatal("ContainsWithin wrong")
+	}
+
+	o := NewHashSet(near, far, base)
+	if u := s.Union(o); u.Len() != 3 {
+		t.Fatalf("union has %d hashes, expected 3", u.Len())
+	}
+	i := o.Intersection(&s)
+	if i.Len() != 1 || !i.Contains(base) {
+		t.Fatalf("intersection has %d hashes, expected just the base", i.Len())
+	}
+
+	n := 0
+	for h := range o.All() {
+		if !o.Contains(h) {
+			t.Fatalf("iterated over %s which isn't in the set", h)
+		}
+		n++
+	}
+	if n != 3 {
+		t.Fatalf("iterated over %d hashes, expected 3", n)
+	}
+
+	if !o.Remove(far) || o.Remove(far) || o.Len() != 2 {
+		t.Fatal("Remove wrong")
+	}
+}
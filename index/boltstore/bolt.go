@@ -0,0 +1,329 @@
+// Package boltstore persists an index in a bbolt file, for single binary
+// deployments whose corpus doesn't fit in memory.
+//
+// Entries are kept under their big-endian id. Each word bucket is a key
+// range: the bucket position, the word and the entry id are concatenated
+// into a key with an empty value, so a bucket lookup is a prefix scan and
+// adding an entry never rewrites an existing id list. The number of entries
+// is kept in a meta bucket, updated in the same transaction as each change.
+package boltstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/whyrusleeping/gopdq"
+	"github.com/whyrusleeping/gopdq/index"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	entriesBucket = []byte("entries")
+	wordsBucket   = []byte("words")
+	metaBucket    = []byte("meta")
+
+	countKey = []byte("count")
+)
+
+// Store is an index.Store backed by a bbolt file
+type Store struct {
+	db *bolt.DB
+}
+
+var (
+	_ index.Store   = (*Store)(nil)
+	_ index.Scanner = (*Store)(nil)
+	_ index.Deleter = (*Store)(nil)
+)
+
+// Open opens or creates the index file at path. bbolt holds an exclusive
+// lock on the file, so only one process can have it open.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0o644, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{entriesBucket, wordsBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		// files written before the counter existed are counted once
+		if tx.Bucket(metaBucket).Get(countKey) == nil {
+			return putCount(tx, tx.Bucket(entriesBucket).Stats().KeyN)
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initializing %s: %w", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+// count returns the number of entries, counting them if the file has no
+// counter, as a file from before it opened read-only doesn't
+func count(tx *bolt.Tx) int {
+	if meta := tx.Bucket(metaBucket); meta != nil {
+		if v := meta.Get(countKey); len(v) == 8 {
+			return int(binary.BigEndian.Uint64(v))
+		}
+	}
+	return tx.Bucket(entriesBucket).Stats().KeyN
+}
+
+func putCount(tx *bolt.Tx, n int) error {
+	return tx.Bucket(metaBucket).Put(countKey, binary.BigEndian.AppendUint64(nil, uint64(n)))
+}
+
+func wordPrefix(seg int, w uint16) []byte {
+	return []byte{byte(seg), byte(w >> 8), byte(w)}
+}
+
+func (s *Store) Put(t *gopdq.TaggedHash) (uint64, error) {
+	val, err := msgpack.Marshal(t)
+	if err != nil {
+		return 0, err
+	}
+
+	var id uint64
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if id, err = put(tx, t, val); err != nil {
+			return err
+		}
+		return putCount(tx, count(tx)+1)
+	})
+	return id, err
+}
+
+// put stores the encoded entry val under the next id and files it in its
+// words' buckets, leaving the count to the caller
+func put(tx *bolt.Tx, t *gopdq.TaggedHash, val []byte) (uint64, error) {
+	entries := tx.Bucket(entriesBucket)
+	id, err := entries.NextSequence()
+	if err != nil {
+		return 0, err
+	}
+	if err := entries.Put(binary.BigEndian.AppendUint64(nil, id), val); err != nil {
+		return 0, err
+	}
+
+	words := tx.Bucket(wordsBucket)
+	for seg, w := range index.Words(t.Hash) {
+		if err := words.Put(binary.BigEndian.AppendUint64(wordPrefix(seg, w), id), nil); err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+func (s *Store) Get(id uint64) (*gopdq.TaggedHash, error) {
+	var t gopdq.TaggedHash
+	err := s.db.View(func(tx *bolt.Tx) error {
+		val := tx.Bucket(entriesBucket).Get(binary.BigEndian.AppendUint64(nil, id))
+		if val == nil {
+			return index.ErrNotFound
+		}
+		return msgpack.Unmarshal(val, &t)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *Store) Bucket(seg int, w uint16) ([]uint64, error) {
+	prefix := wordPrefix(seg, w)
+	var ids []uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(wordsBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			ids = append(ids, binary.BigEndian.Uint64(k[len(prefix):]))
+		}
+		return nil
+	})
+	return ids, err
+}
+
+func (s *Store) Len() (int, error) {
+	var n int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		n = count(tx)
+		return nil
+	})
+	return n, err
+}
+
+func (s *Store) Scan(fn func(id uint64, t *gopdq.TaggedHash) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(entriesBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var t gopdq.TaggedHash
+			if err := msgpack.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			if err := fn(binary.BigEndian.Uint64(k), &t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Delete removes the entry and its bucket keys. The file doesn't shrink;
+// bbolt reuses the freed pages for later writes, and Rewrite reclaims them.
+func (s *Store) Delete(id uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		entries := tx.Bucket(entriesBucket)
+		key := binary.BigEndian.AppendUint64(nil, id)
+		val := entries.Get(key)
+		if val == nil {
+			return index.ErrNotFound
+		}
+		var t gopdq.TaggedHash
+		if err := msgpack.Unmarshal(val, &t); err != nil {
+			return err
+		}
+
+		words := tx.Bucket(wordsBucket)
+		for seg, w := range index.Words(t.Hash) {
+			if err := words.Delete(binary.BigEndian.AppendUint64(wordPrefix(seg, w), id)); err != nil {
+				return err
+			}
+		}
+		if err := entries.Delete(key); err != nil {
+			return err
+		}
+		return putCount(tx, count(tx)-1)
+	})
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Rewrite creates a new index file at dst holding the entries of the index
+// files srcs, in order and renumbered from 1, returning how many it wrote.
+// It serves both to merge files and, with a single source, to compact one:
+// the new file has no space left by deleted entries and its B+tree pages
+// are filled completely. dedupe is as for index.Merge. dst must
+// not exist; it is removed again if the rewrite fails.
+func Rewrite(dst string, srcs []string, dedupe bool) (int, error) {
+	if _, err := os.Stat(dst); err == nil {
+		return 0, fmt.Errorf("%s already exists", dst)
+	}
+	out, err := Open(dst)
+	if err != nil {
+		return 0, err
+	}
+	fail := func(err error) (int, error) {
+		out.Close()
+		os.Remove(dst)
+		return 0, err
+	}
+
+	var stores []index.Store
+	defer func() {
+		for _, s := range stores {
+			s.Close()
+		}
+	}()
+	for _, path := range srcs {
+		s, err := OpenReadOnly(path)
+		if err != nil {
+			return fail(err)
+		}
+		stores = append(stores, s)
+	}
+
+	// one sync at the end rather than one per batch
+	out.db.NoSync = true
+	batch := &batchStore{Store: out}
+	n, err := index.Merge(batch, stores, dedupe)
+	if err == nil {
+		err = batch.flush()
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if err := out.db.Sync(); err != nil {
+		return fail(err)
+	}
+	return n, out.Close()
+}
+
+// rewriteBatch is how many entries Rewrite writes per transaction
+const rewriteBatch = 10000
+
+// batchStore is a new Store being filled by Rewrite. Puts are buffered and
+// written rewriteBatch at a time, into buckets packed full rather than
+// bbolt's default half, as nothing will be inserted among their keys later.
+type batchStore struct {
+	*Store
+	pending [][]byte
+	hashes  []*gopdq.TaggedHash
+	next    uint64
+}
+
+func (b *batchStore) Put(t *gopdq.TaggedHash) (uint64, error) {
+	val, err := msgpack.Marshal(t)
+	if err != nil {
+		return 0, err
+	}
+	b.pending = append(b.pending, val)
+	b.hashes = append(b.hashes, t)
+	b.next++
+	if len(b.pending) == rewriteBatch {
+		if err := b.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return b.next, nil
+}
+
+func (b *batchStore) flush() error {
+	if len(b.pending) == 0 {
+		return nil
+	}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		tx.Bucket(entriesBucket).FillPercent = 1.0
+		tx.Bucket(wordsBucket).FillPercent = 1.0
+		for i, val := range b.pending {
+			if _, err := put(tx, b.hashes[i], val); err != nil {
+				return err
+			}
+		}
+		return putCount(tx, count(tx)+len(b.pending))
+	})
+	b.pending, b.hashes = b.pending[:0], b.hashes[:0]
+	return err
+}
+
+// OpenReadOnly opens an existing index file for reading, failing if there is
+// none rather than creating it. It takes a shared lock, so any number of
+// readers can have the file open, though not alongside a writer; Put and
+// Delete fail.
+func OpenReadOnly(path string) (*Store, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0o644, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(entriesBucket) == nil || tx.Bucket(wordsBucket) == nil {
+			return fmt.Errorf("%s is not an index file", path)
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
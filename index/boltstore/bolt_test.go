@@ -0,0 +1,224 @@
+package boltstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/whyrusleeping/gopdq"
+	"github.com/whyrusleeping/gopdq/index"
+	"github.com/whyrusleeping/gopdq/index/indextest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.bolt")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indextest.TestStore(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// everything added before closing is back after reopening
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ix := index.New(s)
+	indextest.CheckQueries(t, ix, indextest.Corpus(t, 300))
+}
+
+func TestLen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.bolt")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix := index.New(s)
+	for _, e := range indextest.Corpus(t, 20) {
+		if _, err := ix.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ix.Delete(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Delete(3); err == nil {
+		t.Fatal("expected an error deleting a missing entry")
+	}
+	if n, err := s.Len(); err != nil || n != 19 {
+		t.Fatalf("Len = %d, %v, expected 19", n, err)
+	}
+
+	// a file from before the counter is counted
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(metaBucket)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	ro, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := ro.Len(); err != nil || n != 19 {
+		t.Fatalf("read-only Len = %d, %v, expected 19", n, err)
+	}
+	if _, err := ro.Put(indextest.Corpus(t, 1)[0]); err == nil {
+		t.Fatal("Put succeeded on a read-only store")
+	}
+	ro.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := index.New(s).Add(indextest.Corpus(t, 21)[20]); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.Len(); err != nil || n != 20 {
+		t.Fatalf("Len after reopening = %d, %v, expected 20", n, err)
+	}
+}
+
+func TestPayloads(t *testing.T) {
+	type caseRecord struct{ Case int }
+	path := filepath.Join(t.TempDir(), "index.bolt")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corpus := indextest.Corpus(t, 10)
+	p := index.WithPayloads[caseRecord](index.New(s))
+	for i, e := range corpus {
+		if err := p.Insert(e, caseRecord{Case: 100 + i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	// payloads come back after reopening, and go with their entries
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ix := index.New(s)
+	p = index.WithPayloads[caseRecord](ix)
+	got, err := p.Query(corpus[4].Hash, 0)
+	if err != nil || len(got) != 1 || got[0].Payload.Case != 104 {
+		t.Fatalf("Query = %+v, %v, expected the payload of entry 4", got, err)
+	}
+	if err := ix.Delete(got[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.Query(corpus[4].Hash, 0); err != nil || len(got) != 0 {
+		t.Fatalf("Query after delete = %+v, %v", got, err)
+	}
+}
+
+func TestOpenReadOnlyMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.bolt")
+	if _, err := OpenReadOnly(path); err == nil {
+		t.Fatal("expected an error opening a missing file")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("OpenReadOnly created the file")
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "index.bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	indextest.TestMaintenance(t, s)
+}
+
+func TestExpiry(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "index.bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	indextest.TestExpiry(t, s)
+}
+
+func TestRewrite(t *testing.T) {
+	dir := t.TempDir()
+	corpus := indextest.Corpus(t, 300)
+	a, b := filepath.Join(dir, "a.bolt"), filepath.Join(dir, "b.bolt")
+	for _, f := range []struct {
+		path    string
+		entries []*gopdq.TaggedHash
+	}{{a, corpus[:200]}, {b, corpus[150:]}} {
+		s, err := Open(f.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ix := index.New(s)
+		for _, e := range f.entries {
+			if _, err := ix.Add(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s.Close()
+	}
+
+	// the 50 entries in both files are only merged once
+	merged := filepath.Join(dir, "merged.bolt")
+	n, err := Rewrite(merged, []string{a, b}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(corpus) {
+		t.Fatalf("merged %d entries, expected %d", n, len(corpus))
+	}
+	if _, err := Rewrite(merged, []string{a}, false); err == nil {
+		t.Fatal("expected an error rewriting over an existing file")
+	}
+
+	s, err := Open(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix := index.New(s)
+	indextest.CheckQueries(t, ix, corpus)
+	for id := uint64(1); id <= 100; id++ {
+		if err := ix.Delete(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	compacted := filepath.Join(dir, "compacted.bolt")
+	if n, err = Rewrite(compacted, []string{merged}, false); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(corpus)-100 {
+		t.Fatalf("compacted to %d entries, expected %d", n, len(corpus)-100)
+	}
+	s, err = Open(compacted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	indextest.CheckQueries(t, index.New(s), corpus[100:])
+	if e, err := s.Get(1); err != nil || e.ID != corpus[100].ID {
+		t.Fatalf("compacted ids don't start from 1: %+v, %v", e, err)
+	}
+
+	if _, err := Rewrite(filepath.Join(dir, "bad.bolt"), []string{filepath.Join(dir, "missing.bolt")}, false); err == nil {
+		t.Fatal("expected an error for a missing source")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bad.bolt")); !os.IsNotExist(err) {
+		t.Fatal("failed rewrite left its output behind")
+	}
+}
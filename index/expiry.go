@@ -0,0 +1,109 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// purge queues the expired entries a query found for Sweep. Deletes are
+// write transactions in persistent stores, which the query path shouldn't
+// wait on, and concurrent queries often find the same entries.
+func (ix *Index) purge(ids []uint64) {
+	if len(ids) == 0 {
+		return
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.expired == nil {
+		ix.expired = make(map[uint64]struct{})
+	}
+	for _, id := range ids {
+		ix.expired[id] = struct{}{}
+	}
+}
+
+// ExpiredLister is implemented by stores that can list their expired
+// entries without reading every one, which Sweep prefers to a Scan
+type ExpiredLister interface {
+	// Expired returns the ids of the entries expired as of now
+	Expired(now time.Time) ([]uint64, error)
+}
+
+// Sweep deletes every entry expired as of now, returning how many: those
+// queries came across, and the ones no query reached, found through the
+// store's ExpiredLister, or else a Scan of every entry. The store must
+// implement Deleter, and ExpiredLister or Scanner.
+func (ix *Index) Sweep(now time.Time) (int, error) {
+	d, ok := ix.store.(Deleter)
+	if !ok {
+		return 0, fmt.Errorf("%T doesn't support deletion", ix.store)
+	}
+
+	ix.mu.Lock()
+	queued := ix.expired
+	ix.expired = nil
+	ix.mu.Unlock()
+
+	// deleting while scanning would wait on the scan's own lock or
+	// transaction in some stores
+	var expired []uint64
+	switch st := ix.store.(type) {
+	case ExpiredLister:
+		ids, err := st.Expired(now)
+		if err != nil {
+			return 0, err
+		}
+		expired = ids
+	case Scanner:
+		err := st.Scan(func(id uint64, t *gopdq.TaggedHash) error {
+			if t.Expired(now) {
+				expired = append(expired, id)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("%T can't list its entries", ix.store)
+	}
+	for _, id := range expired {
+		delete(queued, id)
+	}
+	for id := range queued {
+		expired = append(expired, id)
+	}
+
+	n := 0
+	for _, id := range expired {
+		switch err := d.Delete(id); {
+		case err == nil:
+			n++
+		case !errors.Is(err, ErrNotFound):
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// RunSweeper calls Sweep every interval until ctx is done, passing each
+// sweep's count and error to report, which may be nil
+func (ix *Index) RunSweeper(ctx context.Context, interval time.Duration, report func(n int, err error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			n, err := ix.Sweep(now)
+			if report != nil {
+				report(n, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package index
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+var _ Matcher = (*HNSW)(nil)
+
+// HNSWConfig tunes an HNSW graph. Zero fields take the defaults.
+type HNSWConfig struct {
+	// M is how many neighbours a node links to on each layer, twice that
+	// on the bottom one. Higher M raises recall and memory. Default 16.
+	M int
+	// EfConstruction is how many candidates an insert considers as its
+	// neighbours. Higher values build a better graph more slowly. Default
+	// 200.
+	EfConstruction int
+	// EfSearch is how many candidates a query starts with; radius queries
+	// widen it until the candidates reach past the radius. Default 64.
+	EfSearch int
+	// Seed seeds the choice of node layers, so the same inserts build the
+	// same graph
+	Seed int64
+}
+
+func (c *HNSWConfig) setDefaults() {
+	if c.M <= 0 {
+		c.M = 16
+	}
+	if c.EfConstruction <= 0 {
+		c.EfConstruction = 200
+	}
+	if c.EfSearch <= 0 {
+		c.EfSearch = 64
+	}
+}
+
+// HNSW is a hierarchical navigable small world graph over Hamming distance.
+// A query walks greedily from a few long range links on the sparse upper
+// layers down to the dense bottom layer and searches outward from there, so
+// its cost grows with the log of the corpus instead of with the corpus, as
+// Flat's does, or with the radius, as multi-index hashing's does.
+//
+// Unlike the other matchers it is approximate: a query can miss entries
+// within its radius, most often when many entries lie at about the same
+// distance. Recall is tuned with HNSWConfig and measured against Flat by
+// BenchmarkMatchers. Expired entries are left out of matches but stay in
+// the graph, which has no deletion, until it is rebuilt.
+type HNSW struct {
+	mu       sync.RWMutex
+	cfg      HNSWConfig
+	nodes    []hnswNode
+	entry    uint32
+	maxLevel int
+	rng      *rand.Rand
+	levelMul float64
+	visited  sync.Pool
+}
+
+type hnswNode struct {
+	entry *gopdq.TaggedHash
+	bits  hashBits
+	// links holds the neighbours on each layer from 0 up to the node's own
+	links [][]uint32
+}
+
+// hashBits is a hash packed for fast distances
+type hashBits [4]uint64
+
+func packBits(h *gopdq.PdqHash256) hashBits {
+	b := h.Bytes()
+	var p hashBits
+	for i := range p {
+		p[i] = binary.BigEndian.Uint64(b[8*i:])
+	}
+	return p
+}
+
+func (a *hashBits) distance(b *hashBits) int {
+	return bits.OnesCount64(a[0]^b[0]) + bits.OnesCount64(a[1]^b[1]) +
+		bits.OnesCount64(a[2]^b[2]) + bits.OnesCount64(a[3]^b[3])
+}
+
+// NewHNSW creates an empty graph
+func NewHNSW(cfg HNSWConfig) *HNSW {
+	cfg.setDefaults()
+	return &HNSW{
+		cfg:      cfg,
+		rng:      rand.New(rand.NewSource(cfg.Seed)),
+		levelMul: 1 / math.Log(float64(cfg.M)),
+	}
+}
+
+// Len returns the number of entries
+func (g *HNSW) Len() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return len(g.nodes)
+}
+
+// maxLinks is how many neighbours a node keeps on layer
+func (g *HNSW) maxLinks(layer int) int {
+	if layer == 0 {
+		return 2 * g.cfg.M
+	}
+	return g.cfg.M
+}
+
+// Insert adds t, with the next id in insertion order, starting at 0
+func (g *HNSW) Insert(t *gopdq.TaggedHash) error {
+	if t.Hash == nil {
+		return errNoHash
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	level := int(-math.Log(1-g.rng.Float64()) * g.levelMul)
+	id := uint32(len(g.nodes))
+	g.nodes = append(g.nodes, hnswNode{entry: t, bits: packBits(t.Hash), links: make([][]uint32, level+1)})
+	if id == 0 {
+		g.entry, g.maxLevel = id, level
+		return nil
+	}
+
+	q := &g.nodes[id].bits
+	eps := []candidate{{id: g.entry, dist: q.distance(&g.nodes[g.entry].bits)}}
+	for layer := g.maxLevel; layer > level; layer-- {
+		eps = g.searchLayer(q, eps, 1, layer)
+	}
+	for layer := min(level, g.maxLevel); layer >= 0; layer-- {
+		eps = g.searchLayer(q, eps, g.cfg.EfConstruction, layer)
+		neighbours := g.selectNeighbours(eps, g.cfg.M)
+		g.nodes[id].links[layer] = ids(neighbours)
+		for _, n := range neighbours {
+			g.link(n.id, id, layer)
+		}
+	}
+	if level > g.maxLevel {
+		g.entry, g.maxLevel = id, level
+	}
+	return nil
+}
+
+// link adds a link from node from to node to on layer, dropping from's
+// worst links if it then has too many
+func (g *HNSW) link(from, to uint32, layer int) {
+	n := &g.nodes[from]
+	n.links[layer] = append(n.links[layer], to)
+	if len(n.links[layer]) <= g.maxLinks(layer) {
+		return
+	}
+	cands := make([]candidate, len(n.links[layer]))
+	for i, l := range n.links[layer] {
+		cands[i] = candidate{id: l, dist: n.bits.distance(&g.nodes[l].bits)}
+	}
+	sortCandidates(cands)
+	n.links[layer] = ids(g.selectNeighbours(cands, g.maxLinks(layer)))
+}
+
+// selectNeighbours picks up to m of cands, which are sorted nearest first,
+// with the paper's heuristic: a candidate is skipped if it is nearer to one
+// already picked than to the node, as that one already leads to it, which
+// keeps links spread in every direction. Skipped candidates fill any places
+// left, so clustered nodes still get m links.
+func (g *HNSW) selectNeighbours(cands []candidate, m int) []candidate {
+	if len(cands) <= m {
+		return cands
+	}
+	picked := make([]candidate, 0, m)
+	var skipped []candidate
+	for _, c := range cands {
+		if len(picked) == m {
+			break
+		}
+		keep := true
+		for _, p := range picked {
+			if g.nodes[c.id].bits.distance(&g.nodes[p.id].bits) < c.dist {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			picked = append(picked, c)
+		} else {
+			skipped = append(skipped, c)
+		}
+	}
+	for _, c := range skipped {
+		if len(picked) == m {
+			break
+		}
+		picked = append(picked, c)
+	}
+	return picked
+}
+
+// search returns the ef nodes nearest q found on the bottom layer, nearest
+// first
+func (g *HNSW) search(q *hashBits, ef int) []candidate {
+	if len(g.nodes) == 0 {
+		return nil
+	}
+	eps := []candidate{{id: g.entry, dist: q.distance(&g.nodes[g.entry].bits)}}
+	for layer := g.maxLevel; layer > 0; layer-- {
+		eps = g.searchLayer(q, eps, 1, layer)
+	}
+	return g.searchLayer(q, eps, ef, 0)
+}
+
+// searchLayer searches layer outward from eps for the ef nodes nearest q,
+// returning them nearest first
+func (g *HNSW) searchLayer(q *hashBits, eps []candidate, ef, layer int) []candidate {
+	visited := g.getVisited()
+	defer g.visited.Put(visited)
+
+	var next minCandidates
+	var found maxCandidates
+	for _, e := range eps {
+		visited.mark(e.id)
+		next = append(next, e)
+		found = append(found, e)
+	}
+	heap.Init(&next)
+	heap.Init(&found)
+	for found.Len() > ef {
+		heap.Pop(&found)
+	}
+
+	for next.Len() > 0 {
+		c := heap.Pop(&next).(candidate)
+		if c.dist > found[0].dist && found.Len() >= ef {
+			break
+		}
+		for _, l := range g.nodes[c.id].links[layer] {
+			if !visited.mark(l) {
+				continue
+			}
+			d := q.distance(&g.nodes[l].bits)
+			if found.Len() < ef || d < found[0].dist {
+				heap.Push(&next, candidate{id: l, dist: d})
+				heap.Push(&found, candidate{id: l, dist: d})
+				if found.Len() > ef {
+					heap.Pop(&found)
+				}
+			}
+		}
+	}
+
+	out := []candidate(found)
+	sortCandidates(out)
+	return out
+}
+
+// Search returns up to k unexpired entries nearest h, nearest first,
+// considering the larger of k and EfSearch candidates
+func (g *HNSW) Search(h *gopdq.PdqHash256, k int) []Match {
+	if k <= 0 {
+		return nil
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	q := packBits(h)
+	out := g.matches(g.search(&q, max(k, g.cfg.EfSearch)))
+	return out[:min(k, len(out))]
+}
+
+// Query returns the entries found within maxDistance of h, nearest first
+// and by id among equal distances. It searches with EfSearch candidates and
+// doubles them until the farthest is beyond maxDistance, so a wide radius
+// costs more, as with the exact matchers.
+func (g *HNSW) Query(h *gopdq.PdqHash256, maxDistance int) ([]Match, error) {
+	if maxDistance < 0 {
+		return nil, nil
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	q := packBits(h)
+	var found []candidate
+	for ef := g.cfg.EfSearch; ; ef *= 2 {
+		found = g.search(&q, ef)
+		// fewer than ef means the search ran out of nodes to visit
+		if len(found) < ef || found[len(found)-1].dist > maxDistance {
+			break
+		}
+	}
+	n, _ := slices.BinarySearchFunc(found, maxDistance+1, func(c candidate, d int) int {
+		return c.dist - d
+	})
+	out := g.matches(found[:n])
+	sortMatches(out)
+	return out, nil
+}
+
+// matches converts cands to matches, leaving out expired entries, which
+// stay in the graph as links for the others
+func (g *HNSW) matches(cands []candidate) []Match {
+	now := time.Now()
+	out := make([]Match, 0, len(cands))
+	for _, c := range cands {
+		if e := g.nodes[c.id].entry; !e.Expired(now) {
+			out = append(out, Match{ID: uint64(c.id), Entry: e, Distance: c.dist})
+		}
+	}
+	return out
+}
+
+// visitedSet marks the nodes a search has visited. Marks are generation
+// numbers, so reusing a set from the pool doesn't need it cleared.
+type visitedSet struct {
+	marks []uint32
+	gen   uint32
+}
+
+func (g *HNSW) getVisited() *visitedSet {
+	v, _ := g.visited.Get().(*visitedSet)
+	if v == nil {
+		v = &visitedSet{}
+	}
+	if len(v.marks) < len(g.nodes) {
+		v.marks = append(v.marks, make([]uint32, len(g.nodes)-len(v.marks))...)
+	}
+	v.gen++
+	if v.gen == 0 {
+		clear(v.marks)
+		v.gen = 1
+	}
+	return v
+}
+
+// mark marks id visited, reporting whether it wasn't already
+func (v *visitedSet) mark(id uint32) bool {
+	if v.marks[id] == v.gen {
+		return false
+	}
+	v.marks[id] = v.gen
+	return true
+}
+
+type candidate struct {
+	id   uint32
+	dist int
+}
+
+func sortCandidates(c []candidate) {
+	slices.SortFunc(c, func(a, b candidate) int {
+		if a.dist != b.dist {
+			return a.dist - b.dist
+		}
+		return int(a.id) - int(b.id)
+	})
+}
+
+func ids(cands []candidate) []uint32 {
+	out := make([]uint32, len(cands))
+	for i, c := range cands {
+		out[i] = c.id
+	}
+	return out
+}
+
+// minCandidates is a heap of candidates, nearest on top
+type minCandidates []candidate
+
+func (h minCandidates) Len() int           { return len(h) }
+func (h minCandidates) Less(i, j int) bool { return h[i].dist < h[j].dist }
+func (h minCandidates) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *minCandidates) Push(x any)        { *h = append(*h, x.(candidate)) }
+func (h *minCandidates) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// maxCandidates is a heap of candidates, farthest on top
+type maxCandidates []candidate
+
+func (h maxCandidates) Len() int           { return len(h) }
+func (h maxCandidates) Less(i, j int) bool { return h[i].dist > h[j].dist }
+func (h maxCandidates) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *maxCandidates) Push(x any)        { *h = append(*h, x.(candidate)) }
+func (h *maxCandidates) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,161 @@
+// Package index finds stored hashes near a query without comparing against
+// every entry.
+//
+// It uses multi-index hashing: the 256 bits of a hash are split into 16
+// words of 16 bits and every entry is filed in one bucket per word. Two
+// hashes within distance d must agree to within d/16 bits on at least one
+// word, so a query only has to probe the buckets of its own words and their
+// near neighbours, then verify the candidates' full distance.
+//
+// Entries and buckets live in a Store, which may keep them in memory or
+// persist them.
+package index
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// NumWords is the number of 16 bit words a hash is split into
+const NumWords = gopdq.HASH256NUMSLOTS
+
+// ErrNotFound is returned by Store.Get for ids that aren't stored
+var ErrNotFound = errors.New("entry not found")
+
+// Store holds index entries and the buckets of their words. Implementations
+// must be safe for concurrent use.
+type Store interface {
+	// Put stores t under a new id and files the id in the bucket of each of
+	// its words
+	Put(t *gopdq.TaggedHash) (uint64, error)
+	// Get returns the entry stored under id, or ErrNotFound
+	Get(id uint64) (*gopdq.TaggedHash, error)
+	// Bucket returns the ids of entries whose word at position seg is w
+	Bucket(seg int, w uint16) ([]uint64, error)
+	// Len returns the number of stored entries
+	Len() (int, error)
+	Close() error
+}
+
+// Match is an entry found by a query
+type Match struct {
+	ID       uint64
+	Entry    *gopdq.TaggedHash
+	Distance int
+}
+
+// Index answers radius queries over the hashes in a Store
+type Index struct {
+	store Store
+
+	// expired holds the expired entries queries came across, for the next
+	// Sweep to delete
+	mu      sync.Mutex
+	expired map[uint64]struct{}
+}
+
+// New creates an index over store, or over a new MemStore if store is nil
+func New(store Store) *Index {
+	if store == nil {
+		store = NewMemStore()
+	}
+	return &Index{store: store}
+}
+
+// Store returns the store backing the index
+func (ix *Index) Store() Store {
+	return ix.store
+}
+
+// Add stores t, returning its id
+func (ix *Index) Add(t *gopdq.TaggedHash) (uint64, error) {
+	if t.Hash == nil {
+		return 0, errNoHash
+	}
+	return ix.store.Put(t)
+}
+
+// Len returns the number of entries
+func (ix *Index) Len() (int, error) {
+	return ix.store.Len()
+}
+
+// Close closes the store
+func (ix *Index) Close() error {
+	return ix.store.Close()
+}
+
+// Query returns every unexpired entry within maxDistance of h, nearest
+// first. Expired entries it comes across are left out and queued for the
+// next Sweep to delete, so queries never write to the store.
+func (ix *Index) Query(h *gopdq.PdqHash256, maxDistance int) ([]Match, error) {
+	if maxDistance < 0 {
+		return nil, nil
+	}
+	radius := maxDistance / NumWords
+
+	now := time.Now()
+	qw := Words(h)
+	seen := make(map[uint64]bool)
+	var out []Match
+	var expired []uint64
+	for seg, w := range qw {
+		for _, probe := range neighbours(w, radius) {
+			ids, err := ix.store.Bucket(seg, probe)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+
+				e, err := ix.store.Get(id)
+				if err != nil {
+					return nil, err
+				}
+				if e.Expired(now) {
+					expired = append(expired, id)
+				} else if d := h.HammingDistance(e.Hash); d <= maxDistance {
+					out = append(out, Match{ID: id, Entry: e, Distance: d})
+				}
+			}
+		}
+	}
+	ix.purge(expired)
+
+	sortMatches(out)
+	return out, nil
+}
+
+// Words returns the 16 words of h, in the order used for bucket positions
+func Words(h *gopdq.PdqHash256) [NumWords]uint16 {
+	var out [NumWords]uint16
+	for i, w := range h.Words() {
+		out[i] = uint16(w)
+	}
+	return out
+}
+
+// neighbours returns every word within radius bits of w, w first
+func neighbours(w uint16, radius int) []uint16 {
+	out := []uint16{w}
+	var flip func(v uint16, from, left int)
+	flip = func(v uint16, from, left int) {
+		for b := from; b < 16; b++ {
+			n := v ^ 1<<b
+			out = append(out, n)
+			if left > 1 {
+				flip(n, b+1, left-1)
+			}
+		}
+	}
+	if radius > 0 {
+		flip(w, 0, min(radius, 16))
+	}
+	return out
+}
@@ -0,0 +1,395 @@
+package index_test
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"testing"
+
+	"github.com/whyrusleeping/gopdq"
+	"github.com/whyrusleeping/gopdq/index"
+	"github.com/whyrusleeping/gopdq/index/indextest"
+)
+
+func TestMemStore(t *testing.T) {
+	indextest.TestStore(t, index.NewMemStore())
+}
+
+func TestMatchers(t *testing.T) {
+	for _, kind := range []string{"flat", "mih", "bktree", "hnsw", "mih/1", "flat/4"} {
+		t.Run(kind, func(t *testing.T) {
+			m, err := index.NewMatcher(kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			indextest.TestMatcher(t, m)
+		})
+	}
+	for _, kind := range []string{"lsh", "lsh/4", "mih/0", "mih/x", "mih/2/2"} {
+		if _, err := index.NewMatcher(kind); err == nil {
+			t.Fatalf("expected an error for matcher %q", kind)
+		}
+	}
+}
+
+// TestMatchersAgree checks the in-memory matchers give identical results,
+// ids included, so swapping one for another changes nothing downstream
+func TestMatchersAgree(t *testing.T) {
+	corpus := indextest.Corpus(t, 300)
+	var matchers []index.Matcher
+	for _, kind := range []string{"flat", "mih", "bktree", "mih/3", "bktree/8"} {
+		m, err := index.NewMatcher(kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range corpus {
+			if err := m.Insert(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		matchers = append(matchers, m)
+	}
+
+	for _, qi := range []int{0, 17, 250} {
+		for _, radius := range []int{0, 10, 31, 64} {
+			want, err := matchers[0].Query(corpus[qi].Hash, radius)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range matchers[1:] {
+				got, err := m.Query(corpus[qi].Hash, radius)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(got) != len(want) {
+					t.Fatalf("%T: %d matches, flat found %d", m, len(got), len(want))
+				}
+				for i := range got {
+					if got[i].ID != want[i].ID || got[i].Distance != want[i].Distance || got[i].Entry != want[i].Entry {
+						t.Fatalf("%T: match %d is %+v, flat found %+v", m, i, got[i], want[i])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestPayloads(t *testing.T) {
+	type caseRecord struct {
+		Case int
+		URL  string
+	}
+	corpus := indextest.Corpus(t, 200)
+	for _, kind := range []string{"flat", "mih", "hnsw", "mih/3"} {
+		t.Run(kind, func(t *testing.T) {
+			m, _ := index.NewMatcher(kind)
+			p := index.WithPayloads[caseRecord](m)
+			for i, e := range corpus {
+				if err := p.Insert(e, caseRecord{Case: i, URL: e.Source}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := p.Insert(&gopdq.TaggedHash{}, caseRecord{}); err == nil {
+				t.Fatal("expected an error inserting an entry without a hash")
+			}
+			for _, qi := range []int{0, 17, 150} {
+				got, err := p.Query(corpus[qi].Hash, 31)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(got) == 0 {
+					t.Fatalf("query %d found nothing", qi)
+				}
+				for _, m := range got {
+					if !m.Entry.Hash.Equal(corpus[m.Payload.Case].Hash) || m.Payload.URL != m.Entry.Source {
+						t.Fatalf("match %d has payload %+v", m.ID, m.Payload)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	corpus := indextest.Corpus(t, 300)
+	matchers := map[string]index.Matcher{
+		"flat-prefilter": index.NewPrefilteredFlat(index.Prefilter{}),
+	}
+	for _, kind := range []string{"flat", "mih", "flat/3", "mih/2"} {
+		matchers[kind], _ = index.NewMatcher(kind)
+	}
+	for name, m := range matchers {
+		t.Run(name, func(t *testing.T) {
+			for _, e := range corpus[:150] {
+				m.Insert(e)
+			}
+			snap, err := m.(index.Snapshotter).Snapshot()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// queries of the snapshot run alongside the rest of the inserts
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for _, e := range corpus[150:] {
+					m.Insert(e)
+				}
+				if ix, ok := m.(*index.Index); ok {
+					if err := ix.Delete(5); err != nil {
+						t.Error(err)
+					}
+				}
+			}()
+			indextest.CheckQueries(t, snap, corpus[:150])
+			<-done
+			indextest.CheckQueries(t, snap, corpus[:150])
+			if ix, ok := m.(*index.Index); ok {
+				got, _ := ix.Query(corpus[5].Hash, 0)
+				for _, m := range got {
+					if m.ID == 5 {
+						t.Fatal("deleted entry still found")
+					}
+				}
+			} else {
+				indextest.CheckQueries(t, m, corpus)
+			}
+
+			if err := snap.Insert(corpus[0]); !errors.Is(err, index.ErrReadOnly) {
+				t.Fatalf("expected ErrReadOnly inserting into a snapshot, got %v", err)
+			}
+		})
+	}
+	if _, ok := index.Matcher(index.NewBKTree()).(index.Snapshotter); ok {
+		t.Fatal("BKTree can't take snapshots")
+	}
+	if _, err := index.NewSharded(2, func() index.Matcher { return index.NewBKTree() }).Snapshot(); err == nil {
+		t.Fatal("expected an error snapshotting BKTree shards")
+	}
+}
+
+func TestMemStoreMaintenance(t *testing.T) {
+	indextest.TestMaintenance(t, index.NewMemStore())
+}
+
+func TestMemStoreExpiry(t *testing.T) {
+	indextest.TestExpiry(t, index.NewMemStore())
+}
+
+func TestMergeWithoutScanner(t *testing.T) {
+	if _, err := index.Merge(index.NewMemStore(), []index.Store{noScan{index.NewMemStore()}}, false); err == nil {
+		t.Fatal("expected an error merging from a store that can't list its entries")
+	}
+	if err := index.New(noScan{index.NewMemStore()}).Delete(0); err == nil {
+		t.Fatal("expected an error deleting from a store without Delete")
+	}
+}
+
+// noScan hides a store's optional methods
+type noScan struct {
+	index.Store
+}
+
+// nearDupCorpus returns n random hashes in groups of ten near duplicates,
+// each within 40 bits of its group's first, and queries within 12 bits of
+// some of them
+func nearDupCorpus(n, queries int, seed int64) ([]*gopdq.TaggedHash, []*gopdq.PdqHash256) {
+	rng := rand.New(rand.NewSource(seed))
+	random := func() *gopdq.PdqHash256 {
+		h := gopdq.NewPdqHash256()
+		for k := 0; k < 256; k++ {
+			if rng.Intn(2) == 1 {
+				h.SetBit(k)
+			}
+		}
+		return h
+	}
+	flip := func(h *gopdq.PdqHash256, maxBits int) *gopdq.PdqHash256 {
+		h = h.Clone()
+		for i := rng.Intn(maxBits + 1); i > 0; i-- {
+			h.FlipBit(rng.Intn(256))
+		}
+		return h
+	}
+
+	corpus := make([]*gopdq.TaggedHash, n)
+	var group *gopdq.PdqHash256
+	for i := range corpus {
+		if i%10 == 0 {
+			group = random()
+		}
+		corpus[i] = &gopdq.TaggedHash{Hash: flip(group, 40), ID: fmt.Sprint(i)}
+	}
+	qs := make([]*gopdq.PdqHash256, queries)
+	for i := range qs {
+		qs[i] = flip(corpus[rng.Intn(n)].Hash, 12)
+	}
+	return corpus, qs
+}
+
+// recall is the fraction of the matches in want also in got
+func recall(got, want []index.Match) float64 {
+	if len(want) == 0 {
+		return 1
+	}
+	ids := make(map[uint64]bool, len(got))
+	for _, m := range got {
+		ids[m.ID] = true
+	}
+	found := 0
+	for _, m := range want {
+		if ids[m.ID] {
+			found++
+		}
+	}
+	return float64(found) / float64(len(want))
+}
+
+func TestPrefilter(t *testing.T) {
+	t.Run("exact", func(t *testing.T) {
+		indextest.TestMatcher(t, index.NewPrefilteredFlat(index.Prefilter{}))
+	})
+
+	corpus, queries := nearDupCorpus(5000, 100, 2)
+	flat := index.NewFlat()
+	exact := index.NewPrefilteredFlat(index.Prefilter{})
+	sampled := index.NewPrefilteredFlat(index.Prefilter{Sigmas: 3})
+	for _, e := range corpus {
+		flat.Insert(e)
+		exact.Insert(e)
+		sampled.Insert(e)
+	}
+	var sum float64
+	for _, q := range queries {
+		want, _ := flat.Query(q, 31)
+		got, _ := exact.Query(q, 31)
+		if r := recall(got, want); r != 1 || len(got) != len(want) {
+			t.Fatalf("exact prefilter found %d of %d matches", len(got), len(want))
+		}
+		got, _ = sampled.Query(q, 31)
+		sum += recall(got, want)
+	}
+	if r := sum / float64(len(queries)); r < 0.99 {
+		t.Errorf("recall %.3f with a 3 sigma bound", r)
+	}
+	if r := exact.PrefilterStats().PruneRate(); r < 0.3 {
+		t.Errorf("exact prefilter pruned %.3f of entries", r)
+	}
+	if r := sampled.PrefilterStats().PruneRate(); r < 0.9 {
+		t.Errorf("3 sigma prefilter pruned %.3f of entries", r)
+	}
+	if st := flat.PrefilterStats(); st.Scanned != 0 {
+		t.Errorf("unfiltered matcher reports %+v", st)
+	}
+}
+
+func TestHNSWRecall(t *testing.T) {
+	corpus, queries := nearDupCorpus(5000, 100, 1)
+	flat, hnsw := index.NewFlat(), index.NewHNSW(index.HNSWConfig{Seed: 1})
+	for _, e := range corpus {
+		flat.Insert(e)
+		hnsw.Insert(e)
+	}
+
+	var sum float64
+	for _, q := range queries {
+		want, _ := flat.Query(q, 31)
+		got, err := hnsw.Query(q, 31)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range got {
+			if m.Distance != q.HammingDistance(m.Entry.Hash) || m.Distance > 31 || m.Entry != corpus[m.ID] {
+				t.Fatalf("bad match %+v", m)
+			}
+		}
+		sum += recall(got, want)
+
+		nearest := hnsw.Search(q, 5)
+		if len(nearest) != 5 || nearest[0].Distance != want[0].Distance {
+			t.Fatalf("Search found %+v, expected the nearest at distance %d", nearest, want[0].Distance)
+		}
+	}
+	if r := sum / float64(len(queries)); r < 0.98 {
+		t.Errorf("recall %.3f at radius 31", r)
+	}
+}
+
+// BenchmarkMatchers compares radius 31 queries over 20000 hashes, reporting
+// the recall of each matcher against a brute force scan
+func BenchmarkMatchers(b *testing.B) {
+	corpus, queries := nearDupCorpus(20000, 200, 1)
+	flat := index.NewFlat()
+	for _, e := range corpus {
+		flat.Insert(e)
+	}
+	want := make([][]index.Match, len(queries))
+	for i, q := range queries {
+		want[i], _ = flat.Query(q, 31)
+	}
+
+	matchers := []struct {
+		name string
+		m    index.Matcher
+	}{
+		{"flat", flat},
+		{"mih", index.New(nil)},
+		{"hnsw", index.NewHNSW(index.HNSWConfig{})},
+		{"hnsw-m8-ef32", index.NewHNSW(index.HNSWConfig{M: 8, EfConstruction: 100, EfSearch: 32})},
+		{"mih-sharded-4", index.NewSharded(4, func() index.Matcher { return index.New(nil) })},
+		{"flat-prefilter", index.NewPrefilteredFlat(index.Prefilter{})},
+		{"flat-prefilter-3sigma", index.NewPrefilteredFlat(index.Prefilter{Sigmas: 3})},
+	}
+	for _, mc := range matchers {
+		if mc.m != flat {
+			for _, e := range corpus {
+				mc.m.Insert(e)
+			}
+		}
+		b.Run(mc.name, func(b *testing.B) {
+			var sum float64
+			for i := 0; i < b.N; i++ {
+				qi := i % len(queries)
+				got, err := mc.m.Query(queries[qi], 31)
+				if err != nil {
+					b.Fatal(err)
+				}
+				sum += recall(got, want[qi])
+			}
+			b.ReportMetric(sum/float64(b.N), "recall")
+			if f, ok := mc.m.(*index.Flat); ok && f != flat {
+				b.ReportMetric(f.PrefilterStats().PruneRate(), "pruned")
+			}
+		})
+	}
+}
+
+// BenchmarkShardedThroughput measures radius 31 queries per second over
+// 20000 hashes from as many goroutines as GOMAXPROCS, against one index and
+// against one sharded across that many
+func BenchmarkShardedThroughput(b *testing.B) {
+	corpus, queries := nearDupCorpus(20000, 200, 1)
+	shards := runtime.GOMAXPROCS(0)
+	for _, mc := range []struct {
+		name string
+		m    index.Matcher
+	}{
+		{"mih", index.New(nil)},
+		{fmt.Sprintf("mih-sharded-%d", shards), index.NewSharded(shards, func() index.Matcher { return index.New(nil) })},
+	} {
+		for _, e := range corpus {
+			mc.m.Insert(e)
+		}
+		b.Run(mc.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					if _, err := mc.m.Query(queries[i%len(queries)], 31); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,240 @@
+// Package indextest checks that index.Store implementations behave alike
+package indextest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/whyrusleeping/gopdq"
+	"github.com/whyrusleeping/gopdq/index"
+)
+
+// Base is the hash entries generated by Corpus are derived from
+const Base = "02704e1ddd10f333c0e6df833130b07f99e36701383d333ac7c6078fe736dccc"
+
+// Corpus returns n entries: the i'th is Base with i%64 bits flipped
+func Corpus(t testing.TB, n int) []*gopdq.TaggedHash {
+	base, err := gopdq.FromHexString(Base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]*gopdq.TaggedHash, n)
+	for i := range out {
+		h := base.Clone()
+		for b := 0; b < i%64; b++ {
+			h.FlipBit((i*67 + b*13) % 256)
+		}
+		out[i] = &gopdq.TaggedHash{
+			Hash:    h,
+			Quality: i % 101,
+			ID:      fmt.Sprintf("img-%d", i),
+			Labels:  map[string]string{"n": fmt.Sprint(i)},
+			Payload: json.RawMessage(fmt.Sprintf(`{"case":%d}`, i)),
+		}
+	}
+	return out
+}
+
+// TestStore runs the common checks against a fresh, empty store
+func TestStore(t *testing.T, s index.Store) {
+	ix := index.New(s)
+	corpus := Corpus(t, 300)
+	ids := make([]uint64, len(corpus))
+	for i, e := range corpus {
+		id, err := ix.Add(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+
+	if n, err := ix.Len(); err != nil || n != len(corpus) {
+		t.Fatalf("Len = %d, %v; expected %d", n, err, len(corpus))
+	}
+
+	e, err := s.Get(ids[7])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Hash.Equal(corpus[7].Hash) || e.ID != "img-7" || e.Quality != 7 || e.Labels["n"] != "7" || string(e.Payload) != `{"case":7}` {
+		t.Fatalf("Get returned %+v, expected %+v", e, corpus[7])
+	}
+	if _, err := s.Get(ids[len(ids)-1] + 1000); !errors.Is(err, index.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	CheckQueries(t, ix, corpus)
+}
+
+// TestMatcher inserts a corpus into a fresh, empty matcher and checks its
+// queries
+func TestMatcher(t *testing.T, m index.Matcher) {
+	corpus := Corpus(t, 200)
+	for _, e := range corpus {
+		if err := m.Insert(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Insert(&gopdq.TaggedHash{}); err == nil {
+		t.Fatal("expected an error inserting an entry without a hash")
+	}
+	// an expired copy of a queried hash mustn't be among the matches
+	expired := *corpus[5]
+	expired.Expires = time.Now().Add(-time.Minute)
+	if err := m.Insert(&expired); err != nil {
+		t.Fatal(err)
+	}
+	CheckQueries(t, m, corpus)
+}
+
+// CheckQueries compares matcher queries against a brute force scan of corpus
+func CheckQueries(t *testing.T, ix index.Matcher, corpus []*gopdq.TaggedHash) {
+	for _, qi := range []int{0, 5, 63, 130} {
+		q := corpus[qi].Hash
+		for _, radius := range []int{0, 15, 31, 40} {
+			want := 0
+			for _, e := range corpus {
+				if q.HammingDistance(e.Hash) <= radius {
+					want++
+				}
+			}
+
+			got, err := ix.Query(q, radius)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != want {
+				t.Fatalf("query %d radius %d: %d matches, brute force found %d", qi, radius, len(got), want)
+			}
+			for i, m := range got {
+				if m.Distance != q.HammingDistance(m.Entry.Hash) || m.Distance > radius {
+					t.Fatalf("query %d radius %d: bad match %+v", qi, radius, m)
+				}
+				if i > 0 && got[i-1].Distance > m.Distance {
+					t.Fatalf("query %d radius %d: matches not nearest first", qi, radius)
+				}
+			}
+		}
+	}
+}
+
+// TestExpiry checks queries skip expired entries and Sweep deletes them,
+// from a fresh, empty store, which must implement index.Deleter and
+// index.Scanner or index.ExpiredLister
+func TestExpiry(t *testing.T, s index.Store) {
+	ix := index.New(s)
+	now := time.Now()
+	corpus := Corpus(t, 200)
+	ids := make([]uint64, len(corpus))
+	for i, e := range corpus {
+		switch {
+		case i < 10:
+			e.Expires = now.Add(-time.Hour).UTC()
+		case i < 20:
+			e.Expires = now.Add(time.Hour).UTC()
+		}
+		id, err := ix.Add(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+
+	if got, err := ix.Query(corpus[1].Hash, 0); err != nil || len(got) != 0 {
+		t.Fatalf("query for an expired entry gave %v, %v", got, err)
+	}
+	if _, err := s.Get(ids[1]); err != nil {
+		t.Fatalf("query deleted the expired entry it found: %v", err)
+	}
+	got, err := ix.Query(corpus[10].Hash, 0)
+	if err != nil || len(got) != 1 || !got[0].Entry.Expires.Equal(corpus[10].Expires) {
+		t.Fatalf("query for an entry expiring later gave %v, %v", got, err)
+	}
+
+	// the entries the queries queued are deleted once, with the rest
+	if n, err := ix.Sweep(now); err != nil || n != 10 {
+		t.Fatalf("Sweep deleted %d, %v; expected the 10 expired entries", n, err)
+	}
+	if _, err := s.Get(ids[1]); !errors.Is(err, index.ErrNotFound) {
+		t.Fatalf("Sweep left the expired entry a query found: %v", err)
+	}
+	if n, err := ix.Sweep(now.Add(2 * time.Hour)); err != nil || n != 10 {
+		t.Fatalf("later Sweep deleted %d, %v; expected 10", n, err)
+	}
+	if n, err := ix.Len(); err != nil || n != 180 {
+		t.Fatalf("Len = %d, %v after sweeping; expected 180", n, err)
+	}
+	CheckQueries(t, ix, corpus[20:])
+}
+
+// TestMaintenance checks Scan, Delete and merging from a fresh, empty store,
+// which must implement index.Scanner and index.Deleter
+func TestMaintenance(t *testing.T, s index.Store) {
+	ix := index.New(s)
+	corpus := Corpus(t, 200)
+	ids := make([]uint64, len(corpus))
+	for i, e := range corpus {
+		id, err := ix.Add(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+
+	var kept []*gopdq.TaggedHash
+	for i, id := range ids {
+		if i%7 != 3 {
+			kept = append(kept, corpus[i])
+			continue
+		}
+		if err := ix.Delete(id); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Get(id); !errors.Is(err, index.ErrNotFound) {
+			t.Fatalf("Get of deleted entry %d: %v", id, err)
+		}
+		if err := ix.Delete(id); !errors.Is(err, index.ErrNotFound) {
+			t.Fatalf("deleting entry %d twice: %v", id, err)
+		}
+	}
+	if n, err := ix.Len(); err != nil || n != len(kept) {
+		t.Fatalf("Len = %d, %v after deletes; expected %d", n, err, len(kept))
+	}
+	CheckQueries(t, ix, kept)
+
+	var scanned []*gopdq.TaggedHash
+	last := uint64(0)
+	err := s.(index.Scanner).Scan(func(id uint64, e *gopdq.TaggedHash) error {
+		if len(scanned) > 0 && id <= last {
+			return fmt.Errorf("Scan gave id %d after %d", id, last)
+		}
+		last = id
+		scanned = append(scanned, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scanned) != len(kept) {
+		t.Fatalf("Scan gave %d entries, expected %d", len(scanned), len(kept))
+	}
+	for i, e := range scanned {
+		if !e.Hash.Equal(kept[i].Hash) || e.ID != kept[i].ID {
+			t.Fatalf("Scan entry %d is %+v, expected %+v", i, e, kept[i])
+		}
+	}
+
+	// merging the store with itself, deduplicated, copies it once
+	merged := index.NewMemStore()
+	n, err := index.Merge(merged, []index.Store{s, s}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(kept) {
+		t.Fatalf("merge added %d entries, expected %d", n, len(kept))
+	}
+	CheckQueries(t, index.New(merged), kept)
+}
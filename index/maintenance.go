@@ -0,0 +1,82 @@
+package index
+
+import (
+	"fmt"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// Scanner is implemented by stores that can list their entries, which
+// merging needs
+type Scanner interface {
+	// Scan calls fn for every entry in id order, stopping at the first
+	// error fn returns
+	Scan(fn func(id uint64, t *gopdq.TaggedHash) error) error
+}
+
+// Deleter is implemented by stores that can remove entries. A deleted entry
+// is gone from Get and from every bucket at once, but persistent stores only
+// reclaim its space when rewritten, as by boltstore.Rewrite.
+type Deleter interface {
+	Delete(id uint64) error
+}
+
+// Delete removes the entry stored under id, or returns ErrNotFound
+func (ix *Index) Delete(id uint64) error {
+	d, ok := ix.store.(Deleter)
+	if !ok {
+		return fmt.Errorf("%T doesn't support deletion", ix.store)
+	}
+	return d.Delete(id)
+}
+
+// Merge adds the entries of every store in srcs, in order, to dst under new
+// ids, returning how many were added. Each source must implement Scanner.
+// With dedupe, an entry with the same hash, ID and Source as one already in
+// dst or added before it is skipped; dst's own entries are only seen if it
+// implements Scanner too.
+func Merge(dst Store, srcs []Store, dedupe bool) (int, error) {
+	type key struct {
+		hash       string
+		id, source string
+	}
+	seen := make(map[key]bool)
+	keyOf := func(t *gopdq.TaggedHash) key {
+		return key{string(t.Hash.Bytes()), t.ID, t.Source}
+	}
+	if sc, ok := dst.(Scanner); ok && dedupe {
+		err := sc.Scan(func(_ uint64, t *gopdq.TaggedHash) error {
+			seen[keyOf(t)] = true
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	added := 0
+	for i, src := range srcs {
+		sc, ok := src.(Scanner)
+		if !ok {
+			return added, fmt.Errorf("source %d: %T can't list its entries", i, src)
+		}
+		err := sc.Scan(func(_ uint64, t *gopdq.TaggedHash) error {
+			if dedupe {
+				k := keyOf(t)
+				if seen[k] {
+					return nil
+				}
+				seen[k] = true
+			}
+			if _, err := dst.Put(t); err != nil {
+				return err
+			}
+			added++
+			return nil
+		})
+		if err != nil {
+			return added, err
+		}
+	}
+	return added, nil
+}
@@ -0,0 +1,268 @@
+package index
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// Matcher is the interface shared by the ways of finding hashes near a
+// query, so applications can pick one by configuration. Index implements it
+// over any Store, in memory or persistent; Flat and BKTree are in-memory
+// alternatives, and HNSW an approximate one for corpora too large for
+// them. Implementations are safe for concurrent use. Payloads wraps any of
+// them to return application values with each match.
+type Matcher interface {
+	// Insert adds t, which must have a hash
+	Insert(t *gopdq.TaggedHash) error
+	// Query returns every entry within maxDistance of h, nearest first and
+	// by id among equal distances, leaving out those that have expired
+	Query(h *gopdq.PdqHash256, maxDistance int) ([]Match, error)
+}
+
+var (
+	_ Matcher = (*Index)(nil)
+	_ Matcher = (*Flat)(nil)
+	_ Matcher = (*BKTree)(nil)
+)
+
+var errNoHash = errors.New("entry has no hash")
+
+// NewMatcher creates an empty in-memory matcher of the named kind: "flat",
+// "mih" for an Index over a MemStore, "bktree", or "hnsw" with the default
+// HNSWConfig. A kind followed by a slash and a count, such as "mih/8", is a
+// Sharded matcher over that many of the kind. Persistent indexes are
+// created with New over the store.
+func NewMatcher(kind string) (Matcher, error) {
+	return NewMatcherConfig(kind, HNSWConfig{})
+}
+
+// NewMatcherConfig is NewMatcher with hnsw configuring "hnsw" matchers,
+// each shard's alike
+func NewMatcherConfig(kind string, hnsw HNSWConfig) (Matcher, error) {
+	if base, count, ok := strings.Cut(kind, "/"); ok {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid shard count in matcher %q", kind)
+		}
+		if _, err := NewMatcherConfig(base, hnsw); err != nil {
+			return nil, fmt.Errorf("unknown matcher %q", kind)
+		}
+		return NewSharded(n, func() Matcher {
+			m, _ := NewMatcherConfig(base, hnsw)
+			return m
+		}), nil
+	}
+	switch kind {
+	case "flat":
+		return NewFlat(), nil
+	case "mih":
+		return New(nil), nil
+	case "bktree":
+		return NewBKTree(), nil
+	case "hnsw":
+		return NewHNSW(hnsw), nil
+	}
+	return nil, fmt.Errorf("unknown matcher %q", kind)
+}
+
+// Insert adds t to the index, discarding its id
+func (ix *Index) Insert(t *gopdq.TaggedHash) error {
+	_, err := ix.Add(t)
+	return err
+}
+
+func sortMatches(out []Match) {
+	slices.SortFunc(out, func(a, b Match) int {
+		return cmp.Or(cmp.Compare(a.Distance, b.Distance), cmp.Compare(a.ID, b.ID))
+	})
+}
+
+// Flat compares a query against every entry. With the packed distance kernel
+// that is fast enough for lists up to a few hundred thousand hashes, and its
+// cost doesn't grow with the query radius as the other matchers' does.
+//
+// Expired entries are left out of matches but never removed, so they keep
+// their memory until the list is rebuilt, as a reload does.
+type Flat struct {
+	mu      sync.RWMutex
+	entries []*gopdq.TaggedHash
+	packed  *gopdq.PackedHashes
+	// prefilter replaces packed in a prefiltered matcher
+	prefilter *prefilterState
+	readOnly  bool
+}
+
+// NewFlat creates an empty flat matcher
+func NewFlat() *Flat {
+	return &Flat{packed: gopdq.PackHashes(nil)}
+}
+
+// NewPrefilteredFlat creates an empty flat matcher that compares a sample
+// of each hash before its full distance, as p describes
+func NewPrefilteredFlat(p Prefilter) *Flat {
+	return &Flat{prefilter: &prefilterState{cfg: p}}
+}
+
+// PrefilterStats returns the counts of entries scanned and skipped by the
+// queries so far, which are zero without a prefilter
+func (f *Flat) PrefilterStats() PrefilterStats {
+	if f.prefilter == nil {
+		return PrefilterStats{}
+	}
+	return PrefilterStats{Scanned: f.prefilter.scanned.Load(), Pruned: f.prefilter.pruned.Load()}
+}
+
+// Insert adds t, with the next id in insertion order, starting at 0
+func (f *Flat) Insert(t *gopdq.TaggedHash) error {
+	if t.Hash == nil {
+		return errNoHash
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.readOnly {
+		return ErrReadOnly
+	}
+	f.entries = append(f.entries, t)
+	if f.prefilter != nil {
+		f.prefilter.append(packBits(t.Hash))
+	} else {
+		f.packed.Append(t.Hash)
+	}
+	return nil
+}
+
+// Len returns the number of entries
+func (f *Flat) Len() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.entries)
+}
+
+func (f *Flat) Query(h *gopdq.PdqHash256, maxDistance int) ([]Match, error) {
+	if maxDistance < 0 {
+		return nil, nil
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	now := time.Now()
+	var out []Match
+	if f.prefilter != nil {
+		q := packBits(h)
+		f.prefilter.query(&q, maxDistance, func(id, dist int) {
+			if !f.entries[id].Expired(now) {
+				out = append(out, Match{ID: uint64(id), Entry: f.entries[id], Distance: dist})
+			}
+		})
+		sortMatches(out)
+		return out, nil
+	}
+
+	dists := make([]uint16, len(f.entries))
+	f.packed.Distances(h, dists)
+	for i, d := range dists {
+		if int(d) <= maxDistance && !f.entries[i].Expired(now) {
+			out = append(out, Match{ID: uint64(i), Entry: f.entries[i], Distance: int(d)})
+		}
+	}
+	sortMatches(out)
+	return out, nil
+}
+
+type bkNode struct {
+	id       uint64
+	entry    *gopdq.TaggedHash
+	children map[int]*bkNode
+}
+
+// BKTree is a Burkhard-Keller tree over Hamming distance. By the triangle
+// inequality a query only descends into children whose edge distance is
+// within maxDistance of its distance to the parent, which prunes well for
+// small radii over clustered hashes.
+//
+// As with Flat, expired entries are left out of matches but stay in the
+// tree, still compared against, until it is rebuilt.
+type BKTree struct {
+	mu   sync.RWMutex
+	root *bkNode
+	n    int
+}
+
+// NewBKTree creates an empty BK-tree
+func NewBKTree() *BKTree {
+	return &BKTree{}
+}
+
+// Insert adds t, with the next id in insertion order, starting at 0
+func (b *BKTree) Insert(t *gopdq.TaggedHash) error {
+	if t.Hash == nil {
+		return errNoHash
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := &bkNode{id: uint64(b.n), entry: t}
+	b.n++
+	if b.root == nil {
+		b.root = n
+		return nil
+	}
+	for cur := b.root; ; {
+		d := cur.entry.Hash.HammingDistance(t.Hash)
+		next, ok := cur.children[d]
+		if !ok {
+			if cur.children == nil {
+				cur.children = make(map[int]*bkNode)
+			}
+			cur.children[d] = n
+			return nil
+		}
+		cur = next
+	}
+}
+
+// Len returns the number of entries
+func (b *BKTree) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.n
+}
+
+func (b *BKTree) Query(h *gopdq.PdqHash256, maxDistance int) ([]Match, error) {
+	if maxDistance < 0 {
+		return nil, nil
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	now := time.Now()
+	var out []Match
+	var stack []*bkNode
+	if b.root != nil {
+		stack = append(stack, b.root)
+	}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		d := h.HammingDistance(n.entry.Hash)
+		if d <= maxDistance && !n.entry.Expired(now) {
+			out = append(out, Match{ID: n.id, Entry: n.entry, Distance: d})
+		}
+		for edge, c := range n.children {
+			if edge >= d-maxDistance && edge <= d+maxDistance {
+				stack = append(stack, c)
+			}
+		}
+	}
+	sortMatches(out)
+	return out, nil
+}
@@ -0,0 +1,114 @@
+package index
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// MemStore keeps entries and buckets in memory
+type MemStore struct {
+	mu      sync.RWMutex
+	entries []*gopdq.TaggedHash // nil once deleted
+	deleted int
+	buckets [NumWords]map[uint16][]uint64
+
+	// shared and sharedEntries are set while a snapshot shares the buckets
+	// and entries
+	shared, sharedEntries bool
+	readOnly              bool
+}
+
+var (
+	_ Scanner = (*MemStore)(nil)
+	_ Deleter = (*MemStore)(nil)
+)
+
+// NewMemStore creates an empty in-memory store
+func NewMemStore() *MemStore {
+	s := &MemStore{}
+	for i := range s.buckets {
+		s.buckets[i] = make(map[uint16][]uint64)
+	}
+	return s
+}
+
+func (s *MemStore) Put(t *gopdq.TaggedHash) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.readOnly {
+		return 0, ErrReadOnly
+	}
+	s.unshare(false)
+
+	id := uint64(len(s.entries))
+	s.entries = append(s.entries, t)
+	for seg, w := range Words(t.Hash) {
+		s.buckets[seg][w] = append(s.buckets[seg][w], id)
+	}
+	return id, nil
+}
+
+func (s *MemStore) Get(id uint64) (*gopdq.TaggedHash, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if id >= uint64(len(s.entries)) || s.entries[id] == nil {
+		return nil, ErrNotFound
+	}
+	return s.entries[id], nil
+}
+
+func (s *MemStore) Bucket(seg int, w uint16) ([]uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.buckets[seg][w], nil
+}
+
+func (s *MemStore) Len() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.entries) - s.deleted, nil
+}
+
+func (s *MemStore) Scan(fn func(id uint64, t *gopdq.TaggedHash) error) error {
+	s.mu.RLock()
+	entries := slices.Clone(s.entries)
+	s.mu.RUnlock()
+
+	for id, t := range entries {
+		if t == nil {
+			continue
+		}
+		if err := fn(uint64(id), t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) Delete(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	if id >= uint64(len(s.entries)) || s.entries[id] == nil {
+		return ErrNotFound
+	}
+	s.unshare(true)
+	// Bucket hands out the slices themselves, so they are replaced rather
+	// than edited
+	for seg, w := range Words(s.entries[id].Hash) {
+		s.buckets[seg][w] = slices.DeleteFunc(slices.Clone(s.buckets[seg][w]), func(v uint64) bool { return v == id })
+	}
+	s.entries[id] = nil
+	s.deleted++
+	return nil
+}
+
+func (s *MemStore) Close() error {
+	return nil
+}
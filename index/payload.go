@@ -0,0 +1,68 @@
+package index
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// Payloads pairs the entries of a matcher with application values, such as
+// a case record or the URL an image was seen at, so queries return them
+// with each match instead of leaving callers to look them up by id.
+// Payloads are encoded as JSON into each entry's Payload field, so they are
+// stored wherever the entry is: a persistent Index keeps them across
+// restarts, and deleting or purging an entry drops its payload with it.
+type Payloads[T any] struct {
+	m Matcher
+}
+
+// PayloadMatch is a match with the payload inserted with its entry
+type PayloadMatch[T any] struct {
+	Match
+	Payload T
+}
+
+// WithPayloads wraps m. Entries already in it, and those inserted without
+// the wrapper, match with the zero payload.
+func WithPayloads[T any](m Matcher) *Payloads[T] {
+	return &Payloads[T]{m: m}
+}
+
+// Matcher returns the wrapped matcher
+func (p *Payloads[T]) Matcher() Matcher {
+	return p.m
+}
+
+// Insert adds a copy of t carrying payload
+func (p *Payloads[T]) Insert(t *gopdq.TaggedHash, payload T) error {
+	if t.Hash == nil {
+		return errNoHash
+	}
+	enc, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encoding payload: %w", err)
+	}
+	e := *t
+	e.Payload = enc
+	return p.m.Insert(&e)
+}
+
+// Query returns the matches of the wrapped matcher with their payloads
+func (p *Payloads[T]) Query(h *gopdq.PdqHash256, maxDistance int) ([]PayloadMatch[T], error) {
+	matches, err := p.m.Query(h, maxDistance)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]PayloadMatch[T], len(matches))
+	for i, m := range matches {
+		out[i].Match = m
+		if len(m.Entry.Payload) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(m.Entry.Payload, &out[i].Payload); err != nil {
+			return nil, fmt.Errorf("decoding payload of entry %d: %w", m.ID, err)
+		}
+	}
+	return out, nil
+}
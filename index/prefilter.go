@@ -0,0 +1,100 @@
+package index
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+)
+
+// Prefilter configures a Flat matcher to compare a sample of 4 of each
+// hash's 16 words before its full distance. The sample is 64 of the 256
+// bits, so an entry whose distance over the sample is already above a bound
+// scaled down from the query radius is skipped, and the full distance is
+// only computed for the few that pass. Samples are stored apart from the
+// hashes, so a scan reads 8 bytes for most entries instead of 32.
+type Prefilter struct {
+	// Sigmas sets the bound over the sample to the distance expected of an
+	// entry at the query radius plus this many standard deviations. An
+	// entry that far from the query differs on about a quarter of its bits
+	// within the sample, so the bound is about a quarter of the radius and
+	// skips almost every unrelated hash, at the cost of missing the rare
+	// match whose differences crowd into the sample: with 3 sigmas and a
+	// radius of 31, about 1 in 1700 entries at distance 31 and 1 in 10
+	// million at 20. BenchmarkMatchers measures it skipping all but 1 in
+	// 2000 entries, twice as fast as the full scan with no lost matches.
+	//
+	// Zero makes the bound the radius itself, which can't skip a match as
+	// the sample's distance never exceeds the full distance, but only skips
+	// about half the unrelated hashes, too few to beat the vectorized full
+	// scan.
+	Sigmas float64
+}
+
+// bound returns the largest sample distance worth a full comparison for
+// queries of radius maxDistance
+func (p *Prefilter) bound(maxDistance int) int {
+	if p.Sigmas <= 0 || maxDistance >= 256 {
+		return maxDistance
+	}
+	// the sample distance of an entry at maxDistance is hypergeometric:
+	// 64 bits drawn from 256 of which maxDistance differ
+	const n, sample = 256.0, 64.0
+	frac := float64(maxDistance) / n
+	mean := sample * frac
+	sd := math.Sqrt(sample * frac * (1 - frac) * (n - sample) / (n - 1))
+	return min(maxDistance, int(math.Ceil(mean+p.Sigmas*sd)))
+}
+
+// sampleBits takes one 16-bit word from each 64-bit quarter of b, a
+// different position in each, so the sample spans the whole hash
+func sampleBits(b *hashBits) uint64 {
+	return b[0]&0xffff<<48 | b[1]>>16&0xffff<<32 | b[2]>>32&0xffff<<16 | b[3]>>48
+}
+
+// PrefilterStats counts the entries a prefiltered Flat matcher's queries
+// have scanned and how many the sample skipped
+type PrefilterStats struct {
+	Scanned uint64
+	Pruned  uint64
+}
+
+// PruneRate returns the fraction of scanned entries skipped
+func (s PrefilterStats) PruneRate() float64 {
+	if s.Scanned == 0 {
+		return 0
+	}
+	return float64(s.Pruned) / float64(s.Scanned)
+}
+
+// prefilterState is the sampled form of a Flat matcher's entries
+type prefilterState struct {
+	cfg     Prefilter
+	samples []uint64
+	bits    []hashBits
+
+	scanned, pruned atomic.Uint64
+}
+
+func (p *prefilterState) append(b hashBits) {
+	p.samples = append(p.samples, sampleBits(&b))
+	p.bits = append(p.bits, b)
+}
+
+// query calls match with the id and distance of each entry within
+// maxDistance of q, in id order
+func (p *prefilterState) query(q *hashBits, maxDistance int, match func(id, dist int)) {
+	qs := sampleBits(q)
+	bound := p.cfg.bound(maxDistance)
+	pruned := 0
+	for i, s := range p.samples {
+		if bits.OnesCount64(qs^s) > bound {
+			pruned++
+			continue
+		}
+		if d := q.distance(&p.bits[i]); d <= maxDistance {
+			match(i, d)
+		}
+	}
+	p.scanned.Add(uint64(len(p.samples)))
+	p.pruned.Add(uint64(pruned))
+}
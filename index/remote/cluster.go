@@ -0,0 +1,342 @@
+package remote
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/whyrusleeping/gopdq"
+	"github.com/whyrusleeping/gopdq/gopdqpb"
+	"github.com/whyrusleeping/gopdq/index"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+var _ index.Matcher = (*Cluster)(nil)
+
+var (
+	// ErrTooFewShards is returned by queries that fewer than
+	// ClusterConfig.MinShards shards answered
+	ErrTooFewShards = errors.New("too few shards answered")
+	// ErrShardUnhealthy is the error of a shard skipped by a query because
+	// its last health check or query failed
+	ErrShardUnhealthy = errors.New("shard is unhealthy")
+	// ErrReadOnly is returned by Cluster.Insert, as entries are added to a
+	// shard's own index
+	ErrReadOnly = errors.New("remote shards are read only")
+)
+
+// maxMessageSize bounds the responses a Cluster accepts, well above the
+// 4 MiB gRPC default, as a wide radius over a dense shard can match many
+// entries
+const maxMessageSize = math.MaxInt32
+
+// ClusterConfig describes the shards of a distributed index
+type ClusterConfig struct {
+	// Addrs are the gRPC addresses of the shards, in shard order
+	Addrs []string
+	// DialOptions are used to connect to every shard. Without any the
+	// connections are plaintext.
+	DialOptions []grpc.DialOption
+	// Timeout bounds each shard's part of a query; defaults to 2s
+	Timeout time.Duration
+	// HealthInterval is how often each shard's health is checked; defaults
+	// to 5s
+	HealthInterval time.Duration
+	// MinShards is how many shards must answer a query for it to succeed.
+	// The default of 0 means all of them; fewer trades completeness for
+	// availability, so a query answers from the shards that are up.
+	MinShards int
+	// Logger reports shards changing health and partial queries. Optional.
+	Logger *slog.Logger
+}
+
+// Cluster queries the shards of a distributed index as one matcher.
+//
+// Each shard numbers its entries from 0, and a Cluster numbers shard i's
+// entry l as l*len(Addrs) + i, the id an index.Sharded over the same shards
+// gives it. So a corpus dealt out round robin, as index.Sharded does, keeps
+// the ids of one matcher over the whole corpus.
+type Cluster struct {
+	cfg    ClusterConfig
+	shards []*shard
+	need   int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type shard struct {
+	addr   string
+	conn   *grpc.ClientConn
+	client gopdqpb.IndexShardClient
+	health healthpb.HealthClient
+
+	healthy atomic.Bool
+	mu      sync.Mutex
+	err     error
+	checked time.Time
+}
+
+// ShardStatus is the health of a shard as of its last health check, or of
+// a query that since failed
+type ShardStatus struct {
+	Addr    string
+	Healthy bool
+	// Err is why the shard is unhealthy
+	Err     error
+	Checked time.Time
+}
+
+// ShardError is the failure of one shard's part of a query
+type ShardError struct {
+	Shard int
+	Addr  string
+	Err   error
+}
+
+func (e *ShardError) Error() string {
+	return fmt.Sprintf("shard %d (%s): %v", e.Shard, e.Addr, e.Err)
+}
+
+func (e *ShardError) Unwrap() error {
+	return e.Err
+}
+
+// Result is the outcome of a query across the shards
+type Result struct {
+	// Matches are those of every shard that answered, nearest first then
+	// by id
+	Matches []index.Match
+	// Failed lists the shards that didn't answer, in shard order
+	Failed []*ShardError
+}
+
+// Dial connects to the shards and checks their health once before
+// returning, so queries made straight away skip those already down. Shards
+// that can't be reached don't make it fail; they are retried by the health
+// checks.
+func Dial(cfg ClusterConfig) (*Cluster, error) {
+	if len(cfg.Addrs) == 0 {
+		return nil, errors.New("no shard addresses")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+	if cfg.HealthInterval <= 0 {
+		cfg.HealthInterval = 5 * time.Second
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.New(slog.DiscardHandler)
+	}
+	if cfg.MinShards > len(cfg.Addrs) {
+		return nil, fmt.Errorf("MinShards is %d, but there are only %d shards", cfg.MinShards, len(cfg.Addrs))
+	}
+	opts := cfg.DialOptions
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	opts = append([]grpc.DialOption{grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMessageSize))}, opts...)
+
+	c := &Cluster{
+		cfg:  cfg,
+		need: cfg.MinShards,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if c.need <= 0 {
+		c.need = len(cfg.Addrs)
+	}
+	for _, addr := range cfg.Addrs {
+		conn, err := grpc.NewClient(addr, opts...)
+		if err != nil {
+			c.closeConns()
+			return nil, fmt.Errorf("shard %s: %w", addr, err)
+		}
+		c.shards = append(c.shards, &shard{
+			addr:   addr,
+			conn:   conn,
+			client: gopdqpb.NewIndexShardClient(conn),
+			health: healthpb.NewHealthClient(conn),
+		})
+	}
+	c.checkHealth()
+	go c.healthLoop()
+	return c, nil
+}
+
+// Close stops the health checks and closes the connections
+func (c *Cluster) Close() error {
+	close(c.stop)
+	<-c.done
+	return c.closeConns()
+}
+
+func (c *Cluster) closeConns() error {
+	var errs []error
+	for _, s := range c.shards {
+		errs = append(errs, s.conn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Cluster) healthLoop() {
+	defer close(c.done)
+	t := time.NewTicker(c.cfg.HealthInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-t.C:
+			c.checkHealth()
+		}
+	}
+}
+
+// checkHealth checks every shard at once
+func (c *Cluster) checkHealth() {
+	var wg sync.WaitGroup
+	for _, s := range c.shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), c.cfg.Timeout)
+			defer cancel()
+			resp, err := s.health.Check(ctx, &healthpb.HealthCheckRequest{})
+			if err == nil && resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+				err = fmt.Errorf("health check: %s", resp.GetStatus())
+			}
+			c.setHealth(s, err)
+		}()
+	}
+	wg.Wait()
+}
+
+// setHealth records the outcome of a health check or failed query
+func (c *Cluster) setHealth(s *shard, err error) {
+	s.mu.Lock()
+	s.err, s.checked = err, time.Now()
+	s.mu.Unlock()
+	if was := s.healthy.Swap(err == nil); was != (err == nil) {
+		if err == nil {
+			c.cfg.Logger.Info("shard is healthy", "addr", s.addr)
+		} else {
+			c.cfg.Logger.Warn("shard is unhealthy", "addr", s.addr, "err", err)
+		}
+	}
+}
+
+// Health returns the status of each shard, in shard order
+func (c *Cluster) Health() []ShardStatus {
+	out := make([]ShardStatus, len(c.shards))
+	for i, s := range c.shards {
+		s.mu.Lock()
+		out[i] = ShardStatus{Addr: s.addr, Healthy: s.healthy.Load(), Err: s.err, Checked: s.checked}
+		s.mu.Unlock()
+	}
+	return out
+}
+
+// Insert returns ErrReadOnly
+func (c *Cluster) Insert(t *gopdq.TaggedHash) error {
+	return ErrReadOnly
+}
+
+// Query queries every healthy shard, as QueryContext does. Shards that fail
+// when enough others answer are logged rather than returned.
+func (c *Cluster) Query(h *gopdq.PdqHash256, maxDistance int) ([]index.Match, error) {
+	res, err := c.QueryContext(context.Background(), h, maxDistance)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range res.Failed {
+		c.cfg.Logger.Warn("query answered without a shard", "shard", f.Shard, "addr", f.Addr, "err", f.Err)
+	}
+	return res.Matches, nil
+}
+
+// QueryContext queries every healthy shard at once and merges their
+// matches. Unhealthy shards fail with ErrShardUnhealthy without being
+// sent the query, so a shard that is down costs a query nothing until a
+// health check finds it back up. If fewer than MinShards answer it returns
+// an error wrapping ErrTooFewShards and each shard's error; otherwise the
+// shards that didn't answer are listed in Result.Failed.
+func (c *Cluster) QueryContext(ctx context.Context, h *gopdq.PdqHash256, maxDistance int) (*Result, error) {
+	if maxDistance < 0 {
+		return &Result{}, nil
+	}
+	req := &gopdqpb.QueryRequest{Hash: gopdqpb.FromHash(h), MaxDistance: int32(min(maxDistance, 256))}
+
+	responses := make([]*gopdqpb.QueryResponse, len(c.shards))
+	errs := make([]error, len(c.shards))
+	var wg sync.WaitGroup
+	for i, s := range c.shards {
+		if !s.healthy.Load() {
+			errs[i] = ErrShardUnhealthy
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			qctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+			defer cancel()
+			responses[i], errs[i] = s.client.Query(qctx, req)
+			// a shard that is down or too slow sits out queries until it
+			// passes a health check, unless the caller gave up first
+			if code := status.Code(errs[i]); (code == codes.Unavailable || code == codes.DeadlineExceeded) && ctx.Err() == nil {
+				c.setHealth(s, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	res := &Result{}
+	n := uint64(len(c.shards))
+	for i, resp := range responses {
+		err := errs[i]
+		if err == nil {
+			err = appendMatches(&res.Matches, resp, uint64(i), n)
+		}
+		if err != nil {
+			res.Failed = append(res.Failed, &ShardError{Shard: i, Addr: c.shards[i].addr, Err: err})
+		}
+	}
+	if answered := len(c.shards) - len(res.Failed); answered < c.need {
+		errs := make([]error, len(res.Failed))
+		for i, f := range res.Failed {
+			errs[i] = f
+		}
+		return nil, fmt.Errorf("%w: %d of %d, %d needed: %w", ErrTooFewShards, answered, len(c.shards), c.need, errors.Join(errs...))
+	}
+	slices.SortFunc(res.Matches, func(a, b index.Match) int {
+		return cmp.Or(cmp.Compare(a.Distance, b.Distance), cmp.Compare(a.ID, b.ID))
+	})
+	return res, nil
+}
+
+// appendMatches converts the matches of shard i of n to global ids. It
+// appends none of them if any fails to convert, so a shard counted as
+// failed contributes no matches.
+func appendMatches(out *[]index.Match, resp *gopdqpb.QueryResponse, i, n uint64) error {
+	ms := make([]index.Match, 0, len(resp.GetMatches()))
+	for _, m := range resp.GetMatches() {
+		t, err := m.GetEntry().ToTaggedHash()
+		if err != nil {
+			return fmt.Errorf("match %d: %w", m.GetId(), err)
+		}
+		ms = append(ms, index.Match{ID: m.GetId()*n + i, Entry: t, Distance: int(m.GetDistance())})
+	}
+	*out = append(*out, ms...)
+	return nil
+}
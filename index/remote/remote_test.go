@@ -0,0 +1,181 @@
+package remote_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/whyrusleeping/gopdq/index"
+	"github.com/whyrusleeping/gopdq/index/indextest"
+	"github.com/whyrusleeping/gopdq/index/remote"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testShards runs shard servers over in-memory listeners
+type testShards struct {
+	listeners map[string]*bufconn.Listener
+	servers   []*grpc.Server
+	matchers  []*atomic.Pointer[index.Matcher]
+}
+
+func (ts *testShards) dialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return ts.listeners[addr].DialContext(ctx)
+		}),
+	}
+}
+
+// startShards deals corpus out round robin to n shards served as
+// passthrough:///shard-i
+func startShards(t *testing.T, n int) ([]string, *testShards) {
+	t.Helper()
+	ts := &testShards{listeners: make(map[string]*bufconn.Listener)}
+	var addrs []string
+	for i := 0; i < n; i++ {
+		addr := fmt.Sprintf("shard-%d", i)
+		lis := bufconn.Listen(1 << 20)
+		ts.listeners[addr] = lis
+
+		var m atomic.Pointer[index.Matcher]
+		flat := index.Matcher(index.NewFlat())
+		m.Store(&flat)
+		ts.matchers = append(ts.matchers, &m)
+
+		gs := grpc.NewServer()
+		remote.NewServer(remote.ServerConfig{
+			Matcher: func() index.Matcher {
+				if p := m.Load(); p != nil {
+					return *p
+				}
+				return nil
+			},
+			MaxDistance: 64,
+		}).Register(gs)
+		go gs.Serve(lis)
+		t.Cleanup(gs.Stop)
+		ts.servers = append(ts.servers, gs)
+		addrs = append(addrs, "passthrough:///"+addr)
+	}
+	return addrs, ts
+}
+
+func TestCluster(t *testing.T) {
+	addrs, ts := startShards(t, 3)
+	corpus := indextest.Corpus(t, 200)
+	flat := index.NewFlat()
+	for i, e := range corpus {
+		if err := (*ts.matchers[i%3].Load()).Insert(e); err != nil {
+			t.Fatal(err)
+		}
+		flat.Insert(e)
+	}
+
+	c, err := remote.Dial(remote.ClusterConfig{Addrs: addrs, DialOptions: ts.dialOptions()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, st := range c.Health() {
+		if !st.Healthy {
+			t.Fatalf("%s is unhealthy: %v", st.Addr, st.Err)
+		}
+	}
+
+	indextest.CheckQueries(t, c, corpus)
+	// dealt out round robin, the shards keep the ids of one matcher
+	for _, qi := range []int{0, 17, 150} {
+		want, _ := flat.Query(corpus[qi].Hash, 31)
+		got, err := c.Query(corpus[qi].Hash, 31)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%d matches, flat found %d", len(got), len(want))
+		}
+		for i := range got {
+			if got[i].ID != want[i].ID || got[i].Distance != want[i].Distance || got[i].Entry.ID != want[i].Entry.ID {
+				t.Fatalf("match %d is %+v, flat found %+v", i, got[i], want[i])
+			}
+		}
+	}
+
+	if err := c.Insert(corpus[0]); !errors.Is(err, remote.ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+}
+
+func TestClusterPartial(t *testing.T) {
+	addrs, ts := startShards(t, 3)
+	corpus := indextest.Corpus(t, 90)
+	for i, e := range corpus {
+		(*ts.matchers[i%3].Load()).Insert(e)
+	}
+	ts.servers[1].Stop()
+
+	all, err := remote.Dial(remote.ClusterConfig{Addrs: addrs, DialOptions: ts.dialOptions(), Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer all.Close()
+	if st := all.Health(); st[1].Healthy || !st[0].Healthy || !st[2].Healthy {
+		t.Fatalf("expected only shard 1 to be unhealthy: %+v", st)
+	}
+	if _, err := all.Query(corpus[0].Hash, 31); !errors.Is(err, remote.ErrTooFewShards) || !errors.Is(err, remote.ErrShardUnhealthy) {
+		t.Fatalf("expected ErrTooFewShards for an unhealthy shard, got %v", err)
+	}
+
+	two, err := remote.Dial(remote.ClusterConfig{Addrs: addrs, DialOptions: ts.dialOptions(), MinShards: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer two.Close()
+	res, err := two.QueryContext(context.Background(), corpus[0].Hash, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Failed) != 1 || res.Failed[0].Shard != 1 {
+		t.Fatalf("expected shard 1 to fail, got %v", res.Failed)
+	}
+	want := 0
+	for i, e := range corpus {
+		if i%3 != 1 && corpus[0].Hash.HammingDistance(e.Hash) <= 64 {
+			want++
+		}
+	}
+	if len(res.Matches) != want {
+		t.Fatalf("%d matches from the two shards up, expected %d", len(res.Matches), want)
+	}
+	for _, m := range res.Matches {
+		if m.ID%3 == 1 {
+			t.Fatalf("match %+v from the failed shard", m)
+		}
+	}
+
+	// a shard with no index loaded fails its health checks until it has one
+	ts.matchers[2].Store(nil)
+	loading, err := remote.Dial(remote.ClusterConfig{Addrs: addrs, DialOptions: ts.dialOptions(), MinShards: 1, HealthInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loading.Close()
+	if loading.Health()[2].Healthy {
+		t.Fatal("expected a shard without an index to be unhealthy")
+	}
+	flat := index.Matcher(index.NewFlat())
+	ts.matchers[2].Store(&flat)
+	deadline := time.Now().Add(5 * time.Second)
+	for !loading.Health()[2].Healthy {
+		if time.Now().After(deadline) {
+			t.Fatalf("shard still unhealthy after loading an index: %v", loading.Health()[2].Err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
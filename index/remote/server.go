@@ -0,0 +1,88 @@
+// Package remote splits a corpus too large for one machine across shards
+// queried over gRPC. Each shard machine serves its part of the corpus with
+// a Server, and a Cluster queries all the shards at once, merging their
+// matches into the answer one matcher over the whole corpus would give.
+//
+// The protocol is the IndexShard service of gopdqpb, alongside the
+// standard grpc.health.v1.Health service, which a Cluster polls to stop
+// sending queries to shards that are down or have no index loaded.
+package remote
+
+import (
+	"context"
+
+	"github.com/whyrusleeping/gopdq/gopdqpb"
+	"github.com/whyrusleeping/gopdq/index"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// ServerConfig controls a shard server
+type ServerConfig struct {
+	// Matcher returns the matcher to answer queries from, nil while none is
+	// loaded, so a server can follow reloads such as server.Server's
+	Matcher func() index.Matcher
+	// MaxDistance is the largest radius a query may ask for; defaults to 31
+	MaxDistance int
+}
+
+// Server serves a matcher as one shard of a distributed index
+type Server struct {
+	gopdqpb.UnimplementedIndexShardServer
+	healthpb.UnimplementedHealthServer
+
+	cfg ServerConfig
+}
+
+// NewServer creates a shard server
+func NewServer(cfg ServerConfig) *Server {
+	if cfg.MaxDistance <= 0 {
+		cfg.MaxDistance = 31
+	}
+	return &Server{cfg: cfg}
+}
+
+// Register registers the IndexShard and Health services with gs
+func (s *Server) Register(gs *grpc.Server) {
+	gopdqpb.RegisterIndexShardServer(gs, s)
+	healthpb.RegisterHealthServer(gs, s)
+}
+
+func (s *Server) Query(ctx context.Context, req *gopdqpb.QueryRequest) (*gopdqpb.QueryResponse, error) {
+	m := s.cfg.Matcher()
+	if m == nil {
+		return nil, status.Error(codes.Unavailable, "no index is loaded")
+	}
+	h, err := req.GetHash().ToHash()
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if d := req.GetMaxDistance(); d < 0 || int(d) > s.cfg.MaxDistance {
+		return nil, status.Errorf(codes.InvalidArgument, "max_distance must be between 0 and %d", s.cfg.MaxDistance)
+	}
+
+	matches, err := m.Query(h, int(req.GetMaxDistance()))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &gopdqpb.QueryResponse{Matches: make([]*gopdqpb.IndexMatch, len(matches))}
+	for i, m := range matches {
+		resp.Matches[i] = &gopdqpb.IndexMatch{
+			Id:       m.ID,
+			Distance: int32(m.Distance),
+			Entry:    gopdqpb.FromTaggedHash(m.Entry),
+		}
+	}
+	return resp, nil
+}
+
+// Check reports SERVING while a matcher is loaded, for any service name
+func (s *Server) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	st := healthpb.HealthCheckResponse_SERVING
+	if s.cfg.Matcher() == nil {
+		st = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
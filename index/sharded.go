@@ -0,0 +1,94 @@
+package index
+
+import (
+	"sync"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+var _ Matcher = (*Sharded)(nil)
+
+// Sharded partitions entries round robin across sub-matchers and queries
+// them all in parallel, merging their matches. Each shard holds a fraction
+// of the corpus behind its own lock, so a single query runs on as many
+// cores as there are shards, and inserts into one shard don't hold up
+// queries of the others.
+//
+// Entries are numbered in insertion order from 0, as with the other
+// matchers, which requires each shard to number its own entries that way;
+// the matchers NewMatcher creates do.
+type Sharded struct {
+	shards []Matcher
+
+	// mu serializes inserts, so the n'th entry lands in shard n%len(shards)
+	mu sync.Mutex
+	n  uint64
+}
+
+// NewSharded creates a matcher over n shards made by newShard
+func NewSharded(n int, newShard func() Matcher) *Sharded {
+	s := &Sharded{shards: make([]Matcher, max(n, 1))}
+	for i := range s.shards {
+		s.shards[i] = newShard()
+	}
+	return s
+}
+
+// Shards returns the number of shards
+func (s *Sharded) Shards() int {
+	return len(s.shards)
+}
+
+// Insert adds t to the next shard in turn
+func (s *Sharded) Insert(t *gopdq.TaggedHash) error {
+	if t.Hash == nil {
+		return errNoHash
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.shards[s.n%uint64(len(s.shards))].Insert(t); err != nil {
+		return err
+	}
+	s.n++
+	return nil
+}
+
+// Query queries every shard at once, the first on the calling goroutine,
+// and returns their matches renumbered as global ids, sorted by distance
+// and then id. It fails if any shard does.
+func (s *Sharded) Query(h *gopdq.PdqHash256, maxDistance int) ([]Match, error) {
+	if maxDistance < 0 {
+		return nil, nil
+	}
+	results := make([][]Match, len(s.shards))
+	errs := make([]error, len(s.shards))
+	var wg sync.WaitGroup
+	for i, shard := range s.shards[1:] {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i+1], errs[i+1] = shard.Query(h, maxDistance)
+		}()
+	}
+	results[0], errs[0] = s.shards[0].Query(h, maxDistance)
+	wg.Wait()
+
+	n := 0
+	for i, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+		n += len(results[i])
+	}
+	// shard i's local id l is global id l*shards + i
+	out := make([]Match, 0, n)
+	shards := uint64(len(s.shards))
+	for i, ms := range results {
+		for _, m := range ms {
+			m.ID = m.ID*shards + uint64(i)
+			out = append(out, m)
+		}
+	}
+	sortMatches(out)
+	return out, nil
+}
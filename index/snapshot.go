@@ -0,0 +1,119 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// ErrReadOnly is returned by inserts into and deletes from a snapshot
+var ErrReadOnly = errors.New("snapshot is read only")
+
+// Snapshotter is implemented by matchers that can take a snapshot: a
+// read-only matcher over the entries as they are at that moment, unchanged
+// by inserts and deletes that follow. A server can keep answering from one
+// snapshot while a large update goes into the matcher, then swap in a
+// snapshot of the result, so queries neither wait for the update nor see
+// it half done.
+//
+// Snapshots are copy on write. Flat, and an Index over a MemStore, share
+// their memory with snapshots; an Index copies its buckets at most once per
+// snapshot, on the first change after it. Sharded snapshots each shard.
+// BKTree and HNSW change entries in place as others are inserted, so they
+// don't support snapshots.
+type Snapshotter interface {
+	Snapshot() (Matcher, error)
+}
+
+var (
+	_ Snapshotter = (*Flat)(nil)
+	_ Snapshotter = (*Index)(nil)
+	_ Snapshotter = (*Sharded)(nil)
+)
+
+// Snapshot returns a read-only copy of f as it is now. It takes constant
+// time, as f only ever appends.
+func (f *Flat) Snapshot() (Matcher, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	snap := &Flat{entries: slices.Clip(f.entries), readOnly: true}
+	if f.prefilter != nil {
+		snap.prefilter = &prefilterState{
+			cfg:     f.prefilter.cfg,
+			samples: slices.Clip(f.prefilter.samples),
+			bits:    slices.Clip(f.prefilter.bits),
+		}
+	} else {
+		snap.packed = f.packed.Snapshot()
+	}
+	return snap, nil
+}
+
+// Snapshot returns a read-only index over a snapshot of its store, which
+// must implement Snapshot() (Store, error) as MemStore does
+func (ix *Index) Snapshot() (Matcher, error) {
+	s, ok := ix.store.(interface{ Snapshot() (Store, error) })
+	if !ok {
+		return nil, fmt.Errorf("%T doesn't support snapshots", ix.store)
+	}
+	snap, err := s.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return New(snap), nil
+}
+
+// Snapshot returns a read-only copy of s as it is now. Entries and bucket
+// contents are shared with s; the bucket maps are too, until s is next
+// changed and copies them.
+func (s *MemStore) Snapshot() (Store, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.shared, s.sharedEntries = true, true
+	return &MemStore{
+		entries:  slices.Clip(s.entries),
+		deleted:  s.deleted,
+		buckets:  s.buckets,
+		readOnly: true,
+	}, nil
+}
+
+// unshare copies what a snapshot shares with s before s changes it: the
+// bucket maps, and the entries too for a delete, which clears one rather
+// than appending. Bucket slices themselves are only appended to or, on
+// delete, replaced, so they are never copied.
+func (s *MemStore) unshare(entries bool) {
+	if s.shared {
+		for i, b := range s.buckets {
+			s.buckets[i] = maps.Clone(b)
+		}
+		s.shared = false
+	}
+	if entries && s.sharedEntries {
+		s.entries = slices.Clone(s.entries)
+		s.sharedEntries = false
+	}
+}
+
+// Snapshot snapshots every shard, between inserts, so the snapshot numbers
+// entries as s does. Every shard must be a Snapshotter.
+func (s *Sharded) Snapshot() (Matcher, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := &Sharded{shards: make([]Matcher, len(s.shards)), n: s.n}
+	for i, shard := range s.shards {
+		ss, ok := shard.(Snapshotter)
+		if !ok {
+			return nil, fmt.Errorf("shard %d: %T doesn't support snapshots", i, shard)
+		}
+		var err error
+		if snap.shards[i], err = ss.Snapshot(); err != nil {
+			return nil, fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+	return snap, nil
+}
@@ -0,0 +1,321 @@
+// Package sqlitestore persists an index in a local SQLite database, giving
+// small deployments durability and restart without an external database.
+//
+// The database runs in WAL mode so queries proceed while entries are being
+// added. Only bucket lookups and entry fetches go to SQLite; candidate
+// distances are still verified by the index in Go.
+package sqlitestore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/whyrusleeping/gopdq"
+	"github.com/whyrusleeping/gopdq/index"
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS entries (
+	id INTEGER PRIMARY KEY,
+	hash BLOB NOT NULL,
+	quality INTEGER NOT NULL,
+	ext_id TEXT NOT NULL,
+	source TEXT NOT NULL,
+	ts INTEGER,
+	labels TEXT,
+	expires INTEGER,
+	payload TEXT
+);
+CREATE TABLE IF NOT EXISTS buckets (
+	seg INTEGER NOT NULL,
+	word INTEGER NOT NULL,
+	id INTEGER NOT NULL,
+	PRIMARY KEY (seg, word, id)
+) WITHOUT ROWID;
+`
+
+// Store is an index.Store backed by a SQLite file
+type Store struct {
+	db *sql.DB
+
+	get    *sql.Stmt
+	bucket *sql.Stmt
+}
+
+var (
+	_ index.Store   = (*Store)(nil)
+	_ index.Scanner = (*Store)(nil)
+	_ index.Deleter = (*Store)(nil)
+
+	_ index.ExpiredLister = (*Store)(nil)
+)
+
+// Open opens or creates the database at path. Entries already in it are
+// immediately searchable.
+func Open(path string) (*Store, error) {
+	q := url.Values{}
+	q.Add("_pragma", "journal_mode(WAL)")
+	q.Add("_pragma", "synchronous(NORMAL)")
+	q.Add("_pragma", "busy_timeout(5000)")
+	db, err := sql.Open("sqlite", "file:"+path+"?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating schema in %s: %w", path, err)
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating %s: %w", path, err)
+	}
+
+	s := &Store{db: db}
+	if s.get, err = db.Prepare(`SELECT hash, quality, ext_id, source, ts, labels, expires, payload FROM entries WHERE id = ?`); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if s.bucket, err = db.Prepare(`SELECT id FROM buckets WHERE seg = ? AND word = ?`); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate adds the columns of newer schemas to databases created before
+// them
+func migrate(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('entries')`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	have := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		have[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, col := range []struct{ name, decl string }{
+		{"expires", "INTEGER"},
+		{"payload", "TEXT"},
+	} {
+		if have[col.name] {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE entries ADD COLUMN ` + col.name + ` ` + col.decl); err != nil {
+			return err
+		}
+	}
+	// Expired looks entries up by expiry, which most don't have
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS entries_expires ON entries (expires) WHERE expires IS NOT NULL`)
+	return err
+}
+
+// nullTime stores a zero time as NULL and others as Unix nanoseconds
+func nullTime(t time.Time) sql.NullInt64 {
+	if t.IsZero() {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.UnixNano(), Valid: true}
+}
+
+func (s *Store) Put(t *gopdq.TaggedHash) (uint64, error) {
+	var labels sql.NullString
+	if len(t.Labels) > 0 {
+		b, err := json.Marshal(t.Labels)
+		if err != nil {
+			return 0, err
+		}
+		labels = sql.NullString{String: string(b), Valid: true}
+	}
+	payload := sql.NullString{String: string(t.Payload), Valid: len(t.Payload) > 0}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO entries (hash, quality, ext_id, source, ts, labels, expires, payload) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.Hash.Bytes(), t.Quality, t.ID, t.Source, nullTime(t.Timestamp), labels, nullTime(t.Expires), payload)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	ins, err := tx.Prepare(`INSERT INTO buckets (seg, word, id) VALUES (?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer ins.Close()
+	for seg, w := range index.Words(t.Hash) {
+		if _, err := ins.Exec(seg, w, id); err != nil {
+			return 0, err
+		}
+	}
+
+	return uint64(id), tx.Commit()
+}
+
+func (s *Store) Get(id uint64) (*gopdq.TaggedHash, error) {
+	t, err := scanEntry(s.get.QueryRow(int64(id)).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, index.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("entry %d: %w", id, err)
+	}
+	return t, nil
+}
+
+// scanEntry reads an entry from a row of the columns selected by s.get,
+// preceded by any columns to be scanned into lead
+func scanEntry(scan func(dest ...any) error, lead ...any) (*gopdq.TaggedHash, error) {
+	var (
+		hash    []byte
+		t       gopdq.TaggedHash
+		ts      sql.NullInt64
+		labels  sql.NullString
+		expires sql.NullInt64
+		payload sql.NullString
+	)
+	if err := scan(append(lead, &hash, &t.Quality, &t.ID, &t.Source, &ts, &labels, &expires, &payload)...); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if t.Hash, err = gopdq.FromBytes(hash); err != nil {
+		return nil, err
+	}
+	if ts.Valid {
+		t.Timestamp = time.Unix(0, ts.Int64).UTC()
+	}
+	if expires.Valid {
+		t.Expires = time.Unix(0, expires.Int64).UTC()
+	}
+	if labels.Valid {
+		if err := json.Unmarshal([]byte(labels.String), &t.Labels); err != nil {
+			return nil, err
+		}
+	}
+	if payload.Valid {
+		t.Payload = json.RawMessage(payload.String)
+	}
+	return &t, nil
+}
+
+func (s *Store) Scan(fn func(id uint64, t *gopdq.TaggedHash) error) error {
+	rows, err := s.db.Query(`SELECT id, hash, quality, ext_id, source, ts, labels, expires, payload FROM entries ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		t, err := scanEntry(rows.Scan, &id)
+		if err != nil {
+			return fmt.Errorf("entry %d: %w", id, err)
+		}
+		if err := fn(uint64(id), t); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Delete removes the entry and its bucket rows. SQLite reuses the freed
+// pages; VACUUM or a rewrite shrinks the file.
+// Expired returns the ids of the entries expired as of now through an
+// index on their expiry, without reading the others
+func (s *Store) Expired(now time.Time) ([]uint64, error) {
+	rows, err := s.db.Query(`SELECT id FROM entries WHERE expires IS NOT NULL AND expires <= ? ORDER BY id`, now.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []uint64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, uint64(id))
+	}
+	return ids, rows.Err()
+}
+
+func (s *Store) Delete(id uint64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var hash []byte
+	err = tx.QueryRow(`SELECT hash FROM entries WHERE id = ?`, int64(id)).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return index.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	h, err := gopdq.FromBytes(hash)
+	if err != nil {
+		return fmt.Errorf("entry %d: %w", id, err)
+	}
+
+	for seg, w := range index.Words(h) {
+		if _, err := tx.Exec(`DELETE FROM buckets WHERE seg = ? AND word = ? AND id = ?`, seg, w, int64(id)); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM entries WHERE id = ?`, int64(id)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) Bucket(seg int, w uint16) ([]uint64, error) {
+	rows, err := s.bucket.Query(seg, w)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uint64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, uint64(id))
+	}
+	return ids, rows.Err()
+}
+
+func (s *Store) Len() (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM entries`).Scan(&n)
+	return n, err
+}
+
+func (s *Store) Close() error {
+	s.get.Close()
+	s.bucket.Close()
+	return s.db.Close()
+}
@@ -0,0 +1,87 @@
+package sqlitestore
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/whyrusleeping/gopdq/index"
+	"github.com/whyrusleeping/gopdq/index/indextest"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indextest.TestStore(t, s)
+
+	var mode string
+	if err := s.db.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil || mode != "wal" {
+		t.Fatalf("journal mode %q, %v; expected wal", mode, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// everything added before closing is back after reopening
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ix := index.New(s)
+	indextest.CheckQueries(t, ix, indextest.Corpus(t, 300))
+}
+
+func TestMaintenance(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	indextest.TestMaintenance(t, s)
+}
+
+func TestExpiry(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	indextest.TestExpiry(t, s)
+}
+
+// TestMigrate opens a database created before entries had an expiry or a
+// payload
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE entries (
+		id INTEGER PRIMARY KEY, hash BLOB NOT NULL, quality INTEGER NOT NULL,
+		ext_id TEXT NOT NULL, source TEXT NOT NULL, ts INTEGER, labels TEXT)`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	indextest.TestExpiry(t, s)
+
+	e := indextest.Corpus(t, 1)[0]
+	id, err := s.Put(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Get(id); err != nil || string(got.Payload) != string(e.Payload) {
+		t.Fatalf("Get = %+v, %v, expected payload %s", got, err, e.Payload)
+	}
+}
@@ -0,0 +1,91 @@
+package gopdq
+
+// jpegIsProgressive walks the marker segments up to the first frame header
+// and reports whether it is a progressive one
+func jpegIsProgressive(data []byte) bool {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return false
+	}
+
+	for i := 2; i+1 < len(data); {
+		if data[i] != 0xff {
+			return false
+		}
+		marker := data[i+1]
+		i += 2
+
+		switch {
+		case marker == 0xff:
+			// fill byte
+			i--
+			continue
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			// standalone markers carry no length
+			continue
+		case marker == 0xda:
+			// start of scan without a frame header
+			return false
+		case marker >= 0xc0 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc:
+			// SOF2, SOF6, SOF10 and SOF14 are the progressive variants
+			return marker&0x03 == 0x02
+		}
+
+		if i+1 >= len(data) {
+			return false
+		}
+		i += int(data[i])<<8 | int(data[i+1])
+	}
+	return false
+}
+
+// jpegIsTruncated reports whether the data ends before the EOI marker. It
+// walks the marker segments by their lengths, so a thumbnail embedded in an
+// APP segment, with its own scan and EOI, is skipped over, and scans the
+// entropy coded data after each scan header for the next marker.
+func jpegIsTruncated(data []byte) bool {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return false
+	}
+
+	for i := 2; ; {
+		if i+1 >= len(data) {
+			return true
+		}
+		if data[i] != 0xff {
+			// garbage between segments: let the decoder judge it
+			return false
+		}
+		marker := data[i+1]
+		i += 2
+
+		switch {
+		case marker == 0xff:
+			// fill byte
+			i--
+			continue
+		case marker == 0xd9:
+			return false
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			continue
+		}
+
+		if i+1 >= len(data) {
+			return true
+		}
+		i += int(data[i])<<8 | int(data[i+1])
+		if marker != 0xda {
+			continue
+		}
+
+		// entropy coded data runs to the next marker other than a stuffed
+		// zero or a restart
+		for ; i+1 < len(data); i++ {
+			if data[i] != 0xff {
+				continue
+			}
+			if next := data[i+1]; next != 0x00 && next != 0xff && (next < 0xd0 || next > 0xd7) {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+//go:build cgo
+
+package gopdq
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+
+	ljpeg "github.com/pixiv/go-libjpeg/jpeg"
+)
+
+// decoderOptions are the libjpeg settings for baseline JPEGs. DCTIFast is
+// accurate enough for hashing and noticeably faster.
+var decoderOptions = &ljpeg.DecoderOptions{
+	DCTMethod:              ljpeg.DCTIFast,
+	DisableFancyUpsampling: false,
+}
+
+// progressiveDecoderOptions are used for progressive JPEGs, where the fast
+// IDCT's rounding error drifts further from the reference decoders
+var progressiveDecoderOptions = &ljpeg.DecoderOptions{
+	DCTMethod:              ljpeg.DCTISlow,
+	DisableFancyUpsampling: false,
+}
+
+// canScaleJpeg reports whether decodeJpeg honours maxDim
+const canScaleJpeg = true
+
+// DecodeJpeg decodes a JPEG with libjpeg, which is considerably faster than
+// the standard library decoder. Grayscale JPEGs decode to an *image.Gray that
+// the hasher reads directly. libjpeg can't convert CMYK or YCCK to RGB, so
+// those go through the standard library decoder, which also handles Adobe's
+// inverted CMYK.
+func DecodeJpeg(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJpeg(data, 0)
+}
+
+// decodeJpeg decodes data, scaling it down while its longer side stays at
+// least maxDim if maxDim is positive
+func decodeJpeg(data []byte, maxDim int) (image.Image, error) {
+	opts := decoderOptions
+	if jpegIsProgressive(data) {
+		opts = progressiveDecoderOptions
+	}
+
+	var img image.Image
+	cfg, cerr := jpeg.DecodeConfig(bytes.NewReader(data))
+	if cerr == nil && maxDim > 0 {
+		if target, ok := jpegScaleTarget(cfg.Width, cfg.Height, maxDim); ok {
+			scaled := *opts
+			scaled.ScaleTarget = target
+			opts = &scaled
+		}
+	}
+
+	var err error
+	switch {
+	case cerr == nil && cfg.ColorModel == color.CMYKModel:
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case cerr == nil && cfg.ColorModel == color.GrayModel:
+		img, err = ljpeg.Decode(bytes.NewReader(data), opts)
+	case ljpeg.SupportRGBA():
+		img, err = ljpeg.DecodeIntoRGBA(bytes.NewReader(data), opts)
+	default:
+		img, err = ljpeg.Decode(bytes.NewReader(data), opts)
+	}
+	if err != nil {
+		return nil, decodeError(err)
+	}
+
+	return img, nil
+}
+
+// jpegScaleTarget returns the size to ask libjpeg for so a width x height
+// image comes out with its longer side no shorter than maxDim. libjpeg scales
+// in eighths and picks the smallest scale covering the target.
+func jpegScaleTarget(width, height, maxDim int) (image.Rectangle, bool) {
+	long := max(width, height)
+	if long <= maxDim {
+		return image.Rectangle{}, false
+	}
+	tw := (width*maxDim + long - 1) / long
+	th := (height*maxDim + long - 1) / long
+	return image.Rect(0, 0, tw, th), true
+}
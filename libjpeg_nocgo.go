@@ -0,0 +1,29 @@
+//go:build !cgo
+
+package gopdq
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+)
+
+// canScaleJpeg reports whether decodeJpeg honours maxDim
+const canScaleJpeg = false
+
+// DecodeJpeg decodes a JPEG. Without cgo libjpeg is unavailable, so this
+// falls back to the standard library decoder.
+func DecodeJpeg(r io.Reader) (image.Image, error) {
+	img, err := jpeg.Decode(r)
+	if err != nil {
+		return nil, decodeError(err)
+	}
+	return img, nil
+}
+
+// decodeJpeg decodes data at full size, as the standard library decoder
+// can't scale
+func decodeJpeg(data []byte, maxDim int) (image.Image, error) {
+	return DecodeJpeg(bytes.NewReader(data))
+}
@@ -0,0 +1,117 @@
+//go:build cgo
+
+package gopdq
+
+import (
+	"bytes"
+	"errors"
+	"image/jpeg"
+	"os"
+	"testing"
+)
+
+func TestTruncated(t *testing.T) {
+	data, err := os.ReadFile("cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cut := data[:len(data)*6/10]
+
+	strict := NewPdqHasher()
+	if _, err := strict.FromReader(bytes.NewReader(cut)); !errors.Is(err, ErrDecodeFailed) {
+		t.Fatalf("FromReader: expected ErrDecodeFailed, got %v", err)
+	}
+	if _, err := strict.FromJpeg(bytes.NewReader(cut)); !errors.Is(err, ErrDecodeFailed) {
+		t.Fatalf("FromJpeg: expected ErrDecodeFailed, got %v", err)
+	}
+
+	lenient := NewPdqHasher(WithTruncated())
+	full, err := lenient.FromReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.Degraded {
+		t.Fatal("complete image marked degraded")
+	}
+
+	for name, hash := range map[string]func() (*HashResult, error){
+		"FromReader": func() (*HashResult, error) { return lenient.FromReader(bytes.NewReader(cut)) },
+		"FromJpeg":   func() (*HashResult, error) { return lenient.FromJpeg(bytes.NewReader(cut)) },
+	} {
+		res, err := hash()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !res.Degraded {
+			t.Errorf("%s: truncated image not marked degraded", name)
+		}
+		if d := res.Hash.HammingDistance(full.Hash); d > 16 {
+			t.Errorf("%s: truncated hash is %d bits from the full one", name, d)
+		}
+	}
+}
+
+// largeJpeg encodes a 3000x2000 test pattern
+func largeJpeg(tb testing.TB) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testPattern(3000, 2000), &jpeg.Options{Quality: 90}); err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestScaledJpeg(t *testing.T) {
+	data := largeJpeg(t)
+
+	img, err := decodeJpeg(data, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() < 512 || b.Dx() > 1024 || b.Dy() != b.Dx()*2/3 {
+		t.Fatalf("scaled decode is %v", b)
+	}
+	if img, err := decodeJpeg(data, 4000); err != nil || img.Bounds().Dx() != 3000 {
+		t.Fatalf("image below the limit was scaled: %v %v", img.Bounds(), err)
+	}
+
+	// the cat is 1200x675, so this decodes at half size
+	cat, err := os.ReadFile("cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := NewPdqHasher().FromJpeg(bytes.NewReader(cat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scaled := NewPdqHasher(WithMaxDimension(512))
+	for name, hash := range map[string]func() (*HashResult, error){
+		"FromReader": func() (*HashResult, error) { return scaled.FromReader(bytes.NewReader(cat)) },
+		"FromJpeg":   func() (*HashResult, error) { return scaled.FromJpeg(bytes.NewReader(cat)) },
+	} {
+		res, err := hash()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if d := res.Hash.HammingDistance(full.Hash); d > 8 {
+			t.Errorf("%s: scaled hash is %d bits from the full size one", name, d)
+		}
+	}
+}
+
+func BenchmarkScaledJpeg(b *testing.B) {
+	data := largeJpeg(b)
+	for _, c := range []struct {
+		name   string
+		maxDim int
+	}{{"full", 0}, {"512", 512}} {
+		hasher := NewPdqHasher(WithMaxDimension(c.maxDim))
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := hasher.FromReader(bytes.NewReader(data)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package gopdq
+
+import (
+	"context"
+	"image"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Limits bounds the resources used by bulk hashing. Zero values mean unlimited.
+type Limits struct {
+	// MaxInFlight is the maximum number of images being decoded and hashed at once
+	MaxInFlight int
+	// MaxBytes is the maximum estimated memory held by in-flight decodes
+	MaxBytes int64
+	// MaxPerSecond is the maximum number of images started per second
+	MaxPerSecond float64
+}
+
+// Limiter enforces Limits across concurrent callers
+type Limiter struct {
+	limits Limits
+
+	lk       sync.Mutex
+	cond     *sync.Cond
+	inFlight int
+	bytes    int64
+	next     time.Time
+}
+
+// NewLimiter creates a Limiter enforcing l
+func NewLimiter(l Limits) *Limiter {
+	lim := &Limiter{limits: l}
+	lim.cond = sync.NewCond(&lim.lk)
+	return lim
+}
+
+// EstimateDecodedBytes approximates the peak memory needed to decode and hash
+// an image of the given dimensions: the RGBA pixels plus the hash's scratch
+// slab.
+func EstimateDecodedBytes(width, height int) int64 {
+	return int64(width)*int64(height)*4 + 4*int64(ScratchSize(width, height))
+}
+
+// Acquire blocks until an image of the given estimated size may be processed.
+// An image larger than MaxBytes is admitted once nothing else is in flight.
+// The returned function must be called to release the reservation.
+func (l *Limiter) Acquire(ctx context.Context, size int64) (func(), error) {
+	if err := l.waitRate(ctx); err != nil {
+		return nil, err
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		l.lk.Lock()
+		l.cond.Broadcast()
+		l.lk.Unlock()
+	})
+	defer stop()
+
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	for !l.fits(size) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		l.cond.Wait()
+	}
+	l.inFlight++
+	l.bytes += size
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.lk.Lock()
+			l.inFlight--
+			l.bytes -= size
+			l.cond.Broadcast()
+			l.lk.Unlock()
+		})
+	}, nil
+}
+
+func (l *Limiter) fits(size int64) bool {
+	if l.limits.MaxInFlight > 0 && l.inFlight >= l.limits.MaxInFlight {
+		return false
+	}
+	if l.limits.MaxBytes > 0 && l.inFlight > 0 && l.bytes+size > l.limits.MaxBytes {
+		return false
+	}
+	return true
+}
+
+// waitRate spaces calls evenly to honor MaxPerSecond
+func (l *Limiter) waitRate(ctx context.Context) error {
+	if l.limits.MaxPerSecond <= 0 {
+		return nil
+	}
+	interval := time.Duration(float64(time.Second) / l.limits.MaxPerSecond)
+
+	l.lk.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(interval)
+	l.lk.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FromReaderLimited computes the PDQ hash from r once l admits it. The image
+// header is read first so the memory reservation reflects the decoded size;
+// if the dimensions can't be read nothing is reserved.
+func (h *PdqHasher) FromReaderLimited(ctx context.Context, r io.Reader, l *Limiter) (*HashResult, error) {
+	return h.fromReaderLimited(ctx, r, l, h.logger)
+}
+
+func (h *PdqHasher) fromReaderLimited(ctx context.Context, r io.Reader, l *Limiter, logger *slog.Logger) (*HashResult, error) {
+	br, cfg, ok, err := peekConfig(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var size int64
+	if ok {
+		if err := h.checkSize(image.Rect(0, 0, cfg.Width, cfg.Height)); err != nil {
+			logger.Warn("rejecting image", "width", cfg.Width, "height", cfg.Height, "err", err)
+			return nil, err
+		}
+		size = EstimateDecodedBytes(cfg.Width, cfg.Height)
+	}
+
+	release, err := l.Acquire(ctx, size)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return h.fromReader(br, logger)
+}
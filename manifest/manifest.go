@@ -0,0 +1,197 @@
+// Package manifest describes a distributed hash list so it can be
+// integrity-checked before being loaded into a matcher.
+//
+// A manifest sits next to a hash list in the format read by
+// gopdq.ReadTaggedHashes and records the list's SHA-256, its record count,
+// the hashing algorithm and version that produced it, and optionally an
+// Ed25519 signature by the publisher over all of those.
+package manifest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+const (
+	// FormatVersion is the manifest format written by this package
+	FormatVersion = 1
+
+	// Algorithm and AlgorithmVersion identify hashes produced by gopdq.
+	// Lists from a different algorithm or a newer version are rejected, as
+	// their hashes can't be compared with ours.
+	Algorithm        = "pdq"
+	AlgorithmVersion = 1
+)
+
+// Errors returned by Verify, to be tested for with errors.Is
+var (
+	// ErrChecksum means the hash list's contents changed since the manifest
+	// was made
+	ErrChecksum = errors.New("hash list checksum mismatch")
+	// ErrCount means the list doesn't hold as many records as the manifest says
+	ErrCount = errors.New("hash list record count mismatch")
+	// ErrSignature means the signature is malformed or not by the given key
+	ErrSignature = errors.New("invalid manifest signature")
+	// ErrUnsigned means a key was given but the manifest has no signature
+	ErrUnsigned = errors.New("manifest is not signed")
+	// ErrUnsupported means the manifest format or hash algorithm is unknown
+	ErrUnsupported = errors.New("unsupported manifest")
+)
+
+// Manifest describes one hash list
+type Manifest struct {
+	Format           int    `json:"format"`
+	Algorithm        string `json:"algorithm"`
+	AlgorithmVersion int    `json:"algorithm_version"`
+	// List is the path of the hash list, relative to the manifest
+	List    string    `json:"list"`
+	SHA256  string    `json:"sha256"`
+	Count   int       `json:"count"`
+	Created time.Time `json:"created"`
+	// Signature is the Ed25519 signature of the manifest with this field
+	// empty, hex encoded
+	Signature string `json:"signature,omitempty"`
+}
+
+// Create builds a manifest for the hash list at listPath, signing it with
+// key unless key is nil. The list is parsed to count its records, so a list
+// that wouldn't load is rejected here rather than by its consumers.
+func Create(listPath string, key ed25519.PrivateKey) (*Manifest, error) {
+	data, err := os.ReadFile(listPath)
+	if err != nil {
+		return nil, err
+	}
+	hashes, err := gopdq.ReadTaggedHashes(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", listPath, err)
+	}
+
+	sum := sha256.Sum256(data)
+	m := &Manifest{
+		Format:           FormatVersion,
+		Algorithm:        Algorithm,
+		AlgorithmVersion: AlgorithmVersion,
+		List:             filepath.Base(listPath),
+		SHA256:           hex.EncodeToString(sum[:]),
+		Count:            len(hashes),
+		Created:          time.Now().UTC().Truncate(time.Second),
+	}
+	if key != nil {
+		if err := m.Sign(key); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// signedBytes is the message covered by the signature
+func (m *Manifest) signedBytes() ([]byte, error) {
+	c := *m
+	c.Signature = ""
+	return json.Marshal(&c)
+}
+
+// Sign sets the manifest's signature
+func (m *Manifest) Sign(key ed25519.PrivateKey) error {
+	msg, err := m.signedBytes()
+	if err != nil {
+		return err
+	}
+	m.Signature = hex.EncodeToString(ed25519.Sign(key, msg))
+	return nil
+}
+
+// VerifySignature checks the manifest was signed by pub
+func (m *Manifest) VerifySignature(pub ed25519.PublicKey) error {
+	if m.Signature == "" {
+		return ErrUnsigned
+	}
+	sig, err := hex.DecodeString(m.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSignature, err)
+	}
+	msg, err := m.signedBytes()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, msg, sig) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// Write saves the manifest as indented JSON
+func (m *Manifest) Write(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Read loads a manifest without verifying it
+func Read(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// Verify loads the manifest at path and the hash list it describes,
+// returning the list's records only if everything checks out. If pub is
+// non-nil the manifest must carry a valid signature by it.
+func Verify(path string, pub ed25519.PublicKey) (*Manifest, []*gopdq.TaggedHash, error) {
+	m, err := Read(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if m.Format != FormatVersion {
+		return nil, nil, fmt.Errorf("%w: format version %d", ErrUnsupported, m.Format)
+	}
+	if m.Algorithm != Algorithm || m.AlgorithmVersion > AlgorithmVersion {
+		return nil, nil, fmt.Errorf("%w: algorithm %s version %d", ErrUnsupported, m.Algorithm, m.AlgorithmVersion)
+	}
+	if pub != nil {
+		if err := m.VerifySignature(pub); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	listPath := m.List
+	if !filepath.IsAbs(listPath) {
+		listPath = filepath.Join(filepath.Dir(path), listPath)
+	}
+	data, err := os.ReadFile(listPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != m.SHA256 {
+		return nil, nil, fmt.Errorf("%w: %s", ErrChecksum, listPath)
+	}
+
+	hashes, err := gopdq.ReadTaggedHashes(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", listPath, err)
+	}
+	if len(hashes) != m.Count {
+		return nil, nil, fmt.Errorf("%w: manifest lists %d, found %d", ErrCount, m.Count, len(hashes))
+	}
+	return m, hashes, nil
+}
@@ -0,0 +1,109 @@
+package manifest
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+func writeList(t *testing.T, path string) {
+	h, err := gopdq.FromHexString("02704e1ddd10f333c0e6df833130b07f99e36701383d333ac7c6078fe736dccc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	list := []*gopdq.TaggedHash{{Hash: h, Quality: 100, ID: "a"}, {Hash: h.BitwiseNOT(), Quality: 90, ID: "b"}}
+	if err := gopdq.WriteTaggedHashes(f, list); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	listPath := filepath.Join(dir, "blocklist.jsonl")
+	writeList(t, listPath)
+
+	m, err := Create(listPath, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Count != 2 || m.List != "blocklist.jsonl" || m.Signature == "" {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+	path := filepath.Join(dir, "blocklist.manifest.json")
+	if err := m.Write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	got, hashes, err := Verify(path, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 2 || hashes[1].ID != "b" || !got.Created.Equal(m.Created) {
+		t.Fatalf("unexpected verified list %+v %+v", got, hashes)
+	}
+
+	if _, _, err := Verify(path, otherPub); !errors.Is(err, ErrSignature) {
+		t.Fatalf("expected ErrSignature for the wrong key, got %v", err)
+	}
+
+	// tampering with any signed field breaks the signature
+	m.Count = 3
+	if err := m.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Verify(path, pub); !errors.Is(err, ErrSignature) {
+		t.Fatalf("expected ErrSignature for a tampered manifest, got %v", err)
+	}
+	// and without a key the count check catches it instead
+	if _, _, err := Verify(path, nil); !errors.Is(err, ErrCount) {
+		t.Fatalf("expected ErrCount, got %v", err)
+	}
+
+	unsigned, err := Create(listPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unsigned.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Verify(path, pub); !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("expected ErrUnsigned, got %v", err)
+	}
+
+	// the list changing after the manifest was made
+	f, err := os.OpenFile(listPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("# appended\n")
+	f.Close()
+	if _, _, err := Verify(path, nil); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("expected ErrChecksum, got %v", err)
+	}
+
+	unsigned.AlgorithmVersion = AlgorithmVersion + 1
+	if err := unsigned.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Verify(path, nil); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package gopdq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestMsgpack(t *testing.T) {
+	res, err := NewPdqHasher().FromFile("cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := msgpack.Marshal(res.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bin 8 header plus the raw hash, half the size of the hex string
+	if len(data) != 34 {
+		t.Fatalf("hash encoded to %d bytes, expected 34", len(data))
+	}
+
+	tagged := res.Tag("cat", "cat.jpg")
+	tagged.Timestamp = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tagged.SetLabel("set", "test")
+
+	data, err = msgpack.Marshal(tagged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got TaggedHash
+	if err := msgpack.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Hash.Equal(res.Hash) || got.Quality != res.Quality || got.ID != "cat" || got.Source != "cat.jpg" ||
+		!got.Timestamp.Equal(tagged.Timestamp) || got.Labels["set"] != "test" {
+		t.Fatalf("round trip changed the record: %+v", got)
+	}
+
+	data, err = msgpack.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]any
+	if err := msgpack.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m["degraded"]; ok || len(m) != 2 {
+		t.Fatalf("unexpected keys in encoded result: %v", m)
+	}
+	var hr HashResult
+	if err := msgpack.Unmarshal(data, &hr); err != nil {
+		t.Fatal(err)
+	}
+	if !hr.Hash.Equal(res.Hash) || hr.Quality != res.Quality {
+		t.Fatalf("round trip changed the result: %+v", hr)
+	}
+}
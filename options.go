@@ -0,0 +1,196 @@
+package gopdq
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Option configures a PdqHasher
+type Option func(*PdqHasher)
+
+// WithLogger sets the logger used to report decode failures and slow hashes.
+// By default, or with a nil l, nothing is logged.
+func WithLogger(l *slog.Logger) Option {
+	return func(h *PdqHasher) {
+		if l == nil {
+			l = slog.New(slog.DiscardHandler)
+		}
+		h.logger = l
+	}
+}
+
+// WithSlowHashThreshold logs a warning whenever decoding plus hashing a single
+// image takes longer than d. Zero disables the warning.
+func WithSlowHashThreshold(d time.Duration) Option {
+	return func(h *PdqHasher) {
+		h.slowHash = d
+	}
+}
+
+// WithMinQuality makes FromFile, FromReader and FromJpeg fail with
+// ErrLowQuality when a hash's quality is below q. Zero accepts every hash.
+func WithMinQuality(q int) Option {
+	return func(h *PdqHasher) {
+		h.minQuality = q
+	}
+}
+
+// WithDeterministic hashes with an integer-only pipeline that produces
+// identical hashes on every platform. Its hashes can differ from the default
+// float pipeline's, and from other PDQ implementations, by a few bits, so
+// don't mix the two modes in one corpus. It replaces WithMode.
+func WithDeterministic() Option {
+	return func(h *PdqHasher) {
+		h.deterministic = true
+		h.mode = Fast
+	}
+}
+
+// WithMode selects the float pipeline. ReferenceExact trades speed for
+// following the C++ reference's arithmetic, for corpora shared with it;
+// Fast, the default, differs from it in rounding only. Parity with the
+// reference is only checked by the regtest conformance test, against
+// vectors fetched separately, see ReferenceExact. It replaces
+// WithDeterministic.
+func WithMode(m Mode) Option {
+	return func(h *PdqHasher) {
+		h.mode = m
+		h.deterministic = false
+	}
+}
+
+// WithTruncated hashes JPEGs that end early instead of failing: the missing
+// rows are padded with gray and the result is marked Degraded. Other formats still
+// fail, and without cgo the standard library decoder can't recover partial
+// JPEGs either.
+//
+// Without it a JPEG is refused with ErrDecodeFailed when its data ends
+// before the EOI marker. This is a change from earlier releases, where
+// libjpeg builds hashed such files as if they were whole; set WithTruncated
+// to keep hashing them, now marked Degraded.
+func WithTruncated() Option {
+	return func(h *PdqHasher) {
+		h.truncated = true
+	}
+}
+
+// WithSmallImages sets how images with a side under 64 pixels are hashed.
+// The default is SmallImagesHash.
+func WithSmallImages(p SmallImages) Option {
+	return func(h *PdqHasher) {
+		h.smallImages = p
+	}
+}
+
+// WithSplitCheck checks every hash against the median split of its DCT
+// coefficients, to catch NaNs and broken filter paths, see SplitCheck
+func WithSplitCheck(c SplitCheck) Option {
+	return func(h *PdqHasher) {
+		h.splitCheck = c
+	}
+}
+
+// WithMaxPixels rejects images with more than n pixels with ErrImageTooLarge.
+// Where the header is readable the check happens before decoding, so a small
+// file claiming huge dimensions can't exhaust memory. Images are always
+// limited to 2^28 pixels.
+func WithMaxPixels(n int64) Option {
+	return func(h *PdqHasher) {
+		h.maxPixels = n
+	}
+}
+
+// WithMaxDecodedBytes rejects images whose decode and hash buffers, as
+// estimated by EstimateDecodedBytes, would exceed n bytes
+func WithMaxDecodedBytes(n int64) Option {
+	return func(h *PdqHasher) {
+		h.maxDecodedBytes = n
+	}
+}
+
+// WithDecodeTimeout fails decodes that take longer than d with
+// ErrImageTooLarge. The abandoned decode can't be interrupted and carries on
+// in the background, but reads from a streaming input fail from then on.
+func WithDecodeTimeout(d time.Duration) Option {
+	return func(h *PdqHasher) {
+		h.decodeTimeout = d
+	}
+}
+
+// WithMaxDimension lets JPEGs whose longer side exceeds n be decoded scaled
+// down, using libjpeg's DCT scaling, to the smallest size in eighths that
+// keeps the longer side at least n. Scaled decoding costs a fraction of the
+// time and memory of a full size one, and as PDQ filters the image down to
+// 64x64 anyway the hash barely moves for n of 512 or more. Other formats, CMYK
+// JPEGs and builds without cgo still decode at full size. Zero, the default,
+// decodes everything at full size.
+func WithMaxDimension(n int) Option {
+	return func(h *PdqHasher) {
+		h.maxDimension = n
+	}
+}
+
+// WithLumaStandard computes luma from RGB with the weights of s rather than
+// BT601's. Use BT709 when hashes of stills must agree with hashes of frames
+// taken from HD video luma planes; changing it changes every hash, so a
+// corpus should stick to one. Values other than BT601 and BT709 keep the
+// current standard.
+func WithLumaStandard(s LumaStandard) Option {
+	return func(h *PdqHasher) {
+		if s >= 0 && int(s) < len(lumaWeights) {
+			h.luma = s
+		}
+	}
+}
+
+// WithJaroszPasses is experimental: it runs n passes of the Jarosz box
+// filter instead of the spec's 2, for studying how smoothing trades
+// robustness for discrimination. Hashes made with it aren't PDQ hashes and
+// won't match other implementations'. n below 1 keeps the default.
+func WithJaroszPasses(n int) Option {
+	return func(h *PdqHasher) {
+		h.jaroszPasses = n
+	}
+}
+
+// WithWindowDivisor is experimental: it sizes the box filter's window as the
+// image dimension over d, rounded up, instead of over the spec's 128.
+// Smaller divisors blur more. As with WithJaroszPasses the hashes are no
+// longer PDQ hashes. d below 1 keeps the default.
+func WithWindowDivisor(d int) Option {
+	return func(h *PdqHasher) {
+		h.windowDivisor = d
+	}
+}
+
+// WithMargins records how far each DCT coefficient is from the median in
+// HashStats.Margins, for studying which bits are close to flipping
+func WithMargins() Option {
+	return func(h *PdqHasher) {
+		h.margins = true
+	}
+}
+
+// ConfigKey returns a string identifying the options that change the hashes
+// h computes, for keying caches of its results: hashers with the same key
+// give the same result for the same input. Options that only reject images,
+// such as the size limits and WithMinQuality, aren't part of it.
+func (h *PdqHasher) ConfigKey() string {
+	return fmt.Sprintf("v1 deterministic=%t mode=%d truncated=%t maxdim=%d small=%d luma=%d passes=%d divisor=%d",
+		h.deterministic, h.mode, h.truncated, max(h.maxDimension, 0), h.smallImages, h.luma,
+		max(h.jaroszPasses, 0), max(h.windowDivisor, 0))
+}
+
+// Progress is called by bulk operations as each item finishes, with the
+// number finished so far, the total, and the item just finished. Calls are
+// serialized and done only increases, so a progress bar can draw straight
+// from them, but the callback runs on the workers and should return quickly.
+type Progress func(done, total int, current string)
+
+// WithProgress reports the progress of HashFiles to fn
+func WithProgress(fn Progress) Option {
+	return func(h *PdqHasher) {
+		h.progress = fn
+	}
+}
@@ -1,11 +1,15 @@
 package gopdq
 
 import (
+	"cmp"
 	"fmt"
 	"math/bits"
 	"math/rand"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 const (
@@ -90,6 +94,31 @@ func (h *PdqHash256) SetBit(k int) {
 	h.w[(k&255)>>4] |= 1 << (k & 15)
 }
 
+// Bit reports whether the bit for DCT coefficient (row, col) is set. Rows
+// are vertical frequencies and columns horizontal ones, both 0..15 with the
+// lowest first; the coefficient's bit position is row*16 + col.
+func (h *PdqHash256) Bit(row, col int) bool {
+	k := (row&15)*16 + col&15
+	return h.w[k>>4]&(1<<(k&15)) != 0
+}
+
+// SetBitRC sets the bit for DCT coefficient (row, col)
+func (h *PdqHash256) SetBitRC(row, col int) {
+	h.SetBit((row&15)*16 + col&15)
+}
+
+// BitMatrix returns the hash laid out on its 16x16 DCT grid, indexed
+// [row][col] as for Bit
+func (h *PdqHash256) BitMatrix() [16][16]bool {
+	var m [16][16]bool
+	for row := range m {
+		for col := range m[row] {
+			m[row][col] = h.w[row]&(1<<col) != 0
+		}
+	}
+	return m
+}
+
 // FlipBit flips the bit at position k
 func (h *PdqHash256) FlipBit(k int) {
 	h.w[(k&255)>>4] ^= 1 << (k & 15)
@@ -131,6 +160,29 @@ func (h *PdqHash256) BitwiseNOT() *PdqHash256 {
 	return rv
 }
 
+// MajorityHash returns the per-bit majority of hashes: each bit is set when
+// it is set in more than half of them, so ties leave it clear. The result is
+// the hash closest on average to the whole set, and stands in for a cluster
+// in coarse matching. An empty slice yields the all-zero hash.
+func MajorityHash(hashes []*PdqHash256) *PdqHash256 {
+	var counts [256]int
+	for _, h := range hashes {
+		for k := 0; k < 256; k++ {
+			if h.w[k>>4]&(1<<(k&15)) != 0 {
+				counts[k]++
+			}
+		}
+	}
+
+	rv := NewPdqHash256()
+	for k, c := range counts {
+		if 2*c > len(hashes) {
+			rv.SetBit(k)
+		}
+	}
+	return rv
+}
+
 // Equal checks if two hashes are equal
 func (h *PdqHash256) Equal(other *PdqHash256) bool {
 	for i := 0; i < HASH256NUMSLOTS; i++ {
@@ -165,6 +217,62 @@ func (h *PdqHash256) Greater(other *PdqHash256) bool {
 	return false
 }
 
+// Compare returns -1, 0 or +1 as h is less than, equal to or greater than
+// other, in the same order as Less and Greater. Words are compared from
+// w[0] up, so this is not the order of the hex strings. The method
+// expression (*PdqHash256).Compare can be passed to slices.SortFunc.
+func (h *PdqHash256) Compare(other *PdqHash256) int {
+	for i := 0; i < HASH256NUMSLOTS; i++ {
+		if h.w[i] != other.w[i] {
+			return cmp.Compare(h.w[i], other.w[i])
+		}
+	}
+	return 0
+}
+
+// SortHashes sorts hashes, or pointers to them, in Compare order
+func SortHashes[E PdqHash256 | *PdqHash256](hashes []E) {
+	if ptrs, ok := any(hashes).([]*PdqHash256); ok {
+		slices.SortFunc(ptrs, (*PdqHash256).Compare)
+		return
+	}
+	sort.Sort(hashValues(any(hashes).([]PdqHash256)))
+}
+
+// hashValues sorts hashes in place, comparing them through pointers rather
+// than copying them into each comparison
+type hashValues []PdqHash256
+
+func (s hashValues) Len() int           { return len(s) }
+func (s hashValues) Less(i, j int) bool { return s[i].Compare(&s[j]) < 0 }
+func (s hashValues) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// SortHashesStable sorts hashes in Compare order, keeping equal hashes in
+// their original order
+func SortHashesStable(hashes []*PdqHash256) {
+	slices.SortStableFunc(hashes, (*PdqHash256).Compare)
+}
+
+// SearchHashes binary searches hashes, which must be sorted in Compare order,
+// for target. It returns the position target is or would be at, and whether
+// it was found.
+func SearchHashes(hashes []*PdqHash256, target *PdqHash256) (int, bool) {
+	return slices.BinarySearchFunc(hashes, target, (*PdqHash256).Compare)
+}
+
+// FindExact binary searches sorted, hashes or pointers to them sorted by
+// SortHashes, for an exact copy of h. It returns the position h is or would
+// be at, and whether it was found. For finding exact duplicates a sorted
+// slice needs no index and no more memory than the hashes themselves.
+func FindExact[E PdqHash256 | *PdqHash256](sorted []E, h *PdqHash256) (int, bool) {
+	if ptrs, ok := any(sorted).([]*PdqHash256); ok {
+		return SearchHashes(ptrs, h)
+	}
+	s := any(sorted).([]PdqHash256)
+	i := sort.Search(len(s), func(i int) bool { return s[i].Compare(h) >= 0 })
+	return i, i < len(s) && s[i].Equal(h)
+}
+
 // DumpBits returns a string representation of the bits
 func (h *PdqHash256) DumpBits() string {
 	var lines []string
@@ -183,7 +291,9 @@ func (h *PdqHash256) DumpBits() string {
 	return strings.Join(lines, "\n")
 }
 
-// ToBits returns the bits as a slice of bytes
+// ToBits returns the 256 bits as bytes of 0 or 1, most significant first so
+// they read in the same order as the hex string. This is the bit vector
+// python-pdqhash returns.
 func (h *PdqHash256) ToBits() []byte {
 	var bits []byte
 	for i := HASH256NUMSLOTS - 1; i >= 0; i-- {
@@ -199,6 +309,26 @@ func (h *PdqHash256) ToBits() []byte {
 	return bits
 }
 
+// FromBits creates a PdqHash256 from 256 bits in the order returned by
+// ToBits. Every element must be 0 or 1.
+func FromBits(bits []byte) (*PdqHash256, error) {
+	if len(bits) != 256 {
+		return nil, fmt.Errorf("incorrect bit count for pdq hash: expected 256, got %d", len(bits))
+	}
+
+	rv := NewPdqHash256()
+	for i, b := range bits {
+		switch b {
+		case 0:
+		case 1:
+			rv.SetBit(255 - i)
+		default:
+			return nil, fmt.Errorf("invalid bit value %d at offset %d", b, i)
+		}
+	}
+	return rv, nil
+}
+
 // DumpBitsAcross returns a string representation of bits in one line
 func (h *PdqHash256) DumpBitsAcross() string {
 	var str []string
@@ -264,7 +394,7 @@ func FromHexString(hexString string) (*PdqHash256, error) {
 
 	for x := 0; x < len(hexString); x += 4 {
 		i--
-		val, err := strconv.ParseInt(hexString[x:x+4], 16, 32)
+		val, err := strconv.ParseUint(hexString[x:x+4], 16, 16)
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse hex string: %w", err)
 		}
@@ -274,6 +404,97 @@ func FromHexString(hexString string) (*PdqHash256, error) {
 	return rv, nil
 }
 
+// ParseHash parses a hash in any of the shapes commonly found in hash lists:
+// an optional "pdq:" prefix, upper or mixed case hex, and whitespace or colons
+// between digits are all accepted. Use ParseHashStrict to accept only the
+// canonical form produced by String.
+func ParseHash(s string) (*PdqHash256, error) {
+	s = strings.TrimSpace(s)
+	if len(s) >= 4 && strings.EqualFold(s[:4], "pdq:") {
+		s = s[4:]
+	}
+
+	s = strings.Map(func(r rune) rune {
+		if r == ':' || unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, s)
+
+	return ParseHashStrict(s)
+}
+
+// ParseHashStrict parses a hash of exactly 64 lowercase hex digits
+func ParseHashStrict(s string) (*PdqHash256, error) {
+	if len(s) != HASH256_HEX_NUM_NYBBLES {
+		return nil, fmt.Errorf("incorrect hex length for pdq hash: expected %d, got %d", HASH256_HEX_NUM_NYBBLES, len(s))
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return nil, fmt.Errorf("invalid character %q at offset %d in pdq hash", c, i)
+		}
+	}
+	return FromHexString(s)
+}
+
+// Bytes returns the hash as 32 bytes in the order of its hex string, so the
+// first byte holds the first two hex digits
+func (h *PdqHash256) Bytes() []byte {
+	b := make([]byte, 0, 2*HASH256NUMSLOTS)
+	for i := HASH256NUMSLOTS - 1; i >= 0; i-- {
+		b = append(b, byte(h.w[i]>>8), byte(h.w[i]))
+	}
+	return b
+}
+
+// FromBytes creates a PdqHash256 from the 32 bytes returned by Bytes
+func FromBytes(b []byte) (*PdqHash256, error) {
+	if len(b) != 2*HASH256NUMSLOTS {
+		return nil, fmt.Errorf("incorrect byte length for pdq hash: expected %d, got %d", 2*HASH256NUMSLOTS, len(b))
+	}
+
+	rv := NewPdqHash256()
+	for i := 0; i < HASH256NUMSLOTS; i++ {
+		rv.w[HASH256NUMSLOTS-1-i] = int(b[2*i])<<8 | int(b[2*i+1])
+	}
+	return rv, nil
+}
+
+// MarshalText implements encoding.TextMarshaler using the String form, so
+// hashes encode as hex strings in JSON and other text formats
+func (h *PdqHash256) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, accepting anything
+// ParseHash does
+func (h *PdqHash256) UnmarshalText(text []byte) error {
+	parsed, err := ParseHash(string(text))
+	if err != nil {
+		return err
+	}
+	*h = *parsed
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler using the form
+// returned by Bytes. encoding/gob and MessagePack encoders use it, so hashes
+// encode as 32 bytes of binary despite having no exported fields.
+func (h *PdqHash256) MarshalBinary() ([]byte, error) {
+	return h.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler
+func (h *PdqHash256) UnmarshalBinary(data []byte) error {
+	parsed, err := FromBytes(data)
+	if err != nil {
+		return err
+	}
+	*h = *parsed
+	return nil
+}
+
 // hammingNorm16 counts the number of set bits in a 16-bit value
 func hammingNorm16(v int) int {
 	return bits.OnesCount16(uint16(v & 0xFFFF))
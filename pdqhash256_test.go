@@ -0,0 +1,250 @@
+package gopdq
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestParseHash(t *testing.T) {
+	const canonical = "06704e1dd910f233c0e6df833130b0ff99e36701383d333ac7c6078fe736dccc"
+
+	good := []string{
+		canonical,
+		"pdq:" + canonical,
+		"PDQ:06704E1DD910F233C0E6DF833130B0FF99E36701383D333AC7C6078FE736DCCC",
+		"  " + canonical + "\n",
+		"0670 4e1d d910 f233 c0e6 df83 3130 b0ff 99e3 6701 383d 333a c7c6 078f e736 dccc",
+		"06:70:4e:1d:d9:10:f2:33:c0:e6:df:83:31:30:b0:ff:99:e3:67:01:38:3d:33:3a:c7:c6:07:8f:e7:36:dc:cc",
+	}
+	for _, s := range good {
+		h, err := ParseHash(s)
+		if err != nil {
+			t.Errorf("ParseHash(%q): %v", s, err)
+			continue
+		}
+		if h.String() != canonical {
+			t.Errorf("ParseHash(%q) = %s", s, h)
+		}
+	}
+
+	bad := []string{
+		"",
+		canonical[:63],
+		canonical + "0",
+		"pdq:pdq:" + canonical,
+		"+" + canonical[1:],
+		"g" + canonical[1:],
+	}
+	for _, s := range bad {
+		if _, err := ParseHash(s); err == nil {
+			t.Errorf("ParseHash(%q) succeeded", s)
+		}
+	}
+
+	if _, err := ParseHashStrict(canonical); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range good[1:] {
+		if _, err := ParseHashStrict(s); err == nil {
+			t.Errorf("ParseHashStrict(%q) succeeded", s)
+		}
+	}
+}
+
+func TestCompareAndSearch(t *testing.T) {
+	base, err := FromHexString("06704e1dd910f233c0e6df833130b0ff99e36701383d333ac7c6078fe736dccc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hashes []*PdqHash256
+	for i := 0; i < 100; i++ {
+		hashes = append(hashes, base.Fuzz(i%20))
+	}
+
+	for _, a := range hashes[:20] {
+		for _, b := range hashes[:20] {
+			c := a.Compare(b)
+			if (c < 0) != a.Less(b) || (c > 0) != a.Greater(b) || (c == 0) != a.Equal(b) {
+				t.Fatalf("Compare(%s, %s) = %d disagrees with Less/Greater/Equal", a, b, c)
+			}
+		}
+	}
+
+	SortHashes(hashes)
+	for i := 1; i < len(hashes); i++ {
+		if hashes[i-1].Greater(hashes[i]) {
+			t.Fatalf("not sorted at %d", i)
+		}
+	}
+
+	for _, h := range hashes {
+		i, ok := SearchHashes(hashes, h)
+		if !ok || !hashes[i].Equal(h) {
+			t.Fatalf("SearchHashes didn't find %s", h)
+		}
+	}
+	missing := NewPdqHash256()
+	missing.SetAll()
+	if i, ok := SearchHashes(hashes, missing); ok || i != len(hashes) {
+		t.Fatalf("SearchHashes found all-ones hash at %d", i)
+	}
+
+	// the same over a slice of hashes rather than pointers
+	values := make([]PdqHash256, len(hashes))
+	for i, h := range hashes {
+		values[len(values)-1-i] = *h
+	}
+	SortHashes(values)
+	for i := range values {
+		if !values[i].Equal(hashes[i]) {
+			t.Fatalf("sorted values differ from sorted pointers at %d", i)
+		}
+	}
+	// sorting values in place copies none of them outside the slice
+	if n := testing.AllocsPerRun(10, func() { SortHashes(values) }); n > 1 {
+		t.Errorf("SortHashes over values made %v allocations", n)
+	}
+	for _, h := range hashes {
+		i, ok := FindExact(values, h)
+		if !ok || !values[i].Equal(h) {
+			t.Fatalf("FindExact didn't find %s", h)
+		}
+		if j, ok := FindExact(hashes, h); !ok || j != i {
+			t.Fatalf("FindExact over pointers gave %d, %v, expected %d", j, ok, i)
+		}
+	}
+	if i, ok := FindExact(values, missing); ok || i != len(values) {
+		t.Fatalf("FindExact found all-ones hash at %d", i)
+	}
+	if i, ok := FindExact(values, values[0].BitwiseNOT()); ok && !values[i].Equal(values[0].BitwiseNOT()) {
+		t.Fatalf("FindExact found a different hash at %d", i)
+	}
+}
+
+func TestMajorityHash(t *testing.T) {
+	base, err := FromHexString("06704e1dd910f233c0e6df833130b0ff99e36701383d333ac7c6078fe736dccc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cluster []*PdqHash256
+	for i := 0; i < 25; i++ {
+		cluster = append(cluster, base.Fuzz(20))
+	}
+	if d := MajorityHash(cluster).HammingDistance(base); d > 4 {
+		t.Fatalf("centroid is %d bits from the cluster's source", d)
+	}
+
+	a := NewPdqHash256()
+	a.SetBit(1)
+	a.SetBit(2)
+	b := NewPdqHash256()
+	b.SetBit(2)
+	if m := MajorityHash([]*PdqHash256{a, b}); m.HammingNorm() != 1 || m.w[0] != 1<<2 {
+		t.Fatalf("ties should leave bits clear, got %s", m)
+	}
+
+	if m := MajorityHash(nil); m.HammingNorm() != 0 {
+		t.Fatal("empty majority should be zero")
+	}
+}
+
+func TestPreview(t *testing.T) {
+	h := NewPdqHash256()
+	h.SetBit(0)        // coefficient (0, 0)
+	h.SetBit(7*16 + 7) // coefficient (7, 7)
+	h.SetBit(8)        // coefficient (0, 8), outside the preview
+	h.SetBit(8 * 16)   // coefficient (8, 0), outside the preview
+	if p := h.Preview(); p != 1<<63|1 {
+		t.Fatalf("unexpected preview %s", p)
+	}
+
+	base, err := FromHexString("06704e1dd910f233c0e6df833130b0ff99e36701383d333ac7c6078fe736dccc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		o := base.Fuzz(i)
+		if pd, d := base.Preview().Distance(o.Preview()), base.HammingDistance(o); pd > d {
+			t.Fatalf("preview distance %d exceeds full distance %d", pd, d)
+		}
+	}
+}
+
+func TestBitMatrix(t *testing.T) {
+	h := NewPdqHash256()
+	h.SetBitRC(3, 9)
+	h.SetBit(15*16 + 0)
+
+	if !h.Bit(3, 9) || h.Bit(9, 3) || !h.Bit(15, 0) {
+		t.Fatal("Bit disagrees with SetBitRC/SetBit")
+	}
+	if h.w[3] != 1<<9 {
+		t.Fatalf("SetBitRC(3, 9) set word %d to %x", 3, h.w[3])
+	}
+
+	m := h.BitMatrix()
+	n := 0
+	for row := range m {
+		for col := range m[row] {
+			if m[row][col] != h.Bit(row, col) {
+				t.Fatalf("BitMatrix disagrees with Bit at (%d, %d)", row, col)
+			}
+			if m[row][col] {
+				n++
+			}
+		}
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 set bits, got %d", n)
+	}
+}
+
+func TestFromBits(t *testing.T) {
+	h, err := FromHexString("8000000000000000000000000000000000000000000000000000000000000003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bits := h.ToBits()
+	if bits[0] != 1 || bits[1] != 0 || bits[254] != 1 || bits[255] != 1 {
+		t.Fatal("ToBits is not most significant bit first")
+	}
+
+	got, err := FromBits(bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(h) {
+		t.Fatalf("FromBits(ToBits()) = %s, expected %s", got, h)
+	}
+
+	// cat.jpg's hash as a python-pdqhash vector, checked against the way
+	// python-threatexchange turns those into hex: the elements joined into
+	// a string of digits and read as a base 2 integer
+	vector := "0000001001110000010011100001110111011101000100001111001100110011" +
+		"1100000011100110110111111000001100110001001100001011000001111111" +
+		"1001100111100011011001110000000100111000001111010011001100111010" +
+		"1100011111000110000001111000111111100111001101101101110011001100"
+	n, _ := new(big.Int).SetString(vector, 2)
+	pyBits := make([]byte, len(vector))
+	for i := range vector {
+		pyBits[i] = vector[i] - '0'
+	}
+	fromPy, err := FromBits(pyBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%064x", n); fromPy.String() != want {
+		t.Fatalf("FromBits of a pdqhash vector = %s, python-threatexchange gives %s", fromPy, want)
+	}
+
+	bits[7] = 2
+	if _, err := FromBits(bits); err == nil {
+		t.Fatal("expected an error for a bit value of 2")
+	}
+	if _, err := FromBits(bits[:255]); err == nil {
+		t.Fatal("expected an error for 255 bits")
+	}
+}
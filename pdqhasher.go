@@ -1,16 +1,18 @@
 package gopdq
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"image"
+	"image/color"
 	"image/draw"
-	_ "image/jpeg"
+	"image/jpeg"
 	_ "image/png"
 	"io"
-	"math"
+	"log/slog"
 	"os"
-
-	ljpeg "github.com/pixiv/go-libjpeg/jpeg"
+	"time"
 )
 
 const (
@@ -21,10 +23,49 @@ const (
 	PDQ_JAROSZ_WINDOW_SIZE_DIVISOR = 128
 )
 
-// HashResult contains the hash and quality metrics
+// LumaStandard selects the weights luma is computed from RGB with
+type LumaStandard int
+
+const (
+	// BT601 is Rec. 601, as used by the reference PDQ implementation
+	BT601 LumaStandard = iota
+	// BT709 is Rec. 709, the standard for HD video, whose decoders hand out
+	// luma planes weighted this way
+	BT709
+)
+
+// lumaWeights are the float weights of each standard, and the integer ones,
+// summing to lumaScale, used by the deterministic pipeline
+var lumaWeights = [...]struct {
+	r, g, b    float32
+	ri, gi, bi int64
+}{
+	BT601: {LUMA_FROM_R_COEFF, LUMA_FROM_G_COEFF, LUMA_FROM_B_COEFF, 299, 587, 114},
+	BT709: {0.2126, 0.7152, 0.0722, 213, 715, 72},
+}
+
+func (s LumaStandard) String() string {
+	switch s {
+	case BT601:
+		return "BT.601"
+	case BT709:
+		return "BT.709"
+	}
+	return fmt.Sprintf("LumaStandard(%d)", int(s))
+}
+
+// HashResult contains the hash and quality metrics. It, TaggedHash and
+// PdqHash256 carry msgpack struct tags and binary marshaling for
+// MessagePack encoders such as github.com/vmihailenco/msgpack.
 type HashResult struct {
-	Hash    *PdqHash256
-	Quality int
+	Hash    *PdqHash256 `msgpack:"hash"`
+	Quality int         `msgpack:"quality"`
+	// Degraded is set when the hash was computed from a partially decoded
+	// image, see WithTruncated
+	Degraded bool `msgpack:"degraded,omitempty"`
+	// Stats describes how the result was computed. It isn't encoded, as it
+	// describes the run rather than the image.
+	Stats HashStats `msgpack:"-"`
 }
 
 // HashAndQuality is an internal struct for hash generation
@@ -35,26 +76,38 @@ type HashAndQuality struct {
 
 // PdqHasher is the main hasher implementation
 type PdqHasher struct {
-	dctMatrix []float32 // 16x64 matrix stored as 1D array
+	logger     *slog.Logger
+	slowHash   time.Duration
+	minQuality int
+
+	deterministic bool
+	mode          Mode
+	truncated     bool
+
+	maxPixels       int64
+	maxDecodedBytes int64
+	decodeTimeout   time.Duration
+	maxDimension    int
+	smallImages     SmallImages
+	splitCheck      SplitCheck
+
+	luma     LumaStandard
+	progress Progress
+	margins  bool
+
+	jaroszPasses  int
+	windowDivisor int
 }
 
 // NewPdqHasher creates a new PdqHasher instance
-func NewPdqHasher() *PdqHasher {
+func NewPdqHasher(opts ...Option) *PdqHasher {
 	h := &PdqHasher{
-		dctMatrix: make([]float32, 16*64),
+		logger: slog.New(slog.DiscardHandler),
 	}
-	h.computeDCTMatrix()
-	return h
-}
-
-// computeDCTMatrix initializes the DCT transformation matrix
-func (h *PdqHasher) computeDCTMatrix() {
-	matrixScaleFactor := float32(math.Sqrt(2.0 / 64.0))
-	for i := 0; i < 16; i++ {
-		for j := 0; j < 64; j++ {
-			h.dctMatrix[i*64+j] = matrixScaleFactor * float32(math.Cos((math.Pi/2.0/64.0)*float64(i+1)*float64(2*j+1)))
-		}
+	for _, o := range opts {
+		o(h)
 	}
+	return h
 }
 
 // FromFile computes the PDQ hash from an image file
@@ -69,56 +122,268 @@ func (h *PdqHasher) FromFile(filePath string) (*HashResult, error) {
 	}
 	defer file.Close()
 
-	return h.FromReader(file)
+	return h.fromReader(file, h.logger.With("path", filePath))
 }
 
-func DecodeJpeg(r io.Reader) (image.Image, error) {
-	var img image.Image
-	if ljpeg.SupportRGBA() {
-		ljimg, err := ljpeg.DecodeIntoRGBA(r, &ljpeg.DecoderOptions{
-			DCTMethod:              ljpeg.DCTIFast,
-			DisableFancyUpsampling: false,
-		})
-		if err != nil {
-			return nil, fmt.Errorf("failed to decode image: %w", err)
+func (h *PdqHasher) FromJpeg(r io.Reader) (*HashResult, error) {
+	start := time.Now()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return h.fromJpegData(data, start, h.logger)
+}
+
+// fromJpegData decodes and hashes a JPEG held in memory, refusing truncated
+// ones unless WithTruncated is set
+func (h *PdqHasher) fromJpegData(data []byte, start time.Time, logger *slog.Logger) (*HashResult, error) {
+	truncated := jpegIsTruncated(data)
+	if truncated && !h.truncated {
+		logger.Warn("failed to decode image", "format", "jpeg", "err", "truncated")
+		return nil, fmt.Errorf("%w: truncated JPEG: %w", ErrDecodeFailed, io.ErrUnexpectedEOF)
+	}
+
+	cfg, cerr := jpeg.DecodeConfig(bytes.NewReader(data))
+	if cerr == nil {
+		if err := h.checkSize(image.Rect(0, 0, cfg.Width, cfg.Height)); err != nil {
+			logger.Warn("rejecting image", "format", "jpeg", "width", cfg.Width, "height", cfg.Height, "err", err)
+			return nil, err
 		}
-		img = ljimg
-	} else {
-		ljimg, err := ljpeg.Decode(r, &ljpeg.DecoderOptions{
-			DCTMethod:              ljpeg.DCTIFast,
-			DisableFancyUpsampling: false,
-		})
-		if err != nil {
-			return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	var deadline time.Time
+	if h.decodeTimeout > 0 {
+		deadline = start.Add(h.decodeTimeout)
+	}
+	img, _, err := h.decodeBefore(deadline, func() (image.Image, string, error) {
+		img, err := decodeJpeg(data, h.maxDimension)
+		return img, "jpeg", err
+	})
+	if err != nil {
+		logger.Warn("failed to decode image", "format", "jpeg", "err", err)
+		return nil, err
+	}
+	// libjpeg may read a header the standard library couldn't, so the
+	// limits still apply to what it decoded
+	if cerr != nil {
+		if err := h.checkSize(img.Bounds()); err != nil {
+			logger.Warn("rejecting image", "format", "jpeg", "width", img.Bounds().Dx(), "height", img.Bounds().Dy(), "err", err)
+			return nil, err
 		}
-		img = ljimg
+	}
+	if !truncated {
+		return h.hashDecoded(img, "jpeg", int64(len(data)), start, logger)
 	}
 
-	return img, nil
+	// libjpeg pads the missing rows with mid-gray. That matches the full
+	// image more closely than cropping to the decoded rows would, since PDQ
+	// is far more sensitive to crops than to a damaged strip.
+	res, err := h.hashDecoded(img, "jpeg", int64(len(data)), start, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("hashed truncated image", "format", "jpeg")
+	res.Degraded = true
+	return res, nil
 }
 
-func (h *PdqHasher) FromJpeg(r io.Reader) (*HashResult, error) {
-	img, err := DecodeJpeg(r)
+func (h *PdqHasher) FromReader(r io.Reader) (*HashResult, error) {
+	return h.fromReader(r, h.logger)
+}
+
+// FromBytes computes the PDQ hash of an encoded image held in memory
+func (h *PdqHasher) FromBytes(data []byte) (*HashResult, error) {
+	return h.fromReader(bytes.NewReader(data), h.logger)
+}
+
+func (h *PdqHasher) fromReader(r io.Reader, logger *slog.Logger) (*HashResult, error) {
+	start := time.Now()
+	counter := &countingReader{r: r}
+	r = counter
+
+	var deadline time.Time
+	if h.decodeTimeout > 0 {
+		deadline = start.Add(h.decodeTimeout)
+		r = &deadlineReader{r: r, deadline: deadline, timeout: h.decodeTimeout}
+	}
+
+	// check the dimensions before the decoder allocates anything
+	br, cfg, ok, err := peekConfig(r)
 	if err != nil {
 		return nil, err
 	}
+	if ok {
+		if err := h.checkSize(image.Rect(0, 0, cfg.Width, cfg.Height)); err != nil {
+			logger.Warn("rejecting image", "width", cfg.Width, "height", cfg.Height, "err", err)
+			return nil, err
+		}
+	}
+	r = br
+
+	if d, ok := sniffDecoder(br); ok {
+		img, format, err := h.decodeBefore(deadline, func() (image.Image, string, error) {
+			img, err := d.decode(br)
+			return img, d.name, err
+		})
+		if err != nil {
+			logger.Warn("failed to decode image", "format", d.name, "err", err)
+			return nil, decodeError(err)
+		}
+		if !ok {
+			if err := h.checkSize(img.Bounds()); err != nil {
+				logger.Warn("rejecting image", "format", format, "width", img.Bounds().Dx(), "height", img.Bounds().Dy(), "err", err)
+				return nil, err
+			}
+		}
+		return h.hashDecoded(img, format, counter.n, start, logger)
+	}
+
+	// a JPEG that can be decoded scaled down is read whole, as libjpeg
+	// needs it in memory anyway
+	if ok && canScaleJpeg && h.maxDimension > 0 && max(cfg.Width, cfg.Height) > h.maxDimension {
+		if sig, _ := br.Peek(2); len(sig) == 2 && sig[0] == 0xff && sig[1] == 0xd8 {
+			data, err := io.ReadAll(br)
+			if err != nil {
+				return nil, err
+			}
+			return h.fromJpegData(data, start, logger)
+		}
+	}
+
+	// keep a copy of the input so a truncated JPEG can be retried through
+	// the partial decoder
+	var buf *bytes.Buffer
+	if h.truncated {
+		buf = new(bytes.Buffer)
+		r = io.TeeReader(r, buf)
+	}
+
+	img, format, err := h.decodeBefore(deadline, func() (image.Image, string, error) {
+		return image.Decode(r)
+	})
+	if err != nil {
+		if errors.Is(err, ErrImageTooLarge) {
+			logger.Warn("failed to decode image", "err", err)
+			return nil, err
+		}
+		if buf != nil && format == "jpeg" {
+			io.Copy(io.Discard, r)
+			if jpegIsTruncated(buf.Bytes()) {
+				return h.fromJpegData(buf.Bytes(), start, logger)
+			}
+		}
+		logger.Warn("failed to decode image", "err", err)
+		return nil, decodeError(err)
+	}
+	// without a header read up front the limits apply after decoding
+	if !ok {
+		if err := h.checkSize(img.Bounds()); err != nil {
+			logger.Warn("rejecting image", "format", format, "width", img.Bounds().Dx(), "height", img.Bounds().Dy(), "err", err)
+			return nil, err
+		}
+	}
 
-	return h.HashImage(img)
+	return h.hashDecoded(img, format, counter.n, start, logger)
 }
 
-func (h *PdqHasher) FromReader(r io.Reader) (*HashResult, error) {
-	img, _, err := image.Decode(r)
+// hashDecoded hashes a freshly decoded image and reports it if slow. read is
+// how many bytes were decoded, and start when reading them began.
+func (h *PdqHasher) hashDecoded(img image.Image, format string, read int64, start time.Time, logger *slog.Logger) (*HashResult, error) {
+	readTime := time.Since(start)
+	res, err := h.HashImage(img)
 	if err != nil {
 		return nil, err
 	}
+	res.Stats.Decoder = format
+	res.Stats.BytesRead = read
+	res.Stats.ReadTime = readTime
+
+	if h.minQuality > 0 && res.Quality < h.minQuality {
+		logger.Debug("low quality hash", "format", format, "quality", res.Quality)
+		return nil, fmt.Errorf("%w: %d < %d", ErrLowQuality, res.Quality, h.minQuality)
+	}
 
-	return h.HashImage(img)
+	if took := time.Since(start); h.slowHash > 0 && took > h.slowHash {
+		bounds := img.Bounds()
+		logger.Warn("slow hash",
+			"format", format,
+			"width", bounds.Dx(),
+			"height", bounds.Dy(),
+			"duration", took,
+			"read", res.Stats.ReadTime,
+		)
+	}
+	return res, nil
 }
 
 func (h *PdqHasher) HashImage(img image.Image) (*HashResult, error) {
 	//width := min(bounds.Dx(), 1024)
 	//height := min(bounds.Dy(), 1024)
 
+	start := time.Now()
+	if err := checkImage(img); err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	if err := h.checkSize(b); err != nil {
+		return nil, err
+	}
+	img = h.upscaleSmall(img)
+
+	var res *HashResult
+	var err error
+	if h.deterministic {
+		res, err = h.hashImageInt(img)
+	} else {
+		slab := getFloats(ScratchSize(img.Bounds().Dx(), img.Bounds().Dy()))
+		res, err = h.hashImageIn(img, *slab)
+		putFloats(slab)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.Stats.HashTime = time.Since(start)
+	res.Stats.Width, res.Stats.Height = b.Dx(), b.Dy()
+	return res, nil
+}
+
+// HashImageScratch is HashImage working in scratch rather than in pooled
+// buffers, so callers hashing many images of one size can allocate once.
+// scratch must hold at least ScratchSize values, for the upscaled size if
+// WithSmallImages(SmallImagesUpscale) enlarges the image; a deterministic
+// hasher doesn't use it.
+func (h *PdqHasher) HashImageScratch(img image.Image, scratch []float32) (*HashResult, error) {
+	start := time.Now()
+	if err := checkImage(img); err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	if err := h.checkSize(b); err != nil {
+		return nil, err
+	}
+	img = h.upscaleSmall(img)
+
+	var res *HashResult
+	var err error
+	if h.deterministic {
+		res, err = h.hashImageInt(img)
+	} else {
+		width, height := img.Bounds().Dx(), img.Bounds().Dy()
+		if n := ScratchSize(width, height); len(scratch) < n {
+			return nil, fmt.Errorf("scratch of %d values is too small for a %dx%d image, which needs %d", len(scratch), width, height, n)
+		}
+		res, err = h.hashImageIn(img, scratch)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.Stats.HashTime = time.Since(start)
+	res.Stats.Width, res.Stats.Height = b.Dx(), b.Dy()
+	return res, nil
+}
+
+// hashImageIn runs the float pipeline on img using slab for its buffers
+func (h *PdqHasher) hashImageIn(img image.Image, slab []float32) (*HashResult, error) {
 	var resized image.Image = img
 	// Resize if needed (simple nearest neighbor for now)
 	/*
@@ -134,17 +399,65 @@ func (h *PdqHasher) HashImage(img image.Image) (*HashResult, error) {
 
 	// Process image
 
-	buffer1 := make([]float32, height*width)
-	buffer2 := make([]float32, height*width)
-	buffer64x64 := make([]float32, 64*64)
-	buffer16x16 := make([]float32, 16*16)
+	s := splitScratch(slab, width, height)
+	h.fillFloatLumaFromImage(resized, s.buffer1)
+	result := h.pdqHash256FromFloatLuma(s.buffer1, s.buffer2, height, width, s.buffer64x64, s.buffer16x16)
+
+	res := &HashResult{
+		Hash:    result.Hash,
+		Quality: result.Quality,
+	}
+	if h.margins {
+		res.Stats.Margins = coefMargins(s.buffer16x16, 1)
+	}
+	return res, checkSplit(h, s.buffer16x16, result.Hash)
+}
+
+// LumaPlane returns the luma of img as a row-major plane of
+// width x height values, the form HashLuma hashes
+func LumaPlane(img image.Image) []float32 {
+	b := img.Bounds()
+	luma := make([]float32, b.Dx()*b.Dy())
+	var h PdqHasher
+	h.fillFloatLumaFromImage(img, luma)
+	return luma
+}
 
-	h.fillFloatLumaFromImage(resized, buffer1)
-	result := h.pdqHash256FromFloatLuma(buffer1, buffer2, height, width, buffer64x64, buffer16x16)
+// HashLuma hashes a luma plane from LumaPlane, or from a decoder producing
+// luma directly, without building an image. luma isn't modified. The
+// deterministic pipeline works from the image itself, so a hasher created
+// with WithDeterministic returns an error.
+func (h *PdqHasher) HashLuma(luma []float32, width, height int) (*HashResult, error) {
+	start := time.Now()
+	if len(luma) != width*height {
+		return nil, fmt.Errorf("luma plane of %d values isn't %dx%d", len(luma), width, height)
+	}
+	if err := h.checkSize(image.Rect(0, 0, width, height)); err != nil {
+		return nil, err
+	}
+	if h.deterministic {
+		return nil, errors.New("the deterministic pipeline can't hash a float luma plane")
+	}
+	stats := HashStats{Width: width, Height: height}
+	luma, width, height = h.upscaleSmallLuma(luma, width, height)
+
+	slab := getFloats(ScratchSize(width, height))
+	defer putFloats(slab)
+	s := splitScratch(*slab, width, height)
+	copy(s.buffer1, luma)
+	result := h.pdqHash256FromFloatLuma(s.buffer1, s.buffer2, height, width, s.buffer64x64, s.buffer16x16)
+	if err := checkSplit(h, s.buffer16x16, result.Hash); err != nil {
+		return nil, err
+	}
+	stats.HashTime = time.Since(start)
+	if h.margins {
+		stats.Margins = coefMargins(s.buffer16x16, 1)
+	}
 
 	return &HashResult{
 		Hash:    result.Hash,
 		Quality: result.Quality,
+		Stats:   stats,
 	}, nil
 }
 
@@ -153,14 +466,51 @@ func (h *PdqHasher) fillFloatLumaFromImage(img image.Image, luma []float32) {
 	bounds := img.Bounds()
 	numCols := bounds.Dx()
 	numRows := bounds.Dy()
-
-	var rgbaImg *image.RGBA
-	if rgbaSrc, ok := img.(*image.RGBA); ok {
-		rgbaImg = rgbaSrc
-	} else {
-		rgbaImg = image.NewRGBA(img.Bounds())
-		draw.Draw(rgbaImg, rgbaImg.Bounds(), img, img.Bounds().Min, draw.Src)
+	if h.mode == ReferenceExact {
+		h.fillLumaReference(img, luma)
+		return
 	}
+	wr, wg, wb := lumaWeights[h.luma].r, lumaWeights[h.luma].g, lumaWeights[h.luma].b
+
+	switch src := img.(type) {
+	case *image.Gray:
+		// same result as expanding to RGBA, without the copy
+		for row := 0; row < numRows; row++ {
+			pix := src.Pix[row*src.Stride:]
+			for col := 0; col < numCols; col++ {
+				y8 := float32(pix[col])
+				luma[row*numCols+col] = wr*y8 + wg*y8 + wb*y8
+			}
+		}
+		return
+	case *image.CMYK:
+		for row := 0; row < numRows; row++ {
+			pix := src.Pix[row*src.Stride:]
+			for col := 0; col < numCols; col++ {
+				r, g, b := color.CMYKToRGB(pix[col*4], pix[col*4+1], pix[col*4+2], pix[col*4+3])
+				luma[row*numCols+col] = wr*float32(r) + wg*float32(g) + wb*float32(b)
+			}
+		}
+		return
+	case *image.Paletted:
+		// each index's luma is worked out once, from the same 8 bit
+		// premultiplied channels drawing into RGBA would give
+		var table [256]float32
+		for i, c := range src.Palette[:min(len(src.Palette), 256)] {
+			r, g, b, _ := c.RGBA()
+			table[i] = wr*float32(uint8(r>>8)) + wg*float32(uint8(g>>8)) + wb*float32(uint8(b>>8))
+		}
+		for row := 0; row < numRows; row++ {
+			pix := src.Pix[row*src.Stride:]
+			out := luma[row*numCols : (row+1)*numCols]
+			for col := range out {
+				out[col] = table[pix[col]]
+			}
+		}
+		return
+	}
+
+	rgbaImg := toRGBA(img)
 
 	// Now access raw RGBA data
 	stride := rgbaImg.Stride
@@ -172,31 +522,39 @@ func (h *PdqHasher) fillFloatLumaFromImage(img image.Image, luma []float32) {
 			g8 := float32(rgbaImg.Pix[offs+1])
 			b8 := float32(rgbaImg.Pix[offs+2])
 
-			luma[row*numCols+col] = LUMA_FROM_R_COEFF*r8 + LUMA_FROM_G_COEFF*g8 + LUMA_FROM_B_COEFF*b8
+			luma[row*numCols+col] = wr*r8 + wg*g8 + wb*b8
 		}
 	}
 }
 
+// toRGBA returns img as an *image.RGBA, converting it if necessary
+func toRGBA(img image.Image) *image.RGBA {
+	if rgbaSrc, ok := img.(*image.RGBA); ok {
+		return rgbaSrc
+	}
+	rgbaImg := image.NewRGBA(img.Bounds())
+	draw.Draw(rgbaImg, rgbaImg.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgbaImg
+}
+
 // pdqHash256FromFloatLuma generates the hash from luminance data
 func (h *PdqHasher) pdqHash256FromFloatLuma(buffer1, buffer2 []float32, numRows, numCols int, buffer64x64, buffer16x16 []float32) HashAndQuality {
-	windowSizeAlongRows := computeJaroszFilterWindowSize(numCols)
-	windowSizeAlongCols := computeJaroszFilterWindowSize(numRows)
+	if h.mode == ReferenceExact {
+		return h.hashLumaReference(buffer1, buffer2, numRows, numCols, buffer64x64, buffer16x16)
+	}
+	windowSizeAlongRows, windowSizeAlongCols, passes := h.filterParams(numRows, numCols)
 
-	jaroszFilterFloat(
+	jaroszFilterDecimateFloat(
 		buffer1,
 		buffer2,
 		numRows,
 		numCols,
 		windowSizeAlongRows,
 		windowSizeAlongCols,
-		PDQ_NUM_JAROSZ_XY_PASSES,
+		passes,
+		buffer64x64,
 	)
-
-	decimateFloat(buffer1, numRows, numCols, buffer64x64)
-	quality := computePDQImageDomainQualityMetric(buffer64x64)
-
-	h.dct64To16(buffer64x64, buffer16x16)
-	hash := pdqBuffer16x16ToBits(buffer16x16)
+	hash, quality := hashBlock(buffer64x64, buffer16x16)
 
 	return HashAndQuality{
 		Hash:    hash,
@@ -204,32 +562,26 @@ func (h *PdqHasher) pdqHash256FromFloatLuma(buffer1, buffer2 []float32, numRows,
 	}
 }
 
-// dct64To16 performs DCT transformation from 64x64 to 16x16
-func (h *PdqHasher) dct64To16(A, B []float32) {
-	// Temporary 16x64 matrix
-	T := make([]float32, 16*64)
+// hashBlock is the end of the pipeline, from the decimated 64x64 block to
+// the hash and quality, using buffer16x16 for the DCT coefficients
+func hashBlock(buffer64x64, buffer16x16 []float32) (*PdqHash256, int) {
+	quality := computePDQImageDomainQualityMetric(buffer64x64)
+	dct64To16(buffer64x64, buffer16x16)
+	return pdqBuffer16x16ToBits(buffer16x16), quality
+}
 
-	// First multiplication: DCT * A
-	for i := 0; i < 16; i++ {
-		for j := 0; j < 64; j++ {
-			var tij float32
-			for k := 0; k < 64; k++ {
-				tij += h.dctMatrix[i*64+k] * A[k*64+j]
-			}
-			T[i*64+j] = tij
-		}
-	}
-
-	// Second multiplication: T * DCT^T
-	for i := 0; i < 16; i++ {
-		for j := 0; j < 16; j++ {
-			var sumk float32
-			for k := 0; k < 64; k++ {
-				sumk += T[i*64+k] * h.dctMatrix[j*64+k]
-			}
-			B[i*16+j] = sumk
-		}
+// Hash64x64 hashes a 64x64 luma block directly, skipping decoding, the
+// filter and decimation, for callers that already keep 64x64 thumbnails.
+// buffer is row-major with luma on a 0 to 255 scale. The block should come
+// from an area-averaging downscale; a point-sampled one aliases and hashes
+// less stably than the full pipeline. Hash64x64 panics if buffer doesn't
+// hold 64*64 values.
+func Hash64x64(buffer []float32) (*PdqHash256, int) {
+	if len(buffer) != 64*64 {
+		panic(fmt.Sprintf("gopdq: Hash64x64 given %d values", len(buffer)))
 	}
+	var coefs [16 * 16]float32
+	return hashBlock(buffer, coefs[:])
 }
 
 // pdqBuffer16x16ToBits converts DCT output to hash bits
@@ -291,14 +643,20 @@ func computePDQImageDomainQualityMetric(buffer64x64 []float32) int {
 // decimateFloat downsamples from input resolution to 64x64
 func decimateFloat(in []float32, inNumRows, inNumCols int, output []float32) {
 	for i := 0; i < 64; i++ {
-		ini := int((float32(i) + 0.5) * float32(inNumRows) / 64)
+		ini := decimationIndex(i, inNumRows)
 		for j := 0; j < 64; j++ {
-			inj := int((float32(j) + 0.5) * float32(inNumCols) / 64)
+			inj := decimationIndex(j, inNumCols)
 			output[i*64+j] = in[ini*inNumCols+inj]
 		}
 	}
 }
 
+// decimationIndex returns the row or column of an n long dimension that
+// decimation samples for output i
+func decimationIndex(i, n int) int {
+	return int((float32(i) + 0.5) * float32(n) / 64)
+}
+
 // jaroszFilterFloat applies Jarosz filter for image smoothing
 func jaroszFilterFloat(buffer1, buffer2 []float32, numRows, numCols, windowSizeAlongRows, windowSizeAlongCols, nreps int) {
 	for i := 0; i < nreps; i++ {
@@ -307,6 +665,90 @@ func jaroszFilterFloat(buffer1, buffer2 []float32, numRows, numCols, windowSizeA
 	}
 }
 
+// jaroszFilterDecimateFloat is jaroszFilterFloat followed by decimateFloat
+// into output, with identical results, but the last pass only produces what
+// decimation reads. Its row filter keeps just the 64 sampled columns, packed
+// into a numRows x 64 band in buffer2, so the column filter runs over 64
+// columns instead of all of them.
+func jaroszFilterDecimateFloat(buffer1, buffer2 []float32, numRows, numCols, windowSizeAlongRows, windowSizeAlongCols, nreps int, output []float32) {
+	if numCols < 64 {
+		// the band wouldn't fit in the buffers, and there's nothing to save
+		jaroszFilterFloat(buffer1, buffer2, numRows, numCols, windowSizeAlongRows, windowSizeAlongCols, nreps)
+		decimateFloat(buffer1, numRows, numCols, output)
+		return
+	}
+
+	for i := 0; i < nreps-1; i++ {
+		boxAlongRowsFloat(buffer1, buffer2, numRows, numCols, windowSizeAlongRows)
+		boxAlongColsFloat(buffer2, buffer1, numRows, numCols, windowSizeAlongCols)
+	}
+
+	var cols [64]int
+	for j := range cols {
+		cols[j] = decimationIndex(j, numCols)
+	}
+	band := buffer2[:numRows*64]
+	for i := 0; i < numRows; i++ {
+		box1DSampledFloat(buffer1[i*numCols:(i+1)*numCols], band[i*64:(i+1)*64], windowSizeAlongRows, cols[:])
+	}
+	boxAlongColsFloat(band, buffer1, numRows, 64, windowSizeAlongCols)
+	for i := 0; i < 64; i++ {
+		row := decimationIndex(i, numRows)
+		copy(output[i*64:(i+1)*64], buffer1[row*64:(row+1)*64])
+	}
+}
+
+// box1DSampledFloat runs box1DFloat over invec with unit stride, storing
+// only the outputs at the positions in samples, which must be ascending, to
+// out
+func box1DSampledFloat(invec, out []float32, fullWindowSize int, samples []int) {
+	halfWindowSize := (fullWindowSize + 2) / 2
+	phase1Nreps := halfWindowSize - 1
+	phase2Nreps := fullWindowSize - halfWindowSize + 1
+	phase3Nreps := len(invec) - fullWindowSize
+	phase4Nreps := halfWindowSize - 1
+
+	li, ri, oi, k := 0, 0, 0, 0
+	sum := float32(0.0)
+	currentWindowSize := float32(0)
+	emit := func(v float32) {
+		for k < len(samples) && samples[k] == oi {
+			out[k] = v
+			k++
+		}
+		oi++
+	}
+
+	for i := 0; i < phase1Nreps; i++ {
+		sum += invec[ri]
+		currentWindowSize++
+		ri++
+	}
+
+	for i := 0; i < phase2Nreps; i++ {
+		sum += invec[ri]
+		currentWindowSize++
+		emit(sum / currentWindowSize)
+		ri++
+	}
+
+	denom := 1 / currentWindowSize
+	for i := 0; i < phase3Nreps; i++ {
+		sum += invec[ri]
+		sum -= invec[li]
+		emit(sum * denom)
+		li++
+		ri++
+	}
+
+	for i := 0; i < phase4Nreps; i++ {
+		sum -= invec[li]
+		currentWindowSize--
+		emit(sum / currentWindowSize)
+		li++
+	}
+}
+
 // boxAlongRowsFloat applies 1D box filter along rows
 func boxAlongRowsFloat(input, output []float32, numRows, numCols, windowSize int) {
 	for i := 0; i < numRows; i++ {
@@ -320,10 +762,93 @@ func boxAlongRowsFloat(input, output []float32, numRows, numCols, windowSize int
 	}
 }
 
-// boxAlongColsFloat applies 1D box filter along columns
+// colBlock is the number of columns boxAlongColsFloat filters at once
+const colBlock = 32
+
+// boxAlongColsFloat applies 1D box filter along columns. Filtering one
+// column at a time strides through memory a whole row at each step, so
+// columns are instead filtered colBlock at a time, reading each row's span
+// of the block contiguously. Every column sees exactly the arithmetic of
+// box1DFloat.
 func boxAlongColsFloat(input, output []float32, numRows, numCols, windowSize int) {
-	for j := 0; j < numCols; j++ {
-		box1DFloat(input[j:], output[j:], numRows, numCols, windowSize)
+	for j := 0; j < numCols; j += colBlock {
+		boxColsBlockFloat(input, output, numRows, numCols, j, min(colBlock, numCols-j), windowSize)
+	}
+}
+
+// boxColsBlockFloat filters the n columns starting at column j
+func boxColsBlockFloat(input, output []float32, numRows, numCols, j, n, fullWindowSize int) {
+	halfWindowSize := (fullWindowSize + 2) / 2
+	phase1Nreps := halfWindowSize - 1
+	phase2Nreps := fullWindowSize - halfWindowSize + 1
+	phase3Nreps := numRows - fullWindowSize
+	phase4Nreps := halfWindowSize - 1
+
+	var sums [colBlock]float32
+	sum := sums[:n]
+	li, ri, oi := j, j, j
+	currentWindowSize := float32(0)
+
+	for i := 0; i < phase1Nreps; i++ {
+		in := input[ri : ri+n]
+		for c := range sum {
+			sum[c] += in[c]
+		}
+		currentWindowSize++
+		ri += numCols
+	}
+
+	for i := 0; i < phase2Nreps; i++ {
+		in, out := input[ri:ri+n], output[oi:oi+n]
+		currentWindowSize++
+		for c := range sum {
+			sum[c] += in[c]
+			out[c] = sum[c] / currentWindowSize
+		}
+		ri += numCols
+		oi += numCols
+	}
+
+	denom := 1 / currentWindowSize
+	if phase3Nreps > 0 {
+		if n == colBlock {
+			boxColsRun32(input, output, li, ri, oi, numCols, phase3Nreps, sum, denom)
+		} else {
+			boxColsRunGeneric(input, output, li, ri, oi, numCols, phase3Nreps, sum, denom)
+		}
+		li += phase3Nreps * numCols
+		oi += phase3Nreps * numCols
+	}
+
+	for i := 0; i < phase4Nreps; i++ {
+		old, out := input[li:li+n], output[oi:oi+n]
+		currentWindowSize--
+		for c := range sum {
+			sum[c] -= old[c]
+			out[c] = sum[c] / currentWindowSize
+		}
+		li += numCols
+		oi += numCols
+	}
+}
+
+// boxColsRunGeneric runs the full window phase of boxColsBlockFloat for reps
+// rows, and is what boxColsRun32 calls for full blocks unless the CPU has a
+// SIMD kernel: the window's sums in sum slide down one row at a time, from input
+// rows starting at offsets ri (entering) and li (leaving), and their means
+// are written to output rows starting at oi
+func boxColsRunGeneric(input, output []float32, li, ri, oi, numCols, reps int, sum []float32, denom float32) {
+	n := len(sum)
+	for i := 0; i < reps; i++ {
+		in, old, out := input[ri:ri+n], input[li:li+n], output[oi:oi+n]
+		for c := range sum {
+			sum[c] += in[c]
+			sum[c] -= old[c]
+			out[c] = sum[c] * denom
+		}
+		li += numCols
+		ri += numCols
+		oi += numCols
 	}
 }
 
@@ -433,6 +958,23 @@ func computeJaroszFilterWindowSize(dimensionSize int) int {
 	return (dimensionSize + PDQ_JAROSZ_WINDOW_SIZE_DIVISOR - 1) / PDQ_JAROSZ_WINDOW_SIZE_DIVISOR
 }
 
+// filterParams returns the Jarosz filter's window sizes along rows and
+// columns and its number of passes for a numRows x numCols image, which are
+// the spec's unless changed by the experimental tuning options
+func (h *PdqHasher) filterParams(numRows, numCols int) (alongRows, alongCols, passes int) {
+	if h.windowDivisor > 0 {
+		d := h.windowDivisor
+		alongRows, alongCols = (numCols+d-1)/d, (numRows+d-1)/d
+	} else {
+		alongRows, alongCols = computeJaroszFilterWindowSize(numCols), computeJaroszFilterWindowSize(numRows)
+	}
+	passes = PDQ_NUM_JAROSZ_XY_PASSES
+	if h.jaroszPasses > 0 {
+		passes = h.jaroszPasses
+	}
+	return alongRows, alongCols, passes
+}
+
 // torbenMedian implements Torben's median algorithm
 // This is a direct port of the C++ implementation
 func torbenMedian(m []float32) float32 {
@@ -0,0 +1,248 @@
+package gopdq
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// PerceptualHash is a hash from any of the algorithms in this package
+type PerceptualHash interface {
+	fmt.Stringer
+	// Size is the length of the hash in bits
+	Size() int
+	// Distance is the Hamming distance to other, or -1 if other is a
+	// different kind of hash
+	Distance(other PerceptualHash) int
+}
+
+// PerceptualHasher computes one kind of perceptual hash. PdqHasher, AHash,
+// DHash and PHash implement it, so a pipeline can decode once and compute a
+// cheap 64-bit prefilter hash alongside PDQ with ComputeAll.
+type PerceptualHasher interface {
+	// Name identifies the algorithm, such as "pdq" or "dhash"
+	Name() string
+	// Compute hashes an already decoded image
+	Compute(img image.Image) (PerceptualHash, error)
+}
+
+// ComputeAll hashes img with each of hashers, returning the hashes in the
+// same order
+func ComputeAll(img image.Image, hashers ...PerceptualHasher) ([]PerceptualHash, error) {
+	out := make([]PerceptualHash, len(hashers))
+	for i, ph := range hashers {
+		h, err := ph.Compute(img)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ph.Name(), err)
+		}
+		out[i] = h
+	}
+	return out, nil
+}
+
+// Name returns "pdq"
+func (h *PdqHasher) Name() string {
+	return "pdq"
+}
+
+// Compute returns the PDQ hash of img, discarding its quality
+func (h *PdqHasher) Compute(img image.Image) (PerceptualHash, error) {
+	res, err := h.HashImage(img)
+	if err != nil {
+		return nil, err
+	}
+	return res.Hash, nil
+}
+
+// Size returns 256
+func (h *PdqHash256) Size() int {
+	return 256
+}
+
+// Distance implements PerceptualHash
+func (h *PdqHash256) Distance(other PerceptualHash) int {
+	o, ok := other.(*PdqHash256)
+	if !ok {
+		return -1
+	}
+	return h.HammingDistance(o)
+}
+
+// Hash64 is a 64-bit perceptual hash. Bit 63 corresponds to the top-left
+// cell of the algorithm's grid and cells follow in row-major order, so the
+// hex form reads in image order.
+type Hash64 uint64
+
+func (h Hash64) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// Size returns 64
+func (h Hash64) Size() int {
+	return 64
+}
+
+// Distance implements PerceptualHash. It can't tell which algorithm
+// produced a Hash64, so only compare hashes from the same one.
+func (h Hash64) Distance(other PerceptualHash) int {
+	o, ok := other.(Hash64)
+	if !ok {
+		return -1
+	}
+	return bits.OnesCount64(uint64(h ^ o))
+}
+
+// AHash is the average hash: an 8x8 luma thumbnail thresholded at its mean.
+// It is the cheapest and least robust of the algorithms here.
+type AHash struct{}
+
+func (AHash) Name() string {
+	return "ahash"
+}
+
+func (AHash) Compute(img image.Image) (PerceptualHash, error) {
+	grid, err := lumaGrid(img, 8, 8)
+	if err != nil {
+		return nil, err
+	}
+	var mean float64
+	for _, v := range grid {
+		mean += v
+	}
+	mean /= float64(len(grid))
+	return thresholdBits(grid, mean), nil
+}
+
+// DHash is the difference hash: each bit records whether a cell of a 9x8
+// luma thumbnail is brighter than its right-hand neighbour
+type DHash struct{}
+
+func (DHash) Name() string {
+	return "dhash"
+}
+
+func (DHash) Compute(img image.Image) (PerceptualHash, error) {
+	grid, err := lumaGrid(img, 9, 8)
+	if err != nil {
+		return nil, err
+	}
+	var h uint64
+	for row := 0; row < 8; row++ {
+		for col := 0; col < 8; col++ {
+			h <<= 1
+			if grid[row*9+col] > grid[row*9+col+1] {
+				h |= 1
+			}
+		}
+	}
+	return Hash64(h), nil
+}
+
+// PHash is the 64-bit DCT hash: the 8x8 lowest frequencies of the DCT of a
+// 32x32 luma thumbnail, thresholded at their median. It is PDQ's smaller
+// ancestor.
+type PHash struct{}
+
+func (PHash) Name() string {
+	return "phash"
+}
+
+func (PHash) Compute(img image.Image) (PerceptualHash, error) {
+	grid, err := lumaGrid(img, 32, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	// separable DCT-II, keeping only the low 8 frequencies of each pass
+	var rows [32 * 8]float64
+	for y := 0; y < 32; y++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < 32; x++ {
+				sum += grid[y*32+x] * dct32[u][x]
+			}
+			rows[y*8+u] = sum
+		}
+	}
+	coeffs := make([]float64, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < 32; y++ {
+				sum += rows[y*8+u] * dct32[v][y]
+			}
+			coeffs[v*8+u] = sum
+		}
+	}
+
+	// the DC term swamps the median, so leave it out
+	sorted := append([]float64(nil), coeffs[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	return thresholdBits(coeffs, median), nil
+}
+
+// dct32 holds the 32-point DCT-II basis for the 8 lowest frequencies
+var dct32 = func() (m [8][32]float64) {
+	for u := range m {
+		for x := range m[u] {
+			m[u][x] = math.Cos(math.Pi / 32 * (float64(x) + 0.5) * float64(u))
+		}
+	}
+	return m
+}()
+
+// thresholdBits packs the 64 values, first value in the top bit, setting
+// those above t
+func thresholdBits(vals []float64, t float64) Hash64 {
+	var h uint64
+	for _, v := range vals[:64] {
+		h <<= 1
+		if v > t {
+			h |= 1
+		}
+	}
+	return Hash64(h)
+}
+
+// lumaGrid shrinks img to a w x h grid of mean luma values by area averaging
+func lumaGrid(img image.Image, w, h int) ([]float64, error) {
+	if err := checkImage(img); err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	rgba := toRGBA(img)
+	numCols, numRows := b.Dx(), b.Dy()
+
+	sums := make([]float64, w*h)
+	counts := make([]int, w*h)
+	for row := 0; row < numRows; row++ {
+		cy := row * h / numRows
+		pix := rgba.Pix[row*rgba.Stride:]
+		for col := 0; col < numCols; col++ {
+			cell := cy*w + col*w/numCols
+			sums[cell] += LUMA_FROM_R_COEFF*float64(pix[col*4]) +
+				LUMA_FROM_G_COEFF*float64(pix[col*4+1]) +
+				LUMA_FROM_B_COEFF*float64(pix[col*4+2])
+			counts[cell]++
+		}
+	}
+
+	for i := range sums {
+		if counts[i] > 0 {
+			sums[i] /= float64(counts[i])
+			continue
+		}
+		// images smaller than the grid leave cells empty; sample the
+		// pixel under the cell's centre instead
+		col := (2*(i%w) + 1) * numCols / (2 * w)
+		row := (2*(i/w) + 1) * numRows / (2 * h)
+		pix := rgba.Pix[row*rgba.Stride+col*4:]
+		sums[i] = LUMA_FROM_R_COEFF*float64(pix[0]) +
+			LUMA_FROM_G_COEFF*float64(pix[1]) +
+			LUMA_FROM_B_COEFF*float64(pix[2])
+	}
+	return sums, nil
+}
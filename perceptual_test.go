@@ -0,0 +1,65 @@
+package gopdq
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"os"
+	"testing"
+)
+
+func TestPerceptualHashers(t *testing.T) {
+	f, err := os.Open("cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cat, err := jpeg.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a heavily recompressed copy should hash nearby
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, cat, &jpeg.Options{Quality: 20}); err != nil {
+		t.Fatal(err)
+	}
+	recompressed, err := jpeg.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := testPattern(300, 200)
+
+	hashers := []PerceptualHasher{NewPdqHasher(), AHash{}, DHash{}, PHash{}}
+	orig, err := ComputeAll(cat, hashers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	near, err := ComputeAll(recompressed, hashers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	far, err := ComputeAll(other, hashers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, ph := range hashers {
+		size := orig[i].Size()
+		if dn, df := orig[i].Distance(near[i]), orig[i].Distance(far[i]); dn > size/8 || df < size/4 {
+			t.Errorf("%s: recompressed copy at %d bits, unrelated image at %d bits of %d", ph.Name(), dn, df, size)
+		}
+	}
+
+	if d := orig[0].Distance(orig[1]); d != -1 {
+		t.Errorf("comparing pdq to ahash gave %d", d)
+	}
+
+	// images smaller than the grid still hash
+	tiny := image.NewGray(image.Rect(0, 0, 4, 3))
+	for _, ph := range []PerceptualHasher{AHash{}, DHash{}, PHash{}} {
+		if _, err := ph.Compute(tiny); err != nil {
+			t.Errorf("%s: %v", ph.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package gopdq
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// NetPBM formats are registered with the image package for the corpora and
+// reference tooling that use them: PBM, PGM and PPM in both their plain
+// (ASCII, P1 to P3) and raw (binary, P4 to P6) forms. Gray decodes to
+// *image.Gray, or *image.Gray16 when the maximum value exceeds 255; color
+// likewise to *image.RGBA or *image.RGBA64. Only the first image of a
+// multi-image file is read, and PAM (P7) isn't supported.
+func init() {
+	for _, f := range []struct{ name, plain, raw string }{
+		{"pbm", "P1", "P4"},
+		{"pgm", "P2", "P5"},
+		{"ppm", "P3", "P6"},
+	} {
+		image.RegisterFormat(f.name, f.plain, decodePNM, decodePNMConfig)
+		image.RegisterFormat(f.name, f.raw, decodePNM, decodePNMConfig)
+	}
+}
+
+// pnmHeader is the header of a NetPBM image
+type pnmHeader struct {
+	magic         byte // the digit after P
+	width, height int
+	maxval        int
+}
+
+func (p *pnmHeader) channels() int {
+	if p.magic == '3' || p.magic == '6' {
+		return 3
+	}
+	return 1
+}
+
+func (p *pnmHeader) plain() bool {
+	return p.magic <= '3'
+}
+
+// readPNMHeader reads the header up to and including the single whitespace
+// byte that ends it
+func readPNMHeader(br *bufio.Reader) (*pnmHeader, error) {
+	var magic [2]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil {
+		return nil, err
+	}
+	if magic[0] != 'P' || magic[1] < '1' || magic[1] > '6' {
+		return nil, errors.New("pnm: not a NetPBM image")
+	}
+	p := &pnmHeader{magic: magic[1], maxval: 1}
+
+	fields := []*int{&p.width, &p.height}
+	if p.magic != '1' && p.magic != '4' {
+		fields = append(fields, &p.maxval)
+	}
+	for _, f := range fields {
+		v, err := readPNMInt(br)
+		if err != nil {
+			return nil, fmt.Errorf("pnm: invalid header: %w", err)
+		}
+		*f = v
+	}
+	if p.width < 1 || p.height < 1 {
+		return nil, fmt.Errorf("pnm: invalid dimensions %dx%d", p.width, p.height)
+	}
+	if p.maxval < 1 || p.maxval > 65535 {
+		return nil, fmt.Errorf("pnm: invalid maximum value %d", p.maxval)
+	}
+	// the header ends with one whitespace byte, after which a raw raster
+	// starts immediately
+	if c, err := br.ReadByte(); err != nil {
+		return nil, err
+	} else if !isPNMSpace(c) {
+		return nil, errors.New("pnm: invalid header: missing whitespace")
+	}
+	return p, nil
+}
+
+// readPNMInt reads a decimal number, skipping whitespace and comments
+// before it and stopping at the byte after it, which is left unread
+func readPNMInt(br *bufio.Reader) (int, error) {
+	if err := skipPNMSpace(br); err != nil {
+		return 0, err
+	}
+	n, digits := 0, 0
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF && digits > 0 {
+			return n, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if c < '0' || c > '9' {
+			br.UnreadByte()
+			if digits == 0 {
+				return 0, fmt.Errorf("unexpected %q", c)
+			}
+			return n, nil
+		}
+		if n > (1<<31)/10 {
+			return 0, errors.New("number too large")
+		}
+		n = n*10 + int(c-'0')
+		digits++
+	}
+}
+
+// skipPNMSpace skips whitespace and comments, which run from # to the end
+// of the line
+func skipPNMSpace(br *bufio.Reader) error {
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case c == '#':
+			for c != '\n' && c != '\r' {
+				if c, err = br.ReadByte(); err != nil {
+					return err
+				}
+			}
+		case !isPNMSpace(c):
+			return br.UnreadByte()
+		}
+	}
+}
+
+func isPNMSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func decodePNMConfig(r io.Reader) (image.Config, error) {
+	p, err := readPNMHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	cfg := image.Config{Width: p.width, Height: p.height}
+	switch {
+	case p.channels() == 1 && p.maxval < 256:
+		cfg.ColorModel = color.GrayModel
+	case p.channels() == 1:
+		cfg.ColorModel = color.Gray16Model
+	case p.maxval < 256:
+		cfg.ColorModel = color.RGBAModel
+	default:
+		cfg.ColorModel = color.RGBA64Model
+	}
+	return cfg, nil
+}
+
+func decodePNM(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	p, err := readPNMHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if int64(p.width)*int64(p.height) > (1<<31)/8 {
+		return nil, fmt.Errorf("pnm: %dx%d image is too large", p.width, p.height)
+	}
+	rect := image.Rect(0, 0, p.width, p.height)
+
+	if p.magic == '1' || p.magic == '4' {
+		return decodePBM(br, p, rect)
+	}
+
+	wide := p.maxval > 255
+	scale := func(v int) int {
+		if wide {
+			return (v*65535 + p.maxval/2) / p.maxval
+		}
+		return (v*255 + p.maxval/2) / p.maxval
+	}
+
+	// samples go straight into the image, a row at a time: bpc bytes per
+	// channel, and out channels per pixel, alpha included for color
+	var img image.Image
+	var pix []byte
+	var stride int
+	bpc, out := 1, 1
+	switch {
+	case p.channels() == 1 && !wide:
+		g := image.NewGray(rect)
+		img, pix, stride = g, g.Pix, g.Stride
+	case p.channels() == 1:
+		g := image.NewGray16(rect)
+		img, pix, stride, bpc = g, g.Pix, g.Stride, 2
+	case !wide:
+		c := image.NewRGBA(rect)
+		img, pix, stride, out = c, c.Pix, c.Stride, 4
+	default:
+		c := image.NewRGBA64(rect)
+		img, pix, stride, bpc, out = c, c.Pix, c.Stride, 2, 4
+	}
+
+	ch := p.channels()
+	row := make([]int, p.width*ch)
+	var raw []byte
+	if !p.plain() {
+		raw = make([]byte, len(row)*bpc)
+	}
+	for y := 0; y < p.height; y++ {
+		if err := readPNMRow(br, p, raw, row, y*len(row)); err != nil {
+			return nil, err
+		}
+		for x := 0; x < p.width; x++ {
+			o := y*stride + x*out*bpc
+			for c := 0; c < ch; c++ {
+				v := scale(row[x*ch+c])
+				if bpc == 1 {
+					pix[o+c] = uint8(v)
+				} else {
+					pix[o+2*c], pix[o+2*c+1] = uint8(v>>8), uint8(v)
+				}
+			}
+			if out == 4 {
+				for i := 3 * bpc; i < 4*bpc; i++ {
+					pix[o+i] = 0xff
+				}
+			}
+		}
+	}
+	return img, nil
+}
+
+// readPNMRow reads one row of a gray or color raster into samples, checking
+// each against the maximum. Raw rasters are read whole into raw, one or two
+// big-endian bytes a sample. first is the index of the row's first sample.
+func readPNMRow(br *bufio.Reader, p *pnmHeader, raw []byte, samples []int, first int) error {
+	if p.plain() {
+		for i := range samples {
+			v, err := readPNMInt(br)
+			if err != nil {
+				return fmt.Errorf("pnm: sample %d: %w", first+i, unexpectedEOF(err))
+			}
+			samples[i] = v
+		}
+	} else {
+		if _, err := io.ReadFull(br, raw); err != nil {
+			return fmt.Errorf("pnm: %w", unexpectedEOF(err))
+		}
+		wide := len(raw) > len(samples)
+		for i := range samples {
+			if wide {
+				samples[i] = int(raw[2*i])<<8 | int(raw[2*i+1])
+			} else {
+				samples[i] = int(raw[i])
+			}
+		}
+	}
+	for i, v := range samples {
+		if v > p.maxval {
+			return fmt.Errorf("pnm: sample %d is %d, above the maximum %d", first+i, v, p.maxval)
+		}
+	}
+	return nil
+}
+
+// decodePBM reads a bitmap, in which 1 is black
+func decodePBM(br *bufio.Reader, p *pnmHeader, rect image.Rectangle) (image.Image, error) {
+	img := image.NewGray(rect)
+	if p.plain() {
+		// the digits of a plain bitmap needn't be separated
+		for i := range img.Pix {
+			if err := skipPNMSpace(br); err != nil {
+				return nil, fmt.Errorf("pnm: pixel %d: %w", i, unexpectedEOF(err))
+			}
+			c, _ := br.ReadByte()
+			switch c {
+			case '0':
+				img.Pix[i] = 0xff
+			case '1':
+			default:
+				return nil, fmt.Errorf("pnm: pixel %d: unexpected %q", i, c)
+			}
+		}
+		return img, nil
+	}
+
+	// raw rows are padded to whole bytes
+	row := make([]byte, (p.width+7)/8)
+	for y := 0; y < p.height; y++ {
+		if _, err := io.ReadFull(br, row); err != nil {
+			return nil, fmt.Errorf("pnm: %w", unexpectedEOF(err))
+		}
+		pix := img.Pix[y*img.Stride:]
+		for x := 0; x < p.width; x++ {
+			if row[x/8]&(0x80>>(x%8)) == 0 {
+				pix[x] = 0xff
+			}
+		}
+	}
+	return img, nil
+}
+
+// unexpectedEOF turns the end of input in the middle of a raster into
+// io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,144 @@
+package gopdq
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// encodePNM writes img in the given NetPBM form, scaling samples to maxval
+func encodePNM(img *image.RGBA, magic string, maxval int) []byte {
+	b := img.Bounds()
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "%s\n# written by pnm_test.go\n%d %d\n", magic, b.Dx(), b.Dy())
+	if magic != "P1" && magic != "P4" {
+		fmt.Fprintf(buf, "%d\n", maxval)
+	}
+	channels := 1
+	if magic == "P3" || magic == "P6" {
+		channels = 3
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var bits byte
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			samples := []uint8{c.R, c.G, c.B}[:channels]
+			for _, s := range samples {
+				v := int(s) * maxval / 255
+				switch magic {
+				case "P1":
+					fmt.Fprintf(buf, "%d", 1-v)
+				case "P2", "P3":
+					fmt.Fprintf(buf, "%d ", v)
+				case "P4":
+					bits |= byte(1-v) << (7 - x%8)
+					if x%8 == 7 || x == b.Max.X-1 {
+						buf.WriteByte(bits)
+						bits = 0
+					}
+				default:
+					if maxval > 255 {
+						buf.WriteByte(byte(v >> 8))
+					}
+					buf.WriteByte(byte(v))
+				}
+			}
+		}
+		if magic <= "P3" {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestPNM(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	src := image.NewRGBA(image.Rect(0, 0, 37, 23))
+	rng.Read(src.Pix)
+	gray, bits := image.NewRGBA(src.Rect), image.NewRGBA(src.Rect)
+	for i := 0; i < len(src.Pix); i += 4 {
+		src.Pix[i+3] = 0xff
+		g := src.Pix[i]
+		copy(gray.Pix[i:], []uint8{g, g, g, 0xff})
+		b := (g >> 7) * 0xff
+		copy(bits.Pix[i:], []uint8{b, b, b, 0xff})
+	}
+
+	cases := []struct {
+		magic  string
+		maxval int
+		img    *image.RGBA
+		format string
+	}{
+		{"P1", 1, bits, "pbm"},
+		{"P4", 1, bits, "pbm"},
+		{"P2", 255, gray, "pgm"},
+		{"P5", 255, gray, "pgm"},
+		{"P5", 65535, gray, "pgm"},
+		{"P3", 255, src, "ppm"},
+		{"P6", 255, src, "ppm"},
+		{"P6", 65535, src, "ppm"},
+	}
+	for _, c := range cases {
+		name := fmt.Sprintf("%s/%d", c.magic, c.maxval)
+		img, format, err := image.Decode(bytes.NewReader(encodePNM(c.img, c.magic, c.maxval)))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if format != c.format {
+			t.Errorf("%s: decoded as %s, expected %s", name, format, c.format)
+		}
+		if img.Bounds() != c.img.Bounds() {
+			t.Errorf("%s: bounds %v, expected %v", name, img.Bounds(), c.img.Bounds())
+			continue
+		}
+		for y := 0; y < c.img.Rect.Dy(); y++ {
+			for x := 0; x < c.img.Rect.Dx(); x++ {
+				if got, want := color.RGBAModel.Convert(img.At(x, y)), c.img.At(x, y); got != want {
+					t.Fatalf("%s: pixel (%d, %d) is %v, expected %v", name, x, y, got, want)
+				}
+			}
+		}
+	}
+
+	// hashing a PPM matches hashing its pixels
+	data := encodePNM(src, "P6", 255)
+	res, err := NewPdqHasher().FromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := NewPdqHasher().HashImage(src)
+	if !res.Hash.Equal(want.Hash) || res.Stats.Decoder != "ppm" {
+		t.Errorf("PPM hashed to %s by %s, expected %s", res.Hash, res.Stats.Decoder, want.Hash)
+	}
+	if _, err := NewPdqHasher(WithMaxPixels(100)).FromBytes(data); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("expected the pixel limit to apply, got %v", err)
+	}
+}
+
+func TestPNMErrors(t *testing.T) {
+	raw := encodePNM(image.NewRGBA(image.Rect(0, 0, 8, 8)), "P6", 255)
+	for name, data := range map[string]string{
+		"truncated":     string(raw[:len(raw)-10]),
+		"zero width":    "P5 0 8 255\n",
+		"maxval":        "P2 1 1 70000\n1\n",
+		"above maxval":  "P2 2 1 15\n7 16\n",
+		"plain letters": "P1 2 1\n0x",
+		"header":        "P3 4\n",
+	} {
+		_, err := NewPdqHasher().FromReader(strings.NewReader(data))
+		if !errors.Is(err, ErrDecodeFailed) {
+			t.Errorf("%s: expected ErrDecodeFailed, got %v", name, err)
+		}
+	}
+	if _, err := decodePNM(bytes.NewReader(raw[:len(raw)-10])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF for a short raster, got %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package gopdq
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// PdqPreview is a 64-bit reduction of a PDQ hash for places where 256 bits is
+// too expensive, such as bloom filters and row keys. It keeps the bits of the
+// 8x8 lowest-frequency DCT coefficients, which are the most stable under
+// edits. Bit 63 is coefficient (0, 0) and the rest follow in row-major order.
+//
+// The preview bits are a subset of the full hash, so the preview distance is
+// a lower bound on the full distance: a preview pair further apart than a
+// threshold is definitely not a match at that threshold, but a close preview
+// pair still needs checking against the full hashes.
+type PdqPreview uint64
+
+// Preview returns the 64-bit preview of h
+func (h *PdqHash256) Preview() PdqPreview {
+	var p uint64
+	for row := 0; row < 8; row++ {
+		// bits row*16 .. row*16+7 are the low half of word row
+		for col := 0; col < 8; col++ {
+			p <<= 1
+			p |= uint64(h.w[row]>>col) & 1
+		}
+	}
+	return PdqPreview(p)
+}
+
+// Distance is the Hamming distance between two previews
+func (p PdqPreview) Distance(other PdqPreview) int {
+	return bits.OnesCount64(uint64(p ^ other))
+}
+
+func (p PdqPreview) String() string {
+	return fmt.Sprintf("%016x", uint64(p))
+}
@@ -0,0 +1,215 @@
+package gopdq
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// Mode selects between the optimized float pipeline and one that follows
+// the C++ reference implementation operation for operation.
+//
+// The divergences of Fast from the reference, which ReferenceExact undoes:
+//
+//   - luma: gray images are weighted as if expanded to RGB, whose weights
+//     don't sum to exactly 1 in float32, where the reference copies the gray
+//     level. Images with alpha are premultiplied before weighting, where the
+//     reference ignores alpha. The compiler may fuse the weighted sum into
+//     fused multiply-adds on arm64 and other architectures having them.
+//   - box filter: the full window phase multiplies by the reciprocal of the
+//     window size instead of dividing by it.
+//   - decimation: sample positions are computed in float32 rather than
+//     double. This only matters for dimensions beyond 2^17.
+//   - DCT: coefficients come from an even/odd folded transform, whose sums
+//     round differently from the reference's 16x64 matrix products.
+//
+// Decoders are out of scope: the standard library's JPEG decoder doesn't
+// produce libjpeg's pixels, so exact parity on JPEGs also requires the cgo
+// libjpeg decoder, and the same libjpeg version as the reference build.
+type Mode int
+
+const (
+	// Fast is the default pipeline. It diverges from the reference in
+	// rounding only, which flips the bits whose DCT coefficients lie within
+	// rounding error of the median, most often in noisy images.
+	Fast Mode = iota
+	// ReferenceExact aims for bit-identical hashes to the C++ reference
+	// given the same decoded pixels, by following its arithmetic operation
+	// for operation. That is checked by the regtest-tagged conformance
+	// test, which needs the reference's vectors fetched with
+	// conformance/fetchregtest; no test in the default run compares against
+	// the reference's own output. Its filter and DCT take several times as
+	// long as Fast's, though decoding still dominates for most files.
+	ReferenceExact
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Fast:
+		return "fast"
+	case ReferenceExact:
+		return "reference-exact"
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+// ParseMode parses the names String returns, for command line flags
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "fast":
+		return Fast, nil
+	case "reference-exact", "reference":
+		return ReferenceExact, nil
+	}
+	return 0, fmt.Errorf("unknown mode %q, expected fast or reference-exact", s)
+}
+
+// refDCTMatrix is the reference's 16x64 DCT matrix, computed in double and
+// stored as float32 as it does
+var refDCTMatrix = func() (d [16 * 64]float32) {
+	scale := math.Sqrt(2.0 / 64.0)
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 64; j++ {
+			d[i*64+j] = float32(scale * math.Cos(math.Pi/2/64.0*float64(i+1)*float64(2*j+1)))
+		}
+	}
+	return d
+}()
+
+// fillLumaReference converts img to luma as the reference does: gray levels
+// are copied and color is weighted from the unpremultiplied channels. The
+// explicit conversions keep each product rounded to float32, preventing
+// fused multiply-adds.
+func (h *PdqHasher) fillLumaReference(img image.Image, luma []float32) {
+	b := img.Bounds()
+	numCols, numRows := b.Dx(), b.Dy()
+	wr, wg, wb := lumaWeights[h.luma].r, lumaWeights[h.luma].g, lumaWeights[h.luma].b
+
+	if src, ok := img.(*image.Gray); ok {
+		for row := 0; row < numRows; row++ {
+			pix := src.Pix[row*src.Stride:]
+			for col := 0; col < numCols; col++ {
+				luma[row*numCols+col] = float32(pix[col])
+			}
+		}
+		return
+	}
+
+	var pix []uint8
+	var stride int
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		rgba := toRGBA(img)
+		pix, stride = rgba.Pix, rgba.Stride
+	} else {
+		nrgba, ok := img.(*image.NRGBA)
+		if !ok {
+			nrgba = image.NewNRGBA(b)
+			draw.Draw(nrgba, nrgba.Bounds(), img, b.Min, draw.Src)
+		}
+		pix, stride = nrgba.Pix, nrgba.Stride
+	}
+	for row := 0; row < numRows; row++ {
+		for col := 0; col < numCols; col++ {
+			offs := row*stride + col*4
+			r, g, b := float32(pix[offs]), float32(pix[offs+1]), float32(pix[offs+2])
+			luma[row*numCols+col] = float32(wr*r) + float32(wg*g) + float32(wb*b)
+		}
+	}
+}
+
+// hashLumaReference is pdqHash256FromFloatLuma as the reference computes it:
+// every filter pass over the full image, dividing in every phase, then
+// decimation and the matrix DCT
+func (h *PdqHasher) hashLumaReference(buffer1, buffer2 []float32, numRows, numCols int, buffer64x64, buffer16x16 []float32) HashAndQuality {
+	windowSizeAlongRows, windowSizeAlongCols, passes := h.filterParams(numRows, numCols)
+	for i := 0; i < passes; i++ {
+		for r := 0; r < numRows; r++ {
+			box1DReference(buffer1[r*numCols:], buffer2[r*numCols:], numCols, 1, windowSizeAlongRows)
+		}
+		for c := 0; c < numCols; c++ {
+			box1DReference(buffer2[c:], buffer1[c:], numRows, numCols, windowSizeAlongCols)
+		}
+	}
+	for i := 0; i < 64; i++ {
+		ini := int((float64(i) + 0.5) * float64(numRows) / 64)
+		for j := 0; j < 64; j++ {
+			inj := int((float64(j) + 0.5) * float64(numCols) / 64)
+			buffer64x64[i*64+j] = buffer1[ini*numCols+inj]
+		}
+	}
+
+	quality := computePDQImageDomainQualityMetric(buffer64x64)
+	dct64To16Reference(buffer64x64, buffer16x16)
+	return HashAndQuality{
+		Hash:    pdqBuffer16x16ToBits(buffer16x16),
+		Quality: quality,
+	}
+}
+
+// box1DReference is box1DFloat dividing by the window size in its full
+// window phase too
+func box1DReference(invec, outVec []float32, vectorLength, stride, fullWindowSize int) {
+	halfWindowSize := (fullWindowSize + 2) / 2
+	phase1Nreps := halfWindowSize - 1
+	phase2Nreps := fullWindowSize - halfWindowSize + 1
+	phase3Nreps := vectorLength - fullWindowSize
+	phase4Nreps := halfWindowSize - 1
+
+	li, ri, oi := 0, 0, 0
+	sum := float32(0)
+	currentWindowSize := float32(0)
+
+	for i := 0; i < phase1Nreps; i++ {
+		sum += invec[ri]
+		currentWindowSize++
+		ri += stride
+	}
+	for i := 0; i < phase2Nreps; i++ {
+		sum += invec[ri]
+		currentWindowSize++
+		outVec[oi] = sum / currentWindowSize
+		ri += stride
+		oi += stride
+	}
+	for i := 0; i < phase3Nreps; i++ {
+		sum += invec[ri]
+		sum -= invec[li]
+		outVec[oi] = sum / currentWindowSize
+		li += stride
+		ri += stride
+		oi += stride
+	}
+	for i := 0; i < phase4Nreps; i++ {
+		sum -= invec[li]
+		currentWindowSize--
+		outVec[oi] = sum / currentWindowSize
+		li += stride
+		oi += stride
+	}
+}
+
+// dct64To16Reference computes B = D A D^T with the reference's loops: T =
+// D A first, each sum accumulated in order of k
+func dct64To16Reference(A, B []float32) {
+	D := &refDCTMatrix
+	var T [16 * 64]float32
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 64; j++ {
+			var sum float32
+			for k := 0; k < 64; k++ {
+				sum += float32(D[i*64+k] * A[k*64+j])
+			}
+			T[i*64+j] = sum
+		}
+	}
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 16; j++ {
+			var sum float32
+			for k := 0; k < 64; k++ {
+				sum += float32(T[i*64+k] * D[j*64+k])
+			}
+			B[i*16+j] = sum
+		}
+	}
+}
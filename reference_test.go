@@ -0,0 +1,118 @@
+package gopdq
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestParseMode(t *testing.T) {
+	for _, m := range []Mode{Fast, ReferenceExact} {
+		got, err := ParseMode(m.String())
+		if err != nil || got != m {
+			t.Fatalf("ParseMode(%q) = %v, %v", m.String(), got, err)
+		}
+	}
+	if _, err := ParseMode("exact"); err == nil {
+		t.Fatal("expected an error for an unknown mode")
+	}
+}
+
+func TestReferenceDCT(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	in := make([]float32, 64*64)
+	for i := range in {
+		in[i] = rng.Float32() * 255
+	}
+	got := make([]float32, 16*16)
+	dct64To16Reference(in, got)
+
+	// against the transform in double precision
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 16; j++ {
+			var want float64
+			for r := 0; r < 64; r++ {
+				for c := 0; c < 64; c++ {
+					want += float64(in[r*64+c]) *
+						math.Cos(math.Pi/128*float64((i+1)*(2*r+1))) *
+						math.Cos(math.Pi/128*float64((j+1)*(2*c+1)))
+				}
+			}
+			want *= 2.0 / 64
+			if d := math.Abs(float64(got[i*16+j]) - want); d > 1e-2 {
+				t.Fatalf("coefficient %d,%d: got %v, expected %v", i, j, got[i*16+j], want)
+			}
+		}
+	}
+}
+
+func TestReferenceLuma(t *testing.T) {
+	h := NewPdqHasher(WithMode(ReferenceExact))
+
+	// gray levels are taken as they are, where the weights would round 35
+	// of the 256 levels
+	gray := image.NewGray(image.Rect(0, 0, 256, 1))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i)
+	}
+	luma := make([]float32, 256)
+	h.fillFloatLumaFromImage(gray, luma)
+	for i, v := range luma {
+		if v != float32(i) {
+			t.Fatalf("gray level %d became %v", i, v)
+		}
+	}
+
+	// alpha is ignored rather than premultiplied
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{200, 100, 50, 255})
+	img.SetNRGBA(1, 0, color.NRGBA{200, 100, 50, 10})
+	h.fillFloatLumaFromImage(img, luma[:2])
+	if luma[0] != luma[1] {
+		t.Fatalf("translucent pixel has luma %v, opaque one %v", luma[1], luma[0])
+	}
+}
+
+// TestModeDivergence tracks how far the fast pipeline strays from the
+// reference-exact one on natural images, where it should be a bit at most
+func TestModeDivergence(t *testing.T) {
+	fast := NewPdqHasher()
+	exact := NewPdqHasher(WithMode(ReferenceExact))
+	images := []image.Image{testPattern(517, 389), testPattern(1024, 768), testPattern(97, 211)}
+	for _, path := range []string{"cat.jpg", "testdata/rgb.jpg", "testdata/gray.jpg", "testdata/cmyk.jpg", "testdata/progressive.jpg"} {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, _, err := image.Decode(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, img)
+	}
+	for i, img := range images {
+		a, err := fast.HashImage(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := exact.HashImage(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := a.Hash.HammingDistance(b.Hash); d > 1 || a.Quality != b.Quality {
+			t.Errorf("image %d: fast and reference-exact differ by %d bits, quality %d vs %d", i, d, a.Quality, b.Quality)
+		}
+	}
+
+	// the later of WithMode and WithDeterministic wins
+	if h := NewPdqHasher(WithDeterministic(), WithMode(ReferenceExact)); h.deterministic || h.mode != ReferenceExact {
+		t.Fatal("WithMode didn't replace WithDeterministic")
+	}
+	if h := NewPdqHasher(WithMode(ReferenceExact), WithDeterministic()); !h.deterministic || h.mode != Fast {
+		t.Fatal("WithDeterministic didn't replace WithMode")
+	}
+}
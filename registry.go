@@ -0,0 +1,59 @@
+package gopdq
+
+import (
+	"bufio"
+	"image"
+	"io"
+	"sync"
+)
+
+// decoder is a format added with RegisterDecoder
+type decoder struct {
+	name   string
+	sniff  func([]byte) bool
+	decode func(io.Reader) (image.Image, error)
+}
+
+var (
+	decodersLk sync.RWMutex
+	decoders   []decoder
+)
+
+// RegisterDecoder adds an image format for FromReader, FromBytes and
+// FromFile to decode. sniff is given the start of the input, up to 64KiB,
+// and reports whether it is in the format; decode then reads the whole
+// input. Registered formats are sniffed in the order they were added and
+// before the standard library's, so one can take over a built-in format.
+// Registering a name again replaces the earlier decoder.
+//
+// The dimensions of a registered format aren't known until it is decoded,
+// so the size limits only apply to the decoded image.
+func RegisterDecoder(name string, sniff func([]byte) bool, decode func(io.Reader) (image.Image, error)) {
+	decodersLk.Lock()
+	defer decodersLk.Unlock()
+	d := decoder{name: name, sniff: sniff, decode: decode}
+	for i := range decoders {
+		if decoders[i].name == name {
+			decoders[i] = d
+			return
+		}
+	}
+	decoders = append(decoders, d)
+}
+
+// sniffDecoder returns the registered decoder claiming the input buffered
+// in br, if any
+func sniffDecoder(br *bufio.Reader) (decoder, bool) {
+	decodersLk.RLock()
+	defer decodersLk.RUnlock()
+	if len(decoders) == 0 {
+		return decoder{}, false
+	}
+	head, _ := br.Peek(headerPeekSize)
+	for _, d := range decoders {
+		if d.sniff(head) {
+			return d, true
+		}
+	}
+	return decoder{}, false
+}
@@ -0,0 +1,112 @@
+package gopdq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"strings"
+	"testing"
+)
+
+// rawMagic starts a toy format of two big-endian uint16 dimensions followed
+// by 8-bit gray pixels
+const rawMagic = "PDQRAW\n"
+
+func encodeRaw(img *image.Gray) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(rawMagic)
+	b := img.Bounds()
+	binary.Write(&buf, binary.BigEndian, [2]uint16{uint16(b.Dx()), uint16(b.Dy())})
+	buf.Write(img.Pix)
+	return buf.Bytes()
+}
+
+func decodeRaw(r io.Reader) (image.Image, error) {
+	if _, err := io.ReadFull(r, make([]byte, len(rawMagic))); err != nil {
+		return nil, err
+	}
+	var dims [2]uint16
+	if err := binary.Read(r, binary.BigEndian, &dims); err != nil {
+		return nil, err
+	}
+	img := image.NewGray(image.Rect(0, 0, int(dims[0]), int(dims[1])))
+	if _, err := io.ReadFull(r, img.Pix); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	RegisterDecoder("pdqraw", func(b []byte) bool {
+		return bytes.HasPrefix(b, []byte(rawMagic))
+	}, decodeRaw)
+
+	img := image.NewGray(image.Rect(0, 0, 120, 90))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i*7 ^ i/120*13)
+	}
+	data := encodeRaw(img)
+
+	hasher := NewPdqHasher()
+	want, err := hasher.HashImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := hasher.FromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Hash.Equal(want.Hash) {
+		t.Fatalf("decoded hash %s, expected %s", got.Hash, want.Hash)
+	}
+	if got, err = hasher.FromReader(bytes.NewReader(data)); err != nil || !got.Hash.Equal(want.Hash) {
+		t.Fatalf("FromReader: %v, %v", got, err)
+	}
+
+	_, err = hasher.FromBytes(data[:len(data)-10])
+	if !errors.Is(err, ErrDecodeFailed) {
+		t.Fatalf("truncated input: expected ErrDecodeFailed, got %v", err)
+	}
+
+	// other formats still go to the standard decoders
+	if _, err := hasher.FromFile("testdata/rgb.jpg"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecoders(t *testing.T) {
+	RegisterDecoder("pdqraw", func(b []byte) bool {
+		return bytes.HasPrefix(b, []byte(rawMagic))
+	}, decodeRaw)
+
+	ds := Decoders()
+	if ds[0].Format != "pdqraw" || !ds[0].Registered {
+		t.Errorf("registered decoder isn't listed first: %+v", ds[0])
+	}
+	formats := make(map[string]DecoderInfo)
+	for _, d := range ds {
+		if _, ok := formats[d.Format]; !ok {
+			formats[d.Format] = d
+		}
+	}
+	if jpeg := formats["jpeg"]; jpeg.Scaled != canScaleJpeg {
+		t.Errorf("jpeg decoder %+v, expected scaling %v", jpeg, canScaleJpeg)
+	}
+	// the fuzz tests link in image/gif, and nothing links in a WebP decoder
+	if _, ok := formats["gif"]; !ok {
+		t.Errorf("gif isn't listed: %+v", ds)
+	}
+	if _, ok := formats["webp"]; ok {
+		t.Errorf("webp is listed: %+v", ds)
+	}
+
+	if err := RequireDecoders("jpeg", "png", "pdqraw"); err != nil {
+		t.Error(err)
+	}
+	err := RequireDecoders("jpeg", "webp", "heic")
+	if !errors.Is(err, ErrUnsupportedFormat) || !strings.Contains(err.Error(), "webp, heic") {
+		t.Errorf("expected webp and heic to be missing, got %v", err)
+	}
+}
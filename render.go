@@ -0,0 +1,59 @@
+package gopdq
+
+import (
+	"image"
+	"image/color"
+)
+
+// Colors used by the renderers
+var (
+	RenderUnset   = color.Gray{Y: 0}
+	RenderSet     = color.Gray{Y: 255}
+	RenderDiffers = color.RGBA{R: 230, G: 40, B: 40, A: 255}
+)
+
+// RenderHash draws h as a 16x16 grid, white for set bits and black for clear
+// ones, with each cell scale pixels square. Rows are vertical DCT
+// frequencies and columns horizontal ones, lowest at the top left.
+func RenderHash(h *PdqHash256, scale int) *image.Paletted {
+	img := newRenderImage(scale, color.Palette{RenderUnset, RenderSet})
+	fillCells(img, scale, func(k int) uint8 {
+		return uint8(h.w[k>>4] >> (k & 15) & 1)
+	})
+	return img
+}
+
+// RenderDiff draws the bits a and b share as RenderHash would and marks the
+// bits where they differ in red
+func RenderDiff(a, b *PdqHash256, scale int) *image.Paletted {
+	img := newRenderImage(scale, color.Palette{RenderUnset, RenderSet, RenderDiffers})
+	fillCells(img, scale, func(k int) uint8 {
+		ab := a.w[k>>4] >> (k & 15) & 1
+		bb := b.w[k>>4] >> (k & 15) & 1
+		if ab != bb {
+			return 2
+		}
+		return uint8(ab)
+	})
+	return img
+}
+
+func newRenderImage(scale int, p color.Palette) *image.Paletted {
+	if scale < 1 {
+		scale = 1
+	}
+	return image.NewPaletted(image.Rect(0, 0, 16*scale, 16*scale), p)
+}
+
+// fillCells sets each cell of img to the palette index returned for its bit
+func fillCells(img *image.Paletted, scale int, index func(k int) uint8) {
+	if scale < 1 {
+		scale = 1
+	}
+	for y := 0; y < 16*scale; y++ {
+		row := y / scale
+		for x := 0; x < 16*scale; x++ {
+			img.Pix[y*img.Stride+x] = index(row*16 + x/scale)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package gopdq
+
+import "testing"
+
+func TestRender(t *testing.T) {
+	a := NewPdqHash256()
+	a.SetBit(0)
+	a.SetBit(2*16 + 5)
+	b := a.Clone()
+	b.FlipBit(15*16 + 15)
+
+	img := RenderHash(a, 4)
+	if w, h := img.Bounds().Dx(), img.Bounds().Dy(); w != 64 || h != 64 {
+		t.Fatalf("unexpected size %dx%d", w, h)
+	}
+	if img.At(0, 0) != RenderSet || img.At(3, 3) != RenderSet || img.At(4, 0) != RenderUnset {
+		t.Fatal("bit 0 rendered wrong")
+	}
+	// bit (2, 5) is row 2, column 5
+	if img.At(5*4+1, 2*4+1) != RenderSet || img.At(2*4+1, 5*4+1) != RenderUnset {
+		t.Fatal("bit (2, 5) rendered wrong")
+	}
+
+	diff := RenderDiff(a, b, 1)
+	if diff.At(15, 15) != RenderDiffers || diff.At(0, 0) != RenderSet || diff.At(1, 0) != RenderUnset {
+		t.Fatal("diff rendered wrong")
+	}
+}
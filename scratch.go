@@ -0,0 +1,51 @@
+package gopdq
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// floatPools holds the scratch slabs HashImage works in, which are the bulk
+// of what hashing allocates. Slabs are bucketed by power of two capacity, so
+// any request fits the slabs of its bucket.
+var floatPools [bits.UintSize]sync.Pool
+
+// getFloats returns a buffer of length n from the pool. Its contents are
+// left over from earlier use.
+func getFloats(n int) *[]float32 {
+	b := bits.Len(uint(n - 1))
+	if p, ok := floatPools[b].Get().(*[]float32); ok {
+		*p = (*p)[:n]
+		return p
+	}
+	buf := make([]float32, n, 1<<b)
+	return &buf
+}
+
+// putFloats returns a buffer from getFloats to the pool
+func putFloats(p *[]float32) {
+	floatPools[bits.Len(uint(cap(*p)-1))].Put(p)
+}
+
+// ScratchSize returns the number of float32 values of scratch space hashing
+// a width x height image needs: two full resolution buffers for the filter
+// passes, then the 64x64 decimated image and the 16x16 DCT output
+func ScratchSize(width, height int) int {
+	return 2*width*height + 64*64 + 16*16
+}
+
+// scratch is a slab of ScratchSize values cut into the buffers of one hash
+type scratch struct {
+	buffer1, buffer2         []float32
+	buffer64x64, buffer16x16 []float32
+}
+
+func splitScratch(slab []float32, width, height int) scratch {
+	n := width * height
+	return scratch{
+		buffer1:     slab[:n:n],
+		buffer2:     slab[n : 2*n : 2*n],
+		buffer64x64: slab[2*n : 2*n+64*64 : 2*n+64*64],
+		buffer16x16: slab[2*n+64*64 : 2*n+64*64+16*16 : 2*n+64*64+16*16],
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKey is a credential accepted by the server, sent either as
+// "Authorization: Bearer <key>" or in an X-API-Key header
+type APIKey struct {
+	// Name identifies the key in logs without revealing it
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	// RatePerSecond limits the requests made with the key, counting a
+	// batch as one request. Zero means unlimited.
+	RatePerSecond float64 `json:"rate_per_second,omitempty"`
+	// Burst is the number of requests that may be made at once before the
+	// rate applies; defaults to the rate rounded up
+	Burst int `json:"burst,omitempty"`
+	// Admin allows the /admin endpoints
+	Admin bool `json:"admin,omitempty"`
+}
+
+// keyState is an APIKey with its token bucket
+type keyState struct {
+	APIKey
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// take spends a token if one is available, returning otherwise how long
+// until one will be
+func (k *keyState) take(now time.Time) (time.Duration, bool) {
+	if k.RatePerSecond <= 0 {
+		return 0, true
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	burst := float64(k.Burst)
+	if k.last.IsZero() {
+		k.tokens = burst
+	} else {
+		k.tokens = min(burst, k.tokens+now.Sub(k.last).Seconds()*k.RatePerSecond)
+	}
+	k.last = now
+	if k.tokens >= 1 {
+		k.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - k.tokens) / k.RatePerSecond * float64(time.Second)), false
+}
+
+// newKeys indexes keys by the SHA-256 of the key, so a lookup takes the
+// same time however much of a guess matches a real key
+func newKeys(keys []APIKey) (map[[32]byte]*keyState, error) {
+	out := make(map[[32]byte]*keyState, len(keys))
+	for _, k := range keys {
+		if k.Key == "" {
+			return nil, fmt.Errorf("server: API key %q is empty", k.Name)
+		}
+		if k.Burst <= 0 {
+			k.Burst = max(1, int(math.Ceil(k.RatePerSecond)))
+		}
+		sum := sha256.Sum256([]byte(k.Key))
+		if _, dup := out[sum]; dup {
+			return nil, fmt.Errorf("server: API key %q is listed twice", k.Name)
+		}
+		out[sum] = &keyState{APIKey: k}
+	}
+	return out, nil
+}
+
+// requestKey returns the key presented with r, if any
+func requestKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if scheme, token, ok := strings.Cut(auth, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// authorize checks the key and rate limit of r, writing the error response
+// and returning false if it may not proceed
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if len(s.keys) == 0 || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+		return true
+	}
+	k := s.keys[sha256.Sum256([]byte(requestKey(r)))]
+	if k == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="pdq"`)
+		httpError(w, http.StatusUnauthorized, errors.New("missing or unknown API key"))
+		return false
+	}
+	if strings.HasPrefix(r.URL.Path, "/admin/") && !k.Admin {
+		s.cfg.Logger.Warn("rejected admin request", "key", k.Name, "path", r.URL.Path)
+		httpError(w, http.StatusForbidden, errors.New("API key may not use admin endpoints"))
+		return false
+	}
+	if wait, ok := k.take(time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		httpError(w, http.StatusTooManyRequests, errors.New("rate limit exceeded"))
+		return false
+	}
+	return true
+}
+
+// limitedBody is a request body cut off at Config.MaxBodyBytes. It records
+// hitting the limit itself, as decoders don't all keep the read error.
+type limitedBody struct {
+	io.ReadCloser
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		b.exceeded = true
+	}
+	return n, err
+}
+
+// errorStatus is the status for a request failing as a whole: 413 if its
+// body went over the size limit, fallback otherwise
+func errorStatus(r *http.Request, fallback int) int {
+	if b, ok := r.Body.(*limitedBody); ok && b.exceeded {
+		return http.StatusRequestEntityTooLarge
+	}
+	return fallback
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sync"
+)
+
+// batchItem is one image of a batch, read from the request but not yet
+// hashed
+type batchItem func(ctx context.Context) Result
+
+// batchSource yields the images of a batch request in order, returning
+// io.EOF after the last
+type batchSource func() (batchItem, error)
+
+// URLLine is a line of an NDJSON batch request
+type URLLine struct {
+	ID  string `json:"id,omitempty"`
+	URL string `json:"url"`
+}
+
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		httpError(w, http.StatusUnsupportedMediaType, err)
+		return
+	}
+
+	var next batchSource
+	switch mediaType {
+	case "multipart/form-data":
+		mr, err := r.MultipartReader()
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err)
+			return
+		}
+		next = s.multipartSource(mr.NextPart)
+	case "application/x-ndjson", "application/jsonl", "application/json":
+		next = s.urlSource(r.Body)
+	default:
+		httpError(w, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %q: expected multipart/form-data or application/x-ndjson", mediaType))
+		return
+	}
+
+	// results are written while the rest of the request is still being read
+	rc := http.NewResponseController(w)
+	rc.EnableFullDuplex()
+	var out batchOutput
+	if acceptsEventStream(r) {
+		out = newSSEOutput(w, rc, s.cfg.KeepAlive)
+	} else {
+		out = newNDJSONOutput(w, rc)
+	}
+	defer out.close()
+
+	ctx := r.Context()
+	sem := make(chan struct{}, s.cfg.Concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i := 0; ; i++ {
+		// take a slot before reading the item, so no more than Concurrency
+		// uploaded images are held in memory at once
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		item, err := next()
+		if err == io.EOF {
+			out.received(i)
+			return
+		}
+		if err == nil && i >= s.cfg.MaxBatch {
+			err = fmt.Errorf("batch exceeds %d images", s.cfg.MaxBatch)
+		}
+		if err != nil {
+			wg.Wait()
+			s.cfg.Logger.Warn("failed to read batch", "err", err)
+			out.result(BatchResult{Index: -1, Result: Result{Error: err.Error()}})
+			return
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			out.result(BatchResult{Index: i, Result: item(ctx)})
+		}(i)
+	}
+}
+
+// batchOutput writes the response to a batch as its results come in
+type batchOutput interface {
+	result(res BatchResult)
+	// received is called once the whole request is read, with the number
+	// of images in it
+	received(n int)
+	// close is called after the last result
+	close()
+}
+
+// ndjsonOutput writes a BatchResult per line
+type ndjsonOutput struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	rc  *http.ResponseController
+}
+
+func newNDJSONOutput(w http.ResponseWriter, rc *http.ResponseController) *ndjsonOutput {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	return &ndjsonOutput{enc: json.NewEncoder(w), rc: rc}
+}
+
+func (o *ndjsonOutput) result(res BatchResult) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.enc.Encode(&res)
+	o.rc.Flush()
+}
+
+func (o *ndjsonOutput) received(n int) {}
+
+func (o *ndjsonOutput) close() {}
+
+// multipartSource reads every part of a multipart body as an image
+func (s *Server) multipartSource(nextPart func() (*multipart.Part, error)) batchSource {
+	return func() (batchItem, error) {
+		part, err := nextPart()
+		if err != nil {
+			return nil, err
+		}
+		defer part.Close()
+		name := part.FileName()
+		if name == "" {
+			name = part.FormName()
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context) Result {
+			res := s.hash(ctx, bytes.NewReader(data))
+			res.Name = name
+			return res
+		}, nil
+	}
+}
+
+// urlSource reads a URLLine per non-blank line of r. A line that doesn't
+// parse gets a result giving the error rather than ending the batch.
+func (s *Server) urlSource(r io.Reader) batchSource {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	return func() (batchItem, error) {
+		var line []byte
+		for len(line) == 0 {
+			if !sc.Scan() {
+				if err := sc.Err(); err != nil {
+					return nil, err
+				}
+				return nil, io.EOF
+			}
+			line = bytes.TrimSpace(sc.Bytes())
+		}
+
+		var req URLLine
+		err := json.Unmarshal(line, &req)
+		if err == nil && req.URL == "" {
+			err = errors.New("line has no url")
+		}
+		if err != nil {
+			return func(ctx context.Context) Result {
+				return Result{ID: req.ID, Error: err.Error()}
+			}, nil
+		}
+		return func(ctx context.Context) Result {
+			res := s.fetchAndHash(ctx, req.URL)
+			res.ID, res.URL = req.ID, req.URL
+			return res
+		}, nil
+	}
+}
+
+func (s *Server) fetchAndHash(ctx context.Context, url string) Result {
+	body, err := s.cfg.Fetcher.Fetch(ctx, url)
+	if err != nil {
+		return Result{Error: err.Error()}
+	}
+	defer body.Close()
+	return s.hash(ctx, body)
+}
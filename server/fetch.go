@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+
+	"github.com/whyrusleeping/gopdq/worker"
+)
+
+// webFetcher is worker.DefaultFetcher without its access to local files,
+// and with a client that only connects where allow says it may
+type webFetcher struct {
+	worker.DefaultFetcher
+}
+
+func newWebFetcher(allow func(netip.AddrPort) bool) webFetcher {
+	dialer := &net.Dialer{
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !allow(netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())) {
+				return fmt.Errorf("fetching from %s isn't allowed", address)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// through a proxy only the proxy's address would be checked
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return webFetcher{worker.DefaultFetcher{Client: &http.Client{Transport: transport}}}
+}
+
+func (f webFetcher) Fetch(ctx context.Context, ref string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(ref, "http://") && !strings.HasPrefix(ref, "https://") {
+		return nil, fmt.Errorf("unsupported URL %q: only http and https are fetched", ref)
+	}
+	return f.DefaultFetcher.Fetch(ctx, ref)
+}
+
+// PublicAddr reports whether addr is a public unicast address: not
+// loopback, link-local, multicast, unspecified or in a private range. It is
+// the default Config.AllowFetch, keeping batch URLs from reaching services
+// on the server's own network.
+func PublicAddr(addr netip.AddrPort) bool {
+	a := addr.Addr().Unmap()
+	return a.IsGlobalUnicast() && !a.IsPrivate() && !sharedAddrSpace.Contains(a)
+}
+
+// sharedAddrSpace is the carrier-grade NAT range, which is internal to the
+// network it is used in though IsPrivate doesn't include it
+var sharedAddrSpace = netip.MustParsePrefix("100.64.0.0/10")
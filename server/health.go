@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+// readiness is the body of a /readyz response. Checks maps each check to
+// "ok" or the reason it failed.
+type readiness struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// SelfTest hashes small PNG and JPEG images with the configured hasher. The
+// PNG must hash exactly as the pixels it was encoded from, since it is
+// lossless, so this catches a broken decoder as well as a missing one. New
+// runs it once, and /readyz reports the result.
+func (s *Server) SelfTest() error {
+	img := image.NewRGBA(image.Rect(0, 0, 96, 80))
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 96; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / 96), uint8((x*y)>>4 ^ y), uint8((x / 17 % 2) * 200), 255})
+		}
+	}
+	want, err := s.cfg.Hasher.HashImage(img)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	got, err := s.cfg.Hasher.FromReader(&buf)
+	if err != nil {
+		return fmt.Errorf("png: %w", err)
+	}
+	if !got.Hash.Equal(want.Hash) {
+		return fmt.Errorf("png: decoded image hashes to %s, expected %s", got.Hash, want.Hash)
+	}
+
+	buf.Reset()
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		return err
+	}
+	if _, err := s.cfg.Hasher.FromReader(&buf); err != nil {
+		return fmt.Errorf("jpeg: %w", err)
+	}
+	return nil
+}
+
+// Drain marks the server as shutting down, failing /readyz so load
+// balancers stop sending it requests while those in flight finish
+func (s *Server) Drain() {
+	s.draining.Store(true)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, struct {
+		Status string `json:"status"`
+	}{"ok"})
+}
+
+// handleReady reports whether the decoders passed their self-test, the index
+// is loaded if one is configured, and the server isn't draining
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{"decoders": "ok"}
+	if s.selfTestErr != nil {
+		checks["decoders"] = s.selfTestErr.Error()
+	}
+	if s.needsIndex {
+		checks["index"] = "ok"
+		if s.Matcher() == nil {
+			checks["index"] = "not loaded"
+		}
+	}
+	checks["shutdown"] = "ok"
+	if s.draining.Load() {
+		checks["shutdown"] = "draining"
+	}
+
+	res := readiness{Ready: true, Checks: checks}
+	for _, v := range checks {
+		if v != "ok" {
+			res.Ready = false
+		}
+	}
+	status := http.StatusOK
+	if !res.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, &res)
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// MatchRequest is the JSON body of a match query by hash
+type MatchRequest struct {
+	Hash string `json:"hash"`
+	// MaxDistance defaults to Config.MaxDistance
+	MaxDistance *int `json:"max_distance,omitempty"`
+}
+
+// MatchResponse lists the index entries near a query
+type MatchResponse struct {
+	// Hash is the query hash, computed from the image if one was sent
+	Hash string `json:"hash"`
+	// Quality is the quality of the query image, absent for a query by hash
+	Quality     *int         `json:"quality,omitempty"`
+	MaxDistance int          `json:"max_distance"`
+	Matches     []MatchEntry `json:"matches"`
+}
+
+// MatchEntry is an index entry with its distance from the query
+type MatchEntry struct {
+	Distance int `json:"distance"`
+	*gopdq.TaggedHash
+}
+
+// handleMatch queries the index with a hash, given as a MatchRequest in an
+// application/json body, or with an image sent as the body in any other
+// type. An image query takes its radius from the max_distance parameter.
+func (s *Server) handleMatch(w http.ResponseWriter, r *http.Request) {
+	matcher := s.Matcher()
+	if matcher == nil {
+		httpError(w, http.StatusServiceUnavailable, errors.New("no index is loaded"))
+		return
+	}
+
+	var resp MatchResponse
+	var h *gopdq.PdqHash256
+	maxDistance := s.cfg.MaxDistance
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		var req MatchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, errorStatus(r, http.StatusBadRequest), err)
+			return
+		}
+		var err error
+		if h, err = gopdq.ParseHash(req.Hash); err != nil {
+			httpError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.MaxDistance != nil {
+			maxDistance = *req.MaxDistance
+		}
+	} else {
+		if v := r.URL.Query().Get("max_distance"); v != "" {
+			d, err := strconv.Atoi(v)
+			if err != nil {
+				httpError(w, http.StatusBadRequest, fmt.Errorf("max_distance: %w", err))
+				return
+			}
+			maxDistance = d
+		}
+		hr, err := s.cfg.Hasher.FromReaderLimited(r.Context(), r.Body, s.limiter)
+		if err != nil {
+			httpError(w, errorStatus(r, http.StatusUnprocessableEntity), err)
+			return
+		}
+		h = hr.Hash
+		resp.Quality = &hr.Quality
+	}
+	if maxDistance < 0 || maxDistance > s.cfg.MaxDistance {
+		httpError(w, http.StatusBadRequest, fmt.Errorf("max_distance must be between 0 and %d", s.cfg.MaxDistance))
+		return
+	}
+
+	matches, err := matcher.Query(h, maxDistance)
+	if err != nil {
+		s.cfg.Logger.Warn("index query failed", "hash", h, "err", err)
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	resp.Hash = h.String()
+	resp.MaxDistance = maxDistance
+	resp.Matches = make([]MatchEntry, len(matches))
+	for i, m := range matches {
+		resp.Matches[i] = MatchEntry{Distance: m.Distance, TaggedHash: m.Entry}
+	}
+	writeJSON(w, http.StatusOK, &resp)
+}
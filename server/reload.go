@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/whyrusleeping/gopdq"
+	"github.com/whyrusleeping/gopdq/index"
+	"github.com/whyrusleeping/gopdq/worker"
+)
+
+// matcherRef holds the matcher being served, so it can be swapped atomically
+type matcherRef struct {
+	m index.Matcher
+}
+
+// Matcher returns the matcher currently answering /match, nil if none
+func (s *Server) Matcher() index.Matcher {
+	if ref := s.matcher.Load(); ref != nil {
+		return ref.m
+	}
+	return nil
+}
+
+// SetMatcher replaces the matcher answering /match. Queries already running
+// finish against the old one.
+func (s *Server) SetMatcher(m index.Matcher) {
+	s.matcher.Store(&matcherRef{m: m})
+}
+
+// ErrNoReload is returned by Reload when the server has no Config.Reload
+var ErrNoReload = errors.New("reloading is not configured")
+
+// Reload builds a new matcher with Config.Reload and swaps it in. If the
+// load fails the current matcher is kept. Concurrent reloads run one at a
+// time.
+func (s *Server) Reload(ctx context.Context) error {
+	if s.cfg.Reload == nil {
+		return ErrNoReload
+	}
+	s.reloadLk.Lock()
+	defer s.reloadLk.Unlock()
+
+	start := time.Now()
+	m, err := s.cfg.Reload(ctx)
+	if err != nil {
+		s.cfg.Logger.Warn("reload failed, keeping the current index", "err", err)
+		return err
+	}
+	s.SetMatcher(m)
+	s.cfg.Logger.Info("reloaded index", "took", time.Since(start))
+	return nil
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if err := s.Reload(r.Context()); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrNoReload) {
+			status = http.StatusNotImplemented
+		}
+		httpError(w, status, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Status string `json:"status"`
+	}{"reloaded"})
+}
+
+// LoadHashList reads the hash list at ref, a file path or http(s) URL, into
+// a new matcher of the kind named as for index.NewMatcher, hnsw configuring
+// it if it's an HNSW graph. It returns the number of hashes loaded. A nil
+// fetcher means worker.DefaultFetcher.
+func LoadHashList(ctx context.Context, fetcher worker.Fetcher, ref, kind string, hnsw index.HNSWConfig) (index.Matcher, int, error) {
+	m, err := index.NewMatcherConfig(kind, hnsw)
+	if err != nil {
+		return nil, 0, err
+	}
+	if fetcher == nil {
+		fetcher = worker.DefaultFetcher{}
+	}
+	r, err := fetcher.Fetch(ctx, ref)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Close()
+	hashes, err := gopdq.ReadTaggedHashes(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", ref, err)
+	}
+	for _, t := range hashes {
+		if err := m.Insert(t); err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", ref, err)
+		}
+	}
+	return m, len(hashes), nil
+}
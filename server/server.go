@@ -0,0 +1,223 @@
+// Package server exposes hashing over HTTP.
+//
+// POST /hash takes an image as the request body and responds with its
+// Result as JSON. POST /hash/batch takes many images, either as the parts of
+// a multipart/form-data body or as NDJSON lines naming URLs to fetch, hashes
+// them concurrently and streams a BatchResult per image back as NDJSON in
+// the order they finish. A batch request accepting text/event-stream gets
+// its results as Server-Sent Events instead, interleaved with progress.
+//
+// POST /match looks a hash or image up in the configured index, returning
+// the entries within a distance of it with their metadata. POST
+// /admin/reload replaces that index with a fresh one from Config.Reload,
+// as Server.Reload does for callers such as a SIGHUP handler.
+//
+// With Config.Keys set every request needs one of the keys, each with its
+// own rate limit, and only admin keys may use /admin. Request bodies are
+// limited to Config.MaxBodyBytes.
+//
+// GET /healthz reports the process is up and GET /readyz that it can serve:
+// its decoders passed a self-test, its index is loaded if it has one, and
+// it isn't draining for shutdown. Neither needs a key, so orchestrators can
+// probe them.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/whyrusleeping/gopdq"
+	"github.com/whyrusleeping/gopdq/index"
+	"github.com/whyrusleeping/gopdq/worker"
+)
+
+// Result is the outcome of hashing one image
+type Result struct {
+	// ID is copied from the request line of a URL batch
+	ID string `json:"id,omitempty"`
+	// URL is the image fetched for a URL batch
+	URL string `json:"url,omitempty"`
+	// Name is the file name of a multipart part, or its form name if it
+	// has none
+	Name     string `json:"name,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+	Quality  int    `json:"quality"`
+	Degraded bool   `json:"degraded,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// BatchResult is one line of a batch response. Index is the position of the
+// image in the request, counting from 0; a request that can't be read to
+// the end finishes the stream with a line of index -1 giving the error.
+type BatchResult struct {
+	Index int `json:"index"`
+	Result
+}
+
+// Config controls server behavior
+type Config struct {
+	// Hasher defaults to gopdq.NewPdqHasher() using Logger
+	Hasher *gopdq.PdqHasher
+	// Matcher answers /match queries until a reload replaces it. Without one
+	// /match responds 503.
+	Matcher index.Matcher
+	// Reload builds a replacement for Matcher, typically from an updated
+	// hash list. Optional; see Server.Reload.
+	Reload func(ctx context.Context) (index.Matcher, error)
+	// Fetcher opens the URLs of a batch. It defaults to worker.DefaultFetcher
+	// restricted to http and https URLs, so requests can't read local files,
+	// and to the addresses AllowFetch accepts.
+	Fetcher worker.Fetcher
+	// AllowFetch reports whether the default Fetcher may connect to addr.
+	// It is asked after DNS resolution for every connection, those made to
+	// follow redirects included, so a public name resolving to an internal
+	// address is refused too. It defaults to PublicAddr.
+	AllowFetch func(addr netip.AddrPort) bool
+	// Logger reports failed requests. Optional.
+	Logger *slog.Logger
+
+	// Limits bounds memory and throughput across all requests
+	Limits gopdq.Limits
+
+	Concurrency int // images hashed at once per batch; defaults to runtime.NumCPU()
+	MaxBatch    int // images accepted per batch; defaults to 1000
+	// KeepAlive is the interval of the comments sent on an idle event
+	// stream, so proxies don't time long batches out; defaults to 15s
+	KeepAlive time.Duration
+	// Keys are the API keys accepted. With none, requests aren't
+	// authenticated.
+	Keys []APIKey
+	// MaxBodyBytes bounds the size of a request body, batches included;
+	// defaults to 64 MiB, and a negative value means no limit
+	MaxBodyBytes int64
+	// MaxDistance is the radius of match queries that don't give one, and
+	// the largest one may ask for; defaults to 31
+	MaxDistance int
+}
+
+// Server is an http.Handler serving the hashing endpoints
+type Server struct {
+	cfg     Config
+	limiter *gopdq.Limiter
+	mux     *http.ServeMux
+	keys    map[[32]byte]*keyState
+
+	matcher  atomic.Pointer[matcherRef]
+	reloadLk sync.Mutex
+
+	selfTestErr error
+	needsIndex  bool
+	draining    atomic.Bool
+}
+
+// New creates a new Server
+func New(cfg Config) (*Server, error) {
+	if cfg.Logger == nil {
+		cfg.Logger = slog.New(slog.DiscardHandler)
+	}
+	if cfg.Hasher == nil {
+		cfg.Hasher = gopdq.NewPdqHasher(gopdq.WithLogger(cfg.Logger))
+	}
+	if cfg.AllowFetch == nil {
+		cfg.AllowFetch = PublicAddr
+	}
+	if cfg.Fetcher == nil {
+		cfg.Fetcher = newWebFetcher(cfg.AllowFetch)
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = runtime.NumCPU()
+	}
+	if cfg.MaxBatch <= 0 {
+		cfg.MaxBatch = 1000
+	}
+	if cfg.KeepAlive <= 0 {
+		cfg.KeepAlive = 15 * time.Second
+	}
+	if cfg.MaxDistance <= 0 {
+		cfg.MaxDistance = 31
+	}
+	if cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = 64 << 20
+	}
+	keys, err := newKeys(cfg.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		cfg:     cfg,
+		limiter: gopdq.NewLimiter(cfg.Limits),
+		mux:     http.NewServeMux(),
+		keys:    keys,
+
+		needsIndex: cfg.Matcher != nil || cfg.Reload != nil,
+	}
+	if cfg.Matcher != nil {
+		s.SetMatcher(cfg.Matcher)
+	}
+	if s.selfTestErr = s.SelfTest(); s.selfTestErr != nil {
+		cfg.Logger.Error("decoder self-test failed", "err", s.selfTestErr)
+	}
+	s.mux.HandleFunc("POST /hash", s.handleHash)
+	s.mux.HandleFunc("POST /hash/batch", s.handleBatch)
+	s.mux.HandleFunc("POST /match", s.handleMatch)
+	s.mux.HandleFunc("POST /admin/reload", s.handleReload)
+	s.mux.HandleFunc("GET /healthz", s.handleHealth)
+	s.mux.HandleFunc("GET /readyz", s.handleReady)
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r) {
+		return
+	}
+	if s.cfg.MaxBodyBytes > 0 {
+		r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes)}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleHash(w http.ResponseWriter, r *http.Request) {
+	res := s.hash(r.Context(), r.Body)
+	status := http.StatusOK
+	if res.Error != "" {
+		status = errorStatus(r, http.StatusUnprocessableEntity)
+	}
+	writeJSON(w, status, res)
+}
+
+// hash hashes the image read from r, reporting failure in the result
+func (s *Server) hash(ctx context.Context, r io.Reader) Result {
+	hr, err := s.cfg.Hasher.FromReaderLimited(ctx, r, s.limiter)
+	if err != nil {
+		return Result{Error: err.Error()}
+	}
+	return Result{
+		Hash:     hr.Hash.String(),
+		Quality:  hr.Quality,
+		Degraded: hr.Degraded,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// errorResponse is the body of a request that failed as a whole
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func httpError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,660 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whyrusleeping/gopdq"
+	"github.com/whyrusleeping/gopdq/index"
+)
+
+var testImages = []string{"../cat.jpg", "../testdata/rgb.jpg", "../testdata/progressive.jpg"}
+
+func newTestServer(t *testing.T, cfg Config) *httptest.Server {
+	t.Helper()
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func expectedHash(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	res, err := gopdq.NewPdqHasher().FromReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Hash.String()
+}
+
+// readBatch reads a batch response, indexed by position in the request
+// allowServers is an AllowFetch accepting the addresses of the test servers
+// given, and no others
+func allowServers(servers ...*httptest.Server) func(netip.AddrPort) bool {
+	return func(addr netip.AddrPort) bool {
+		for _, ts := range servers {
+			if netip.MustParseAddrPort(ts.Listener.Addr().String()) == addr {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func readBatch(t *testing.T, resp *http.Response) map[int]BatchResult {
+	t.Helper()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("content type %q", ct)
+	}
+	results := map[int]BatchResult{}
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		var res BatchResult
+		if err := json.Unmarshal(sc.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if _, dup := results[res.Index]; dup {
+			t.Fatalf("index %d reported twice", res.Index)
+		}
+		results[res.Index] = res
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestHash(t *testing.T) {
+	ts := newTestServer(t, Config{})
+
+	data, err := os.ReadFile(testImages[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(ts.URL+"/hash", "image/jpeg", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res Result
+	json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || res.Hash != expectedHash(t, testImages[0]) || res.Quality != 100 {
+		t.Fatalf("status %d, result %+v", resp.StatusCode, res)
+	}
+
+	resp, err = http.Post(ts.URL+"/hash", "image/jpeg", strings.NewReader("not an image"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = Result{}
+	json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity || res.Error == "" {
+		t.Fatalf("status %d, result %+v", resp.StatusCode, res)
+	}
+
+	resp, err = http.Get(ts.URL + "/hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("GET gave status %d", resp.StatusCode)
+	}
+}
+
+func TestBatchMultipart(t *testing.T) {
+	ts := newTestServer(t, Config{Concurrency: 2})
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, path := range testImages {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pw, _ := mw.CreateFormFile("image", path)
+		pw.Write(data)
+	}
+	pw, _ := mw.CreateFormField("broken")
+	pw.Write([]byte("not an image"))
+	mw.Close()
+
+	resp, err := http.Post(ts.URL+"/hash/batch", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := readBatch(t, resp)
+	if len(results) != len(testImages)+1 {
+		t.Fatalf("got %d results: %v", len(results), results)
+	}
+	for i, path := range testImages {
+		if res := results[i]; res.Name != filepath.Base(path) || res.Hash != expectedHash(t, path) || res.Error != "" {
+			t.Errorf("result %d is %+v", i, res)
+		}
+	}
+	if res := results[len(testImages)]; res.Name != "broken" || res.Error == "" || res.Hash != "" {
+		t.Errorf("broken part gave %+v", res)
+	}
+}
+
+func TestBatchInternalURLs(t *testing.T) {
+	files := httptest.NewServer(http.FileServer(http.Dir("..")))
+	defer files.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(files.URL+"/cat.jpg", http.StatusFound))
+	defer redirect.Close()
+
+	for _, c := range []struct {
+		name  string
+		cfg   Config
+		url   string
+		allow bool
+	}{
+		// the test servers listen on loopback, which is refused by default
+		{"default", Config{}, files.URL + "/cat.jpg", false},
+		{"allowed", Config{AllowFetch: allowServers(files)}, files.URL + "/cat.jpg", true},
+		{"redirect allowed", Config{AllowFetch: allowServers(redirect, files)}, redirect.URL, true},
+		{"redirect refused", Config{AllowFetch: allowServers(redirect)}, redirect.URL, false},
+	} {
+		ts := newTestServer(t, c.cfg)
+		resp, err := http.Post(ts.URL+"/hash/batch", "application/x-ndjson", strings.NewReader(`{"id":"a","url":"`+c.url+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := readBatch(t, resp)[0]
+		if c.allow && (res.Error != "" || res.Hash != expectedHash(t, "../cat.jpg")) {
+			t.Errorf("%s: %+v", c.name, res)
+		}
+		if !c.allow && (!strings.Contains(res.Error, "isn't allowed") || res.Hash != "") {
+			t.Errorf("%s: fetch wasn't refused: %+v", c.name, res)
+		}
+	}
+
+	for addr, public := range map[string]bool{
+		"8.8.8.8:80":                true,
+		"[2001:4860::8888]:443":     true,
+		"127.0.0.1:80":              false,
+		"[::1]:80":                  false,
+		"10.1.2.3:80":               false,
+		"172.16.0.1:80":             false,
+		"192.168.1.1:80":            false,
+		"169.254.169.254:80":        false,
+		"100.64.0.1:80":             false,
+		"0.0.0.0:80":                false,
+		"[::]:80":                   false,
+		"[fe80::1]:80":              false,
+		"[fd00::1]:80":              false,
+		"[::ffff:127.0.0.1]:80":     false,
+		"[::ffff:93.184.216.34]:80": true,
+		"224.0.0.1:80":              false,
+	} {
+		if got := PublicAddr(netip.MustParseAddrPort(addr)); got != public {
+			t.Errorf("PublicAddr(%s) = %v, expected %v", addr, got, public)
+		}
+	}
+}
+
+func TestBatchURLs(t *testing.T) {
+	files := httptest.NewServer(http.FileServer(http.Dir("..")))
+	defer files.Close()
+	ts := newTestServer(t, Config{MaxBatch: 5, AllowFetch: allowServers(files)})
+
+	var lines []string
+	for i, path := range testImages {
+		lines = append(lines, fmt.Sprintf(`{"id":"img%d","url":"%s/%s"}`, i, files.URL, strings.TrimPrefix(path, "../")))
+	}
+	lines = append(lines,
+		"",
+		`{"id":"missing","url":"`+files.URL+`/missing.jpg"}`,
+		`{"id":"local","url":"/etc/passwd"}`,
+		`{"id":"nourl"}`,
+	)
+	resp, err := http.Post(ts.URL+"/hash/batch", "application/x-ndjson", strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := readBatch(t, resp)
+	if len(results) != 6 {
+		t.Fatalf("got %d results: %v", len(results), results)
+	}
+	for i, path := range testImages {
+		if res := results[i]; res.ID != fmt.Sprintf("img%d", i) || res.Hash != expectedHash(t, path) || res.Error != "" {
+			t.Errorf("result %d is %+v", i, res)
+		}
+	}
+	for i, id := range []string{"missing", "local"} {
+		if res := results[len(testImages)+i]; res.ID != id || res.Error == "" || res.Hash != "" {
+			t.Errorf("%s gave %+v", id, res)
+		}
+	}
+	// the sixth line is over the limit of five
+	if res := results[-1]; !strings.Contains(res.Error, "exceeds 5") {
+		t.Errorf("batch over MaxBatch ended with %+v", res)
+	}
+
+	resp, err = http.Post(ts.URL+"/hash/batch", "text/plain", strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("text/plain batch gave status %d", resp.StatusCode)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	resp, err := http.Post(ts.URL+"/match", "application/json", strings.NewReader(`{"hash":"`+expectedHash(t, testImages[0])+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("match without an index gave status %d", resp.StatusCode)
+	}
+
+	m := index.NewFlat()
+	for _, path := range testImages {
+		h, _ := gopdq.ParseHash(expectedHash(t, path))
+		m.Insert(&gopdq.TaggedHash{Hash: h, Quality: 100, ID: filepath.Base(path), Labels: map[string]string{"set": "test"}})
+	}
+	ts = newTestServer(t, Config{Matcher: m, MaxDistance: 40})
+
+	query := func(contentType, path string, body io.Reader) (int, MatchResponse) {
+		t.Helper()
+		resp, err := http.Post(ts.URL+path, contentType, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var mr MatchResponse
+		json.NewDecoder(resp.Body).Decode(&mr)
+		return resp.StatusCode, mr
+	}
+
+	// rgb.jpg and progressive.jpg hash identically, cat.jpg is far from both
+	rgb := expectedHash(t, testImages[1])
+	status, mr := query("application/json", "/match", strings.NewReader(`{"hash":"`+rgb+`","max_distance":0}`))
+	if status != http.StatusOK || mr.Hash != rgb || mr.Quality != nil || mr.MaxDistance != 0 || len(mr.Matches) != 2 {
+		t.Fatalf("status %d, response %+v", status, mr)
+	}
+	for i, id := range []string{"rgb.jpg", "progressive.jpg"} {
+		if e := mr.Matches[i]; e.ID != id || e.Distance != 0 || e.Labels["set"] != "test" {
+			t.Errorf("match %d is %+v", i, e)
+		}
+	}
+
+	data, err := os.ReadFile(testImages[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, mr = query("image/jpeg", "/match?max_distance=10", bytes.NewReader(data))
+	if status != http.StatusOK || mr.Quality == nil || *mr.Quality != 100 || len(mr.Matches) != 1 || mr.Matches[0].ID != "cat.jpg" {
+		t.Fatalf("status %d, response %+v", status, mr)
+	}
+
+	for _, tc := range []struct {
+		contentType, path, body string
+		status                  int
+	}{
+		{"application/json", "/match", `{"hash":"nothex"}`, http.StatusBadRequest},
+		{"application/json", "/match", `{"hash":"` + rgb + `","max_distance":41}`, http.StatusBadRequest},
+		{"image/jpeg", "/match?max_distance=x", string(data), http.StatusBadRequest},
+		{"image/jpeg", "/match", "not an image", http.StatusUnprocessableEntity},
+	} {
+		if status, _ := query(tc.contentType, tc.path, strings.NewReader(tc.body)); status != tc.status {
+			t.Errorf("%s %s: status %d, expected %d", tc.path, tc.body[:min(len(tc.body), 20)], status, tc.status)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	list := filepath.Join(dir, "hashes.txt")
+	rgb := expectedHash(t, testImages[1])
+	cat := expectedHash(t, testImages[0])
+	os.WriteFile(list, []byte(rgb+"\n"), 0o644)
+
+	reload := func(ctx context.Context) (index.Matcher, error) {
+		m, _, err := LoadHashList(ctx, nil, list, "flat", index.HNSWConfig{})
+		return m, err
+	}
+	m, err := reload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(Config{Matcher: m, Reload: reload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	matches := func(hash string) int {
+		t.Helper()
+		resp, err := http.Post(ts.URL+"/match", "application/json", strings.NewReader(`{"hash":"`+hash+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var mr MatchResponse
+		json.NewDecoder(resp.Body).Decode(&mr)
+		return len(mr.Matches)
+	}
+	postReload := func() int {
+		t.Helper()
+		resp, err := http.Post(ts.URL+"/admin/reload", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if matches(rgb) != 1 || matches(cat) != 0 {
+		t.Fatal("initial list not served")
+	}
+	os.WriteFile(list, []byte(rgb+"\n"+cat+"\n"), 0o644)
+	if matches(cat) != 0 {
+		t.Fatal("list changed before a reload")
+	}
+	if status := postReload(); status != http.StatusOK {
+		t.Fatalf("reload gave status %d", status)
+	}
+	if matches(rgb) != 1 || matches(cat) != 1 {
+		t.Fatal("reloaded list not served")
+	}
+
+	// a broken list is rejected and the last good one kept
+	os.WriteFile(list, []byte("not a hash\n"), 0o644)
+	if status := postReload(); status != http.StatusInternalServerError {
+		t.Fatalf("broken reload gave status %d", status)
+	}
+	if matches(cat) != 1 {
+		t.Fatal("broken reload replaced the index")
+	}
+
+	// and Reload can be used directly, concurrently with queries
+	os.WriteFile(list, []byte(cat+"\n"), 0o644)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			if n := matches(cat); n != 1 {
+				t.Errorf("query during reload gave %d matches", n)
+			}
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		if err := s.Reload(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	if matches(rgb) != 0 {
+		t.Fatal("last reload not served")
+	}
+
+	s, _ = New(Config{})
+	if err := s.Reload(context.Background()); err != ErrNoReload {
+		t.Fatalf("Reload without Config.Reload gave %v", err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	ts := newTestServer(t, Config{
+		Keys: []APIKey{
+			{Name: "client", Key: "secret", RatePerSecond: 0.01, Burst: 2},
+			{Name: "ops", Key: "admin-secret", Admin: true},
+		},
+		MaxBodyBytes: 1000,
+	})
+
+	do := func(path, header, value, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "image/jpeg")
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	for _, tc := range []struct {
+		header, value string
+	}{
+		{"", ""},
+		{"Authorization", "Bearer wrong"},
+		{"Authorization", "Basic c2VjcmV0"},
+		{"X-API-Key", "secre"},
+	} {
+		resp := do("/hash", tc.header, tc.value, "x")
+		if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("%s %q: status %d", tc.header, tc.value, resp.StatusCode)
+		}
+	}
+
+	// two requests fit the client's burst, and the third waits for a token
+	for i, want := range []int{http.StatusUnprocessableEntity, http.StatusUnprocessableEntity, http.StatusTooManyRequests} {
+		header := "Authorization"
+		value := "bearer secret"
+		if i == 1 {
+			header, value = "X-API-Key", "secret"
+		}
+		resp := do("/hash", header, value, "not an image")
+		if resp.StatusCode != want {
+			t.Fatalf("request %d: status %d, expected %d", i, resp.StatusCode, want)
+		}
+		if want == http.StatusTooManyRequests && resp.Header.Get("Retry-After") == "" {
+			t.Fatal("no Retry-After")
+		}
+	}
+
+	if resp := do("/admin/reload", "X-API-Key", "secret", ""); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("admin request by client: status %d", resp.StatusCode)
+	}
+	if resp := do("/admin/reload", "X-API-Key", "admin-secret", ""); resp.StatusCode != http.StatusNotImplemented {
+		t.Fatalf("admin request by ops: status %d", resp.StatusCode)
+	}
+
+	data, err := os.ReadFile(testImages[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := do("/hash", "X-API-Key", "admin-secret", string(data)); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized body: status %d", resp.StatusCode)
+	}
+
+	if _, err := New(Config{Keys: []APIKey{{Name: "a", Key: "k"}, {Name: "b", Key: "k"}}}); err == nil {
+		t.Fatal("duplicate keys accepted")
+	}
+	if _, err := New(Config{Keys: []APIKey{{Name: "a"}}}); err == nil {
+		t.Fatal("empty key accepted")
+	}
+}
+
+func TestHealth(t *testing.T) {
+	var ok bool
+	reload := func(ctx context.Context) (index.Matcher, error) {
+		if !ok {
+			return nil, errors.New("list unavailable")
+		}
+		return index.NewFlat(), nil
+	}
+	s, err := New(Config{Reload: reload, Keys: []APIKey{{Name: "ops", Key: "k", Admin: true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	probe := func(path string) (int, readiness) {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var res readiness
+		json.NewDecoder(resp.Body).Decode(&res)
+		return resp.StatusCode, res
+	}
+
+	// probes need no key
+	if status, _ := probe("/healthz"); status != http.StatusOK {
+		t.Fatalf("healthz: status %d", status)
+	}
+	status, res := probe("/readyz")
+	if status != http.StatusServiceUnavailable || res.Ready || res.Checks["index"] != "not loaded" || res.Checks["decoders"] != "ok" {
+		t.Fatalf("readyz without an index: status %d, %+v", status, res)
+	}
+
+	ok = true
+	if err := s.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if status, res := probe("/readyz"); status != http.StatusOK || !res.Ready {
+		t.Fatalf("readyz with an index: status %d, %+v", status, res)
+	}
+
+	s.Drain()
+	if status, res := probe("/readyz"); status != http.StatusServiceUnavailable || res.Checks["shutdown"] != "draining" {
+		t.Fatalf("readyz while draining: status %d, %+v", status, res)
+	}
+	if status, _ := probe("/healthz"); status != http.StatusOK {
+		t.Fatalf("healthz while draining: status %d", status)
+	}
+
+	// a server with no index to load is ready without one
+	ts2 := newTestServer(t, Config{})
+	resp, err := http.Get(ts2.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("readyz with no index configured: status %d", resp.StatusCode)
+	}
+}
+
+// slowFetcher serves files after a delay
+type slowFetcher struct {
+	delay time.Duration
+}
+
+func (f slowFetcher) Fetch(ctx context.Context, ref string) (io.ReadCloser, error) {
+	time.Sleep(f.delay)
+	return os.Open(ref)
+}
+
+func TestBatchEvents(t *testing.T) {
+	ts := newTestServer(t, Config{Fetcher: slowFetcher{50 * time.Millisecond}, KeepAlive: 10 * time.Millisecond, Concurrency: 1})
+
+	body := fmt.Sprintf(`{"id":"a","url":"%s"}`+"\n"+`{"id":"b","url":"%s"}`, testImages[0], testImages[1])
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/hash/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Accept", "application/json, text/event-stream;q=0.9")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type %q", ct)
+	}
+
+	type event struct {
+		name, data string
+	}
+	var events []event
+	keepalives := 0
+	var cur event
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if cur.name != "" {
+				events = append(events, cur)
+			}
+			cur = event{}
+		case line == ": keepalive":
+			keepalives++
+		case strings.HasPrefix(line, "event: "):
+			cur.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			cur.data = strings.TrimPrefix(line, "data: ")
+		default:
+			t.Fatalf("unexpected line %q", line)
+		}
+	}
+	if keepalives == 0 {
+		t.Error("no keepalives sent while fetching")
+	}
+
+	results := map[string]BatchResult{}
+	var last BatchProgress
+	for _, ev := range events {
+		switch ev.name {
+		case "result":
+			var res BatchResult
+			if err := json.Unmarshal([]byte(ev.data), &res); err != nil {
+				t.Fatal(err)
+			}
+			results[res.ID] = res
+		case "progress", "done":
+			var p BatchProgress
+			if err := json.Unmarshal([]byte(ev.data), &p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Done < last.Done {
+				t.Fatalf("progress went from %d to %d", last.Done, p.Done)
+			}
+			last = p
+		default:
+			t.Fatalf("unexpected event %q", ev.name)
+		}
+	}
+	if events[len(events)-1].name != "done" || last.Done != 2 || last.Total == nil || *last.Total != 2 {
+		t.Fatalf("stream ended with %+v, progress %+v", events[len(events)-1], last)
+	}
+	for i, id := range []string{"a", "b"} {
+		if res := results[id]; res.Index != i || res.Hash != expectedHash(t, testImages[i]) {
+			t.Errorf("%s: %+v", id, res)
+		}
+	}
+}
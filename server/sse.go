@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BatchProgress is the data of the progress and done events of a batch
+// streamed as Server-Sent Events. Total is absent until the whole request
+// has been read.
+type BatchProgress struct {
+	Done  int  `json:"done"`
+	Total *int `json:"total,omitempty"`
+}
+
+// acceptsEventStream reports whether r asks for text/event-stream
+func acceptsEventStream(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mt, _, err := mime.ParseMediaType(part); err == nil && mt == "text/event-stream" {
+			return true
+		}
+	}
+	return false
+}
+
+// sseOutput streams a batch as Server-Sent Events: a result event carrying
+// each BatchResult, followed by a progress event, and a done event at the
+// end. Comments are sent while nothing else is, to keep the connection
+// from looking idle.
+type sseOutput struct {
+	mu    sync.Mutex
+	w     io.Writer
+	rc    *http.ResponseController
+	done  int
+	total *int
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newSSEOutput(w http.ResponseWriter, rc *http.ResponseController, keepAlive time.Duration) *sseOutput {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	o := &sseOutput{w: w, rc: rc, stop: make(chan struct{})}
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		t := time.NewTicker(keepAlive)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				o.mu.Lock()
+				io.WriteString(o.w, ": keepalive\n\n")
+				o.rc.Flush()
+				o.mu.Unlock()
+			case <-o.stop:
+				return
+			}
+		}
+	}()
+	return o
+}
+
+// event writes one event; the caller holds mu
+func (o *sseOutput) event(name string, v any) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(o.w, "event: %s\ndata: %s\n\n", name, data)
+}
+
+func (o *sseOutput) progress() BatchProgress {
+	return BatchProgress{Done: o.done, Total: o.total}
+}
+
+func (o *sseOutput) result(res BatchResult) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.event("result", &res)
+	if res.Index >= 0 {
+		o.done++
+		o.event("progress", o.progress())
+	}
+	o.rc.Flush()
+}
+
+func (o *sseOutput) received(n int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.total = &n
+	o.event("progress", o.progress())
+	o.rc.Flush()
+}
+
+func (o *sseOutput) close() {
+	close(o.stop)
+	o.wg.Wait()
+	o.event("done", o.progress())
+	o.rc.Flush()
+}
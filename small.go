@@ -0,0 +1,117 @@
+package gopdq
+
+import (
+	"fmt"
+	"image"
+)
+
+// minSide is the side length below which decimation to 64x64 samples some
+// rows or columns more than once
+const minSide = 64
+
+// SmallImages says how images narrower or shorter than 64 pixels are hashed
+type SmallImages int
+
+const (
+	// SmallImagesHash hashes them as they are, as the reference does.
+	// Decimation then samples some rows or columns twice or more, so the
+	// 64x64 block is the image stretched by repetition, and a 1x1 image
+	// hashes as a flat block with quality 0.
+	SmallImagesHash SmallImages = iota
+	// SmallImagesReject fails them with ErrImageTooSmall
+	SmallImagesReject
+	// SmallImagesUpscale stretches each side shorter than 64 pixels to 64
+	// with bilinear interpolation before hashing, so decimation sees a
+	// smooth image instead of repeated rows
+	SmallImagesUpscale
+)
+
+// isSmall reports whether a w x h image has a side under minSide
+func isSmall(w, h int) bool {
+	return w < minSide || h < minSide
+}
+
+// checkSmall applies SmallImagesReject
+func (h *PdqHasher) checkSmall(w, ht int) error {
+	if h.smallImages == SmallImagesReject && isSmall(w, ht) {
+		return fmt.Errorf("%w: %dx%d is under %dx%d", ErrImageTooSmall, w, ht, minSide, minSide)
+	}
+	return nil
+}
+
+// upscaleSmall applies SmallImagesUpscale to img, returning it unchanged if
+// it is large enough or the policy is another
+func (h *PdqHasher) upscaleSmall(img image.Image) image.Image {
+	b := img.Bounds()
+	if h.smallImages != SmallImagesUpscale || !isSmall(b.Dx(), b.Dy()) {
+		return img
+	}
+	src := toRGBA(img)
+	w, ht := max(b.Dx(), minSide), max(b.Dy(), minSide)
+	dst := image.NewRGBA(image.Rect(0, 0, w, ht))
+	var in, out [4][]float32
+	for c := range in {
+		in[c] = make([]float32, b.Dx()*b.Dy())
+		out[c] = make([]float32, w*ht)
+	}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			o := y*src.Stride + x*4
+			for c := range in {
+				in[c][y*b.Dx()+x] = float32(src.Pix[o+c])
+			}
+		}
+	}
+	for c := range in {
+		resizeBilinear(in[c], b.Dx(), b.Dy(), out[c], w, ht)
+	}
+	for y := 0; y < ht; y++ {
+		for x := 0; x < w; x++ {
+			o := y*dst.Stride + x*4
+			for c := range out {
+				dst.Pix[o+c] = uint8(out[c][y*w+x] + 0.5)
+			}
+		}
+	}
+	return dst
+}
+
+// upscaleSmallLuma is upscaleSmall for a luma plane, returning the plane
+// and its new dimensions
+func (h *PdqHasher) upscaleSmallLuma(luma []float32, w, ht int) ([]float32, int, int) {
+	if h.smallImages != SmallImagesUpscale || !isSmall(w, ht) {
+		return luma, w, ht
+	}
+	dw, dh := max(w, minSide), max(ht, minSide)
+	out := make([]float32, dw*dh)
+	resizeBilinear(luma, w, ht, out, dw, dh)
+	return out, dw, dh
+}
+
+// resizeBilinear resamples the sw x sh plane src into the dw x dh plane dst,
+// aligning pixel centers and clamping at the edges
+func resizeBilinear(src []float32, sw, sh int, dst []float32, dw, dh int) {
+	for y := 0; y < dh; y++ {
+		y0, y1, fy := bilinearTaps(y, sh, dh)
+		for x := 0; x < dw; x++ {
+			x0, x1, fx := bilinearTaps(x, sw, dw)
+			top := src[y0*sw+x0]*(1-fx) + src[y0*sw+x1]*fx
+			bottom := src[y1*sw+x0]*(1-fx) + src[y1*sw+x1]*fx
+			dst[y*dw+x] = top*(1-fy) + bottom*fy
+		}
+	}
+}
+
+// bilinearTaps returns the two source indices output i of n interpolates
+// between, out of a dimension of length sn, and the weight of the second
+func bilinearTaps(i, sn, n int) (int, int, float32) {
+	pos := (float32(i)+0.5)*float32(sn)/float32(n) - 0.5
+	if pos <= 0 {
+		return 0, 0, 0
+	}
+	i0 := int(pos)
+	if i0 >= sn-1 {
+		return sn - 1, sn - 1, 0
+	}
+	return i0, i0 + 1, pos - float32(i0)
+}
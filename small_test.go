@@ -0,0 +1,64 @@
+package gopdq
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"testing"
+)
+
+func TestSmallImages(t *testing.T) {
+	for _, size := range []int{1, 32, 63} {
+		img := testPattern(size, size)
+		t.Run(fmt.Sprintf("%dx%d", size, size), func(t *testing.T) {
+			for _, opts := range [][]Option{nil, {WithDeterministic()}, {WithMode(ReferenceExact)}} {
+				if _, err := NewPdqHasher(opts...).HashImage(img); err != nil {
+					t.Fatalf("hashing as is: %v", err)
+				}
+			}
+
+			_, err := NewPdqHasher(WithSmallImages(SmallImagesReject)).HashImage(img)
+			if !errors.Is(err, ErrImageTooSmall) {
+				t.Fatalf("expected ErrImageTooSmall, got %v", err)
+			}
+
+			up := NewPdqHasher(WithSmallImages(SmallImagesUpscale))
+			got, err := up.HashImage(img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			scaled := up.upscaleSmall(img)
+			if b := scaled.Bounds(); b.Dx() != 64 || b.Dy() != 64 {
+				t.Fatalf("upscaled to %v", b)
+			}
+			want, err := NewPdqHasher().HashImage(scaled)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Hash.Equal(want.Hash) {
+				t.Fatalf("hash %s, expected that of the upscaled image %s", got.Hash, want.Hash)
+			}
+			fromLuma, err := up.HashLuma(LumaPlane(img), size, size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size > 1 && fromLuma.Hash.HammingDistance(got.Hash) > 16 {
+				t.Fatalf("upscaled luma hashes %d bits from the upscaled image", fromLuma.Hash.HammingDistance(got.Hash))
+			}
+
+			scratch := make([]float32, ScratchSize(64, 64))
+			if _, err := up.HashImageScratch(img, scratch); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	// only the short side is stretched
+	img := testPattern(200, 10)
+	if b := NewPdqHasher(WithSmallImages(SmallImagesUpscale)).upscaleSmall(img).Bounds(); b != image.Rect(0, 0, 200, 64) {
+		t.Fatalf("200x10 upscaled to %v", b)
+	}
+	if _, err := NewPdqHasher(WithSmallImages(SmallImagesReject)).HashImage(testPattern(64, 64)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,71 @@
+package gopdq
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// SplitCheck says whether each hash is checked against the median split it
+// is made by, and what to do about one that fails. A correct hash has a bit
+// set for every DCT coefficient above the coefficients' median: 128 bits
+// when they are distinct, fewer when some tie at the median, as in flat or
+// symmetric images. A hash failing the check was computed from NaN or
+// infinite coefficients, which usually means a broken filter path or bad
+// input to HashLuma, or thresholded at the wrong median.
+type SplitCheck int
+
+const (
+	// SplitCheckOff skips the check, the default
+	SplitCheckOff SplitCheck = iota
+	// SplitCheckWarn logs failures to the hasher's logger and returns the
+	// hash anyway
+	SplitCheckWarn
+	// SplitCheckError fails the hash with ErrSplitAnomaly
+	SplitCheckError
+)
+
+// checkSplit applies the hasher's SplitCheck to hash, made from coefs
+func checkSplit[T float32 | int64](h *PdqHasher, coefs []T, hash *PdqHash256) error {
+	if h.splitCheck == SplitCheckOff {
+		return nil
+	}
+	err := medianSplit(coefs, hash)
+	if err == nil {
+		return nil
+	}
+	if h.splitCheck == SplitCheckWarn {
+		h.logger.Warn("hash breaks the median split", "hash", hash, "err", err)
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrSplitAnomaly, err)
+}
+
+// medianSplit returns an error describing how hash differs from coefs
+// thresholded at their lower median
+func medianSplit[T float32 | int64](coefs []T, hash *PdqHash256) error {
+	for i, c := range coefs {
+		if f := float64(c); math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Errorf("coefficient %d is %v", i, f)
+		}
+	}
+	sorted := slices.Clone(coefs)
+	slices.Sort(sorted)
+	median := sorted[(len(sorted)-1)/2]
+
+	above := 0
+	for _, c := range coefs {
+		if c > median {
+			above++
+		}
+	}
+	if n := hash.HammingNorm(); n != above {
+		return fmt.Errorf("%d bits set where %d coefficients are above the median", n, above)
+	}
+	for i, c := range coefs {
+		if hash.Bit(i/16, i%16) != (c > median) {
+			return fmt.Errorf("bit %d doesn't match its coefficient", i)
+		}
+	}
+	return nil
+}
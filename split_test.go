@@ -0,0 +1,80 @@
+package gopdq
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSplitCheck(t *testing.T) {
+	checker := image.NewGray(image.Rect(0, 0, 128, 128))
+	for i := range checker.Pix {
+		if (i%128/16+i/128/16)%2 == 0 {
+			checker.Pix[i] = 255
+		}
+	}
+	data, err := os.ReadFile("cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := NewPdqHasher().FromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cat, err := DecodeJpeg(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := res.Hash.HammingNorm(); n != 128 {
+		t.Fatalf("cat.jpg has %d bits set", n)
+	}
+
+	// a checkerboard's coefficients tie at zero, leaving far fewer than 128
+	// bits set, and a flat image sets none; neither is an anomaly
+	for _, opt := range []Option{WithMode(Fast), WithDeterministic(), WithMode(ReferenceExact)} {
+		h := NewPdqHasher(opt, WithSplitCheck(SplitCheckError))
+		for _, img := range []image.Image{cat, checker, image.NewGray(image.Rect(0, 0, 64, 64))} {
+			if _, err := h.HashImage(img); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	luma := LumaPlane(cat)
+	luma[len(luma)/2] = float32(math.NaN())
+	b := cat.Bounds()
+	if _, err := NewPdqHasher(WithSplitCheck(SplitCheckError)).HashLuma(luma, b.Dx(), b.Dy()); !errors.Is(err, ErrSplitAnomaly) {
+		t.Errorf("expected a NaN to fail the check, got %v", err)
+	}
+
+	logs := new(bytes.Buffer)
+	warn := NewPdqHasher(WithSplitCheck(SplitCheckWarn), WithLogger(slog.New(slog.NewTextHandler(logs, nil))))
+	if _, err := warn.HashLuma(luma, b.Dx(), b.Dy()); err != nil {
+		t.Errorf("SplitCheckWarn failed the hash: %v", err)
+	}
+	if !strings.Contains(logs.String(), "median split") {
+		t.Errorf("expected a warning, got %q", logs.String())
+	}
+
+	coefs := make([]float32, 256)
+	for i := range coefs {
+		coefs[i] = float32(i)
+	}
+	hash := NewPdqHash256()
+	for i := 128; i < 256; i++ {
+		hash.SetBit(i)
+	}
+	if err := medianSplit(coefs, hash); err != nil {
+		t.Error(err)
+	}
+	hash.FlipBit(127)
+	hash.FlipBit(128)
+	if err := medianSplit(coefs, hash); err == nil {
+		t.Error("expected a misplaced bit to fail the check")
+	}
+}
@@ -0,0 +1,66 @@
+package gopdq
+
+import (
+	"io"
+	"slices"
+	"time"
+)
+
+// HashStats describes the work behind a HashResult. Entry points that
+// decode fill in every field; HashImage, HashImageScratch and HashLuma
+// leave Decoder, BytesRead and ReadTime zero.
+type HashStats struct {
+	// Decoder names the decoder used: an image format registered with the
+	// image package, such as "jpeg" or "png", or a RegisterDecoder name
+	Decoder string
+	// BytesRead counts the encoded bytes consumed from the input
+	BytesRead int64
+	// ReadTime is the time spent reading and decoding the input, limit
+	// checks included
+	ReadTime time.Duration
+	// HashTime is the time spent hashing the decoded image
+	HashTime time.Duration
+	// Width and Height are the dimensions of the decoded image, before
+	// WithSmallImages(SmallImagesUpscale) enlarges it
+	Width, Height int
+	// Margins is set when hashing WithMargins to each DCT coefficient's
+	// distance above the median the hash is thresholded at, indexed like
+	// the hash's bits, so a bit is set where its margin is positive. Bits
+	// with margins near zero are those rounding can flip.
+	Margins *[256]float32
+}
+
+// NumPixels returns Width * Height
+func (s HashStats) NumPixels() int64 {
+	return int64(s.Width) * int64(s.Height)
+}
+
+// Total returns ReadTime + HashTime
+func (s HashStats) Total() time.Duration {
+	return s.ReadTime + s.HashTime
+}
+
+// coefMargins returns each of the 16x16 DCT coefficients less their lower
+// median, which the hash bits are set above, times scale
+func coefMargins[T float32 | int64](coefs []T, scale float64) *[256]float32 {
+	sorted := slices.Clone(coefs)
+	slices.Sort(sorted)
+	median := sorted[(len(sorted)-1)/2]
+	var m [256]float32
+	for i, c := range coefs {
+		m[i] = float32(float64(c-median) * scale)
+	}
+	return &m
+}
+
+// countingReader counts the bytes read through it for HashStats.BytesRead
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,94 @@
+package gopdq
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestHashStats(t *testing.T) {
+	data, err := os.ReadFile("cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher := NewPdqHasher()
+
+	fromFile, err := hasher.FromFile("cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromJpeg, err := hasher.FromJpeg(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, res := range map[string]*HashResult{"FromFile": fromFile, "FromJpeg": fromJpeg} {
+		s := res.Stats
+		if s.Decoder != "jpeg" || s.BytesRead != int64(len(data)) {
+			t.Errorf("%s: decoder %q read %d bytes, expected jpeg and %d", name, s.Decoder, s.BytesRead, len(data))
+		}
+		if s.ReadTime <= 0 || s.HashTime <= 0 || s.Total() != s.ReadTime+s.HashTime {
+			t.Errorf("%s: unexpected times %+v", name, s)
+		}
+	}
+
+	img, err := DecodeJpeg(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := img.Bounds()
+	if s := fromFile.Stats; s.Width != b.Dx() || s.Height != b.Dy() || s.NumPixels() != int64(b.Dx()*b.Dy()) {
+		t.Errorf("stats are for a %dx%d image, expected %dx%d", s.Width, s.Height, b.Dx(), b.Dy())
+	}
+
+	res, err := hasher.HashImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := res.Stats; s.Decoder != "" || s.BytesRead != 0 || s.ReadTime != 0 || s.HashTime <= 0 || s.Width != b.Dx() {
+		t.Errorf("HashImage: unexpected stats %+v", s)
+	}
+
+	res, err = hasher.HashLuma(LumaPlane(img), b.Dx(), b.Dy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := res.Stats; s.HashTime <= 0 || s.Width != b.Dx() || s.Height != b.Dy() {
+		t.Errorf("HashLuma: unexpected stats %+v", s)
+	}
+}
+
+func TestMargins(t *testing.T) {
+	plain, err := NewPdqHasher().FromFile("cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain.Stats.Margins != nil {
+		t.Fatal("margins recorded without WithMargins")
+	}
+
+	var margins [][256]float32
+	for _, opts := range [][]Option{{WithMargins()}, {WithMargins(), WithMode(ReferenceExact)}, {WithMargins(), WithDeterministic()}} {
+		res, err := NewPdqHasher(opts...).FromFile("cat.jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := res.Stats.Margins
+		if m == nil {
+			t.Fatal("no margins recorded")
+		}
+		for i, v := range m {
+			if (v > 0) != res.Hash.Bit(i/16, i%16) {
+				t.Fatalf("bit %d has margin %v", i, v)
+			}
+		}
+		margins = append(margins, *m)
+	}
+
+	// the integer pipeline's margins are scaled to the float pipeline's
+	for i := range margins[0] {
+		if d := math.Abs(float64(margins[2][i] - margins[0][i])); d > 0.01*math.Abs(float64(margins[0][i]))+0.1 {
+			t.Fatalf("coefficient %d has deterministic margin %v, float margin %v", i, margins[2][i], margins[0][i])
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package gopdq
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// TaggedHash is a hash together with the metadata describing what was
+// hashed. Hash lists, indexes and matchers carry it end to end, so a match
+// identifies its source without a separate lookup table.
+type TaggedHash struct {
+	Hash    *PdqHash256 `json:"hash" msgpack:"hash"`
+	Quality int         `json:"quality" msgpack:"quality"`
+	// ID is the caller's identifier for the hashed item
+	ID string `json:"id,omitempty" msgpack:"id,omitempty"`
+	// Source is where the item was read from, such as a path or URL
+	Source string `json:"source,omitempty" msgpack:"source,omitempty"`
+	// Timestamp is when the item was hashed
+	Timestamp time.Time         `json:"timestamp,omitzero" msgpack:"timestamp,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty" msgpack:"labels,omitempty"`
+	// Expires is when the entry stops matching, such as the end of a
+	// temporary takedown or a retention period. Zero means never.
+	Expires time.Time `json:"expires,omitzero" msgpack:"expires,omitempty"`
+	// Payload is an application value stored with the entry, encoded as
+	// JSON, such as the case record index.Payloads keeps
+	Payload json.RawMessage `json:"payload,omitempty" msgpack:"payload,omitempty"`
+}
+
+// Expired reports whether the entry has an expiry that isn't after now
+func (t *TaggedHash) Expired(now time.Time) bool {
+	return !t.Expires.IsZero() && !now.Before(t.Expires)
+}
+
+// Tag wraps the result as a TaggedHash hashed now
+func (r *HashResult) Tag(id, source string) *TaggedHash {
+	return &TaggedHash{
+		Hash:      r.Hash,
+		Quality:   r.Quality,
+		ID:        id,
+		Source:    source,
+		Timestamp: time.Now().UTC(),
+	}
+}
+
+// Tagged returns the result as a TaggedHash whose ID and Source are the
+// path, or nil if hashing the file failed
+func (b BatchResult) Tagged() *TaggedHash {
+	if b.Err != nil || b.Result == nil {
+		return nil
+	}
+	return b.Result.Tag(b.Path, b.Path)
+}
+
+// SetLabel sets a label, allocating the map if needed
+func (t *TaggedHash) SetLabel(key, value string) {
+	if t.Labels == nil {
+		t.Labels = make(map[string]string)
+	}
+	t.Labels[key] = value
+}
+
+// ReadTaggedHashes reads a hash list with one record per line. A line is
+// either a JSON object as written by WriteTaggedHashes or a bare hash in any
+// form ParseHash accepts. Blank lines and lines starting with '#' are
+// skipped.
+func ReadTaggedHashes(r io.Reader) ([]*TaggedHash, error) {
+	var out []*TaggedHash
+
+	sc := bufio.NewScanner(r)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		t := &TaggedHash{}
+		if strings.HasPrefix(line, "{") {
+			if err := json.Unmarshal([]byte(line), t); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			if t.Hash == nil {
+				return nil, fmt.Errorf("line %d: record has no hash", lineNo)
+			}
+		} else {
+			hash, err := ParseHash(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			t.Hash = hash
+		}
+		out = append(out, t)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WriteTaggedHashes writes hashes as JSON lines, one record per line
+func WriteTaggedHashes(w io.Writer, hashes []*TaggedHash) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, t := range hashes {
+		if err := enc.Encode(t); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,59 @@
+package gopdq
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTaggedHashes(t *testing.T) {
+	res, err := NewPdqHasher().FromFile("cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tagged := res.Tag("cat", "cat.jpg")
+	tagged.Timestamp = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tagged.Expires = tagged.Timestamp.Add(90 * 24 * time.Hour)
+	tagged.SetLabel("set", "test")
+
+	buf := new(bytes.Buffer)
+	if err := WriteTaggedHashes(buf, []*TaggedHash{tagged}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"hash":"`+res.Hash.String()+`"`) {
+		t.Fatalf("hash not written as hex: %s", buf)
+	}
+
+	// a bare hash line mixed in with JSON records
+	buf.WriteString("# comment\n\nPDQ:" + strings.ToUpper(res.Hash.String()) + "\n")
+
+	got, err := ReadTaggedHashes(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("read %d records, expected 2", len(got))
+	}
+
+	r := got[0]
+	if !r.Hash.Equal(res.Hash) || r.Quality != res.Quality || r.ID != "cat" || r.Source != "cat.jpg" {
+		t.Fatalf("record changed in round trip: %+v", r)
+	}
+	if !r.Timestamp.Equal(tagged.Timestamp) || !r.Expires.Equal(tagged.Expires) || r.Labels["set"] != "test" {
+		t.Fatalf("metadata changed in round trip: %+v", r)
+	}
+	if r.Expired(tagged.Expires.Add(-time.Second)) || !r.Expired(tagged.Expires) || got[1].Expired(time.Now()) {
+		t.Fatalf("wrong expiry for %v", r.Expires)
+	}
+	if !got[1].Hash.Equal(res.Hash) || got[1].ID != "" {
+		t.Fatalf("bare hash line read as %+v", got[1])
+	}
+
+	for _, bad := range []string{`{"id":"x"}`, "not a hash", `{"hash":"00"}`} {
+		if _, err := ReadTaggedHashes(strings.NewReader("\n" + bad)); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+			t.Errorf("%q: expected a line 2 error, got %v", bad, err)
+		}
+	}
+}
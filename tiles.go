@@ -0,0 +1,155 @@
+package gopdq
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// TileGrid describes how an image is split into regional hashes
+type TileGrid struct {
+	// Rows and Cols are the number of tiles down and across
+	Rows, Cols int
+	// Overlap is the fraction of a tile shared with its neighbour, in [0, 1)
+	Overlap float64
+	// MinQuality drops tiles whose hash quality is below it. Flat tiles,
+	// such as borders, hash to near-random bits that match too readily.
+	MinQuality int
+}
+
+// DefaultTileGrid is a 3x3 grid of tiles overlapping by half
+var DefaultTileGrid = TileGrid{Rows: 3, Cols: 3, Overlap: 0.5, MinQuality: 50}
+
+// TileHash is the hash of one region of an image
+type TileHash struct {
+	Rect    image.Rectangle
+	Hash    *PdqHash256
+	Quality int
+}
+
+// TiledHash holds the hash of a whole image plus the hashes of its tiles
+type TiledHash struct {
+	Full  *HashResult
+	Tiles []TileHash
+}
+
+// HashTiles hashes img and every tile of grid over it. A crop that keeps most
+// of some tile still matches that tile's hash, so comparing tiled hashes with
+// MatchTiles survives crops that move the full-frame hash far away.
+func (h *PdqHasher) HashTiles(img image.Image, grid TileGrid) (*TiledHash, error) {
+	if grid.Rows < 1 || grid.Cols < 1 || grid.Overlap < 0 || grid.Overlap >= 1 {
+		return nil, fmt.Errorf("invalid tile grid %dx%d with overlap %v", grid.Rows, grid.Cols, grid.Overlap)
+	}
+
+	full, err := h.HashImage(img)
+	if err != nil {
+		return nil, err
+	}
+
+	// tiles are cut out of an RGBA copy so any image type can be split up
+	rgba := toRGBA(img)
+	bounds := rgba.Bounds()
+
+	th := &TiledHash{Full: full}
+	for _, r := range tileRects(bounds, grid) {
+		res, err := h.HashImage(rgba.SubImage(r))
+		if err != nil {
+			return nil, err
+		}
+		if res.Quality < grid.MinQuality {
+			continue
+		}
+		th.Tiles = append(th.Tiles, TileHash{Rect: r, Hash: res.Hash, Quality: res.Quality})
+	}
+	return th, nil
+}
+
+// tileRects lays out grid over b
+func tileRects(b image.Rectangle, grid TileGrid) []image.Rectangle {
+	xs := tileSpans(b.Min.X, b.Dx(), grid.Cols, grid.Overlap)
+	ys := tileSpans(b.Min.Y, b.Dy(), grid.Rows, grid.Overlap)
+
+	rects := make([]image.Rectangle, 0, len(xs)*len(ys))
+	for _, y := range ys {
+		for _, x := range xs {
+			rects = append(rects, image.Rect(x[0], y[0], x[1], y[1]))
+		}
+	}
+	return rects
+}
+
+// tileSpans splits [min, min+size) into n spans overlapping by the given
+// fraction, with the first and last flush against the edges
+func tileSpans(min, size, n int, overlap float64) [][2]int {
+	tile := float64(size) / (float64(n) - float64(n-1)*overlap)
+	step := tile * (1 - overlap)
+
+	spans := make([][2]int, 0, n)
+	for i := 0; i < n; i++ {
+		lo := min + int(math.Round(float64(i)*step))
+		hi := min + int(math.Round(float64(i)*step+tile))
+		if i == n-1 {
+			hi = min + size
+		}
+		if hi > lo {
+			spans = append(spans, [2]int{lo, hi})
+		}
+	}
+	return spans
+}
+
+// TileMatch scores how much of one tiled hash is found in another
+type TileMatch struct {
+	// Matched is the number of hashes in a, counting the full frame, that
+	// are within the distance of some hash in b
+	Matched int
+	// Total is the number of hashes in a, counting the full frame
+	Total int
+	// Score is Matched / Total
+	Score float64
+	// BestDistance is the smallest distance between any hash in a and any
+	// hash in b
+	BestDistance int
+	// FullDistance is the distance between the two full-frame hashes
+	FullDistance int
+}
+
+// MatchTiles compares every hash in a, full frame and tiles, against every
+// hash in b. A crop of an image typically matches through a tile of the
+// original against the crop's full frame, so callers checking for crops
+// should compare in both directions or treat any BestDistance under their
+// threshold as a match.
+func MatchTiles(a, b *TiledHash, maxDistance int) TileMatch {
+	bHashes := b.hashes()
+	m := TileMatch{
+		BestDistance: math.MaxInt,
+		FullDistance: a.Full.Hash.HammingDistance(b.Full.Hash),
+	}
+
+	for _, ha := range a.hashes() {
+		m.Total++
+		best := math.MaxInt
+		for _, hb := range bHashes {
+			if d := ha.HammingDistance(hb); d < best {
+				best = d
+			}
+		}
+		if best <= maxDistance {
+			m.Matched++
+		}
+		if best < m.BestDistance {
+			m.BestDistance = best
+		}
+	}
+	m.Score = float64(m.Matched) / float64(m.Total)
+	return m
+}
+
+func (t *TiledHash) hashes() []*PdqHash256 {
+	out := make([]*PdqHash256, 0, len(t.Tiles)+1)
+	out = append(out, t.Full.Hash)
+	for _, tile := range t.Tiles {
+		out = append(out, tile.Hash)
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package gopdq
+
+import (
+	"image"
+	"image/jpeg"
+	"os"
+	"testing"
+)
+
+func TestTiles(t *testing.T) {
+	f, err := os.Open("cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := jpeg.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hasher := NewPdqHasher()
+	orig, err := hasher.HashTiles(img, DefaultTileGrid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orig.Tiles) == 0 {
+		t.Fatal("no tiles survived the quality filter")
+	}
+
+	// crop to roughly the centre tile
+	b := img.Bounds()
+	crop := toRGBA(img).SubImage(image.Rect(
+		b.Min.X+b.Dx()/4, b.Min.Y+b.Dy()/4,
+		b.Max.X-b.Dx()/4, b.Max.Y-b.Dy()/4,
+	))
+	cropped, err := hasher.HashTiles(crop, DefaultTileGrid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := MatchTiles(orig, cropped, 31)
+	if m.Matched == 0 || m.BestDistance > 31 {
+		t.Fatalf("crop not matched through tiles: %+v", m)
+	}
+
+	other, err := hasher.HashTiles(testPattern(400, 300), DefaultTileGrid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := MatchTiles(orig, other, 31); m.Matched != 0 {
+		t.Fatalf("unrelated image matched: %+v", m)
+	}
+
+	if _, err := hasher.HashTiles(img, TileGrid{Rows: 2, Cols: 2, Overlap: 1}); err == nil {
+		t.Fatal("expected error for overlap of 1")
+	}
+}
+
+func TestTileRects(t *testing.T) {
+	rects := tileRects(image.Rect(10, 20, 110, 80), TileGrid{Rows: 2, Cols: 3, Overlap: 0.5})
+	if len(rects) != 6 {
+		t.Fatalf("expected 6 tiles, got %d", len(rects))
+	}
+	if rects[0].Min != image.Pt(10, 20) || rects[5].Max != image.Pt(110, 80) {
+		t.Fatalf("tiles don't cover the bounds: %v", rects)
+	}
+	// with 50% overlap each tile starts halfway across the previous one
+	if w := rects[0].Dx(); rects[1].Min.X != 10+w/2 {
+		t.Fatalf("unexpected overlap: %v", rects)
+	}
+}
@@ -0,0 +1,145 @@
+// Package kafkaqueue connects the worker to Kafka. Jobs are read from a topic
+// through a consumer group and results written to another topic.
+//
+// Kafka has no redelivery of single messages, so Nack writes a copy of the
+// job back through the retry writer, carrying its attempt count and when it
+// may run again, and commits the original. Receive holds a copy until its
+// time comes, which holds up the rest of its partition meanwhile. The retry
+// writer must write to the jobs topic, or to another the reader consumes.
+//
+// Commits move the group's offset past everything before the committed
+// message, so with a worker Concurrency above one a crash can lose jobs that
+// were still in flight behind a finished one.
+package kafkaqueue
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/whyrusleeping/gopdq/worker"
+)
+
+var (
+	_ worker.Queue     = (*Queue)(nil)
+	_ worker.Publisher = (*Publisher)(nil)
+)
+
+// Headers carrying a retried job's state
+const (
+	// AttemptsHeader is how many times the job was delivered before
+	AttemptsHeader = "pdq-attempts"
+	// RetryAtHeader is when the job may run again, in Unix milliseconds
+	RetryAtHeader = "pdq-retry-at"
+)
+
+// Reader is the part of *kafka.Reader the Queue uses. The reader needs a
+// GroupID for commits to work.
+type Reader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// Writer is the part of *kafka.Writer the adapters use
+type Writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// Queue receives jobs from a Kafka topic
+type Queue struct {
+	reader Reader
+	retry  Writer
+}
+
+// NewQueue creates a Queue reading jobs from reader and writing retries
+// with retry
+func NewQueue(reader Reader, retry Writer) *Queue {
+	return &Queue{reader: reader, retry: retry}
+}
+
+// Receive fetches the next job, waiting out its retry delay if it has one
+func (q *Queue) Receive(ctx context.Context) (worker.Message, error) {
+	m, err := q.reader.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	attempts, _ := strconv.Atoi(header(m, AttemptsHeader))
+	if at, err := strconv.ParseInt(header(m, RetryAtHeader), 10, 64); err == nil {
+		if wait := time.Until(time.UnixMilli(at)); wait > 0 {
+			t := time.NewTimer(wait)
+			defer t.Stop()
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+	return &message{q: q, msg: m, attempts: attempts + 1}, nil
+}
+
+func header(m kafka.Message, key string) string {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+type message struct {
+	q        *Queue
+	msg      kafka.Message
+	attempts int
+}
+
+func (m *message) Body() []byte  { return m.msg.Value }
+func (m *message) Attempts() int { return m.attempts }
+
+func (m *message) Ack() error {
+	return m.q.reader.CommitMessages(context.Background(), m.msg)
+}
+
+// Nack writes the job back for another attempt after delay, then commits
+// this delivery
+func (m *message) Nack(delay time.Duration) error {
+	retry := kafka.Message{
+		Key:   m.msg.Key,
+		Value: m.msg.Value,
+		Headers: []kafka.Header{
+			{Key: AttemptsHeader, Value: []byte(strconv.Itoa(m.attempts))},
+			{Key: RetryAtHeader, Value: []byte(strconv.FormatInt(time.Now().Add(delay).UnixMilli(), 10))},
+		},
+	}
+	for _, h := range m.msg.Headers {
+		if h.Key != AttemptsHeader && h.Key != RetryAtHeader {
+			retry.Headers = append(retry.Headers, h)
+		}
+	}
+	if err := m.q.retry.WriteMessages(context.Background(), retry); err != nil {
+		return err
+	}
+	return m.Ack()
+}
+
+// Publisher writes results as JSON, keyed by job id
+type Publisher struct {
+	writer Writer
+}
+
+// NewPublisher creates a Publisher writing with writer, which names the
+// results topic
+func NewPublisher(writer Writer) *Publisher {
+	return &Publisher{writer: writer}
+}
+
+func (p *Publisher) Publish(ctx context.Context, res *worker.Result) error {
+	body, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	return p.writer.WriteMessages(ctx, kafka.Message{Key: []byte(res.JobID), Value: body})
+}
@@ -0,0 +1,154 @@
+package kafkaqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/whyrusleeping/gopdq/worker"
+)
+
+// fakeTopic is a single partition topic with one consumer group
+type fakeTopic struct {
+	lk        sync.Mutex
+	cond      *sync.Cond
+	msgs      []kafka.Message
+	read      int
+	committed int64
+}
+
+func newFakeTopic() *fakeTopic {
+	f := &fakeTopic{committed: -1}
+	f.cond = sync.NewCond(&f.lk)
+	return f
+}
+
+func (f *fakeTopic) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	for _, m := range msgs {
+		m.Offset = int64(len(f.msgs))
+		f.msgs = append(f.msgs, m)
+	}
+	f.cond.Broadcast()
+	return nil
+}
+
+func (f *fakeTopic) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	stop := context.AfterFunc(ctx, func() {
+		f.lk.Lock()
+		f.cond.Broadcast()
+		f.lk.Unlock()
+	})
+	defer stop()
+
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	for f.read == len(f.msgs) {
+		if err := ctx.Err(); err != nil {
+			return kafka.Message{}, err
+		}
+		f.cond.Wait()
+	}
+	f.read++
+	return f.msgs[f.read-1], nil
+}
+
+func (f *fakeTopic) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	for _, m := range msgs {
+		f.committed = max(f.committed, m.Offset)
+	}
+	f.cond.Broadcast()
+	return nil
+}
+
+// waitCommitted waits until everything written has been committed
+func (f *fakeTopic) waitCommitted() {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	for f.committed != int64(len(f.msgs))-1 {
+		f.cond.Wait()
+	}
+}
+
+func TestWorker(t *testing.T) {
+	jobs, results := newFakeTopic(), newFakeTopic()
+	jobs.WriteMessages(context.Background(),
+		kafka.Message{Value: []byte(`{"id":"cat","ref":"../../../cat.jpg"}`)},
+		kafka.Message{Value: []byte(`{"id":"missing","ref":"nope.jpg"}`)},
+	)
+
+	// the first fetch fails, so the cat job is retried through the topic
+	fetcher := &failOnce{}
+	w, err := worker.New(worker.Config{
+		Queue:       NewQueue(jobs, jobs),
+		Results:     NewPublisher(results),
+		Fetcher:     fetcher,
+		MaxAttempts: 3,
+		RetryDelay:  10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+
+	for {
+		results.lk.Lock()
+		n := len(results.msgs)
+		results.lk.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	jobs.waitCommitted()
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatal(err)
+	}
+
+	if len(jobs.msgs) != 3 {
+		t.Fatalf("expected one retry written back, topic has %d messages", len(jobs.msgs))
+	}
+	if got := header(jobs.msgs[2], AttemptsHeader); got != "1" {
+		t.Fatalf("retry carries %q attempts", got)
+	}
+	for _, m := range results.msgs {
+		var res worker.Result
+		if err := json.Unmarshal(m.Value, &res); err != nil {
+			t.Fatal(err)
+		}
+		if string(m.Key) != res.JobID {
+			t.Fatalf("result keyed %q for job %q", m.Key, res.JobID)
+		}
+		if res.JobID == "cat" && (res.Hash == nil || res.Attempts != 2) {
+			t.Fatalf("expected cat job to succeed on its second attempt: %+v", res)
+		}
+	}
+}
+
+// failOnce fails the first fetch, then opens files
+type failOnce struct {
+	lk     sync.Mutex
+	failed bool
+}
+
+func (f *failOnce) Fetch(ctx context.Context, ref string) (io.ReadCloser, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	if !f.failed {
+		f.failed = true
+		return nil, errors.New("temporary failure")
+	}
+	return worker.DefaultFetcher{}.Fetch(ctx, ref)
+}
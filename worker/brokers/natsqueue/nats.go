@@ -0,0 +1,97 @@
+// Package natsqueue connects the worker to NATS JetStream. Jobs are pulled
+// from a durable consumer and results published to a subject.
+//
+// JetStream tracks deliveries itself: Attempts is the message's delivery
+// count, Ack acknowledges it and Nack asks the server to redeliver it after
+// the delay. Give the consumer an AckWait longer than a job takes, and a
+// MaxDeliver of at least the worker's MaxAttempts.
+package natsqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/whyrusleeping/gopdq/worker"
+)
+
+var (
+	_ worker.Queue     = (*Queue)(nil)
+	_ worker.Publisher = (*Publisher)(nil)
+)
+
+// Consumer is the part of jetstream.Consumer the Queue uses
+type Consumer interface {
+	Next(opts ...jetstream.FetchOpt) (jetstream.Msg, error)
+}
+
+// Queue receives jobs from a JetStream pull consumer
+type Queue struct {
+	consumer Consumer
+}
+
+// NewQueue creates a Queue pulling from consumer, as returned by
+// jetstream.JetStream.Consumer or CreateOrUpdateConsumer
+func NewQueue(consumer Consumer) *Queue {
+	return &Queue{consumer: consumer}
+}
+
+// Receive pulls the next message, waiting until one arrives or ctx is done
+func (q *Queue) Receive(ctx context.Context) (worker.Message, error) {
+	for {
+		msg, err := q.consumer.Next(jetstream.FetchContext(ctx))
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if errors.Is(err, nats.ErrTimeout) || errors.Is(err, jetstream.ErrNoMessages) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		attempts := 1
+		if md, err := msg.Metadata(); err == nil {
+			attempts = max(int(md.NumDelivered), 1)
+		}
+		return &message{msg: msg, attempts: attempts}, nil
+	}
+}
+
+type message struct {
+	msg      jetstream.Msg
+	attempts int
+}
+
+func (m *message) Body() []byte  { return m.msg.Data() }
+func (m *message) Attempts() int { return m.attempts }
+func (m *message) Ack() error    { return m.msg.Ack() }
+
+func (m *message) Nack(delay time.Duration) error {
+	return m.msg.NakWithDelay(delay)
+}
+
+// Publisher publishes results as JSON to a JetStream subject
+type Publisher struct {
+	js      jetstream.Publisher
+	subject string
+}
+
+// NewPublisher creates a Publisher sending to subject, which a stream must
+// capture
+func NewPublisher(js jetstream.Publisher, subject string) *Publisher {
+	return &Publisher{js: js, subject: subject}
+}
+
+func (p *Publisher) Publish(ctx context.Context, res *worker.Result) error {
+	body, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	_, err = p.js.Publish(ctx, p.subject, body)
+	return err
+}
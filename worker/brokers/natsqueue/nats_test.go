@@ -0,0 +1,155 @@
+package natsqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/whyrusleeping/gopdq/worker"
+)
+
+// fakeStream is a consumer over a stream that redelivers nacked messages
+// after their delay, as the server does
+type fakeStream struct {
+	lk        sync.Mutex
+	pending   chan *fakeMsg
+	acked     int
+	published []*fakeMsg
+}
+
+func (s *fakeStream) add(m *fakeMsg) {
+	m.s = s
+	m.delivered++
+	s.pending <- m
+}
+
+func (s *fakeStream) Next(opts ...jetstream.FetchOpt) (jetstream.Msg, error) {
+	select {
+	case m := <-s.pending:
+		return m, nil
+	case <-time.After(10 * time.Millisecond):
+		return nil, nats.ErrTimeout
+	}
+}
+
+func (s *fakeStream) Publish(_ context.Context, subject string, data []byte, _ ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.published = append(s.published, &fakeMsg{subject: subject, data: data})
+	return &jetstream.PubAck{}, nil
+}
+
+func (s *fakeStream) PublishMsg(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	return s.Publish(ctx, msg.Subject, msg.Data, opts...)
+}
+
+func (s *fakeStream) PublishAsync(string, []byte, ...jetstream.PublishOpt) (jetstream.PubAckFuture, error) {
+	panic("not implemented")
+}
+
+func (s *fakeStream) PublishMsgAsync(*nats.Msg, ...jetstream.PublishOpt) (jetstream.PubAckFuture, error) {
+	panic("not implemented")
+}
+
+func (s *fakeStream) PublishAsyncPending() int              { return 0 }
+func (s *fakeStream) PublishAsyncComplete() <-chan struct{} { return nil }
+func (s *fakeStream) CleanupPublisher()                     {}
+
+type fakeMsg struct {
+	jetstream.Msg
+	s         *fakeStream
+	subject   string
+	data      []byte
+	delivered uint64
+}
+
+func (m *fakeMsg) Data() []byte { return m.data }
+
+func (m *fakeMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{NumDelivered: m.delivered}, nil
+}
+
+func (m *fakeMsg) Ack() error {
+	m.s.lk.Lock()
+	defer m.s.lk.Unlock()
+	m.s.acked++
+	return nil
+}
+
+func (m *fakeMsg) NakWithDelay(delay time.Duration) error {
+	time.AfterFunc(delay, func() { m.s.add(m) })
+	return nil
+}
+
+func TestWorker(t *testing.T) {
+	s := &fakeStream{pending: make(chan *fakeMsg, 10)}
+	s.add(&fakeMsg{data: []byte(`{"id":"cat","ref":"../../../cat.jpg"}`)})
+	s.add(&fakeMsg{data: []byte(`{"id":"missing","ref":"nope.jpg"}`)})
+
+	w, err := worker.New(worker.Config{
+		Queue:       NewQueue(s),
+		Results:     NewPublisher(s, "results"),
+		Fetcher:     &failOnce{ref: "../../../cat.jpg"},
+		MaxAttempts: 3,
+		RetryDelay:  time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+	for {
+		s.lk.Lock()
+		n := s.acked
+		s.lk.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatal(err)
+	}
+
+	if len(s.published) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(s.published))
+	}
+	for _, m := range s.published {
+		var res worker.Result
+		if err := json.Unmarshal(m.data, &res); err != nil {
+			t.Fatal(err)
+		}
+		if m.subject != "results" {
+			t.Fatalf("result published to %q", m.subject)
+		}
+		if res.JobID == "cat" && (res.Hash == nil || res.Attempts != 2) {
+			t.Fatalf("expected cat job to succeed on its second delivery: %+v", res)
+		}
+	}
+}
+
+// failOnce fails the first fetch of ref, then opens files
+type failOnce struct {
+	lk     sync.Mutex
+	ref    string
+	failed bool
+}
+
+func (f *failOnce) Fetch(ctx context.Context, ref string) (io.ReadCloser, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	if ref == f.ref && !f.failed {
+		f.failed = true
+		return nil, errors.New("temporary failure")
+	}
+	return worker.DefaultFetcher{}.Fetch(ctx, ref)
+}
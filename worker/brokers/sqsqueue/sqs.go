@@ -0,0 +1,137 @@
+// Package sqsqueue connects the worker to Amazon SQS. Jobs are received from
+// one queue and results sent to another.
+//
+// SQS tracks deliveries itself: Attempts is the message's approximate receive
+// count, Ack deletes the message and Nack makes it visible again after the
+// delay. Give the jobs queue a visibility timeout longer than a job takes, so
+// a message isn't redelivered while a worker is still hashing it.
+package sqsqueue
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"github.com/whyrusleeping/gopdq/worker"
+)
+
+var (
+	_ worker.Queue     = (*Queue)(nil)
+	_ worker.Publisher = (*Publisher)(nil)
+)
+
+// API is the part of *sqs.Client the adapters use
+type API interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
+
+// maxVisibility is the longest visibility timeout SQS accepts, 12 hours
+const maxVisibility = 12 * time.Hour
+
+// Queue receives jobs from an SQS queue
+type Queue struct {
+	client API
+	url    string
+	// WaitTime is how long each receive long polls, at most 20 seconds,
+	// the default
+	WaitTime time.Duration
+}
+
+// NewQueue creates a Queue receiving from the queue at url
+func NewQueue(client API, url string) *Queue {
+	return &Queue{client: client, url: url, WaitTime: 20 * time.Second}
+}
+
+// Receive long polls until a message arrives or ctx is done
+func (q *Queue) Receive(ctx context.Context) (worker.Message, error) {
+	wait := int32(min(max(q.WaitTime, 0), 20*time.Second) / time.Second)
+	for {
+		out, err := q.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:                    aws.String(q.url),
+			MaxNumberOfMessages:         1,
+			WaitTimeSeconds:             wait,
+			MessageSystemAttributeNames: []types.MessageSystemAttributeName{types.MessageSystemAttributeNameApproximateReceiveCount},
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		if len(out.Messages) == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		m := out.Messages[0]
+		attempts, _ := strconv.Atoi(m.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+		return &message{
+			q:        q,
+			body:     []byte(aws.ToString(m.Body)),
+			receipt:  m.ReceiptHandle,
+			attempts: max(attempts, 1),
+		}, nil
+	}
+}
+
+type message struct {
+	q        *Queue
+	body     []byte
+	receipt  *string
+	attempts int
+}
+
+func (m *message) Body() []byte  { return m.body }
+func (m *message) Attempts() int { return m.attempts }
+
+func (m *message) Ack() error {
+	_, err := m.q.client.DeleteMessage(context.Background(), &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(m.q.url),
+		ReceiptHandle: m.receipt,
+	})
+	return err
+}
+
+// Nack makes the message visible again after delay, capped at the 12 hour
+// most SQS allows
+func (m *message) Nack(delay time.Duration) error {
+	_, err := m.q.client.ChangeMessageVisibility(context.Background(), &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(m.q.url),
+		ReceiptHandle:     m.receipt,
+		VisibilityTimeout: int32(min(max(delay, 0), maxVisibility) / time.Second),
+	})
+	return err
+}
+
+// Publisher sends results as JSON to an SQS queue
+type Publisher struct {
+	client API
+	url    string
+}
+
+// NewPublisher creates a Publisher sending to the queue at url
+func NewPublisher(client API, url string) *Publisher {
+	return &Publisher{client: client, url: url}
+}
+
+func (p *Publisher) Publish(ctx context.Context, res *worker.Result) error {
+	body, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	_, err = p.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(p.url),
+		MessageBody: aws.String(string(body)),
+	})
+	return err
+}
@@ -0,0 +1,154 @@
+package sqsqueue
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"github.com/whyrusleeping/gopdq/worker"
+)
+
+// fakeSQS is a single queue that redelivers a message once its visibility
+// timeout, in whole seconds as SQS has it, has passed
+type fakeSQS struct {
+	lk       sync.Mutex
+	bodies   map[string]string
+	visible  map[string]time.Time
+	receives map[string]int
+	sent     []string
+	next     int
+}
+
+func newFakeSQS(bodies ...string) *fakeSQS {
+	f := &fakeSQS{bodies: map[string]string{}, visible: map[string]time.Time{}, receives: map[string]int{}}
+	for _, b := range bodies {
+		id := strconv.Itoa(f.next)
+		f.next++
+		f.bodies[id] = b
+	}
+	return f
+}
+
+func (f *fakeSQS) ReceiveMessage(ctx context.Context, in *sqs.ReceiveMessageInput, _ ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	for id, body := range f.bodies {
+		if time.Now().Before(f.visible[id]) {
+			continue
+		}
+		f.receives[id]++
+		f.visible[id] = time.Now().Add(time.Hour)
+		return &sqs.ReceiveMessageOutput{Messages: []types.Message{{
+			Body:          aws.String(body),
+			ReceiptHandle: aws.String(id),
+			Attributes:    map[string]string{"ApproximateReceiveCount": strconv.Itoa(f.receives[id])},
+		}}}, nil
+	}
+	return &sqs.ReceiveMessageOutput{}, ctx.Err()
+}
+
+func (f *fakeSQS) DeleteMessage(_ context.Context, in *sqs.DeleteMessageInput, _ ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	delete(f.bodies, *in.ReceiptHandle)
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (f *fakeSQS) ChangeMessageVisibility(_ context.Context, in *sqs.ChangeMessageVisibilityInput, _ ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	f.visible[*in.ReceiptHandle] = time.Now().Add(time.Duration(in.VisibilityTimeout) * time.Second)
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func (f *fakeSQS) SendMessage(_ context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	f.sent = append(f.sent, *in.MessageBody)
+	return &sqs.SendMessageOutput{}, nil
+}
+
+func (f *fakeSQS) len() int {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	return len(f.bodies)
+}
+
+func TestWorker(t *testing.T) {
+	jobs := newFakeSQS(`{"id":"cat","ref":"../../../cat.jpg"}`, `{"id":"missing","ref":"nope.jpg"}`)
+	results := newFakeSQS()
+	w, err := worker.New(worker.Config{
+		Queue:       NewQueue(jobs, "jobs"),
+		Results:     NewPublisher(results, "results"),
+		MaxAttempts: 2,
+		RetryDelay:  time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+	for jobs.len() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatal(err)
+	}
+
+	if len(results.sent) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results.sent))
+	}
+	for _, body := range results.sent {
+		var res worker.Result
+		if err := json.Unmarshal([]byte(body), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.JobID == "cat" && (res.Hash == nil || res.Attempts != 1) {
+			t.Fatalf("expected cat job to succeed: %+v", res)
+		}
+		if res.JobID == "missing" && (res.Error == "" || res.Attempts != 1) {
+			t.Fatalf("expected missing job to fail permanently: %+v", res)
+		}
+	}
+}
+
+func TestNack(t *testing.T) {
+	jobs := newFakeSQS(`{}`)
+	q := NewQueue(jobs, "jobs")
+	ctx := context.Background()
+
+	msg, err := q.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Attempts() != 1 {
+		t.Fatalf("first delivery has %d attempts", msg.Attempts())
+	}
+	// visibility timeouts are whole seconds, so this is visible at once
+	if err := msg.Nack(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	msg, err = q.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Attempts() != 2 {
+		t.Fatalf("redelivery has %d attempts", msg.Attempts())
+	}
+	if err := msg.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if jobs.len() != 0 {
+		t.Fatal("acked message not deleted")
+	}
+}
@@ -0,0 +1,65 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemQueue is an in-process Queue, useful for tests and for embedding the
+// worker in a single binary. Adapters for NATS JetStream, Kafka and SQS are
+// in the github.com/whyrusleeping/gopdq/worker/brokers module, which keeps
+// their client libraries out of this one.
+type MemQueue struct {
+	ch chan *memMessage
+	wg sync.WaitGroup
+}
+
+// NewMemQueue creates a MemQueue buffering up to size messages
+func NewMemQueue(size int) *MemQueue {
+	return &MemQueue{
+		ch: make(chan *memMessage, size),
+	}
+}
+
+// Push enqueues a raw message body
+func (q *MemQueue) Push(body []byte) {
+	q.wg.Add(1)
+	q.ch <- &memMessage{q: q, body: body, attempts: 0}
+}
+
+// Wait blocks until every pushed message has been acked
+func (q *MemQueue) Wait() {
+	q.wg.Wait()
+}
+
+func (q *MemQueue) Receive(ctx context.Context) (Message, error) {
+	select {
+	case m := <-q.ch:
+		m.attempts++
+		return m, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type memMessage struct {
+	q        *MemQueue
+	body     []byte
+	attempts int
+}
+
+func (m *memMessage) Body() []byte  { return m.body }
+func (m *memMessage) Attempts() int { return m.attempts }
+
+func (m *memMessage) Ack() error {
+	m.q.wg.Done()
+	return nil
+}
+
+func (m *memMessage) Nack(delay time.Duration) error {
+	time.AfterFunc(delay, func() {
+		m.q.ch <- m
+	})
+	return nil
+}
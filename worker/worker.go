@@ -0,0 +1,329 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+// Job is a request to hash a single object
+type Job struct {
+	ID  string `json:"id"`
+	Ref string `json:"ref"` // file path or http(s) URL of the object to hash
+}
+
+// Result is published for every job the worker finishes, successfully or not.
+// The hash encodes as hex in JSON and as its 32 raw bytes in MessagePack.
+type Result struct {
+	JobID    string            `json:"job_id" msgpack:"job_id"`
+	Ref      string            `json:"ref" msgpack:"ref"`
+	Hash     *gopdq.PdqHash256 `json:"hash,omitempty" msgpack:"hash,omitempty"`
+	Quality  int               `json:"quality" msgpack:"quality"`
+	Degraded bool              `json:"degraded,omitempty" msgpack:"degraded,omitempty"`
+	Error    string            `json:"error,omitempty" msgpack:"error,omitempty"`
+	Attempts int               `json:"attempts" msgpack:"attempts"`
+}
+
+// Message is a single delivery from a Queue
+type Message interface {
+	// Body returns the raw payload, a JSON-encoded Job
+	Body() []byte
+	// Attempts returns how many times this message has been delivered, starting at 1
+	Attempts() int
+	// Ack removes the message from the queue
+	Ack() error
+	// Nack returns the message to the queue for redelivery after delay
+	Nack(delay time.Duration) error
+}
+
+// Queue is the source of hashing jobs
+type Queue interface {
+	// Receive blocks until a message is available or ctx is done
+	Receive(ctx context.Context) (Message, error)
+}
+
+// Publisher is the sink for results
+type Publisher interface {
+	Publish(ctx context.Context, res *Result) error
+}
+
+// Fetcher opens the object referenced by a job
+type Fetcher interface {
+	Fetch(ctx context.Context, ref string) (io.ReadCloser, error)
+}
+
+// PublisherFunc adapts a function to the Publisher interface
+type PublisherFunc func(ctx context.Context, res *Result) error
+
+func (f PublisherFunc) Publish(ctx context.Context, res *Result) error {
+	return f(ctx, res)
+}
+
+// ErrPermanent marks failures that will not succeed on retry
+var ErrPermanent = errors.New("permanent failure")
+
+// Permanent wraps err so the worker treats it as non-retryable
+func Permanent(err error) error {
+	return fmt.Errorf("%w: %w", ErrPermanent, err)
+}
+
+// Config controls worker behavior
+type Config struct {
+	Queue   Queue
+	Results Publisher
+	// DeadLetter receives results for poison messages: malformed payloads,
+	// permanent failures, and jobs that exhausted MaxAttempts. Optional.
+	DeadLetter Publisher
+	// Fetcher defaults to DefaultFetcher
+	Fetcher Fetcher
+	// Hasher defaults to gopdq.NewPdqHasher() using Logger
+	Hasher *gopdq.PdqHasher
+	// Logger reports retries and poison messages. Optional.
+	Logger *slog.Logger
+
+	// Limits bounds memory and throughput across all concurrent jobs
+	Limits gopdq.Limits
+
+	Concurrency int           // defaults to 1
+	MaxAttempts int           // defaults to 5
+	RetryDelay  time.Duration // base delay, doubled per attempt; defaults to 1s
+}
+
+// Worker consumes jobs from a queue, hashes them and publishes results
+type Worker struct {
+	cfg     Config
+	limiter *gopdq.Limiter
+}
+
+// New creates a new Worker
+func New(cfg Config) (*Worker, error) {
+	if cfg.Queue == nil {
+		return nil, fmt.Errorf("worker: queue is required")
+	}
+	if cfg.Results == nil {
+		return nil, fmt.Errorf("worker: results publisher is required")
+	}
+	if cfg.Fetcher == nil {
+		cfg.Fetcher = DefaultFetcher{}
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.New(slog.DiscardHandler)
+	}
+	if cfg.Hasher == nil {
+		cfg.Hasher = gopdq.NewPdqHasher(gopdq.WithLogger(cfg.Logger))
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = time.Second
+	}
+	return &Worker{
+		cfg:     cfg,
+		limiter: gopdq.NewLimiter(cfg.Limits),
+	}, nil
+}
+
+// Run processes messages until ctx is cancelled or the queue returns an error
+func (w *Worker) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, w.cfg.Concurrency)
+	for i := 0; i < w.cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				msg, err := w.cfg.Queue.Receive(ctx)
+				if err != nil {
+					if ctx.Err() == nil {
+						errs <- err
+					}
+					cancel()
+					return
+				}
+				if err := w.handle(ctx, msg); err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	if err, ok := <-errs; ok {
+		return err
+	}
+	return ctx.Err()
+}
+
+// handle processes a single message. Only errors talking to the queue or
+// publishers are returned; job failures are reported through results.
+func (w *Worker) handle(ctx context.Context, msg Message) error {
+	var job Job
+	if err := json.Unmarshal(msg.Body(), &job); err != nil {
+		return w.poison(ctx, msg, &Result{Attempts: msg.Attempts()}, fmt.Errorf("malformed job: %w", err))
+	}
+
+	res := &Result{
+		JobID:    job.ID,
+		Ref:      job.Ref,
+		Attempts: msg.Attempts(),
+	}
+
+	hr, err := w.hash(ctx, job)
+	if err != nil {
+		if errors.Is(err, ErrPermanent) || msg.Attempts() >= w.cfg.MaxAttempts {
+			return w.poison(ctx, msg, res, err)
+		}
+		delay := w.cfg.RetryDelay << (msg.Attempts() - 1)
+		w.cfg.Logger.Info("retrying job",
+			"job", job.ID,
+			"ref", job.Ref,
+			"attempt", msg.Attempts(),
+			"delay", delay,
+			"err", err,
+		)
+		return msg.Nack(delay)
+	}
+
+	res.Hash = hr.Hash
+	res.Quality = hr.Quality
+	res.Degraded = hr.Degraded
+	if err := w.cfg.Results.Publish(ctx, res); err != nil {
+		return fmt.Errorf("failed to publish result: %w", err)
+	}
+	return msg.Ack()
+}
+
+func (w *Worker) hash(ctx context.Context, job Job) (*gopdq.HashResult, error) {
+	if job.Ref == "" {
+		return nil, Permanent(fmt.Errorf("job %q has no ref", job.ID))
+	}
+
+	r, err := w.cfg.Fetcher.Fetch(ctx, job.Ref)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// decoders wrap read errors in ErrDecodeFailed, so a body cut off
+	// mid-download is told apart from a bad image by what the read saw
+	body := &readErrRecorder{r: r}
+	hr, err := w.cfg.Hasher.FromReaderLimited(ctx, body, w.limiter)
+	if err != nil {
+		if ctx.Err() == nil && body.err == nil && isBadImage(err) {
+			return nil, Permanent(err)
+		}
+		return nil, err
+	}
+	return hr, nil
+}
+
+// isBadImage reports whether err is the hasher rejecting the image itself,
+// which fetching it again won't change, rather than failing to read it
+func isBadImage(err error) bool {
+	for _, target := range []error{
+		gopdq.ErrDecodeFailed,
+		gopdq.ErrUnsupportedFormat,
+		gopdq.ErrImageTooLarge,
+		gopdq.ErrImageTooSmall,
+		gopdq.ErrInvalidImage,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// readErrRecorder keeps the first error other than io.EOF its reader returns
+type readErrRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (e *readErrRecorder) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
+
+func (w *Worker) poison(ctx context.Context, msg Message, res *Result, cause error) error {
+	res.Error = cause.Error()
+	w.cfg.Logger.Warn("dropping poison message",
+		"job", res.JobID,
+		"ref", res.Ref,
+		"attempts", res.Attempts,
+		"err", cause,
+	)
+	if w.cfg.DeadLetter != nil {
+		if err := w.cfg.DeadLetter.Publish(ctx, res); err != nil {
+			return fmt.Errorf("failed to publish dead letter: %w", err)
+		}
+	}
+	if err := w.cfg.Results.Publish(ctx, res); err != nil {
+		return fmt.Errorf("failed to publish result: %w", err)
+	}
+	return msg.Ack()
+}
+
+// DefaultFetcher opens local files and http(s) URLs
+type DefaultFetcher struct {
+	Client *http.Client
+}
+
+func (f DefaultFetcher) Fetch(ctx context.Context, ref string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(ref, "http://") && !strings.HasPrefix(ref, "https://") {
+		file, err := os.Open(strings.TrimPrefix(ref, "file://"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, Permanent(err)
+			}
+			return nil, err
+		}
+		return file, nil
+	}
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ref, nil)
+	if err != nil {
+		return nil, Permanent(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err := fmt.Errorf("fetching %s: unexpected status %d", ref, resp.StatusCode)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return nil, Permanent(err)
+		}
+		return nil, err
+	}
+	return resp.Body, nil
+}
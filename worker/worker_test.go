@@ -0,0 +1,229 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/whyrusleeping/gopdq"
+)
+
+type collector struct {
+	lk      sync.Mutex
+	results []*Result
+}
+
+func (c *collector) Publish(ctx context.Context, res *Result) error {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.results = append(c.results, res)
+	return nil
+}
+
+type flakyFetcher struct {
+	lk       sync.Mutex
+	failures map[string]int
+}
+
+func (f *flakyFetcher) Fetch(ctx context.Context, ref string) (io.ReadCloser, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	if f.failures[ref] > 0 {
+		f.failures[ref]--
+		return nil, errors.New("temporary failure")
+	}
+	return os.Open(ref)
+}
+
+func runWorker(t *testing.T, cfg Config, q *MemQueue) {
+	t.Helper()
+
+	w, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Run(ctx)
+	}()
+
+	q.Wait()
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatal(err)
+	}
+}
+
+func TestWorker(t *testing.T) {
+	q := NewMemQueue(10)
+	q.Push([]byte(`{"id":"cat","ref":"../cat.jpg"}`))
+	q.Push([]byte(`{"id":"missing","ref":"../nope.jpg"}`))
+	q.Push([]byte(`not json`))
+
+	results := &collector{}
+	dead := &collector{}
+	runWorker(t, Config{
+		Queue:       q,
+		Results:     results,
+		DeadLetter:  dead,
+		Concurrency: 2,
+	}, q)
+
+	if len(results.results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results.results))
+	}
+	if len(dead.results) != 2 {
+		t.Fatalf("expected 2 dead letters, got %d", len(dead.results))
+	}
+	for _, res := range results.results {
+		if res.JobID == "cat" && (res.Hash == nil || res.Error != "") {
+			t.Fatalf("expected cat job to succeed: %+v", res)
+		}
+	}
+}
+
+func TestWorkerRetry(t *testing.T) {
+	q := NewMemQueue(10)
+	q.Push([]byte(`{"id":"cat","ref":"../cat.jpg"}`))
+	q.Push([]byte(`{"id":"cat2","ref":"./../cat.jpg"}`))
+
+	results := &collector{}
+	dead := &collector{}
+	runWorker(t, Config{
+		Queue:      q,
+		Results:    results,
+		DeadLetter: dead,
+		Fetcher: &flakyFetcher{failures: map[string]int{
+			"../cat.jpg":   2,
+			"./../cat.jpg": 5,
+		}},
+		MaxAttempts: 3,
+		RetryDelay:  time.Millisecond,
+	}, q)
+
+	if len(results.results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results.results))
+	}
+	if len(dead.results) != 1 || dead.results[0].JobID != "cat2" || dead.results[0].Attempts != 3 {
+		t.Fatalf("expected one dead letter after 3 attempts, got %+v", dead.results)
+	}
+}
+
+// cutFetcher serves files, cutting those in cut off partway with a read
+// error the first time they are fetched
+type cutFetcher struct {
+	lk  sync.Mutex
+	cut map[string]bool
+}
+
+func (f *cutFetcher) Fetch(ctx context.Context, ref string) (io.ReadCloser, error) {
+	data, err := os.ReadFile(ref)
+	if err != nil {
+		return nil, err
+	}
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	if !f.cut[ref] {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	f.cut[ref] = false
+	r := io.MultiReader(bytes.NewReader(data[:len(data)/2]), iotest.ErrReader(errors.New("connection reset")))
+	return io.NopCloser(r), nil
+}
+
+func TestWorkerReadErrors(t *testing.T) {
+	q := NewMemQueue(10)
+	q.Push([]byte(`{"id":"cat","ref":"../cat.jpg"}`))
+	q.Push([]byte(`{"id":"text","ref":"worker.go"}`))
+
+	results := &collector{}
+	dead := &collector{}
+	runWorker(t, Config{
+		Queue:       q,
+		Results:     results,
+		DeadLetter:  dead,
+		Fetcher:     &cutFetcher{cut: map[string]bool{"../cat.jpg": true}},
+		MaxAttempts: 3,
+		RetryDelay:  time.Millisecond,
+	}, q)
+
+	// the download failing is retried, the file not being an image isn't
+	if len(results.results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results.results))
+	}
+	if len(dead.results) != 1 || dead.results[0].JobID != "text" || dead.results[0].Attempts != 1 {
+		t.Fatalf("expected one dead letter on the first attempt, got %+v", dead.results)
+	}
+	for _, res := range results.results {
+		if res.JobID == "cat" && (res.Hash == nil || res.Attempts != 2) {
+			t.Fatalf("expected cat job to succeed on its second attempt: %+v", res)
+		}
+	}
+}
+
+func TestResultEncoding(t *testing.T) {
+	hash := gopdq.NewPdqHash256()
+	hash.SetBit(5)
+	res := &Result{JobID: "cat", Ref: "cat.jpg", Hash: hash, Quality: 90, Attempts: 1}
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["hash"] != hash.String() {
+		t.Fatalf("JSON hash is %v, expected %s", fields["hash"], hash)
+	}
+
+	packed, err := msgpack.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]any
+	if err := msgpack.Unmarshal(packed, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := raw["hash"].([]byte); !ok || !bytes.Equal(b, hash.Bytes()) {
+		t.Fatalf("msgpack hash is %#v, expected the 32 raw bytes", raw["hash"])
+	}
+
+	for name, decode := range map[string]func(*Result) error{
+		"json":    func(r *Result) error { return json.Unmarshal(data, r) },
+		"msgpack": func(r *Result) error { return msgpack.Unmarshal(packed, r) },
+	} {
+		var got Result
+		if err := decode(&got); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got.Hash == nil || !got.Hash.Equal(hash) || got.JobID != res.JobID || got.Quality != res.Quality {
+			t.Fatalf("%s round trip gave %+v", name, got)
+		}
+	}
+
+	// failed jobs carry no hash in either encoding
+	failed, err := msgpack.Marshal(&Result{JobID: "bad", Error: "broken"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clear(raw)
+	if err := msgpack.Unmarshal(failed, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := raw["hash"]; ok {
+		t.Fatal("failed result encoded a hash")
+	}
+}